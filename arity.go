@@ -0,0 +1,190 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"slices"
+)
+
+// WithArity builds a tree in which every interior node has k children rather than two,
+// under the default and sorted constructions. It is two by default, and WithArity(2)
+// builds exactly the tree the option's absence does.
+//
+// A wider node makes a shallower tree: ⌈log_k n⌉ levels rather than ⌈log_2 n⌉. An
+// audit path then carries k-1 siblings per level, so it is longer in total, (k-1)
+// log_k n hashes against log_2 n, but takes fewer hashing steps to check, each over more
+// input. That is the trade to make when a verifier pays per hash invocation more than
+// per byte hashed, as a contract or a circuit often does.
+//
+// Node j of a level is the parent of nodes kj to kj+k-1 of the level below, hashed in
+// that order, or ordered by big-endian value first under WithSortedSiblings. A group
+// short of k nodes, at the end of a level, is filled with copies of its last node, as a
+// binary tree pairs the last node of an odd level with itself; so a lone leaf is hashed
+// with k-1 copies of itself. Unlike the binary tree there is no padding leaf: Leafs
+// and leaf positions count content only.
+//
+// The audit path GetMerklePath returns for such a tree holds the k-1 siblings of each
+// level in order of position, and the index holds the position of each sibling within
+// its group, 0 to k-1, where a binary tree's records 0 for a left and 1 for a right
+// sibling. The position a sibling does not fill is the node being carried up. Pass the
+// same option to VerifyProof to check such a path.
+//
+// A Node has a Left and a Right and nothing else, so a k-ary tree is always held in the
+// flat layout, as if built with WithFlatLayout as well. Proofs, verification, Append
+// and UpdateLeaf work on it. Everything that describes a tree by its binary shape is
+// refused with an error wrapping ErrConstructionMismatch instead: the self-describing
+// Proof, multiproofs, absence proofs, tree heads, Diff, the Builder, StoredTree, and
+// serialization, whose formats have nowhere to record the arity. It cannot be combined
+// with WithRFC6962, whose tree is binary by definition.
+func WithArity(k int) TreeOption {
+	return func(m *MerkleTree) {
+		m.arity = k
+	}
+}
+
+// Arity returns the number of children of each interior node: two unless the tree was
+// built with WithArity.
+func (m *MerkleTree) Arity() int {
+	return m.fanout()
+}
+
+// fanout returns the number of children of each interior node.
+func (m *MerkleTree) fanout() int {
+	if m.arity > 2 {
+		return m.arity
+	}
+
+	return 2
+}
+
+// checkArityOptions normalizes the arity WithArity set, and reports the options it
+// conflicts with.
+func (m *MerkleTree) checkArityOptions() error {
+	switch {
+	case m.arity == 0:
+		return nil
+	case m.arity < 2:
+		return fmt.Errorf("error: WithArity(%d): a tree needs at least two children per node", m.arity)
+	case m.arity == 2:
+		// Binary is the default, and everything handles it as such.
+		m.arity = 0
+
+		return nil
+	case m.rfc6962:
+		return errors.New("error: WithArity and WithRFC6962 cannot be combined; RFC 6962 specifies a binary tree")
+	case m.bitcoin:
+		return errors.New("error: WithArity and WithBitcoinCompat cannot be combined; Bitcoin builds a binary tree")
+	}
+	m.flatLayout = true
+
+	return nil
+}
+
+// requireBinary returns an error wrapping ErrConstructionMismatch when the tree was
+// built with WithArity, for the operations that describe a tree by its binary shape.
+func (m *MerkleTree) requireBinary(what string) error {
+	if m.arity > 2 {
+		return fmt.Errorf("%w: %s is only defined for binary trees, and this one was built with WithArity(%d)", ErrConstructionMismatch, what, m.arity)
+	}
+
+	return nil
+}
+
+// appendGroupHash appends the interior hash of children, a full group of siblings, to
+// dst, reusing h. Under WithSortedSiblings it orders children in place first.
+func (m *MerkleTree) appendGroupHash(h hash.Hash, dst []byte, children [][]byte) ([]byte, error) {
+	if m.sort {
+		slices.SortFunc(children, compareBigEndian)
+	}
+	h.Reset()
+	for _, c := range children {
+		if _, err := h.Write(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return h.Sum(dst), nil
+}
+
+// appendGroup appends to dst the k children of node j of the level above level, which
+// holds count nodes, filling a short group with copies of its last node.
+func (f *flatTree) appendGroup(dst [][]byte, level, j, count int) [][]byte {
+	for c := j * f.arity; c < (j+1)*f.arity; c++ {
+		dst = append(dst, f.node(level, min(c, count-1)))
+	}
+
+	return dst
+}
+
+// appendGroupPath is appendPath for a k-ary tree: the siblings of each level in order of
+// position, and each one's position in its group.
+func (f *flatTree) appendGroupPath(path [][]byte, index []int64, i int) ([][]byte, []int64) {
+	k := f.arity
+	buf := f.pathBuffer(k - 1)
+	for level, j := 0, i; level+1 < len(f.levels); level, j = level+1, j/k {
+		count := f.count(level)
+		for p := 0; p < k; p++ {
+			if c := j/k*k + p; c != j {
+				var node []byte
+				buf, node = f.appendNode(buf, level, min(c, count-1))
+				path = append(path, node)
+				index = append(index, int64(p))
+			}
+		}
+	}
+
+	return path, index
+}
+
+// groupPathLen returns the number of hashes in the audit path of a k-ary tree of count
+// leaves.
+func groupPathLen(count, k int) int {
+	n := k - 1
+	for ; count > k; count = (count + k - 1) / k {
+		n += k - 1
+	}
+
+	return n
+}
+
+// groupProofReproducesRoot is proofReproducesRoot for a k-ary tree, whose path holds
+// k-1 siblings per level and whose index holds each sibling's position.
+func (m *MerkleTree) groupProofReproducesRoot(digest []byte, path [][]byte, index []int64, root []byte) (bool, error) {
+	k := m.arity
+	if len(path)%(k-1) != 0 {
+		return false, fmt.Errorf("%w: a path through a tree of arity %d holds a multiple of %d hashes, got %d", ErrMalformedProof, k, k-1, len(path))
+	}
+
+	h := m.hashStrategy()
+	cur := bytes.Clone(digest)
+	children := make([][]byte, k)
+	for lo := 0; lo < len(path); lo += k - 1 {
+		for p := range children {
+			children[p] = nil
+		}
+		for s := lo; s < lo+k-1; s++ {
+			p := index[s]
+			if p < 0 || p >= int64(k) || children[p] != nil {
+				return false, fmt.Errorf("%w: index entry %d is %d, expected a distinct position below %d", ErrMalformedProof, s, p, k)
+			}
+			children[p] = path[s]
+		}
+		for p := range children {
+			if children[p] == nil {
+				children[p] = cur
+			}
+		}
+
+		var err error
+		if cur, err = m.appendGroupHash(h, nil, children); err != nil {
+			return false, err
+		}
+	}
+
+	return bytes.Equal(cur, root), nil
+}
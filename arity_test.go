@@ -0,0 +1,228 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// referenceArityRoot computes the root of the k-ary tree over cs directly from the rule
+// WithArity documents: each level hashes groups of k, a short group is filled with
+// copies of its last node, and a lone leaf still gets a level above it.
+func referenceArityRoot(t *testing.T, cs []Content, k int, sorted bool) []byte {
+	t.Helper()
+	var level [][]byte
+	for _, c := range cs {
+		digest, err := c.CalculateHash()
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		level = append(level, digest)
+	}
+	for first := true; first || len(level) > 1; first = false {
+		var up [][]byte
+		for lo := 0; lo < len(level); lo += k {
+			group := make([][]byte, k)
+			for p := range group {
+				group[p] = level[min(lo+p, len(level)-1)]
+			}
+			if sorted {
+				slices.SortFunc(group, bytes.Compare)
+			}
+			h := sha256.New()
+			for _, g := range group {
+				h.Write(g)
+			}
+			up = append(up, h.Sum(nil))
+		}
+		level = up
+	}
+
+	return level[0]
+}
+
+func TestArityRoots(t *testing.T) {
+	for _, k := range []int{3, 4, 5, 16} {
+		for _, sorted := range []bool{false, true} {
+			for n := 1; n <= 40; n++ {
+				opts := []TreeOption{WithArity(k)}
+				if sorted {
+					opts = append(opts, WithSortedSiblings())
+				}
+				cs := propSeries(n)
+				tree, err := NewTreeWithOptions(cs, opts...)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				label := fmt.Sprintf("k=%d sorted=%t n=%d", k, sorted, n)
+				if want := referenceArityRoot(t, cs, k, sorted); !bytes.Equal(tree.MerkleRoot(), want) {
+					t.Fatalf("error: %s: root %x, want %x", label, tree.MerkleRoot(), want)
+				}
+				if tree.Arity() != k || tree.Len() != n || tree.LevelSize(0) != n {
+					t.Errorf("error: %s: Arity %d Len %d LevelSize(0) %d", label, tree.Arity(), tree.Len(), tree.LevelSize(0))
+				}
+				if ok, err := tree.VerifyTree(); err != nil || !ok {
+					t.Errorf("error: %s: VerifyTree returned %v, %v", label, ok, err)
+				}
+
+				for i, c := range cs {
+					path, index, err := tree.GetMerklePathByIndex(i)
+					if err != nil {
+						t.Fatalf("error: unexpected error: %v", err)
+					}
+					if len(path) != tree.Height()*(k-1) {
+						t.Fatalf("error: %s: leaf %d: path of %d hashes, want %d", label, i, len(path), tree.Height()*(k-1))
+					}
+					if ok, err := VerifyProof(c, path, index, tree.MerkleRoot(), opts...); err != nil || !ok {
+						t.Errorf("error: %s: leaf %d: VerifyProof returned %v, %v", label, i, ok, err)
+					}
+					if ok, err := tree.VerifyContent(c); err != nil || !ok {
+						t.Errorf("error: %s: leaf %d: VerifyContent returned %v, %v", label, i, ok, err)
+					}
+					if ok, _ := VerifyProof(propContent{x: "absent"}, path, index, tree.MerkleRoot(), opts...); ok {
+						t.Errorf("error: %s: leaf %d: path verified content not in the tree", label, i)
+					}
+					if ok, _ := VerifyProof(c, path, index, tree.MerkleRoot()); ok && n > 1 {
+						t.Errorf("error: %s: leaf %d: path verified as a binary one", label, i)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestArityTwoIsTheDefault(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		cs := propSeries(n)
+		want, err := NewTreeWithOptions(cs)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		got, err := NewTreeWithOptions(cs, WithArity(2))
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if !bytes.Equal(got.MerkleRoot(), want.MerkleRoot()) || got.Root == nil || got.Arity() != 2 {
+			t.Errorf("error: n=%d: WithArity(2) built a different tree", n)
+		}
+		if _, err := got.TreeHead(time.Time{}); err != nil {
+			t.Errorf("error: n=%d: unexpected error: %v", n, err)
+		}
+	}
+}
+
+func TestArityChanges(t *testing.T) {
+	const k = 3
+	cs := propSeries(20)
+	tree, err := NewTreeWithOptions(cs[:1], WithArity(k))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for n := 2; n <= len(cs); n++ {
+		if err := tree.Append(cs[n-1]); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if want := referenceArityRoot(t, cs[:n], k, false); !bytes.Equal(tree.MerkleRoot(), want) {
+			t.Fatalf("error: n=%d: root after Append %x, want %x", n, tree.MerkleRoot(), want)
+		}
+	}
+
+	updated := slices.Clone(cs)
+	for _, i := range []int{0, 8, 9, 19} {
+		updated[i] = propContent{x: fmt.Sprintf("updated-%d", i)}
+		if err := tree.UpdateLeaf(i, updated[i]); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if want := referenceArityRoot(t, updated, k, false); !bytes.Equal(tree.MerkleRoot(), want) {
+			t.Fatalf("error: leaf %d: root after UpdateLeaf %x, want %x", i, tree.MerkleRoot(), want)
+		}
+	}
+	if ok, err := tree.VerifyTree(); err != nil || !ok {
+		t.Errorf("error: VerifyTree returned %v, %v", ok, err)
+	}
+}
+
+func TestArityMalformedProofs(t *testing.T) {
+	opts := []TreeOption{WithArity(4)}
+	cs := propSeries(10)
+	tree, err := NewTreeWithOptions(cs, opts...)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	path, index, err := tree.GetMerklePathByIndex(5)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	for name, tamper := range map[string]func(p [][]byte, i []int64) ([][]byte, []int64){
+		"short path":   func(p [][]byte, i []int64) ([][]byte, []int64) { return p[1:], i[1:] },
+		"out of range": func(p [][]byte, i []int64) ([][]byte, []int64) { i[0] = 4; return p, i },
+		"negative":     func(p [][]byte, i []int64) ([][]byte, []int64) { i[0] = -1; return p, i },
+		"repeated":     func(p [][]byte, i []int64) ([][]byte, []int64) { i[1] = i[0]; return p, i },
+	} {
+		p, i := tamper(slices.Clone(path), slices.Clone(index))
+		if _, err := VerifyProof(cs[5], p, i, tree.MerkleRoot(), opts...); !errors.Is(err, ErrMalformedProof) {
+			t.Errorf("error: %s: got %v, want ErrMalformedProof", name, err)
+		}
+	}
+}
+
+func TestArityRejects(t *testing.T) {
+	cs := propSeries(5)
+	for _, opts := range [][]TreeOption{
+		{WithArity(1)},
+		{WithArity(-3)},
+		{WithArity(3), WithRFC6962()},
+		{WithArity(3), WithBitcoinCompat()},
+	} {
+		if _, err := NewTreeWithOptions(cs, opts...); err == nil {
+			t.Errorf("error: %d options: expected an error", len(opts))
+		}
+	}
+
+	opts := []TreeOption{WithArity(3)}
+	tree, err := NewTreeWithOptions(cs, opts...)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	binary, err := NewTreeWithOptions(cs)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	proof, err := binary.GetProofByIndex(0)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for name, call := range map[string]func() error{
+		"GetProofByIndex": func() error { _, err := tree.GetProofByIndex(0); return err },
+		"Proof.Verify":    func() error { _, err := proof.Verify(cs[0], binary.MerkleRoot(), opts...); return err },
+		"GetMultiProof":   func() error { _, err := tree.GetMultiProof([]int{0, 1}); return err },
+		"VerifyMultiProof": func() error {
+			_, err := VerifyMultiProof(nil, &MultiProof{}, nil, opts...)
+			return err
+		},
+		"TreeHead":      func() error { _, err := tree.TreeHead(time.Time{}); return err },
+		"MarshalBinary": func() error { _, err := tree.MarshalBinary(); return err },
+		"Diff":          func() error { _, err := Diff(tree, binary); return err },
+		"NewBuilder":    func() error { _, err := NewBuilder(opts...); return err },
+		"NewStoredTree": func() error {
+			_, err := NewStoredTree(NewMemoryNodeStore(), len(cs), contentAt(cs), opts...)
+			return err
+		},
+		"NewSortedContentTree": func() error {
+			_, err := NewSortedContentTree(cs, func(a, b Content) int { return 0 }, opts...)
+			return err
+		},
+	} {
+		if err := call(); !errors.Is(err, ErrConstructionMismatch) {
+			t.Errorf("error: %s: got %v, want ErrConstructionMismatch", name, err)
+		}
+	}
+}
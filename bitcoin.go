@@ -0,0 +1,149 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"reflect"
+)
+
+// doubleSHA256 is SHA-256 applied twice, which is how Bitcoin hashes transactions, block
+// headers and the interior nodes of its Merkle trees.
+type doubleSHA256 struct {
+	hash.Hash
+}
+
+// NewDoubleSHA256 returns a hash.Hash computing SHA-256 of the SHA-256 of what is
+// written to it, the hash Bitcoin calls SHA256d. It is registered for serialization as
+// "sha256d".
+func NewDoubleSHA256() hash.Hash {
+	return doubleSHA256{sha256.New()}
+}
+
+func (d doubleSHA256) Sum(b []byte) []byte {
+	var inner [sha256.Size]byte
+	outer := sha256.Sum256(d.Hash.Sum(inner[:0]))
+
+	return append(b, outer[:]...)
+}
+
+// WithBitcoinCompat builds the tree a Bitcoin block header commits to, so that a tree
+// over a block's transaction IDs, in block order, has the block's Merkle root as its
+// root.
+//
+// Bitcoin's tree is this package's default construction over double SHA-256: leaves
+// are txids as they are, pairs are concatenated in order, and an odd level pairs its
+// last node with itself. The option selects NewDoubleSHA256 as the hash strategy, and
+// cannot be combined with WithSortedSiblings, WithRFC6962 or a different WithHasher,
+// any of which would build some other tree. The one place Bitcoin departs from the
+// default construction is a block of one transaction, whose Merkle root is that txid
+// rather than the txid paired with itself. A tree of one item built with the option is
+// the same: its single leaf is its root, with no padding leaf and an empty audit path,
+// as under WithRFC6962.
+//
+// Leaf digests must be txids in the byte order they are hashed in, which is the
+// reverse of the hex block explorers and bitcoind's RPC display. BitcoinTxID parses
+// the displayed form into a Content that returns the right bytes, and
+// FormatBitcoinHash displays a root the way a block header's merkleroot is shown.
+//
+// The option is not recorded when a tree is serialized. A tree read back is the same
+// tree, since the hash strategy is, except for a tree of one transaction, which would be
+// read back padded; marshaling one returns an error wrapping ErrConstructionMismatch.
+func WithBitcoinCompat() TreeOption {
+	return func(m *MerkleTree) {
+		m.hashStrategy = NewDoubleSHA256
+		m.bitcoin = true
+	}
+}
+
+// checkBitcoinOptions reports the options WithBitcoinCompat conflicts with.
+func (m *MerkleTree) checkBitcoinOptions() error {
+	if !m.bitcoin {
+		return nil
+	}
+	if m.sort || m.rfc6962 {
+		return errors.New("error: WithBitcoinCompat cannot be combined with WithSortedSiblings or WithRFC6962; Bitcoin builds neither")
+	}
+	if reflect.ValueOf(m.hashStrategy).Pointer() != reflect.ValueOf(NewDoubleSHA256).Pointer() {
+		return errors.New("error: WithBitcoinCompat cannot be combined with WithHasher; Bitcoin hashes with double SHA-256")
+	}
+
+	return nil
+}
+
+// ParseBitcoinHash decodes a hash displayed the way Bitcoin displays txids, block hashes
+// and Merkle roots: hex, with the bytes in reverse order. The result is in the order
+// the hash is computed in, the order this package works with.
+func ParseBitcoinHash(s string) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid Bitcoin hash %q: %w", s, err)
+	}
+	if len(b) != sha256.Size {
+		return nil, fmt.Errorf("error: invalid Bitcoin hash %q: %d bytes, want %d", s, len(b), sha256.Size)
+	}
+	reverseBytes(b)
+
+	return b, nil
+}
+
+// FormatBitcoinHash displays h the way Bitcoin displays hashes, as the hex of its bytes
+// in reverse order. It is the inverse of ParseBitcoinHash.
+func FormatBitcoinHash(h []byte) string {
+	b := make([]byte, len(h))
+	copy(b, h)
+	reverseBytes(b)
+
+	return hex.EncodeToString(b)
+}
+
+func reverseBytes(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
+
+// BitcoinTxID is a Bitcoin transaction ID as Content, held in the order it is hashed
+// in. A tree of a block's BitcoinTxIDs built with WithBitcoinCompat has the block's
+// Merkle root.
+type BitcoinTxID [sha256.Size]byte
+
+// ParseBitcoinTxID decodes a txid as Bitcoin displays it, reversing its bytes.
+func ParseBitcoinTxID(s string) (BitcoinTxID, error) {
+	var id BitcoinTxID
+	b, err := ParseBitcoinHash(s)
+	if err != nil {
+		return id, err
+	}
+	copy(id[:], b)
+
+	return id, nil
+}
+
+// String returns the txid as Bitcoin displays it.
+func (id BitcoinTxID) String() string {
+	return FormatBitcoinHash(id[:])
+}
+
+// CalculateHash returns the txid itself, which is the leaf Bitcoin's tree holds.
+func (id BitcoinTxID) CalculateHash() ([]byte, error) {
+	b := make([]byte, len(id))
+	copy(b, id[:])
+
+	return b, nil
+}
+
+// Equals reports whether other is the same txid.
+func (id BitcoinTxID) Equals(other Content) (bool, error) {
+	o, ok := other.(BitcoinTxID)
+	if !ok {
+		return false, errors.New("error: value is not of type BitcoinTxID")
+	}
+
+	return id == o, nil
+}
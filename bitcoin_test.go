@@ -0,0 +1,335 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// bitcoinBlock is a mainnet block as bitcoind's getblock reports it, trimmed to the
+// header and the txids. The header fields let the test check the vector itself: the
+// header they serialize to must hash to the block hash, so a txid or root transcribed
+// wrongly fails the test rather than being trusted.
+type bitcoinBlock struct {
+	Height            int      `json:"height"`
+	Hash              string   `json:"hash"`
+	Version           uint32   `json:"version"`
+	PreviousBlockHash string   `json:"previousblockhash"`
+	MerkleRoot        string   `json:"merkleroot"`
+	Time              uint32   `json:"time"`
+	Bits              string   `json:"bits"`
+	Nonce             uint32   `json:"nonce"`
+	Tx                []string `json:"tx"`
+}
+
+func loadBitcoinBlocks(t *testing.T) []bitcoinBlock {
+	t.Helper()
+	data, err := os.ReadFile("testdata/bitcoin_blocks.json")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	var blocks []bitcoinBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	return blocks
+}
+
+// header serializes the block's 80 byte header.
+func (b bitcoinBlock) header(t *testing.T) []byte {
+	t.Helper()
+	prev, err := ParseBitcoinHash(b.PreviousBlockHash)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	root, err := ParseBitcoinHash(b.MerkleRoot)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	bits, err := strconv.ParseUint(b.Bits, 16, 32)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	h := binary.LittleEndian.AppendUint32(nil, b.Version)
+	h = append(h, prev...)
+	h = append(h, root...)
+	h = binary.LittleEndian.AppendUint32(h, b.Time)
+	h = binary.LittleEndian.AppendUint32(h, uint32(bits))
+
+	return binary.LittleEndian.AppendUint32(h, b.Nonce)
+}
+
+func (b bitcoinBlock) txids(t *testing.T) []Content {
+	t.Helper()
+	cs := make([]Content, len(b.Tx))
+	for i, s := range b.Tx {
+		id, err := ParseBitcoinTxID(s)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		cs[i] = id
+	}
+
+	return cs
+}
+
+// TestBitcoinBlockRoots checks the roots of real blocks. At least one must have a level
+// of odd length above a lone root, so that Bitcoin's duplication of the last hash is
+// pinned by the network's own roots and not only by referenceBitcoinRoot.
+func TestBitcoinBlockRoots(t *testing.T) {
+	odd := false
+	for _, b := range loadBitcoinBlocks(t) {
+		for count := len(b.Tx); count > 1; count = (count + 1) / 2 {
+			odd = odd || count%2 == 1
+		}
+		h := NewDoubleSHA256()
+		h.Write(b.header(t))
+		if got := FormatBitcoinHash(h.Sum(nil)); got != b.Hash {
+			t.Fatalf("error: block %d: header hashes to %s, want %s", b.Height, got, b.Hash)
+		}
+
+		cs := b.txids(t)
+		for _, flat := range []bool{false, true} {
+			opts := []TreeOption{WithBitcoinCompat()}
+			if flat {
+				opts = append(opts, WithFlatLayout())
+			}
+			tree, err := NewTreeWithOptions(cs, opts...)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got := FormatBitcoinHash(tree.MerkleRoot()); got != b.MerkleRoot {
+				t.Fatalf("error: block %d flat=%v: root %s, want %s", b.Height, flat, got, b.MerkleRoot)
+			}
+			for i, c := range cs {
+				proof, err := tree.GetProofByIndex(i)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				if ok, err := proof.Verify(c, tree.MerkleRoot(), WithBitcoinCompat()); err != nil || !ok {
+					t.Errorf("error: block %d tx %d: got %v, %v, want true", b.Height, i, ok, err)
+				}
+				path, index, _ := tree.GetMerklePathByIndex(i)
+				if ok, _ := VerifyProof(cs[(i+1)%len(cs)], path, index, tree.MerkleRoot(), WithBitcoinCompat()); ok && len(cs) > 1 {
+					t.Errorf("error: block %d tx %d: proof verified another transaction", b.Height, i)
+				}
+			}
+		}
+
+		builder, _ := NewBuilder(WithBitcoinCompat())
+		for _, c := range cs {
+			if err := builder.Add(c); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+		}
+		if root, err := builder.Finish(); err != nil || FormatBitcoinHash(root) != b.MerkleRoot {
+			t.Errorf("error: block %d: builder root %x, %v, want %s", b.Height, root, err, b.MerkleRoot)
+		}
+		stored, err := NewStoredTree(NewMemoryNodeStore(), len(cs), contentAt(cs), WithBitcoinCompat())
+		if err != nil || FormatBitcoinHash(stored.MerkleRoot()) != b.MerkleRoot {
+			t.Errorf("error: block %d: stored tree returned %v", b.Height, err)
+		}
+	}
+	if !odd {
+		t.Error("error: no block has a level of odd length")
+	}
+}
+
+// referenceBitcoinRoot is the Merkle root computation from Bitcoin Core's
+// ComputeMerkleRoot, transcribed directly.
+func referenceBitcoinRoot(hashes [][]byte) []byte {
+	for len(hashes) > 1 {
+		if len(hashes)%2 == 1 {
+			hashes = append(hashes, hashes[len(hashes)-1])
+		}
+		next := make([][]byte, 0, len(hashes)/2)
+		for i := 0; i < len(hashes); i += 2 {
+			inner := sha256.Sum256(append(append([]byte{}, hashes[i]...), hashes[i+1]...))
+			outer := sha256.Sum256(inner[:])
+			next = append(next, outer[:])
+		}
+		hashes = next
+	}
+
+	return hashes[0]
+}
+
+// TestBitcoinOddLevels covers the block sizes the mainnet vectors do not, in particular
+// levels of odd length above the leaves.
+func TestBitcoinOddLevels(t *testing.T) {
+	for n := 1; n <= 33; n++ {
+		cs := make([]Content, n)
+		digests := make([][]byte, n)
+		for i := range cs {
+			var id BitcoinTxID
+			id[0], id[31] = byte(i), byte(n)
+			cs[i], digests[i] = id, id[:]
+		}
+		tree, err := NewTreeWithOptions(cs, WithBitcoinCompat())
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if want := referenceBitcoinRoot(digests); !bytes.Equal(tree.MerkleRoot(), want) {
+			t.Errorf("error: n=%d: root %x, want %x", n, tree.MerkleRoot(), want)
+		}
+
+		for _, flat := range []bool{false, true} {
+			opts := []TreeOption{WithBitcoinCompat()}
+			if flat {
+				opts = append(opts, WithFlatLayout())
+			}
+			grown, _ := NewTreeWithOptions(cs[:1], opts...)
+			if err := grown.Append(cs[1:]...); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !bytes.Equal(grown.MerkleRoot(), tree.MerkleRoot()) {
+				t.Errorf("error: n=%d flat=%v: appended tree's root differs", n, flat)
+			}
+			if ok, err := grown.VerifyTree(); err != nil || !ok {
+				t.Errorf("error: n=%d flat=%v: VerifyTree returned %v, %v", n, flat, ok, err)
+			}
+		}
+	}
+}
+
+func TestBitcoinCompatRejects(t *testing.T) {
+	for name, opts := range map[string][]TreeOption{
+		"sorted":      {WithBitcoinCompat(), WithSortedSiblings()},
+		"rfc6962":     {WithRFC6962(), WithBitcoinCompat()},
+		"hasher":      {WithBitcoinCompat(), WithHasher(sha512.New)},
+		"same hasher": {WithBitcoinCompat(), WithHasher(sha256.New)},
+	} {
+		if _, err := NewTreeWithOptions(bitcoinTxIDs(4), opts...); err == nil {
+			t.Errorf("error: %s: expected an error", name)
+		}
+	}
+
+}
+
+// TestBitcoinSingleTransaction checks that a tree of one txid has that txid as its root
+// however it is built, and serves and verifies its empty proof.
+func TestBitcoinSingleTransaction(t *testing.T) {
+	one := bitcoinTxIDs(1)
+	txid, _ := one[0].CalculateHash()
+	for _, flat := range []bool{false, true} {
+		opts := []TreeOption{WithBitcoinCompat()}
+		if flat {
+			opts = append(opts, WithFlatLayout())
+		}
+		tree, err := NewTreeWithOptions(one, opts...)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if !bytes.Equal(tree.MerkleRoot(), txid) || tree.Len() != 1 {
+			t.Fatalf("error: flat=%v: root %x, want the txid %x", flat, tree.MerkleRoot(), txid)
+		}
+		if ok, err := tree.VerifyTree(); err != nil || !ok {
+			t.Errorf("error: flat=%v: VerifyTree returned %v, %v", flat, ok, err)
+		}
+		if _, err := tree.GetProofByIndex(1); !errors.Is(err, ErrContentNotFound) {
+			t.Errorf("error: flat=%v: a lone transaction has no padding leaf: got %v", flat, err)
+		}
+		proof, err := tree.GetProofByIndex(0)
+		if err != nil || len(proof.Siblings) != 0 {
+			t.Fatalf("error: flat=%v: GetProofByIndex returned %d siblings, %v", flat, len(proof.Siblings), err)
+		}
+		if ok, err := proof.Verify(one[0], tree.MerkleRoot(), WithBitcoinCompat()); err != nil || !ok {
+			t.Errorf("error: flat=%v: Proof.Verify returned %v, %v", flat, ok, err)
+		}
+		if _, err := proof.Verify(one[0], tree.MerkleRoot(), WithHasher(NewDoubleSHA256)); !errors.Is(err, ErrMalformedProof) {
+			t.Errorf("error: flat=%v: empty proof without the option: got %v, want ErrMalformedProof", flat, err)
+		}
+		mp, err := tree.GetMultiProof([]int{0})
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if ok, err := VerifyMultiProof([][]byte{txid}, mp, tree.MerkleRoot(), WithBitcoinCompat()); err != nil || !ok {
+			t.Errorf("error: flat=%v: VerifyMultiProof returned %v, %v", flat, ok, err)
+		}
+
+		updated := bitcoinTxIDs(2)[1]
+		if err := tree.UpdateLeaf(0, updated); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if want, _ := updated.CalculateHash(); !bytes.Equal(tree.MerkleRoot(), want) {
+			t.Errorf("error: flat=%v: root after UpdateLeaf %x, want %x", flat, tree.MerkleRoot(), want)
+		}
+		if _, err := tree.MarshalBinary(); !errors.Is(err, ErrConstructionMismatch) {
+			t.Errorf("error: flat=%v: MarshalBinary: got %v, want ErrConstructionMismatch", flat, err)
+		}
+	}
+
+	tree, _ := NewTreeWithOptions(bitcoinTxIDs(2), WithBitcoinCompat())
+	if err := tree.RebuildTreeWith(one); err != nil || !bytes.Equal(tree.MerkleRoot(), txid) {
+		t.Errorf("error: rebuild: root %x, %v, want the txid", tree.MerkleRoot(), err)
+	}
+	store := NewMemoryNodeStore()
+	stored, err := NewStoredTree(store, len(one), contentAt(one), WithBitcoinCompat())
+	if err != nil || !bytes.Equal(stored.MerkleRoot(), txid) {
+		t.Fatalf("error: stored tree: root %x, %v, want the txid", stored.MerkleRoot(), err)
+	}
+	if path, _, err := stored.GetMerklePathByIndex(0); err != nil || len(path) != 0 {
+		t.Errorf("error: stored tree: path of %d hashes, %v", len(path), err)
+	}
+	if reopened, err := OpenStoredTree(store, 1, WithBitcoinCompat()); err != nil || !bytes.Equal(reopened.MerkleRoot(), txid) {
+		t.Errorf("error: reopened stored tree returned %v", err)
+	}
+	b, _ := NewBuilder(WithBitcoinCompat())
+	b.Add(one[0])
+	if root, err := b.Finish(); err != nil || !bytes.Equal(root, txid) {
+		t.Errorf("error: builder: root %x, %v, want the txid", root, err)
+	}
+
+	// Double SHA-256 alone, without the option, pads the lone leaf as any tree does.
+	padded, err := NewTreeWithOptions(one, WithHasher(NewDoubleSHA256))
+	if err != nil || bytes.Equal(padded.MerkleRoot(), txid) {
+		t.Errorf("error: without the option: root %x, %v", padded.MerkleRoot(), err)
+	}
+}
+
+func bitcoinTxIDs(n int) []Content {
+	cs := make([]Content, n)
+	for i := range cs {
+		cs[i] = BitcoinTxID(sha256.Sum256([]byte{byte(i)}))
+	}
+
+	return cs
+}
+
+func TestBitcoinHashEncoding(t *testing.T) {
+	const display = "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+	id, err := ParseBitcoinTxID(display)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if got := hex.EncodeToString(id[:]); got != "3ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a" {
+		t.Errorf("error: txid bytes %s are not reversed", got)
+	}
+	if id.String() != display {
+		t.Errorf("error: got %s, want %s", id, display)
+	}
+	if ok, _ := id.Equals(BitcoinTxID{}); ok {
+		t.Errorf("error: distinct txids reported equal")
+	}
+	if _, err := id.Equals(TestSHA256Content{x: "a"}); err == nil {
+		t.Errorf("error: comparing with another content type: expected an error")
+	}
+
+	for _, s := range []string{"", "zz", display[:62], display + "00"} {
+		if _, err := ParseBitcoinHash(s); err == nil {
+			t.Errorf("error: %q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// errBuilderFinished is returned by a Builder asked to take leaves after Finish.
+var errBuilderFinished = errors.New("error: builder is already finished")
+
+// Builder computes the root of a tree from leaves given one at a time, for content too
+// large to hold as a []Content. The root is the one NewTreeWithOptions would build from
+// the same leaves in the same order with the same options, under the default, sorted
+// and RFC 6962 constructions alike.
+//
+// A Builder holds one pending hash per level, the left half of a pair still waiting for
+// its right, so O(log n) hashes whatever the leaf count. A Builder created with
+// NewBuilderWithStore also writes every node to a NodeStore as it is completed, and
+// once finished serves proofs from there as a StoredTree.
+//
+// The zero value is not usable; create builders with NewBuilder or
+// NewBuilderWithStore. A Builder is not safe for concurrent use.
+type Builder struct {
+	// cfg carries the construction and hash strategy, and provides the hashing.
+	cfg   *MerkleTree
+	h     hash.Hash
+	store NodeStore
+	n     int
+	// pending[l] is the root of a perfect subtree of 2^l leaves whose right sibling
+	// has not been seen yet. It is set exactly when bit l of n is.
+	pending [][]byte
+	root    []byte
+}
+
+// NewBuilder returns an empty Builder. It takes the same options as NewTreeWithOptions;
+// WithParallelism and WithLeafIndex have no effect.
+func NewBuilder(opts ...TreeOption) (*Builder, error) {
+	cfg, err := configFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.requireBinary("a Builder"); err != nil {
+		return nil, err
+	}
+
+	return &Builder{cfg: cfg, h: cfg.hashStrategy()}, nil
+}
+
+// NewBuilderWithStore returns an empty Builder that retains the tree it builds in store,
+// laid out as NewStoredTree lays it out, so that Tree can serve proofs once it is
+// finished.
+func NewBuilderWithStore(store NodeStore, opts ...TreeOption) (*Builder, error) {
+	if store == nil {
+		return nil, errors.New("error: NewBuilderWithStore requires a NodeStore")
+	}
+	b, err := NewBuilder(opts...)
+	if err != nil {
+		return nil, err
+	}
+	b.store = store
+
+	return b, nil
+}
+
+// Len returns the number of leaves added so far.
+func (b *Builder) Len() int {
+	return b.n
+}
+
+// Add adds the leaf holding c after those already added. c is not retained.
+func (b *Builder) Add(c Content) error {
+	if c == nil {
+		return fmt.Errorf("%w: index %d", ErrNilContent, b.n)
+	}
+	digest, err := c.CalculateHash()
+	if err != nil {
+		return err
+	}
+
+	return b.AddDigest(digest)
+}
+
+// AddDigest adds the leaf whose content hashes to digest after those already added. As
+// with VerifyProofWithDigest, digest is the value Content.CalculateHash returns, and any
+// leaf prefix the construction calls for is applied here.
+func (b *Builder) AddDigest(digest []byte) error {
+	if b.root != nil {
+		return errBuilderFinished
+	}
+	leaf := digest
+	if b.cfg.rfc6962 {
+		var err error
+		if leaf, err = b.cfg.appendLeafDigest(b.h, nil, digest); err != nil {
+			return err
+		}
+	} else {
+		// The leaf outlives the call, so it must not share the caller's array.
+		leaf = append([]byte(nil), digest...)
+	}
+
+	return b.push(leaf)
+}
+
+// AddDigests adds a leaf for each size-byte digest read from r, in order, until r is
+// exhausted, and returns how many it added. A trailing record shorter than size is
+// reported as io.ErrUnexpectedEOF, having added the leaves before it.
+func (b *Builder) AddDigests(r io.Reader, size int) (int, error) {
+	if size < 1 {
+		return 0, fmt.Errorf("error: digests must be at least one byte, got %d", size)
+	}
+	buf := make([]byte, size)
+	for added := 0; ; added++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if errors.Is(err, io.EOF) {
+				return added, nil
+			}
+			return added, err
+		}
+		if err := b.AddDigest(buf); err != nil {
+			return added, err
+		}
+	}
+}
+
+// push adds leaf as leaf n, joining it with every pending subtree it completes, which
+// are those at the levels of the low one bits of n. Nothing changes unless all of the
+// hashing succeeds.
+func (b *Builder) push(leaf []byte) error {
+	i := b.n
+	if b.store != nil {
+		if err := b.store.PutNode(0, i, leaf); err != nil {
+			return err
+		}
+	}
+
+	node, level := leaf, 0
+	for ; i>>level&1 == 1; level++ {
+		parent, err := b.cfg.appendInteriorHash(b.h, nil, b.pending[level], node)
+		if err != nil {
+			return err
+		}
+		node = parent
+		if b.store != nil {
+			if err := b.store.PutNode(level+1, i>>(level+1), node); err != nil {
+				return err
+			}
+		}
+	}
+
+	if level == len(b.pending) {
+		b.pending = append(b.pending, nil)
+	}
+	for l := 0; l < level; l++ {
+		b.pending[l] = nil
+	}
+	b.pending[level] = node
+	b.n++
+
+	return nil
+}
+
+// Finish returns the root of the tree over the leaves added, after which no more can be
+// added. Calling it again returns the same root. Returns ErrNoContent if no leaf was
+// added.
+//
+// The subtrees still pending are the nodes along the right edge of the tree, and are
+// joined bottom up with whatever the levels below leave over. A node left without a
+// right sibling is paired with itself, which is what the padding leaf of the default
+// construction amounts to, or under WithRFC6962 carried up unchanged.
+func (b *Builder) Finish() ([]byte, error) {
+	if b.root != nil {
+		return b.root, nil
+	}
+	if b.n == 0 {
+		return nil, ErrNoContent
+	}
+
+	n := b.n
+	height := b.cfg.storedHeight(n)
+	var carry []byte
+	for level := 0; level < height; level++ {
+		var left []byte
+		if n>>level&1 == 1 {
+			left = b.pending[level]
+		}
+
+		var err error
+		switch {
+		case left == nil && carry == nil:
+			continue
+		case left != nil && carry != nil:
+			carry, err = b.cfg.appendInteriorHash(b.h, nil, left, carry)
+		default:
+			if left != nil {
+				carry = left
+			}
+			if !b.cfg.rfc6962 {
+				carry, err = b.cfg.appendInteriorHash(b.h, nil, carry, carry)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b.store != nil {
+			if err := b.store.PutNode(level+1, (n-1)>>(level+1), carry); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if carry == nil {
+		carry = b.pending[height]
+	}
+	b.root, b.pending = carry, nil
+
+	return b.root, nil
+}
+
+// Tree finishes the builder if need be and returns the tree it retained, which must
+// have been created with NewBuilderWithStore.
+func (b *Builder) Tree() (*StoredTree, error) {
+	if b.store == nil {
+		return nil, errors.New("error: builder retains no tree; create it with NewBuilderWithStore")
+	}
+	root, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoredTree{cfg: b.cfg, store: b.store, size: b.n, root: root}, nil
+}
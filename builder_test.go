@@ -0,0 +1,170 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestBuilderMatchesTree checks a builder fed the leaves one at a time finishes with the
+// root NewTreeWithOptions builds from them, for every construction, and that the tree
+// it retains serves the MerkleTree's paths.
+func TestBuilderMatchesTree(t *testing.T) {
+	for _, mode := range propModes {
+		for _, n := range propSizes {
+			t.Run(fmt.Sprintf("%s/n=%d", mode.name, n), func(t *testing.T) {
+				contents := propSeries(n)
+				tree, err := mode.build(contents, sha256.New)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+
+				b, err := NewBuilderWithStore(NewMemoryNodeStore(), optsFor(mode, sha256.New)...)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				for i, c := range contents {
+					if i%2 == 0 {
+						err = b.Add(c)
+					} else {
+						err = b.AddDigest(c.(propContent).mustHash(t))
+					}
+					if err != nil {
+						t.Fatalf("error: leaf %d: %v", i, err)
+					}
+				}
+				if b.Len() != n {
+					t.Errorf("error: Len is %d, want %d", b.Len(), n)
+				}
+				root, err := b.Finish()
+				if err != nil {
+					t.Fatalf("error: Finish: %v", err)
+				}
+				if !bytes.Equal(root, tree.MerkleRoot()) {
+					t.Fatalf("error: root %x, MerkleTree %x", root, tree.MerkleRoot())
+				}
+
+				stored, err := b.Tree()
+				if err != nil {
+					t.Fatalf("error: Tree: %v", err)
+				}
+				for i := range contents {
+					path, index, err := stored.GetMerklePathByIndex(i)
+					if err != nil {
+						t.Fatalf("error: unexpected error: %v", err)
+					}
+					wantPath, wantIndex, err := tree.GetMerklePathByIndex(i)
+					if err != nil {
+						t.Fatalf("error: unexpected error: %v", err)
+					}
+					if !reflect.DeepEqual(path, wantPath) || !reflect.DeepEqual(index, wantIndex) {
+						t.Fatalf("error: leaf %d: retained path differs from the MerkleTree's", i)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestBuilderAddDigests checks leaves read from a stream give the root of the same
+// leaves added one by one, and that a torn final record is reported.
+func TestBuilderAddDigests(t *testing.T) {
+	contents := propSeries(21)
+	var stream bytes.Buffer
+	for _, c := range contents {
+		stream.Write(c.(propContent).mustHash(t))
+	}
+	tree, err := NewTreeWithOptions(contents, WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	b, err := NewBuilder(WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	added, err := b.AddDigests(&stream, sha256.Size)
+	if err != nil || added != len(contents) {
+		t.Fatalf("error: AddDigests returned %d, %v", added, err)
+	}
+	root, err := b.Finish()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(root, tree.MerkleRoot()) {
+		t.Errorf("error: streamed root %x, want %x", root, tree.MerkleRoot())
+	}
+
+	b, err = NewBuilder()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	added, err = b.AddDigests(bytes.NewReader(make([]byte, 2*sha256.Size+3)), sha256.Size)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || added != 2 || b.Len() != 2 {
+		t.Errorf("error: torn record: AddDigests returned %d, %v", added, err)
+	}
+	if _, err := b.AddDigests(&stream, 0); err == nil {
+		t.Error("error: expected a zero digest size to be refused")
+	}
+}
+
+// TestBuilderErrors covers what a builder refuses, and checks a refused leaf leaves it
+// as it was.
+func TestBuilderErrors(t *testing.T) {
+	if _, err := NewBuilder(WithSortedSiblings(), WithRFC6962()); err == nil {
+		t.Error("error: expected conflicting options to be refused")
+	}
+	if _, err := NewBuilderWithStore(nil); err == nil {
+		t.Error("error: expected a nil store to be refused")
+	}
+
+	b, err := NewBuilder()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if _, err := b.Finish(); !errors.Is(err, ErrNoContent) {
+		t.Errorf("error: expected ErrNoContent, got %v", err)
+	}
+	if _, err := b.Tree(); err == nil {
+		t.Error("error: expected Tree to be refused without a store")
+	}
+	for _, c := range propSeries(3) {
+		if err := b.Add(c); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+	}
+	if err := b.Add(nil); !errors.Is(err, ErrNilContent) {
+		t.Errorf("error: expected ErrNilContent, got %v", err)
+	}
+	if err := b.Add(failingContent{x: "x", failHash: true}); err == nil {
+		t.Error("error: expected a failing CalculateHash to be reported")
+	}
+	if b.Len() != 3 {
+		t.Errorf("error: refused leaves changed the length to %d", b.Len())
+	}
+
+	first, err := b.Finish()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	tree, err := NewTree(propSeries(3))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(first, tree.MerkleRoot()) {
+		t.Error("error: a refused leaf changed the root")
+	}
+	if err := b.Add(propContent{x: "late"}); err == nil {
+		t.Error("error: expected Add after Finish to be refused")
+	}
+	if again, err := b.Finish(); err != nil || !bytes.Equal(again, first) {
+		t.Errorf("error: second Finish returned %x, %v", again, err)
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrMalformedCheckpoint is returned when text is not a checkpoint or signed note in
+// the C2SP format. Test for it with errors.Is.
+var ErrMalformedCheckpoint = errors.New("error: checkpoint is malformed")
+
+const (
+	// signatureLinePrefix begins every signature line of a signed note: an em dash
+	// and a space.
+	signatureLinePrefix = "— "
+	// algEd25519 is the signed note signature type identifier for Ed25519.
+	algEd25519 = 0x01
+	// maxCheckpointSignatures bounds the signature lines a note may carry, as the
+	// Go checksum database's note package does.
+	maxCheckpointSignatures = 100
+)
+
+// Checkpoint is the body of a checkpoint as C2SP specifies it: the statement, by a log
+// identified by Origin, that the RFC 6962 tree of its first Size entries has root Hash.
+// It is the text form transparency logs publish their tree heads in, signed with the
+// C2SP signed note format, and tooling across the transparency ecosystem reads it.
+//
+// https://c2sp.org/tlog-checkpoint
+// https://c2sp.org/signed-note
+type Checkpoint struct {
+	// Origin identifies the log, conventionally by a schema-less URL such as
+	// example.com/log.
+	Origin string
+	// Size is the number of entries in the tree.
+	Size int
+	// Hash is the RFC 6962 root of the tree.
+	Hash []byte
+	// Extensions are any further lines of the body, without their newlines. Their
+	// meaning is up to the log, and they are covered by its signature.
+	Extensions []string
+}
+
+// Checkpoint returns the checkpoint of the tree as the log identified by origin. A
+// checkpoint is a statement about an RFC 6962 tree using SHA-256, so the tree must have
+// been built with WithRFC6962 and the default hash strategy; otherwise the returned
+// error wraps ErrConstructionMismatch. The hash is a copy of the root.
+func (m *MerkleTree) Checkpoint(origin string) (*Checkpoint, error) {
+	if m.empty() {
+		return nil, fmt.Errorf("%w: tree has no root", ErrMalformedTree)
+	}
+	if !m.IsRFC6962SHA256() {
+		return nil, fmt.Errorf("%w: a checkpoint describes an RFC 6962 tree using SHA-256", ErrConstructionMismatch)
+	}
+	c := &Checkpoint{Origin: origin, Size: m.contentCount(), Hash: slices.Clone(m.merkleRoot)}
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// FormatCheckpoint returns the text of c, the body a signed note signs: the origin, the
+// size in decimal and the hash in standard base64, then any extension lines, each line
+// ending in a newline.
+func FormatCheckpoint(c *Checkpoint) ([]byte, error) {
+	if c == nil {
+		return nil, fmt.Errorf("%w: checkpoint is nil", ErrMalformedCheckpoint)
+	}
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Origin)
+	b.WriteByte('\n')
+	b.WriteString(strconv.Itoa(c.Size))
+	b.WriteByte('\n')
+	b.WriteString(base64.StdEncoding.EncodeToString(c.Hash))
+	b.WriteByte('\n')
+	for _, ext := range c.Extensions {
+		b.WriteString(ext)
+		b.WriteByte('\n')
+	}
+
+	return []byte(b.String()), nil
+}
+
+// ParseCheckpoint parses the text of a checkpoint, as FormatCheckpoint writes it. It
+// takes the body alone; VerifyCheckpoint takes a signed note, and returns the checkpoint
+// in it only once a signature verifies.
+//
+// Parsing is strict, as the specification asks: the size must be in canonical decimal
+// form, the hash in padded standard base64, and every line, the last included, must end
+// in a newline. Returns an error wrapping ErrMalformedCheckpoint otherwise.
+func ParseCheckpoint(text []byte) (*Checkpoint, error) {
+	if err := checkNoteText(text); err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(text[:len(text)-1]), "\n")
+	if len(lines) < 3 {
+		return nil, fmt.Errorf("%w: a checkpoint has at least 3 lines, got %d", ErrMalformedCheckpoint, len(lines))
+	}
+
+	size, err := strconv.ParseUint(lines[1], 10, 63)
+	if err != nil || strconv.FormatUint(size, 10) != lines[1] {
+		return nil, fmt.Errorf("%w: size %q is not a canonical decimal number", ErrMalformedCheckpoint, lines[1])
+	}
+	hash, err := base64.StdEncoding.Strict().DecodeString(lines[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: hash %q is not standard base64", ErrMalformedCheckpoint, lines[2])
+	}
+
+	c := &Checkpoint{Origin: lines[0], Size: int(size), Hash: hash}
+	if len(lines) > 3 {
+		c.Extensions = lines[3:]
+	}
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// check reports whether c can be written as a checkpoint and read back unchanged.
+func (c *Checkpoint) check() error {
+	switch {
+	case c.Origin == "" || strings.ContainsRune(c.Origin, '\n'):
+		return fmt.Errorf("%w: origin %q is empty or spans lines", ErrMalformedCheckpoint, c.Origin)
+	case c.Size < 0:
+		return fmt.Errorf("%w: size %d is negative", ErrMalformedCheckpoint, c.Size)
+	case len(c.Hash) == 0:
+		return fmt.Errorf("%w: hash is empty", ErrMalformedCheckpoint)
+	}
+	for _, ext := range c.Extensions {
+		if ext == "" || strings.ContainsRune(ext, '\n') {
+			return fmt.Errorf("%w: extension line %q is empty or spans lines", ErrMalformedCheckpoint, ext)
+		}
+	}
+
+	return nil
+}
+
+// SignCheckpoint returns c as a signed note, signed by key under the key name name. The
+// note is the checkpoint text, a blank line, and a signature line of the form the C2SP
+// signed note format gives Ed25519 keys, so any verifier of that format can check it
+// given the key as CheckpointVerifierKey writes it. The name is conventionally the
+// checkpoint's origin.
+func SignCheckpoint(c *Checkpoint, name string, key ed25519.PrivateKey) ([]byte, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("error: ed25519 private key is %d bytes, want %d", len(key), ed25519.PrivateKeySize)
+	}
+	if err := checkKeyName(name); err != nil {
+		return nil, err
+	}
+	text, err := FormatCheckpoint(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNoteText(text); err != nil {
+		return nil, err
+	}
+
+	sig := binary.BigEndian.AppendUint32(nil, noteKeyHash(name, key.Public().(ed25519.PublicKey)))
+	sig = append(sig, ed25519.Sign(key, text)...)
+
+	note := append(text, '\n')
+	note = append(note, signatureLinePrefix+name+" "+base64.StdEncoding.EncodeToString(sig)...)
+
+	return append(note, '\n'), nil
+}
+
+// VerifyCheckpoint checks that note carries a valid signature by the Ed25519 key pub
+// under the key name name, and returns the checkpoint it signs. Signatures by other keys,
+// such as a witness's cosignatures, are allowed and ignored.
+//
+// Returns an error wrapping ErrInvalidSignature when the note has no signature by the
+// key or the one it has does not verify, and ErrMalformedCheckpoint when the note or
+// the checkpoint in it is malformed. A verified checkpoint is the log's word for its
+// root at that size, and the caller should still check its Origin is the log expected.
+func VerifyCheckpoint(note []byte, name string, pub ed25519.PublicKey) (*Checkpoint, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("error: ed25519 public key is %d bytes, want %d", len(pub), ed25519.PublicKeySize)
+	}
+	split := bytes.LastIndex(note, []byte("\n\n"))
+	if split < 0 {
+		return nil, fmt.Errorf("%w: a signed note has a blank line between its text and its signatures", ErrMalformedCheckpoint)
+	}
+	text, sigs := note[:split+1], note[split+2:]
+	if len(sigs) == 0 || sigs[len(sigs)-1] != '\n' {
+		return nil, fmt.Errorf("%w: a signed note ends in a newline", ErrMalformedCheckpoint)
+	}
+
+	lines := strings.Split(string(sigs[:len(sigs)-1]), "\n")
+	if len(lines) > maxCheckpointSignatures {
+		return nil, fmt.Errorf("%w: note has %d signatures, more than %d", ErrMalformedCheckpoint, len(lines), maxCheckpointSignatures)
+	}
+	hash := noteKeyHash(name, pub)
+	verified := false
+	for _, line := range lines {
+		rest, ok := strings.CutPrefix(line, signatureLinePrefix)
+		if !ok {
+			return nil, fmt.Errorf("%w: signature line %q does not begin with an em dash", ErrMalformedCheckpoint, line)
+		}
+		sigName, b64, ok := strings.Cut(rest, " ")
+		sig, err := base64.StdEncoding.Strict().DecodeString(b64)
+		if !ok || checkKeyName(sigName) != nil || err != nil || len(sig) < 5 {
+			return nil, fmt.Errorf("%w: malformed signature line %q", ErrMalformedCheckpoint, line)
+		}
+		if sigName != name || binary.BigEndian.Uint32(sig) != hash {
+			continue
+		}
+		if !ed25519.Verify(pub, text, sig[4:]) {
+			return nil, fmt.Errorf("%w: signature by %s does not verify", ErrInvalidSignature, name)
+		}
+		verified = true
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: note has no signature by %s", ErrInvalidSignature, name)
+	}
+
+	return ParseCheckpoint(text)
+}
+
+// CheckpointVerifierKey returns the verifier key for pub under the key name name, in
+// the form signed note tooling exchanges keys in: the name, the key hash in hex, and the
+// base64 of the algorithm byte and the key, joined by plus signs.
+func CheckpointVerifierKey(name string, pub ed25519.PublicKey) (string, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("error: ed25519 public key is %d bytes, want %d", len(pub), ed25519.PublicKeySize)
+	}
+	if err := checkKeyName(name); err != nil {
+		return "", err
+	}
+	key := append([]byte{algEd25519}, pub...)
+
+	return fmt.Sprintf("%s+%08x+%s", name, noteKeyHash(name, pub), base64.StdEncoding.EncodeToString(key)), nil
+}
+
+// ParseCheckpointVerifierKey parses a verifier key as CheckpointVerifierKey writes it,
+// and returns the key name and the Ed25519 public key. It checks the key hash the string
+// carries against the name and key, so a key pasted from elsewhere is checked whole.
+func ParseCheckpointVerifierKey(vkey string) (string, ed25519.PublicKey, error) {
+	// The name cannot hold a plus sign and the hash is hex, so only the first two
+	// delimit fields; the base64 key may hold more.
+	parts := strings.SplitN(vkey, "+", 3)
+	if len(parts) != 3 {
+		return "", nil, fmt.Errorf("%w: verifier key has %d fields, want 3", ErrMalformedCheckpoint, len(parts))
+	}
+	name := parts[0]
+	hash, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil || len(parts[1]) != 8 {
+		return "", nil, fmt.Errorf("%w: verifier key hash %q is not 8 hex digits", ErrMalformedCheckpoint, parts[1])
+	}
+	key, err := base64.StdEncoding.Strict().DecodeString(parts[2])
+	if err != nil || len(key) != 1+ed25519.PublicKeySize || key[0] != algEd25519 {
+		return "", nil, fmt.Errorf("%w: verifier key is not an Ed25519 key", ErrMalformedCheckpoint)
+	}
+	if err := checkKeyName(name); err != nil {
+		return "", nil, err
+	}
+	pub := ed25519.PublicKey(key[1:])
+	if uint32(hash) != noteKeyHash(name, pub) {
+		return "", nil, fmt.Errorf("%w: verifier key hash does not match its name and key", ErrMalformedCheckpoint)
+	}
+
+	return name, pub, nil
+}
+
+// noteKeyHash returns the key hash that identifies an Ed25519 key in signature lines:
+// the first four bytes of SHA-256 over the key name, a newline, the algorithm byte and
+// the key.
+func noteKeyHash(name string, pub ed25519.PublicKey) uint32 {
+	h := sha256.New()
+	h.Write([]byte(name))
+	h.Write([]byte{'\n', algEd25519})
+	h.Write(pub)
+
+	return binary.BigEndian.Uint32(h.Sum(nil))
+}
+
+// checkKeyName reports whether name can name a key: non-empty, valid UTF-8, and free of
+// spaces and plus signs, which delimit it in signature lines and verifier keys.
+func checkKeyName(name string) error {
+	if name == "" || !utf8.ValidString(name) || strings.ContainsFunc(name, unicode.IsSpace) || strings.ContainsRune(name, '+') {
+		return fmt.Errorf("%w: key name %q must be non-empty UTF-8 without spaces or plus signs", ErrMalformedCheckpoint, name)
+	}
+
+	return nil
+}
+
+// checkNoteText reports whether text can be the text of a signed note: valid UTF-8 of
+// non-empty lines each ending in a newline, with no other control characters.
+func checkNoteText(text []byte) error {
+	if len(text) == 0 || text[len(text)-1] != '\n' {
+		return fmt.Errorf("%w: text must end in a newline", ErrMalformedCheckpoint)
+	}
+	if !utf8.Valid(text) {
+		return fmt.Errorf("%w: text is not valid UTF-8", ErrMalformedCheckpoint)
+	}
+	if bytes.Contains(text, []byte("\n\n")) || text[0] == '\n' {
+		return fmt.Errorf("%w: text has a blank line", ErrMalformedCheckpoint)
+	}
+	for _, r := range string(text) {
+		if r != '\n' && (unicode.IsControl(r) || r == utf8.RuneError) {
+			return fmt.Errorf("%w: text contains control character %U", ErrMalformedCheckpoint, r)
+		}
+	}
+
+	return nil
+}
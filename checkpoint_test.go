@@ -0,0 +1,212 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// specCheckpoint is the example checkpoint body from https://c2sp.org/tlog-checkpoint.
+const specCheckpoint = "example.com/behind-the-sofa\n20852163\nCsUYapGGPo4dkMgIAUqom/Xajj7h2fB2MPA3j2jxq2I=\n"
+
+func TestCheckpointFormatParse(t *testing.T) {
+	c, err := ParseCheckpoint([]byte(specCheckpoint))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if c.Origin != "example.com/behind-the-sofa" || c.Size != 20852163 || len(c.Hash) != 32 || c.Extensions != nil {
+		t.Errorf("error: parsed %+v", c)
+	}
+	text, err := FormatCheckpoint(c)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if string(text) != specCheckpoint {
+		t.Errorf("error: formatted %q, want %q", text, specCheckpoint)
+	}
+
+	c.Extensions = []string{"ext one", "ext two"}
+	text, _ = FormatCheckpoint(c)
+	got, err := ParseCheckpoint(text)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("error: round trip gave %+v, want %+v", got, c)
+	}
+}
+
+func TestParseCheckpointRejects(t *testing.T) {
+	for name, text := range map[string]string{
+		"empty":              "",
+		"no final newline":   strings.TrimSuffix(specCheckpoint, "\n"),
+		"two lines":          "origin\n5\n",
+		"leading zero":       "origin\n05\nAAAA\n",
+		"signed size":        "origin\n+5\nAAAA\n",
+		"negative size":      "origin\n-5\nAAAA\n",
+		"size overflow":      "origin\n18446744073709551616\nAAAA\n",
+		"unpadded base64":    "origin\n5\nAAA\n",
+		"url base64":         "origin\n5\n-_-_\n",
+		"empty hash":         "origin\n5\n\n",
+		"empty origin":       "\n5\nAAAA\n",
+		"blank extension":    "origin\n5\nAAAA\n\next\n",
+		"control character":  "origin\x01\n5\nAAAA\n",
+		"invalid utf8":       "origin\xff\n5\nAAAA\n",
+		"carriage return":    "origin\r\n5\nAAAA\n",
+		"trailing blank":     specCheckpoint + "\n",
+		"signed note passed": specCheckpoint + "\n— name AAAA\n",
+	} {
+		if _, err := ParseCheckpoint([]byte(text)); !errors.Is(err, ErrMalformedCheckpoint) {
+			t.Errorf("error: %s: got %v, want ErrMalformedCheckpoint", name, err)
+		}
+	}
+}
+
+func TestCheckpointSignVerify(t *testing.T) {
+	pub, key := testKey(1)
+	otherPub, otherKey := testKey(2)
+	tree, err := NewTreeWithOptions(propSeries(17), WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	c, err := tree.Checkpoint("example.com/log")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if c.Size != 17 || !bytes.Equal(c.Hash, tree.MerkleRoot()) {
+		t.Fatalf("error: checkpoint does not describe the tree: %+v", c)
+	}
+	note, err := SignCheckpoint(c, "example.com/log", key)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	got, err := VerifyCheckpoint(note, "example.com/log", pub)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("error: verified %+v, want %+v", got, c)
+	}
+
+	// A cosignature by another key is ignored by the log's verifier and verifies under
+	// its own.
+	cosigned, _ := SignCheckpoint(c, "witness", otherKey)
+	both := append(bytes.Clone(note), cosigned[bytes.LastIndex(cosigned, []byte("\n\n"))+2:]...)
+	if _, err := VerifyCheckpoint(both, "example.com/log", pub); err != nil {
+		t.Errorf("error: cosigned note: unexpected error: %v", err)
+	}
+	if _, err := VerifyCheckpoint(both, "witness", otherPub); err != nil {
+		t.Errorf("error: cosigned note under the witness key: unexpected error: %v", err)
+	}
+
+	if _, err := VerifyCheckpoint(note, "example.com/log", otherPub); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("error: other key: got %v, want ErrInvalidSignature", err)
+	}
+	if _, err := VerifyCheckpoint(note, "other.com/log", pub); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("error: other name: got %v, want ErrInvalidSignature", err)
+	}
+	tampered := bytes.Replace(note, []byte("\n17\n"), []byte("\n18\n"), 1)
+	if _, err := VerifyCheckpoint(tampered, "example.com/log", pub); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("error: tampered size: got %v, want ErrInvalidSignature", err)
+	}
+	for name, bad := range map[string][]byte{
+		"no signatures":   []byte(specCheckpoint),
+		"no final line":   bytes.TrimSuffix(note, []byte("\n")),
+		"no em dash":      bytes.Replace(note, []byte("— "), []byte("- "), 1),
+		"bad base64":      append(bytes.Clone(note), "— witness !!!!\n"...),
+		"short signature": append(bytes.Clone(note), "— witness AAAA\n"...),
+	} {
+		if _, err := VerifyCheckpoint(bad, "example.com/log", pub); !errors.Is(err, ErrMalformedCheckpoint) {
+			t.Errorf("error: %s: got %v, want ErrMalformedCheckpoint", name, err)
+		}
+	}
+
+	if _, err := SignCheckpoint(c, "has space", key); !errors.Is(err, ErrMalformedCheckpoint) {
+		t.Errorf("error: bad key name: got %v, want ErrMalformedCheckpoint", err)
+	}
+	if _, err := SignCheckpoint(&Checkpoint{Origin: "o\x00", Size: 1, Hash: []byte{1}}, "name", key); !errors.Is(err, ErrMalformedCheckpoint) {
+		t.Errorf("error: control character: got %v, want ErrMalformedCheckpoint", err)
+	}
+}
+
+func TestCheckpointVerifierKey(t *testing.T) {
+	pub, _ := testKey(1)
+	vkey, err := CheckpointVerifierKey("example.com/log", pub)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	name, got, err := ParseCheckpointVerifierKey(vkey)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if name != "example.com/log" || !bytes.Equal(got, pub) {
+		t.Errorf("error: parsed %q %x, want %q %x", name, got, "example.com/log", pub)
+	}
+
+	otherPub, _ := testKey(2)
+	otherKey, _ := CheckpointVerifierKey("example.com/log", otherPub)
+	mixed := vkey[:strings.LastIndex(vkey, "+")] + otherKey[strings.LastIndex(otherKey, "+"):]
+	for name, bad := range map[string]string{
+		"fields":     "example.com/log+abcd",
+		"hash":       strings.Replace(vkey, "+", "+0", 1),
+		"wrong hash": mixed,
+		"not base64": vkey + "!",
+	} {
+		if _, _, err := ParseCheckpointVerifierKey(bad); !errors.Is(err, ErrMalformedCheckpoint) {
+			t.Errorf("error: %s: got %v, want ErrMalformedCheckpoint", name, err)
+		}
+	}
+}
+
+func TestCheckpointRequiresRFC6962SHA256(t *testing.T) {
+	for name, opts := range map[string][]TreeOption{
+		"default": nil,
+		"sorted":  {WithSortedSiblings()},
+		"sha512":  {WithRFC6962(), WithHasher(sha512.New)},
+	} {
+		tree, err := NewTreeWithOptions(propSeries(4), opts...)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if tree.IsRFC6962SHA256() {
+			t.Errorf("error: %s: IsRFC6962SHA256 returned true", name)
+		}
+		if _, err := tree.Checkpoint("example.com/log"); !errors.Is(err, ErrConstructionMismatch) {
+			t.Errorf("error: %s: got %v, want ErrConstructionMismatch", name, err)
+		}
+	}
+}
+
+// TestIsRFC6962SHA256 checks the construction is read from the tree rather than from its
+// content, so trees without content qualify.
+func TestIsRFC6962SHA256(t *testing.T) {
+	cs := propSeries(4)
+	fromDigests, err := NewTreeFromDigests(contentDigests(t, cs), WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	discarded, err := NewTreeWithOptions(cs, WithRFC6962(), WithDiscardContent())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	var decoded MerkleTree
+	data, _ := fromDigests.MarshalBinary()
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for name, tree := range map[string]*MerkleTree{"from digests": fromDigests, "discarded": discarded, "decoded": &decoded} {
+		if !tree.IsRFC6962SHA256() {
+			t.Errorf("error: %s: IsRFC6962SHA256 returned false", name)
+		}
+		if _, err := tree.Checkpoint("example.com/log"); err != nil {
+			t.Errorf("error: %s: Checkpoint: unexpected error: %v", name, err)
+		}
+	}
+}
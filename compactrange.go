@@ -0,0 +1,194 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"reflect"
+)
+
+// CompactRange is the least state from which an append-only RFC 6962 tree can go on
+// growing: for the leaves in [Begin, End), the hashes of the perfect subtrees that
+// cover the range, at most two per level. A range beginning at zero holds only the right
+// frontier of the tree, O(log n) hashes however many leaves have been added, and its
+// Root is the root NewTreeWithOptions with WithRFC6962 would build from the same leaves,
+// without a single Node in memory.
+//
+// Ranges that meet can be merged, so a log can hash disjoint stretches of leaves
+// separately, in parallel or on different machines, and join the results. The
+// representation is the one Certificate Transparency logs use, and the oracle tests
+// check it against theirs hash for hash.
+//
+// The zero value is not usable; create ranges with NewCompactRange or
+// NewCompactRangeAt. A CompactRange is safe for concurrent reads, and writes must not
+// run concurrently with anything else.
+type CompactRange struct {
+	// cfg carries the hash strategy, with the RFC 6962 construction selected, and
+	// provides the leaf and interior hashing.
+	cfg        *MerkleTree
+	begin, end uint64
+	// hashes are the roots of the perfect subtrees covering [begin, end), left to
+	// right. Their sizes rise and then fall, each subtree being the largest that
+	// begins where the one before it ends and fits within the range.
+	hashes [][]byte
+}
+
+// NewCompactRange returns an empty range beginning at leaf zero, to which a tree's
+// leaves can be appended from the first. It takes the same options as NewTreeWithOptions,
+// of which WithHasher is the one that matters: the construction is RFC 6962 whatever the
+// options say, so they need not include WithRFC6962 and cannot include
+// WithSortedSiblings.
+func NewCompactRange(opts ...TreeOption) (*CompactRange, error) {
+	return NewCompactRangeAt(0, opts...)
+}
+
+// NewCompactRangeAt returns an empty range beginning at leaf begin, to be filled with
+// the leaves from there on and merged into the range that ends there.
+func NewCompactRangeAt(begin int, opts ...TreeOption) (*CompactRange, error) {
+	if begin < 0 {
+		return nil, fmt.Errorf("error: a compact range cannot begin at leaf %d", begin)
+	}
+	cfg, err := configFromOptions(append(opts[:len(opts):len(opts)], WithRFC6962()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &CompactRange{cfg: cfg, begin: uint64(begin), end: uint64(begin)}, nil
+}
+
+// Begin returns the index of the first leaf of the range.
+func (r *CompactRange) Begin() int {
+	return int(r.begin)
+}
+
+// End returns the index one past the last leaf of the range, the leaf the next append
+// adds.
+func (r *CompactRange) End() int {
+	return int(r.end)
+}
+
+// Hashes returns the roots of the perfect subtrees covering the range, left to right.
+// The slices are the range's own; treat them as read only.
+func (r *CompactRange) Hashes() [][]byte {
+	return r.hashes
+}
+
+// Append adds the leaf holding c to the end of the range.
+func (r *CompactRange) Append(c Content) error {
+	if c == nil {
+		return ErrNilContent
+	}
+	leaf, err := r.cfg.hashLeaf(c)
+	if err != nil {
+		return err
+	}
+
+	return r.merge(r.end+1, leaf, nil)
+}
+
+// AppendDigest adds the leaf whose content hashes to digest to the end of the range.
+// As with VerifyProofWithDigest, digest is the value Content.CalculateHash returns, and
+// the leaf prefix is applied here; see WithRFC6962 for what that means for leaves from
+// another implementation.
+func (r *CompactRange) AppendDigest(digest []byte) error {
+	leaf, err := r.cfg.appendLeafDigest(r.cfg.hashStrategy(), nil, digest)
+	if err != nil {
+		return err
+	}
+
+	return r.merge(r.end+1, leaf, nil)
+}
+
+// Merge appends other, which must begin where r ends and use the same hash strategy,
+// so that r covers both ranges. other is not modified.
+func (r *CompactRange) Merge(other *CompactRange) error {
+	if other.begin != r.end {
+		return fmt.Errorf("error: cannot merge a range beginning at leaf %d into one ending at leaf %d", other.begin, r.end)
+	}
+	if reflect.ValueOf(r.cfg.hashStrategy).Pointer() != reflect.ValueOf(other.cfg.hashStrategy).Pointer() {
+		return fmt.Errorf("%w: the ranges use different hash strategies", ErrConstructionMismatch)
+	}
+	if len(other.hashes) == 0 {
+		return nil
+	}
+
+	return r.merge(other.end, other.hashes[0], other.hashes[1:])
+}
+
+// merge extends the range to end by appending the subtree hash seed, which covers the
+// leaves from r.end, and the hashes after it, which cover the rest.
+//
+// Joining two ranges only ever hashes along one path: the nodes that end up straddling
+// the old end are built by merging seed, level by level, with the trailing hashes of r
+// and the leading hashes of the other range. Bit h of the old end, shifted past its
+// trailing zeros, says which: a one means the node to the left of seed at that level is
+// r's last remaining hash, a zero that the node to its right is the other range's next.
+// The path stops at the highest level where the joined node still lies within both
+// the new range and the old, which is where the two ends' bits first differ.
+func (r *CompactRange) merge(end uint64, seed []byte, hashes [][]byte) error {
+	mid := r.end
+	low := bits.TrailingZeros64(mid)
+	high := 64
+	if r.begin != 0 {
+		high = bits.Len64(mid ^ (r.begin - 1))
+	}
+	if n := bits.Len64((mid - 1) ^ end); n < high {
+		high = n
+	}
+	high--
+	if high < low {
+		high = low
+	}
+
+	index := mid >> low
+	ones := bits.OnesCount64(index & (1<<(high-low) - 1))
+	if ones > len(r.hashes) || high-low-ones > len(hashes) {
+		return errors.New("error: compact range is inconsistent with its bounds")
+	}
+
+	h := r.cfg.hashStrategy()
+	left, right := len(r.hashes), 0
+	for l := low; l < high; l++ {
+		var err error
+		if index&1 == 0 {
+			seed, err = r.cfg.appendInteriorHash(h, nil, seed, hashes[right])
+			right++
+		} else {
+			left--
+			seed, err = r.cfg.appendInteriorHash(h, nil, r.hashes[left], seed)
+		}
+		if err != nil {
+			return err
+		}
+		index >>= 1
+	}
+
+	// The merged hashes go into a fresh slice, so an error above leaves r as it was
+	// and hashes returned by an earlier call to Hashes keep their values.
+	merged := make([][]byte, 0, left+1+len(hashes)-right)
+	merged = append(merged, r.hashes[:left]...)
+	merged = append(merged, seed)
+	merged = append(merged, hashes[right:]...)
+	r.hashes = merged
+	r.end = end
+
+	return nil
+}
+
+// Root returns the root of the tree over the leaves of the range, which must begin at
+// zero. It is the root NewTreeWithOptions would build from the same leaves with
+// WithRFC6962 and the same hash strategy. An empty range has the RFC's root for no
+// leaves, the hash of the empty string.
+func (r *CompactRange) Root() ([]byte, error) {
+	if r.begin != 0 {
+		return nil, fmt.Errorf("error: a range beginning at leaf %d has no tree root; merge it into one beginning at zero", r.begin)
+	}
+	if len(r.hashes) == 0 {
+		return r.cfg.hashStrategy().Sum(nil), nil
+	}
+
+	return r.cfg.bagPeaks(r.hashes)
+}
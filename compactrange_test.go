@@ -0,0 +1,187 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"testing"
+)
+
+// TestCompactRangeMatchesTree checks the root of a range filled one leaf at a time is
+// the RFC 6962 root of the tree over the same leaves at every size, and that the range
+// never holds more than its frontier.
+func TestCompactRangeMatchesTree(t *testing.T) {
+	const n = 70
+	contents := propSeries(n)
+	r, err := NewCompactRange(WithHasher(sha512.New))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	root, err := r.Root()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if want := sha512.New().Sum(nil); !bytes.Equal(root, want) {
+		t.Errorf("error: empty root is %x, want %x", root, want)
+	}
+
+	for size := 1; size <= n; size++ {
+		if size%2 == 0 {
+			err = r.Append(contents[size-1])
+		} else {
+			err = r.AppendDigest(contents[size-1].(propContent).mustHash(t))
+		}
+		if err != nil {
+			t.Fatalf("error: append %d: %v", size, err)
+		}
+		tree, err := NewTreeWithOptions(contents[:size], WithRFC6962(), WithHasher(sha512.New))
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		root, err := r.Root()
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if !bytes.Equal(root, tree.MerkleRoot()) {
+			t.Fatalf("error: size %d: root %x, tree %x", size, root, tree.MerkleRoot())
+		}
+		if got, want := len(r.Hashes()), bits.OnesCount(uint(size)); got != want {
+			t.Errorf("error: size %d: range holds %d hashes, want %d", size, got, want)
+		}
+		if r.Begin() != 0 || r.End() != size {
+			t.Errorf("error: size %d: range is [%d, %d)", size, r.Begin(), r.End())
+		}
+	}
+}
+
+// TestCompactRangeMerge checks that splitting the leaves into ranges anywhere, filling
+// each separately and merging them in order gives the range filled in one go.
+func TestCompactRangeMerge(t *testing.T) {
+	const n = 50
+	contents := propSeries(n)
+	whole, err := NewCompactRange()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for _, c := range contents {
+		if err := whole.Append(c); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+	}
+	want, err := whole.Root()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	rng := rand.New(rand.NewSource(10))
+	for trial := 0; trial < 50; trial++ {
+		cuts := []int{0}
+		for c := rng.Intn(n); c < n; c += 1 + rng.Intn(n/3) {
+			if c > cuts[len(cuts)-1] {
+				cuts = append(cuts, c)
+			}
+		}
+		cuts = append(cuts, n)
+
+		var parts []*CompactRange
+		for k := 0; k+1 < len(cuts); k++ {
+			part, err := NewCompactRangeAt(cuts[k])
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			for _, c := range contents[cuts[k]:cuts[k+1]] {
+				if err := part.Append(c); err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+			}
+			parts = append(parts, part)
+		}
+
+		// Merge from the right as well as the left, since a range not beginning
+		// at zero takes merges too.
+		for len(parts) > 1 {
+			k := rng.Intn(len(parts) - 1)
+			if err := parts[k].Merge(parts[k+1]); err != nil {
+				t.Fatalf("error: trial %d: Merge: %v", trial, err)
+			}
+			parts = append(parts[:k+1], parts[k+2:]...)
+		}
+		got, err := parts[0].Root()
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("error: trial %d: cuts %v: merged root %x, want %x", trial, cuts, got, want)
+		}
+		if fmt.Sprint(parts[0].Hashes()) != fmt.Sprint(whole.Hashes()) {
+			t.Fatalf("error: trial %d: cuts %v: merged hashes differ", trial, cuts)
+		}
+	}
+}
+
+// TestCompactRangeErrors covers the merges and roots that do not exist, and checks a
+// refused change leaves the range as it was.
+func TestCompactRangeErrors(t *testing.T) {
+	if _, err := NewCompactRangeAt(-1); err == nil {
+		t.Error("error: expected a negative beginning to be rejected")
+	}
+	if _, err := NewCompactRange(WithSortedSiblings()); err == nil {
+		t.Error("error: expected WithSortedSiblings to be rejected")
+	}
+
+	left, err := NewCompactRange(WithHasher(sha256.New))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for _, c := range propSeries(5) {
+		if err := left.Append(c); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+	}
+	before := fmt.Sprint(left.Hashes())
+
+	gap, err := NewCompactRangeAt(6)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := left.Merge(gap); err == nil {
+		t.Error("error: expected a range that does not meet to be refused")
+	}
+	other, err := NewCompactRangeAt(5, WithHasher(sha512.New))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := other.Append(propContent{x: "x"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := left.Merge(other); !errors.Is(err, ErrConstructionMismatch) {
+		t.Errorf("error: expected ErrConstructionMismatch, got %v", err)
+	}
+	if _, err := other.Root(); err == nil {
+		t.Error("error: expected Root to be refused for a range not beginning at zero")
+	}
+	if err := left.Append(nil); !errors.Is(err, ErrNilContent) {
+		t.Errorf("error: expected ErrNilContent, got %v", err)
+	}
+	if err := left.Append(failingContent{x: "y", failHash: true}); err == nil {
+		t.Error("error: expected a failing CalculateHash to be reported")
+	}
+	if fmt.Sprint(left.Hashes()) != before || left.End() != 5 {
+		t.Error("error: a refused change modified the range")
+	}
+
+	empty, err := NewCompactRangeAt(5, WithHasher(sha256.New))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := left.Merge(empty); err != nil || left.End() != 5 {
+		t.Errorf("error: merging an empty range returned %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrNotRFC6962 is returned by operations that RFC 6962 defines and the other
+// constructions do not, such as consistency proofs, when they are asked of a tree or a
+// verifier configuration built without WithRFC6962. Test for it with errors.Is.
+var ErrNotRFC6962 = errors.New("error: operation requires the RFC 6962 construction")
+
+// ConsistencyProof returns the RFC 6962 consistency proof that the tree formed by the
+// first size leaves of this tree is a prefix of the whole tree: the PROOF(m, D[n])
+// of section 2.1.2, with m being size and n the leaf count.
+//
+// This is what an append-only log hands a client that last saw it at an earlier size.
+// With the root it remembers and the root it has just been given, the client checks the
+// proof with VerifyConsistency and learns that nothing it saw before has been rewritten,
+// only appended to.
+//
+// The tree must have been built with WithRFC6962, otherwise the returned error wraps
+// ErrNotRFC6962: the default construction pads odd levels by duplication, so the tree
+// over a prefix is not a subtree of the tree over the whole and there is nothing to
+// prove. A size equal to the leaf count yields an empty proof. A size below one is
+// rejected, because this package does not represent an empty tree.
+//
+// The returned hashes are the tree's own, not copies; treat them as read only.
+func (m *MerkleTree) ConsistencyProof(size int) ([][]byte, error) {
+	if !m.rfc6962 {
+		return nil, fmt.Errorf("%w: the tree was not built with WithRFC6962", ErrNotRFC6962)
+	}
+	if m.empty() {
+		return nil, fmt.Errorf("%w: tree has no root", ErrMalformedTree)
+	}
+	if size < 1 || size > m.leafCount() {
+		return nil, fmt.Errorf("error: no consistency proof for size %d, the tree has %d leaves", size, m.leafCount())
+	}
+	if m.flat != nil {
+		return m.flat.appendFlatSubproof(make([][]byte, 0, consistencyProofLen(size, m.leafCount(), true)), 0, size, m.leafCount(), true), nil
+	}
+
+	return appendSubproof(make([][]byte, 0, consistencyProofLen(size, len(m.Leafs), true)), m.Root, size, len(m.Leafs), true)
+}
+
+// appendSubproof appends SUBPROOF(size, D[count], complete) for the subtree rooted at n,
+// which covers count leaves, to proof.
+//
+// RFC 6962 splits every node list at the largest power of two below its length, and the
+// recursion here splits at the same place, so every range it visits is exactly the range
+// beneath one node of the tree. That is what lets it read each MTH it needs straight off
+// a node rather than recomputing it.
+//
+// https://datatracker.ietf.org/doc/html/rfc6962#section-2.1.2
+func appendSubproof(proof [][]byte, n *Node, size, count int, complete bool) ([][]byte, error) {
+	if size == count {
+		if !complete {
+			proof = append(proof, n.Hash)
+		}
+
+		return proof, nil
+	}
+	if n.Left == nil || n.Right == nil {
+		return nil, fmt.Errorf("%w: interior node is missing a child", ErrMalformedTree)
+	}
+
+	k := largestPowerOfTwoBelow(count)
+	var err error
+	if size <= k {
+		if proof, err = appendSubproof(proof, n.Left, size, k, complete); err != nil {
+			return nil, err
+		}
+
+		return append(proof, n.Right.Hash), nil
+	}
+	if proof, err = appendSubproof(proof, n.Right, size-k, count-k, false); err != nil {
+		return nil, err
+	}
+
+	return append(proof, n.Left.Hash), nil
+}
+
+// consistencyProofLen returns how many hashes SUBPROOF(size, D[count], complete)
+// produces. It follows the same recursion as appendSubproof without touching a tree, so
+// the verifier can reject a proof of the wrong length before hashing anything, and the
+// prover can size its slice exactly.
+func consistencyProofLen(size, count int, complete bool) int {
+	n := 0
+	for size != count {
+		k := largestPowerOfTwoBelow(count)
+		if size > k {
+			size, count, complete = size-k, count-k, false
+		} else {
+			count = k
+		}
+		n++
+	}
+	if !complete {
+		n++
+	}
+
+	return n
+}
+
+// VerifyConsistency reports whether proof shows that the RFC 6962 tree of oldSize leaves
+// with root oldRoot is a prefix of the tree of newSize leaves with root newRoot. It is
+// the verification half of MerkleTree.ConsistencyProof, and like VerifyProof it needs no
+// tree: a log client holds two roots and a proof, and nothing else.
+//
+// The algorithm is the one given in RFC 9162 section 2.1.4.2, which verifies the proofs
+// RFC 6962 section 2.1.2 defines. The options describe the construction the roots were
+// computed under and must include WithRFC6962, since consistency is not defined for the
+// other constructions; the returned error wraps ErrNotRFC6962 otherwise. WithHasher
+// selects the hash strategy as usual.
+//
+// Returns false when the proof is the right shape and does not connect the two roots,
+// and an error wrapping ErrMalformedProof when it cannot be the proof for these sizes at
+// all: sizes out of order or below one, or a proof of the wrong length.
+//
+// As with VerifyProof, a verified proof establishes a relationship between two roots
+// and nothing about whether either of them is a root worth trusting.
+//
+// https://datatracker.ietf.org/doc/html/rfc9162#section-2.1.4.2
+func VerifyConsistency(oldRoot, newRoot []byte, oldSize, newSize int, proof [][]byte, opts ...TreeOption) (bool, error) {
+	cfg, err := configFromOptions(opts)
+	if err != nil {
+		return false, err
+	}
+	if !cfg.rfc6962 {
+		return false, fmt.Errorf("%w: pass WithRFC6962 to verify a consistency proof", ErrNotRFC6962)
+	}
+	if oldSize < 1 || newSize < oldSize {
+		return false, fmt.Errorf("%w: no consistency proof exists from size %d to size %d", ErrMalformedProof, oldSize, newSize)
+	}
+	if want := consistencyProofLen(oldSize, newSize, true); len(proof) != want {
+		return false, fmt.Errorf("%w: a consistency proof from size %d to size %d has %d hashes, got %d", ErrMalformedProof, oldSize, newSize, want, len(proof))
+	}
+	if oldSize == newSize {
+		return bytes.Equal(oldRoot, newRoot), nil
+	}
+
+	// A power of two sized old tree is a single node of the new one, so the proof
+	// leaves it out and the verifier starts from the root it already holds.
+	if oldSize&(oldSize-1) == 0 {
+		proof = append([][]byte{oldRoot}, proof...)
+	}
+
+	fn, sn := oldSize-1, newSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false, fmt.Errorf("%w: consistency proof is longer than the sizes allow", ErrMalformedProof)
+		}
+		if fn&1 == 1 || fn == sn {
+			if fr, err = cfg.hashInterior(c, fr); err != nil {
+				return false, err
+			}
+			if sr, err = cfg.hashInterior(c, sr); err != nil {
+				return false, err
+			}
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else if sr, err = cfg.hashInterior(sr, c); err != nil {
+			return false, err
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	return sn == 0 && bytes.Equal(fr, oldRoot) && bytes.Equal(sr, newRoot), nil
+}
@@ -0,0 +1,212 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// rfc6962Roots builds the RFC 6962 tree over every prefix of contents and returns the
+// root of each, indexed by prefix length. Index zero is unused.
+func rfc6962Roots(t *testing.T, contents []Content, opts ...TreeOption) [][]byte {
+	t.Helper()
+
+	roots := make([][]byte, len(contents)+1)
+	for n := 1; n <= len(contents); n++ {
+		tree, err := NewTreeWithOptions(contents[:n], append([]TreeOption{WithRFC6962()}, opts...)...)
+		if err != nil {
+			t.Fatalf("error: building a tree of %d leaves: %v", n, err)
+		}
+		roots[n] = tree.MerkleRoot()
+	}
+
+	return roots
+}
+
+// TestConsistencyProofRoundTrip checks every pair of sizes up to a bound: the proof from
+// each prefix to the whole tree verifies against the prefix's independently built root.
+func TestConsistencyProofRoundTrip(t *testing.T) {
+	for _, s := range propStrategies {
+		t.Run(s.name, func(t *testing.T) {
+			const maxSize = 33
+			contents := propSeries(maxSize)
+			roots := rfc6962Roots(t, contents, WithHasher(s.fn))
+
+			for n := 1; n <= maxSize; n++ {
+				tree, err := NewTreeWithOptions(contents[:n], WithRFC6962(), WithHasher(s.fn))
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				for m := 1; m <= n; m++ {
+					proof, err := tree.ConsistencyProof(m)
+					if err != nil {
+						t.Fatalf("error: ConsistencyProof(%d) of %d: %v", m, n, err)
+					}
+					if m == n && len(proof) != 0 {
+						t.Errorf("error: proof from a size to itself has %d hashes, want none", len(proof))
+					}
+
+					ok, err := VerifyConsistency(roots[m], roots[n], m, n, proof, WithRFC6962(), WithHasher(s.fn))
+					if err != nil {
+						t.Fatalf("error: VerifyConsistency(%d, %d): %v", m, n, err)
+					}
+					if !ok {
+						t.Errorf("error: consistency proof from %d to %d did not verify", m, n)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestConsistencyProofRejectsForgeries confirms the verifier is discriminating: a
+// tampered hash, a root that is not the prefix's, or the right proof presented for the
+// wrong sizes must all fail.
+func TestConsistencyProofRejectsForgeries(t *testing.T) {
+	const n = 21
+	contents := propSeries(n)
+	roots := rfc6962Roots(t, contents)
+	tree, err := NewTreeWithOptions(contents, WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	for m := 1; m < n; m++ {
+		proof, err := tree.ConsistencyProof(m)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+
+		for k := range proof {
+			forged := append([][]byte(nil), proof...)
+			forged[k] = bytes.Clone(forged[k])
+			forged[k][0] ^= 0x01
+			ok, err := VerifyConsistency(roots[m], roots[n], m, n, forged, WithRFC6962())
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if ok {
+				t.Errorf("error: m=%d: proof with hash %d flipped still verified", m, k)
+			}
+		}
+
+		other := roots[m%(n-1)+1]
+		if !bytes.Equal(other, roots[m]) {
+			ok, err := VerifyConsistency(other, roots[n], m, n, proof, WithRFC6962())
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if ok {
+				t.Errorf("error: m=%d: proof verified against another prefix's root", m)
+			}
+		}
+
+		ok, err := VerifyConsistency(roots[m], roots[n-1], m, n, proof, WithRFC6962())
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if ok {
+			t.Errorf("error: m=%d: proof verified against the wrong new root", m)
+		}
+	}
+}
+
+// TestConsistencyProofShapeErrors pins which mistakes are reported as errors rather
+// than as a false: those where the inputs cannot describe any proof at all.
+func TestConsistencyProofShapeErrors(t *testing.T) {
+	contents := propSeries(10)
+	tree, err := NewTreeWithOptions(contents, WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	root := tree.MerkleRoot()
+
+	for _, size := range []int{0, -1, 11} {
+		if _, err := tree.ConsistencyProof(size); err == nil {
+			t.Errorf("error: ConsistencyProof(%d) of a 10 leaf tree succeeded", size)
+		}
+	}
+
+	proof, err := tree.ConsistencyProof(3)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	cases := []struct {
+		name             string
+		oldSize, newSize int
+		proof            [][]byte
+	}{
+		{"too short", 3, 10, proof[:len(proof)-1]},
+		{"too long", 3, 10, append(append([][]byte(nil), proof...), root)},
+		{"sizes reversed", 10, 3, proof},
+		{"zero old size", 0, 10, proof},
+		{"equal sizes with a proof", 10, 10, proof},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := VerifyConsistency(root, root, tc.oldSize, tc.newSize, tc.proof, WithRFC6962())
+			if !errors.Is(err, ErrMalformedProof) {
+				t.Errorf("error: expected ErrMalformedProof, got %v", err)
+			}
+		})
+	}
+}
+
+// TestConsistencyRequiresRFC6962 covers both halves: a tree built another way cannot
+// produce a proof, and a verifier not told the construction refuses to guess.
+func TestConsistencyRequiresRFC6962(t *testing.T) {
+	for _, mode := range propModes {
+		if mode.rfc6962 {
+			continue
+		}
+		t.Run(mode.name, func(t *testing.T) {
+			tree, err := mode.build(propSeries(8), sha256.New)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if _, err := tree.ConsistencyProof(4); !errors.Is(err, ErrNotRFC6962) {
+				t.Errorf("error: expected ErrNotRFC6962, got %v", err)
+			}
+		})
+	}
+
+	tree, err := NewTreeWithOptions(propSeries(8), WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	proof, err := tree.ConsistencyProof(5)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if _, err := VerifyConsistency(tree.MerkleRoot(), tree.MerkleRoot(), 5, 8, proof); !errors.Is(err, ErrNotRFC6962) {
+		t.Errorf("error: expected ErrNotRFC6962 without the option, got %v", err)
+	}
+}
+
+// TestConsistencyProofLenMatchesProofs holds the length the verifier expects against the
+// proofs actually produced, since a disagreement would reject every honest proof of the
+// affected shape.
+func TestConsistencyProofLenMatchesProofs(t *testing.T) {
+	const maxSize = 70
+	contents := propSeries(maxSize)
+	for n := 1; n <= maxSize; n++ {
+		tree, err := NewTreeWithOptions(contents[:n], WithRFC6962())
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		for m := 1; m <= n; m++ {
+			proof, err := tree.ConsistencyProof(m)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got := consistencyProofLen(m, n, true); got != len(proof) {
+				t.Fatalf("error: %s: expected length %d, proof has %d", fmt.Sprintf("m=%d n=%d", m, n), got, len(proof))
+			}
+		}
+	}
+}
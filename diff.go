@@ -0,0 +1,187 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+	"reflect"
+)
+
+// LeafRange is the run of leaf positions from Begin up to but not including End.
+type LeafRange struct {
+	Begin, End int
+}
+
+// Diff returns the runs of leaf positions at which a and b hold different content, in
+// ascending order, with adjacent runs joined. Two replicas whose roots disagree can use
+// it to find what to reconcile without comparing every leaf: it descends only into
+// subtrees whose hashes differ, so it costs O(d log n) comparisons for d differing
+// leaves and returns nil at the cost of one comparison when the roots agree.
+//
+// The trees must hold the same number of content items and be built under the same
+// construction, which is what gives them the same shape; they may differ in layout.
+// Trees whose hash strategy, sorted siblings or RFC 6962 setting differ return an error
+// wrapping ErrConstructionMismatch, since no node of one can be compared with a node of
+// the other. Positions are content positions: the padding leaf is never reported.
+//
+// Leaves are compared by their hashes, as the tree does everywhere else, so content
+// that is not Equals but hashes alike is not reported.
+func Diff(a, b *MerkleTree) ([]LeafRange, error) {
+	if a == nil || b == nil || a.empty() || b.empty() {
+		return nil, fmt.Errorf("%w: tree has no root", ErrMalformedTree)
+	}
+	for _, t := range []*MerkleTree{a, b} {
+		if err := t.requireBinary("Diff"); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case reflect.ValueOf(a.hashStrategy).Pointer() != reflect.ValueOf(b.hashStrategy).Pointer():
+		return nil, fmt.Errorf("%w: the trees use different hash strategies", ErrConstructionMismatch)
+	case a.sort != b.sort:
+		return nil, fmt.Errorf("%w: one tree sorts siblings and the other does not", ErrConstructionMismatch)
+	case a.rfc6962 != b.rfc6962:
+		return nil, fmt.Errorf("%w: one tree was built with WithRFC6962 and the other was not", ErrConstructionMismatch)
+	}
+	if a.contentCount() != b.contentCount() {
+		return nil, fmt.Errorf("error: cannot diff a tree of %d leaves with one of %d", a.contentCount(), b.contentCount())
+	}
+	if a.leafCount() != b.leafCount() {
+		return nil, fmt.Errorf("%w: one tree pads its single leaf and the other, built with WithBitcoinCompat, does not", ErrConstructionMismatch)
+	}
+
+	d := &differ{rfc6962: a.rfc6962, count: a.leafCount()}
+	sa, sb := diffSide{n: a.Root, f: a.flat}, diffSide{n: b.Root, f: b.flat}
+	var err error
+	if a.rfc6962 {
+		err = d.split(sa, sb, 0, d.count)
+	} else {
+		err = d.padded(sa, sb, bits.Len(uint(d.count-1)), 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The padding leaf differs exactly when the leaf it copies does, so it can only
+	// ever extend the last run by one.
+	if n := a.contentCount(); len(d.ranges) > 0 && d.ranges[len(d.ranges)-1].End > n {
+		d.ranges[len(d.ranges)-1].End = n
+	}
+
+	return d.ranges, nil
+}
+
+// diffSide is one tree's view of the node Diff has reached: the Node itself under the
+// Node layout, or the flat tree, in which the node is found by its position.
+type diffSide struct {
+	n *Node
+	f *flatTree
+}
+
+// hash returns the hash of the node at position j of level.
+func (s diffSide) hash(level, j int) []byte {
+	if s.f != nil {
+		return s.f.node(level, j)
+	}
+
+	return s.n.Hash
+}
+
+// children returns the sides for the two children of the node. A flat tree locates
+// them by position, so its side is unchanged.
+func (s diffSide) children() (diffSide, diffSide, error) {
+	if s.f != nil {
+		return s, s, nil
+	}
+	if s.n.Left == nil || s.n.Right == nil {
+		return s, s, fmt.Errorf("%w: interior node is missing a child", ErrMalformedTree)
+	}
+
+	return diffSide{n: s.n.Left}, diffSide{n: s.n.Right}, nil
+}
+
+// differ collects the runs of differing leaves as Diff descends.
+type differ struct {
+	rfc6962 bool
+	// count is the number of leaves, the padding leaf included.
+	count  int
+	ranges []LeafRange
+}
+
+// add records leaves [begin, end) as differing, joining them to the last run when
+// they follow on from it. The descent is left to right, so runs arrive in order.
+func (d *differ) add(begin, end int) {
+	if k := len(d.ranges) - 1; k >= 0 && d.ranges[k].End == begin {
+		d.ranges[k].End = end
+
+		return
+	}
+	d.ranges = append(d.ranges, LeafRange{Begin: begin, End: end})
+}
+
+// padded descends into node j of level under the default and sorted constructions,
+// where node j has nodes 2j and 2j+1 of the level below as its children, or node 2j
+// alone when it closes an odd level.
+func (d *differ) padded(a, b diffSide, level, j int) error {
+	if bytes.Equal(a.hash(level, j), b.hash(level, j)) {
+		return nil
+	}
+	if level == 0 {
+		d.add(j, j+1)
+
+		return nil
+	}
+
+	al, ar, err := a.children()
+	if err != nil {
+		return err
+	}
+	bl, br, err := b.children()
+	if err != nil {
+		return err
+	}
+	if err := d.padded(al, bl, level-1, 2*j); err != nil {
+		return err
+	}
+	// A node closing an odd level is its own right hand child, and has been
+	// compared already as the left.
+	if (2*j+1)<<(level-1) >= d.count {
+		return nil
+	}
+
+	return d.padded(ar, br, level-1, 2*j+1)
+}
+
+// split descends into the node over leaves [lo, hi) under WithRFC6962, whose children
+// split the range at the largest power of two below its length.
+func (d *differ) split(a, b diffSide, lo, hi int) error {
+	// A flat tree keeps the node over the range at the height of the smallest
+	// perfect subtree that holds it, and the range is aligned to that height.
+	height := bits.Len(uint(hi - lo - 1))
+	if bytes.Equal(a.hash(height, lo>>height), b.hash(height, lo>>height)) {
+		return nil
+	}
+	if hi-lo == 1 {
+		d.add(lo, hi)
+
+		return nil
+	}
+
+	al, ar, err := a.children()
+	if err != nil {
+		return err
+	}
+	bl, br, err := b.children()
+	if err != nil {
+		return err
+	}
+	k := largestPowerOfTwoBelow(hi - lo)
+	if err := d.split(al, bl, lo, lo+k); err != nil {
+		return err
+	}
+
+	return d.split(ar, br, lo+k, hi)
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// diffReference returns the runs of positions at which the leaf hashes of a and b
+// differ, found by comparing every leaf.
+func diffReference(a, b *MerkleTree) []LeafRange {
+	var ranges []LeafRange
+	for i := 0; i < a.contentCount(); i++ {
+		if string(a.leafHash(i)) == string(b.leafHash(i)) {
+			continue
+		}
+		if k := len(ranges) - 1; k >= 0 && ranges[k].End == i {
+			ranges[k].End++
+			continue
+		}
+		ranges = append(ranges, LeafRange{Begin: i, End: i + 1})
+	}
+
+	return ranges
+}
+
+// TestDiffMatchesReference checks Diff finds exactly the leaves that changed, for every
+// construction, with either tree in either layout.
+func TestDiffMatchesReference(t *testing.T) {
+	rng := rand.New(rand.NewSource(14))
+	for _, mode := range propModes {
+		for _, n := range propSizes {
+			for _, flat := range [][2]bool{{false, false}, {true, false}, {false, true}, {true, true}} {
+				t.Run(fmt.Sprintf("%s/n=%d/flat=%v", mode.name, n, flat), func(t *testing.T) {
+					contents := propSeries(n)
+					build := func(flat bool) *MerkleTree {
+						opts := optsFor(mode, sha256.New)
+						if flat {
+							opts = append(opts, WithFlatLayout())
+						}
+						tree, err := NewTreeWithOptions(contents, opts...)
+						if err != nil {
+							t.Fatalf("error: unexpected error: %v", err)
+						}
+						return tree
+					}
+					a, b := build(flat[0]), build(flat[1])
+					if ranges, err := Diff(a, b); err != nil || ranges != nil {
+						t.Fatalf("error: identical trees: Diff returned %v, %v", ranges, err)
+					}
+
+					for trial := 0; trial < 8; trial++ {
+						updates := make(map[int]Content)
+						for k := rng.Intn(n) + 1; k > 0; k-- {
+							i := rng.Intn(n)
+							updates[i] = propContent{x: fmt.Sprintf("changed-%d-%d", trial, i)}
+						}
+						if err := b.UpdateLeaves(updates); err != nil {
+							t.Fatalf("error: unexpected error: %v", err)
+						}
+						ranges, err := Diff(a, b)
+						if err != nil {
+							t.Fatalf("error: Diff: %v", err)
+						}
+						if want := diffReference(a, b); !reflect.DeepEqual(ranges, want) {
+							t.Fatalf("error: trial %d: Diff returned %v, want %v", trial, ranges, want)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestDiffErrors covers the pairs of trees Diff refuses to compare.
+func TestDiffErrors(t *testing.T) {
+	contents := propSeries(6)
+	build := func(cs []Content, opts ...TreeOption) *MerkleTree {
+		tree, err := NewTreeWithOptions(cs, opts...)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		return tree
+	}
+	base := build(contents)
+
+	for name, other := range map[string]*MerkleTree{
+		"hash strategy": build(contents, WithHasher(sha512.New)),
+		"sorted":        build(contents, WithSortedSiblings()),
+		"rfc6962":       build(contents, WithRFC6962()),
+	} {
+		if _, err := Diff(base, other); !errors.Is(err, ErrConstructionMismatch) {
+			t.Errorf("error: %s: expected ErrConstructionMismatch, got %v", name, err)
+		}
+	}
+	if _, err := Diff(base, build(contents[:5])); err == nil {
+		t.Error("error: expected trees of different sizes to be refused")
+	}
+	if _, err := Diff(base, &MerkleTree{}); !errors.Is(err, ErrMalformedTree) {
+		t.Errorf("error: expected ErrMalformedTree, got %v", err)
+	}
+	if _, err := Diff(nil, base); !errors.Is(err, ErrMalformedTree) {
+		t.Errorf("error: expected ErrMalformedTree, got %v", err)
+	}
+}
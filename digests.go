@@ -0,0 +1,141 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Digest is a leaf digest standing in for content, the Content a tree built with
+// NewTreeFromDigests holds at each leaf. CalculateHash returns the digest itself, so a
+// tree of Digests has the root a tree of the content they were computed from has.
+//
+// Pass a Digest wherever a method takes Content, to locate or verify a leaf of such a
+// tree by its digest.
+type Digest []byte
+
+// CalculateHash returns the digest.
+func (d Digest) CalculateHash() ([]byte, error) {
+	return d, nil
+}
+
+// Equals reports whether other is the same digest.
+func (d Digest) Equals(other Content) (bool, error) {
+	o, ok := other.(Digest)
+	if !ok {
+		return false, errors.New("error: value is not of type Digest")
+	}
+
+	return bytes.Equal(d, o), nil
+}
+
+// NewTreeFromDigests builds a tree over leaf digests a caller already holds, with the
+// given options, for a pipeline that has hashed its content once and kept only the
+// hashes. Each digest is the value Content.CalculateHash would return for the leaf, as
+// VerifyProofWithDigest and Builder.AddDigest take it, so the root is the one
+// NewTreeWithOptions builds from that content under the same options; under
+// WithRFC6962 the tree applies the leaf prefix itself.
+//
+// The digests are copied, and each leaf holds its copy as a Digest. Proofs, changes and
+// verification work as they do on any tree, given a Digest where they take Content.
+// MarshalBinary and MarshalJSON need no content registration for such a tree, and write
+// the digests alone, back to back in the binary form. Every digest must be the same
+// width; returns ErrNoContent if digests is empty and an error wrapping ErrNilContent
+// for an empty digest.
+func NewTreeFromDigests(digests [][]byte, opts ...TreeOption) (*MerkleTree, error) {
+	t, err := configFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := digestContents(digests)
+	if err != nil {
+		return nil, err
+	}
+	t.digests = true
+	if err := t.build(cs); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// digestContents copies digests into one allocation and returns them as Content,
+// checking that they are all present and of one width.
+func digestContents(digests [][]byte) ([]Content, error) {
+	if len(digests) == 0 {
+		return nil, ErrNoContent
+	}
+	width := len(digests[0])
+	arena := make([]byte, 0, width*len(digests))
+	cs := make([]Content, len(digests))
+	for i, d := range digests {
+		if len(d) == 0 {
+			return nil, fmt.Errorf("%w: the digest at index %d is empty", ErrNilContent, i)
+		}
+		if len(d) != width {
+			return nil, fmt.Errorf("error: leaf digests are %d bytes, the digest at index %d is %d", width, i, len(d))
+		}
+		off := len(arena)
+		arena = append(arena, d...)
+		cs[i] = Digest(arena[off:len(arena):len(arena)])
+	}
+
+	return cs, nil
+}
+
+// snapshotDigests records the leaf digests of a tree built with NewTreeFromDigests in
+// td, for the marshalers that would otherwise encode content through the registry.
+func (m *MerkleTree) snapshotDigests(td *treeData) error {
+	td.Digests = make([][]byte, m.contentCount())
+	for i := range td.Digests {
+		digest, err := m.leafContent(i).CalculateHash()
+		if err != nil {
+			return err
+		}
+		if len(digest) == 0 || i > 0 && len(digest) != len(td.Digests[0]) {
+			return fmt.Errorf("error: the digest at index %d is %d bytes, and every leaf digest must be as wide as the first", i, len(digest))
+		}
+		td.Digests[i] = digest
+	}
+
+	return nil
+}
+
+// writeDigests writes the count, width and digests of the digest form.
+func (td *treeData) writeDigests(buf *bytes.Buffer) {
+	writeUvarint(buf, uint64(len(td.Digests)))
+	writeUvarint(buf, uint64(len(td.Digests[0])))
+	for _, d := range td.Digests {
+		buf.Write(d)
+	}
+}
+
+// readDigests reads what writeDigests wrote. The digests are views of the payload;
+// rebuilding the tree copies them.
+func (td *treeData) readDigests(r *binaryReader) error {
+	count, err := r.uvarint()
+	if err != nil {
+		return fmt.Errorf("%w: reading digest count: %w", ErrCorruptData, err)
+	}
+	width, err := r.uvarint()
+	if err != nil {
+		return fmt.Errorf("%w: reading digest width: %w", ErrCorruptData, err)
+	}
+	// Checked by division, so that a hostile count and width cannot overflow their
+	// product into something that fits.
+	if count == 0 || width == 0 || count > uint64(r.remaining())/width || count*width != uint64(r.remaining()) {
+		return fmt.Errorf("%w: %d digests of %d bytes do not fill the %d bytes remaining", ErrCorruptData, count, width, r.remaining())
+	}
+
+	td.Digests = make([][]byte, count)
+	for i := range td.Digests {
+		end := r.off + int(width)
+		td.Digests[i] = r.data[r.off:end:end]
+		r.off = end
+	}
+
+	return nil
+}
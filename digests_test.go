@@ -0,0 +1,160 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// contentDigests returns the digests CalculateHash gives for cs.
+func contentDigests(t *testing.T, cs []Content) [][]byte {
+	t.Helper()
+	digests := make([][]byte, len(cs))
+	for i, c := range cs {
+		var err error
+		if digests[i], err = c.CalculateHash(); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+	}
+
+	return digests
+}
+
+func TestTreeFromDigestsMatchesContent(t *testing.T) {
+	for _, opts := range [][]TreeOption{nil, {WithSortedSiblings()}, {WithRFC6962()}, {WithFlatLayout()}, {WithRFC6962(), WithLeafIndex()}} {
+		for n := 1; n <= 17; n++ {
+			cs := propSeries(n)
+			digests := contentDigests(t, cs)
+			want, err := NewTreeWithOptions(cs, opts...)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			got, err := NewTreeFromDigests(digests, opts...)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !bytes.Equal(got.MerkleRoot(), want.MerkleRoot()) || got.Len() != n {
+				t.Fatalf("error: n=%d: root %x, Content build %x", n, got.MerkleRoot(), want.MerkleRoot())
+			}
+			if ok, err := got.VerifyTree(); err != nil || !ok {
+				t.Errorf("error: n=%d: VerifyTree returned %v, %v", n, ok, err)
+			}
+			for i, d := range digests {
+				path, index, err := got.GetMerklePathByIndex(i)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				wantPath, wantIndex, _ := want.GetMerklePathByIndex(i)
+				if !reflect.DeepEqual(path, wantPath) || !reflect.DeepEqual(index, wantIndex) {
+					t.Errorf("error: n=%d: leaf %d: path differs from the Content build's", n, i)
+				}
+				if byDigest, _, err := got.GetMerklePath(Digest(d)); err != nil || !reflect.DeepEqual(byDigest, path) {
+					t.Errorf("error: n=%d: leaf %d: GetMerklePath by Digest returned %v", n, i, err)
+				}
+				if ok, err := VerifyProofWithDigest(d, path, index, got.MerkleRoot(), opts...); err != nil || !ok {
+					t.Errorf("error: n=%d: leaf %d: VerifyProofWithDigest returned %v, %v", n, i, ok, err)
+				}
+				if ok, err := got.VerifyContent(Digest(d)); err != nil || !ok {
+					t.Errorf("error: n=%d: leaf %d: VerifyContent returned %v, %v", n, i, ok, err)
+				}
+			}
+		}
+	}
+}
+
+func TestTreeFromDigestsCopies(t *testing.T) {
+	digests := contentDigests(t, propSeries(4))
+	tree, err := NewTreeFromDigests(digests)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	root := bytes.Clone(tree.MerkleRoot())
+	digests[0][0] ^= 0xff
+	if ok, err := tree.VerifyTree(); err != nil || !ok || !bytes.Equal(tree.MerkleRoot(), root) {
+		t.Errorf("error: changing the caller's digest changed the tree")
+	}
+}
+
+func TestTreeFromDigestsSerialization(t *testing.T) {
+	digests := contentDigests(t, propSeries(7))
+	tree, err := NewTreeFromDigests(digests, WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	data, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte(digestsMagic)) {
+		t.Fatalf("error: payload does not carry the digest form's magic")
+	}
+	// The digests dominate: everything else is the header and the root.
+	if overhead := len(data) - len(digests)*sha256.Size; overhead > 64 {
+		t.Errorf("error: %d bytes beyond the digests themselves", overhead)
+	}
+	var got MerkleTree
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(got.MerkleRoot(), tree.MerkleRoot()) || !got.RFC6962() || got.Len() != len(digests) {
+		t.Fatalf("error: decoded tree differs")
+	}
+	if again, err := got.MarshalBinary(); err != nil || !bytes.Equal(again, data) {
+		t.Errorf("error: re-encoding the decoded tree gave different bytes: %v", err)
+	}
+	// Decoding must not alias the payload.
+	data[len(data)-1] ^= 0xff
+	if ok, err := got.VerifyTree(); err != nil || !ok {
+		t.Errorf("error: the decoded tree changed with its payload")
+	}
+	data[len(data)-1] ^= 0xff
+
+	js, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	var fromJSON MerkleTree
+	if err := json.Unmarshal(js, &fromJSON); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(fromJSON.MerkleRoot(), tree.MerkleRoot()) {
+		t.Errorf("error: JSON round trip changed the root")
+	}
+
+	for name, payload := range map[string][]byte{
+		"truncated":      data[:len(data)-1],
+		"trailing bytes": append(bytes.Clone(data), 0),
+		"flipped digest": append(bytes.Clone(data[:len(data)-1]), data[len(data)-1]^1),
+	} {
+		var m MerkleTree
+		err := m.UnmarshalBinary(payload)
+		if !errors.Is(err, ErrCorruptData) && !errors.Is(err, ErrRootMismatch) {
+			t.Errorf("error: %s: got %v, want ErrCorruptData or ErrRootMismatch", name, err)
+		}
+	}
+}
+
+func TestTreeFromDigestsRejects(t *testing.T) {
+	if _, err := NewTreeFromDigests(nil); !errors.Is(err, ErrNoContent) {
+		t.Errorf("error: no digests: got %v, want ErrNoContent", err)
+	}
+	if _, err := NewTreeFromDigests([][]byte{{1}, nil}); !errors.Is(err, ErrNilContent) {
+		t.Errorf("error: empty digest: got %v, want ErrNilContent", err)
+	}
+	if _, err := NewTreeFromDigests([][]byte{{1, 2}, {3}}); err == nil {
+		t.Errorf("error: mixed widths: expected an error")
+	}
+	if _, err := NewTreeFromDigests([][]byte{{1}}, WithRFC6962(), WithSortedSiblings()); err == nil {
+		t.Errorf("error: conflicting options: expected an error")
+	}
+	if ok, err := (Digest{1}).Equals(TestSHA256Content{x: "a"}); ok || err == nil {
+		t.Errorf("error: comparing with another content type: got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrContentDiscarded is returned by the methods that need a leaf's content, such as
+// VerifyContent, ContentAt and the marshalers, when the tree has released it with
+// WithDiscardContent or Compact. Test for it with errors.Is.
+var ErrContentDiscarded = errors.New("error: the tree has discarded its content")
+
+// WithDiscardContent builds a tree that keeps only hashes: each leaf's content is
+// hashed and then released, so the tree holds no reference to it. It is off by
+// default. Content added later with Append, UpdateLeaf or RebuildTreeWith is released
+// the same way.
+//
+// It is for a tree that is built once to serve proofs, where the content is held
+// somewhere else or not needed at all and would otherwise dominate the tree's memory.
+// The root and every hash are unaffected, and so is everything computed from hashes
+// alone: GetMerklePathByIndex, GetProofByIndex and multiproofs, consistency proofs,
+// tree heads and Diff. GetMerklePath, GetProof and MerkleTree.VerifyProof still take
+// content, which they hash and look up by leaf hash rather than by Content.Equals, so a
+// Digest finds a leaf by its digest.
+//
+// What needs the stored content returns an error wrapping ErrContentDiscarded:
+// VerifyTree and VerifyContent, which recompute leaves from it, ContentAt, RebuildTree,
+// and serialization. Node.C is nil on every leaf. It cannot be combined with
+// NewSortedContentTree, which proves absence with the content of its leaves.
+func WithDiscardContent() TreeOption {
+	return func(m *MerkleTree) {
+		m.discardContent = true
+	}
+}
+
+// Compact releases the content of every leaf of a built tree, leaving it as
+// WithDiscardContent would have built it. It cannot be undone short of RebuildTreeWith.
+func (m *MerkleTree) Compact() error {
+	if m.empty() {
+		return fmt.Errorf("%w: tree has no root", ErrMalformedTree)
+	}
+	if m.compare != nil {
+		return errors.New("error: a sorted content tree cannot discard its content; absence proofs carry it")
+	}
+	m.discardContent = true
+	m.dropContent()
+
+	return nil
+}
+
+// ContentDiscarded reports whether the tree has released its content, under
+// WithDiscardContent or after Compact.
+func (m *MerkleTree) ContentDiscarded() bool {
+	return m.discardContent
+}
+
+// dropContent releases the content of every leaf. A flat tree keeps its contents slice
+// at full length, all nil, because its length is the content count.
+func (m *MerkleTree) dropContent() {
+	if m.flat != nil {
+		clear(m.flat.contents)
+
+		return
+	}
+	for _, l := range m.Leafs {
+		l.C = nil
+	}
+}
+
+// requireContent returns an error wrapping ErrContentDiscarded when the tree has
+// released its content, for the operations that need it.
+func (m *MerkleTree) requireContent(what string) error {
+	if m.discardContent {
+		return fmt.Errorf("%w: %s needs the content of the leaves", ErrContentDiscarded, what)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiscardContent(t *testing.T) {
+	for _, opts := range [][]TreeOption{nil, {WithRFC6962()}, {WithFlatLayout()}, {WithSortedSiblings(), WithLeafIndex()}, {WithArity(3)}} {
+		for n := 1; n <= 9; n++ {
+			cs := propSeries(n)
+			want, err := NewTreeWithOptions(cs, opts...)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			got, err := NewTreeWithOptions(cs, append(opts, WithDiscardContent())...)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			label := fmt.Sprintf("%d options n=%d", len(opts), n)
+			if !bytes.Equal(got.MerkleRoot(), want.MerkleRoot()) || got.Len() != n || !got.ContentDiscarded() {
+				t.Fatalf("error: %s: discarding content changed the tree", label)
+			}
+			for _, l := range got.Leafs {
+				if l.C != nil {
+					t.Fatalf("error: %s: a leaf still holds its content", label)
+				}
+			}
+
+			for i, c := range cs {
+				path, index, err := got.GetMerklePathByIndex(i)
+				if err != nil {
+					t.Fatalf("error: %s: unexpected error: %v", label, err)
+				}
+				wantPath, wantIndex, _ := want.GetMerklePathByIndex(i)
+				if !reflect.DeepEqual(path, wantPath) || !reflect.DeepEqual(index, wantIndex) {
+					t.Errorf("error: %s: leaf %d: path differs", label, i)
+				}
+				byContent, _, err := got.GetMerklePath(c)
+				if err != nil || !reflect.DeepEqual(byContent, wantPath) {
+					t.Errorf("error: %s: leaf %d: GetMerklePath returned %v", label, i, err)
+				}
+				digest, _ := c.CalculateHash()
+				if _, _, err := got.GetMerklePath(Digest(digest)); err != nil {
+					t.Errorf("error: %s: leaf %d: GetMerklePath by Digest returned %v", label, i, err)
+				}
+				if ok, err := got.VerifyProof(c, path, index); err != nil || !ok {
+					t.Errorf("error: %s: leaf %d: VerifyProof returned %v, %v", label, i, ok, err)
+				}
+			}
+			if _, _, err := got.GetMerklePath(propContent{x: "absent"}); !errors.Is(err, ErrContentNotFound) {
+				t.Errorf("error: %s: absent content: got %v, want ErrContentNotFound", label, err)
+			}
+
+			for name, call := range map[string]func() error{
+				"VerifyTree":    func() error { _, err := got.VerifyTree(); return err },
+				"VerifyContent": func() error { _, err := got.VerifyContent(cs[0]); return err },
+				"ContentAt":     func() error { _, err := got.ContentAt(0); return err },
+				"RebuildTree":   func() error { return got.RebuildTree() },
+				"MarshalWith": func() error {
+					_, err := got.MarshalWith(func(Content) ([]byte, error) { return nil, nil }, WithHashStrategyName("sha256"))
+					return err
+				},
+			} {
+				if err := call(); !errors.Is(err, ErrContentDiscarded) {
+					t.Errorf("error: %s: %s: got %v, want ErrContentDiscarded", label, name, err)
+				}
+			}
+		}
+	}
+}
+
+func TestDiscardContentChanges(t *testing.T) {
+	for _, opts := range [][]TreeOption{nil, {WithRFC6962()}, {WithFlatLayout()}} {
+		cs := propSeries(12)
+		tree, err := NewTreeWithOptions(cs[:5], append(opts, WithDiscardContent())...)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if err := tree.Append(cs[5:]...); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		cs[2] = propContent{x: "updated"}
+		if err := tree.UpdateLeaf(2, cs[2]); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		want, err := NewTreeWithOptions(cs, opts...)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if !bytes.Equal(tree.MerkleRoot(), want.MerkleRoot()) || tree.Len() != len(cs) {
+			t.Errorf("error: %d options: root after changes %x, want %x", len(opts), tree.MerkleRoot(), want.MerkleRoot())
+		}
+		for _, l := range tree.Leafs {
+			if l.C != nil {
+				t.Errorf("error: %d options: changed leaf holds its content", len(opts))
+			}
+		}
+		if tree.flat != nil {
+			for _, c := range tree.flat.contents {
+				if c != nil {
+					t.Errorf("error: %d options: changed flat leaf holds its content", len(opts))
+				}
+			}
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	cs := propSeries(7)
+	tree, err := NewTreeWithOptions(cs, WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	root := bytes.Clone(tree.MerkleRoot())
+	if err := tree.Compact(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(tree.MerkleRoot(), root) || !tree.ContentDiscarded() {
+		t.Fatalf("error: Compact changed the tree")
+	}
+	if _, err := tree.TreeHead(time.Time{}); err != nil {
+		t.Errorf("error: TreeHead: unexpected error: %v", err)
+	}
+	if _, err := tree.ConsistencyProof(3); err != nil {
+		t.Errorf("error: ConsistencyProof: unexpected error: %v", err)
+	}
+	if _, err := tree.MarshalBinary(); !errors.Is(err, ErrContentDiscarded) {
+		t.Errorf("error: MarshalBinary: got %v, want ErrContentDiscarded", err)
+	}
+	if err := tree.RebuildTreeWith(cs); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(tree.MerkleRoot(), root) || tree.Leafs[0].C != nil {
+		t.Errorf("error: RebuildTreeWith kept content on a compacted tree")
+	}
+
+	if err := (&MerkleTree{}).Compact(); !errors.Is(err, ErrMalformedTree) {
+		t.Errorf("error: empty tree: got %v, want ErrMalformedTree", err)
+	}
+	cmp := func(a, b Content) int { return bytes.Compare([]byte(a.(propContent).x), []byte(b.(propContent).x)) }
+	sorted, err := NewSortedContentTree(cs, cmp)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := sorted.Compact(); err == nil {
+		t.Errorf("error: sorted content tree: expected an error")
+	}
+	if _, err := NewSortedContentTree(cs, cmp, WithDiscardContent()); err == nil {
+		t.Errorf("error: sorted content tree with WithDiscardContent: expected an error")
+	}
+}
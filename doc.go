@@ -29,6 +29,18 @@ A slice of the Content items should be created and then passed to the NewTree me
 t represents the Merkle Tree and can be verified and manipulated with the API methods
 described below.
 
+A TypedTree holds plain values instead, given a function that hashes one and another
+that compares two, and returns them as their own type:
+
+	t, err := merkletree.NewTypedTree(values, hash, equal)
+	v, err := t.At(i)
+
+A caller that has already hashed its content, and kept only the digests, builds the
+same tree from them with NewTreeFromDigests. Each leaf holds a Digest, and the tree
+serializes as its digests alone:
+
+	t, err := merkletree.NewTreeFromDigests(digests, merkletree.WithRFC6962())
+
 # Constructions
 
 By default the tree is built the way Bitcoin builds one: sibling hashes are concatenated
@@ -49,6 +61,18 @@ padded, so every distinct leaf sequence has a distinct root.
 
 	t, err := merkletree.NewTreeWithOptions(list, merkletree.WithRFC6962())
 
+The default construction is Bitcoin's, but Bitcoin hashes with double SHA-256, so only
+WithBitcoinCompat reproduces the Merkle root of a real block. Given the block's txids,
+which BitcoinTxID parses from the reversed hex Bitcoin displays them in, it builds the
+tree the block header commits to.
+
+	t, err := merkletree.NewTreeWithOptions(txids, merkletree.WithBitcoinCompat())
+
+The sorted construction matches how OpenZeppelin's MerkleProof verifies a pair, but not
+the trees its StandardMerkleTree library builds, which also hash each leaf twice and lay
+the tree out as a heap. The standardtree module, nested in this repository, reproduces
+those.
+
 The constructions produce different roots and are not interchangeable, so pick one when
 the tree is created. See WithRFC6962 for the details, including how it relates to
 Certificate Transparency.
@@ -66,6 +90,54 @@ in parallel is byte for byte the tree built serially. What it is worth depends o
 CalculateHash costs, and is large when content is expensive to hash and negative on a
 small tree of cheap content. See WithParallelism.
 
+# Flat layout
+
+WithFlatLayout stores each level of the tree as one contiguous []byte of digests rather
+than as Node values, and finds parents and siblings by arithmetic on positions. A tree
+costs its digests and its content and little else, and an audit path reads one small
+region per level:
+
+	t, err := merkletree.NewTreeWithOptions(list, merkletree.WithFlatLayout())
+
+Proofs, verification, changes and serialization work as they do with Nodes and give the
+same results. Root and Leafs are nil, so only code walking the Node graph itself needs
+the default layout.
+
+The flat layout is also what holds a tree of wider nodes. WithArity(k) gives each
+interior node k children, which makes the tree shallower and each audit path carry k-1
+siblings a level, with the position of each in its group. VerifyProof checks such a path
+when given the same option:
+
+	t, err := merkletree.NewTreeWithOptions(list, merkletree.WithArity(4))
+	ok, err := merkletree.VerifyProof(content, path, index, root, merkletree.WithArity(4))
+
+# Streaming construction
+
+A Builder takes the leaves one at a time and keeps only the O(log n) subtree roots still
+waiting for a sibling, so a dataset can be hashed as it is read rather than loaded
+first. Finish returns the root NewTreeWithOptions would build from the same leaves:
+
+	b, err := merkletree.NewBuilder(merkletree.WithRFC6962())
+	err = b.Add(content)
+	n, err := b.AddDigests(r, sha256.Size)
+	root, err := b.Finish()
+
+A builder made with NewBuilderWithStore also writes each node to a NodeStore as it is
+completed, and Tree then serves proofs for the finished tree.
+
+# Changing a tree
+
+RebuildTreeWith replaces a tree's content and rehashes every node. Append adds content to
+the end and touches only the right hand edge, O(log n) hashes per item, and the tree it
+leaves is byte for byte the one a full build would produce:
+
+	err := t.Append(more...)
+
+UpdateLeaf replaces the content at one position and rehashes only the path above it, and
+UpdateLeaves does the same for a batch, rehashing each shared ancestor once:
+
+	err := t.UpdateLeaf(i, replacement)
+
 # Proof lookup
 
 GetMerklePath and VerifyContent locate their content by scanning every leaf, which makes
@@ -91,6 +163,14 @@ allocating at all:
 
 	path, index, err = t.AppendMerklePathByIndex(path[:0], index[:0], i)
 
+A proof server rarely needs the content it proves, which can be most of a tree's
+memory. WithDiscardContent releases each leaf's content once it has been hashed, and
+Compact releases it from a tree already built. Proofs by position are unaffected and
+content is located by its hash, while what needs the content itself, VerifyContent
+among it, returns an error wrapping ErrContentDiscarded:
+
+	t, err := merkletree.NewTreeWithOptions(list, merkletree.WithDiscardContent())
+
 # Verifying a proof without the tree
 
 VerifyProof checks an audit path against a root and needs no tree, which is what a
@@ -103,12 +183,179 @@ The options must describe the construction the proof was produced under. A verif
 has no Content implementation can use VerifyProofWithDigest instead, and one that does
 hold the tree can call the MerkleTree.VerifyProof method and skip restating the options.
 
+RFC 6962 implementations exchange inclusion proofs as a leaf index, a tree size and the
+hashes, with no side markers. VerifyInclusionRFC6962 takes them in that form, so a path
+from a Certificate Transparency log verifies as it arrives:
+
+	ok, err := merkletree.VerifyInclusionRFC6962(leaf, leafIndex, treeSize, path, root)
+
 This is a different question from MerkleTree.VerifyContent, which walks a tree it already
 has and recomputes every hash on the path from its own stored nodes. VerifyContent is the
 stronger check when you have the tree; VerifyProof is what you use when you do not. See
 VerifyProof for what a verified proof does and does not establish, and why WithRFC6962
 matters more for proofs from untrusted sources.
 
+# Storing and sending proofs
+
+GetProof and GetProofByIndex return a Proof, which carries the leaf index, the tree
+size, the construction and the hash strategy name alongside the siblings, and marshals
+to a compact binary form or to JSON:
+
+	p, err := t.GetProofByIndex(i)
+	data, err := p.MarshalBinary()
+	ok, err := p.Verify(content, root, merkletree.WithRFC6962())
+
+The side markers are derived from the leaf index and tree size rather than stored. The
+verifier's options still decide the construction; a proof recording a different one is
+refused with ErrConstructionMismatch.
+
+# Signed tree heads
+
+A proof is only as good as the root it is checked against. TreeHead describes a tree's
+root, size and construction at a moment, and Sign binds them with an ed25519 key, so a
+verifier holding the public key can take the head from anywhere. VerifyInclusion checks
+the signature and then the proof, under the construction the head records:
+
+	sth, err := t.TreeHead(time.Now())
+	err = sth.Sign(privateKey)
+	ok, err := sth.VerifyInclusion(publicKey, content, p)
+
+Transparency logs publish their heads as C2SP checkpoints instead, a text format signed
+as a note, which Checkpoint, SignCheckpoint and VerifyCheckpoint produce and check for a
+tree built with WithRFC6962, so the tree interoperates with the tooling that reads them:
+
+	c, err := t.Checkpoint("example.com/log")
+	note, err := merkletree.SignCheckpoint(c, "example.com/log", privateKey)
+	c, err = merkletree.VerifyCheckpoint(note, "example.com/log", publicKey)
+
+# Multiproofs
+
+Proving many leaves with one audit path each repeats every interior hash the paths
+share. GetMultiProof proves a set of leaves at once, sending each needed hash once and
+none the verifier can compute itself, and VerifyMultiProof checks it without the tree:
+
+	proof, err := t.GetMultiProof(indices)
+	ok, err := merkletree.VerifyMultiProof(digests, proof, root, opts...)
+
+Under WithSortedSiblings the proof is in the flag format of OpenZeppelin's
+MerkleProof.multiProofVerify.
+
+# Proving absence
+
+An inclusion proof shows what a tree holds, never what it lacks. NewSortedContentTree
+builds a tree whose leaves are kept in the order of a comparator, so an item that is
+missing has a place it would be, and ProveAbsence proves the leaves on either side of
+it. VerifyAbsence checks that both are in the tree, that the item orders between them,
+and that the leaf positions the proofs are bound to leave no room for anything else:
+
+	t, err := merkletree.NewSortedContentTree(cs, cmp)
+	proof, err := t.ProveAbsence(c)
+	ok, err := merkletree.VerifyAbsence(c, proof, root, cmp)
+
+# Consistency proofs
+
+An RFC 6962 tree can also prove that an earlier version of itself is a prefix of the
+current one, which is what an append-only log owes a client that last saw it at a smaller
+size. ConsistencyProof produces the proof from the size the client remembers, and
+VerifyConsistency checks it against the two roots without a tree:
+
+	proof, err := t.ConsistencyProof(oldSize)
+	ok, err := merkletree.VerifyConsistency(oldRoot, newRoot, oldSize, newSize, proof, merkletree.WithRFC6962())
+
+Both require the RFC 6962 construction. The default construction duplicates its last
+node, so the tree over a prefix is not part of the tree over the whole.
+
+# Comparing trees
+
+Diff finds where two trees of the same size and construction disagree, descending only
+into subtrees whose hashes differ, and returns the runs of leaf positions that changed:
+
+	ranges, err := merkletree.Diff(local, remote)
+
+When the replicas are on different machines, NodeHash exposes any node by level and
+position, and the sync subpackage uses it to reconcile over a connection: the side
+with the authoritative copy runs Serve, and the other runs Sync, which exchanges hashes
+a level at a time, descending only into the nodes that differ, and fetches and applies
+just the leaves that changed:
+
+	err := merklesync.Serve(conn, tree, marshal)              // on one side
+	changed, err := merklesync.Sync(conn, replica, unmarshal) // on the other
+
+# Merkle Mountain Ranges
+
+For a list that only ever grows, an MMR keeps the row of perfect subtrees, its peaks,
+that appending has produced so far, and never rewrites a node. Its root bags the peaks
+and equals the RFC 6962 root of the same leaves. A proof climbs to the leaf's peak and
+carries the peaks, so it stays valid against the root it was made for, and
+UpgradeProof extends it as the range grows:
+
+	mmr, err := merkletree.NewMMR()
+	err = mmr.Append(cs...)
+	proof, err := mmr.GetProof(i)
+	ok, err := proof.Verify(c, mmr.Root())
+
+That an older range is a prefix of a newer one is an RFC 6962 consistency proof,
+produced by MMR.ConsistencyProof and checked with VerifyConsistency.
+
+# Compact ranges
+
+The root of an append-only RFC 6962 tree depends on its leaves only through the perfect
+subtrees along its right edge. A CompactRange keeps just those hashes, O(log n) of them,
+so a log too large to hold in memory can still compute its root as leaves arrive:
+
+	r, err := merkletree.NewCompactRange()
+	err = r.AppendDigest(digest)
+	root, err := r.Root()
+
+Ranges created with NewCompactRangeAt cover a stretch further on, and Merge joins a
+range to the one that ends where it begins, so stretches can be hashed separately.
+
+# Trees larger than memory
+
+A MerkleTree holds a Node per leaf and per interior node, and its content. NewStoredTree
+builds the same tree into a NodeStore instead, asking for the content one leaf at a time
+and keeping none of it, and the StoredTree it returns serves the same roots and audit
+paths by reading one node per level back from the store:
+
+	store, err := merkletree.NewFileNodeStore(dir, sha256.Size)
+	tree, err := merkletree.NewStoredTree(store, n, func(i int) (merkletree.Content, error) {
+		return readRecord(i)
+	}, merkletree.WithHasher(sha256.New))
+	path, index, err := tree.GetMerklePathByIndex(i)
+
+MemoryNodeStore keeps the hashes on the heap and FileNodeStore in one file per level;
+anything else that can get and put a hash by level and index can be used in their
+place. OpenStoredTree picks up a tree an earlier process built into a store.
+
+An append-only RFC 6962 tree can also be published as tiles, the immutable files of
+hashes Go's checksum database serves its log from. The tiles subpackage writes them
+and serves roots, inclusion proofs and consistency proofs for any size from the tiles
+alone:
+
+	err := tiles.Write(dir, tree, tiles.DefaultHeight)
+	r, err := tiles.NewReader(dir, tiles.DefaultHeight)
+	proof, err := r.InclusionProof(i, size)
+
+The tree tiles describe is the one golang.org/x/mod/sumdb/tlog builds, and the separate
+tlogcompat module converts between a MerkleTree and tlog's HashReader, RecordProof and
+TreeProof, keeping the dependency out of this package.
+
+# Sparse Merkle trees
+
+A MerkleTree commits to content by position, so it can prove what is in it but not what
+is missing. A SparseMerkleTree commits to a mapping from 256-bit keys to values, giving
+every key a fixed leaf whether or not it holds a value, and so proves absence as readily
+as presence:
+
+	smt, err := merkletree.NewSparseMerkleTree(merkletree.WithHasher(sha256.New))
+	err = smt.Set(key, value)
+	proof, err := smt.Prove(other)
+	ok, err := merkletree.VerifySparseNonInclusion(other, proof, smt.Root())
+
+Empty subtrees are never stored or hashed, and proofs leave out the empty siblings the
+verifier can compute itself. A SparseMerkleTree implements encoding.BinaryMarshaler,
+recording its hash strategy by name as described below.
+
 # Serialization
 
 A tree holds reference cycles - a Node points back at its Tree and at its Parent - so it
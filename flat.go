@@ -0,0 +1,545 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"slices"
+)
+
+// WithFlatLayout stores the tree as one contiguous []byte per level instead of a graph
+// of Node values. Node j of a level is the digest at offset j times the digest width,
+// its children are nodes 2j and 2j+1 of the level below, and its sibling is node j^1, so
+// nothing about the shape needs storing at all. It is off by default.
+//
+// A Node costs well over a hundred bytes before its hash: the Tree, Parent, Left and
+// Right pointers, a slice header and an interface value. The flat layout costs the
+// digests and nothing else, around 2n of them for n leaves plus the content itself,
+// and keeps the hashes of a level next to one another, so walking an audit path touches
+// one small region per level rather than chasing pointers around the heap. The
+// difference is large on a tree that is built once and then serves proofs.
+//
+// The root is unaffected, and so is everything computed from the tree: proofs,
+// multiproofs, consistency proofs, Append and UpdateLeaf all give what they give under
+// the Node layout. What changes is the exported structure. A flat tree has no Root and
+// no Leafs, both being nil, so code that walks the Node graph directly cannot be used
+// with it. Every digest on a level is the same width, which under the default and
+// sorted constructions means every Content.CalculateHash in the tree must return the
+// same number of bytes; content that does not is refused.
+//
+// Append and UpdateLeaf rewrite the levels in place, so the hashes a flat tree hands
+// out in audit paths, proofs and NodeHash are copies, and keep the values they had, as
+// they do under the Node layout.
+//
+// Like WithParallelism the layout is not recorded when a tree is serialized, so a tree
+// read back with UnmarshalBinary or UnmarshalJSON is built with Node values.
+func WithFlatLayout() TreeOption {
+	return func(m *MerkleTree) {
+		m.flatLayout = true
+	}
+}
+
+// flatTree is the packed representation WithFlatLayout selects.
+type flatTree struct {
+	// levels[0] holds the leaf digests and each level after it the parents of the one
+	// before, every digest on a level back to back. The last node of an odd level is
+	// paired with itself under the default and sorted constructions and copied up
+	// unchanged under RFC 6962, which keeps node j of every level above node 2j of
+	// the level below. The top level holds the root alone.
+	levels [][]byte
+	// leafSize is the width of a leaf digest and size that of an interior one. They
+	// differ only when the default construction is given content hashed with
+	// something other than the tree's strategy.
+	leafSize, size int
+	// contents holds the content of each leaf, without the padding leaf.
+	contents []Content
+	// padded records that level zero ends with a copy of the last leaf, as Leafs
+	// does under the default and sorted constructions when the content count is odd.
+	padded bool
+	// arity is the number of children of each interior node, two unless the tree
+	// was built with WithArity. Node j of a level is then the parent of nodes kj to
+	// kj+k-1, a short group at the end of a level being filled with copies of its
+	// last node, and no padding leaf is stored.
+	arity int
+	// group holds the children of the node rehashNode is computing in a k-ary tree.
+	group [][]byte
+}
+
+// width returns the width of a digest on level.
+func (f *flatTree) width(level int) int {
+	if level == 0 {
+		return f.leafSize
+	}
+
+	return f.size
+}
+
+// count returns the number of nodes on level.
+func (f *flatTree) count(level int) int {
+	return len(f.levels[level]) / f.width(level)
+}
+
+// node returns the digest of node j on level. The slice is a view of the level, capped
+// so that appending to it cannot overwrite the node after it.
+func (f *flatTree) node(level, j int) []byte {
+	w := f.width(level)
+
+	return f.levels[level][j*w : (j+1)*w : (j+1)*w]
+}
+
+// appendNode copies the digest of node j on level to the end of buf, and returns buf
+// and the copy, capped as node caps its view. The hashes a flat tree hands out are
+// copied this way, the nodes of one path or proof sharing one buffer.
+func (f *flatTree) appendNode(buf []byte, level, j int) ([]byte, []byte) {
+	off := len(buf)
+	buf = append(buf, f.node(level, j)...)
+
+	return buf, buf[off:len(buf):len(buf)]
+}
+
+// pathBuffer returns an empty buffer with room for the given number of nodes from each
+// level below the root, which is what an audit path holds.
+func (f *flatTree) pathBuffer(siblings int) []byte {
+	if len(f.levels) < 2 {
+		return nil
+	}
+
+	return make([]byte, 0, siblings*(f.leafSize+(len(f.levels)-2)*f.size))
+}
+
+// root returns the digest at the top of the tree.
+func (f *flatTree) root() []byte {
+	return f.node(len(f.levels)-1, 0)
+}
+
+// buildFlat builds the flat tree over cs. Returns ErrNoContent if cs is empty and
+// ErrNilContent if any entry is nil.
+func (m *MerkleTree) buildFlat(cs []Content) (*flatTree, error) {
+	if len(cs) == 0 {
+		return nil, ErrNoContent
+	}
+
+	h := m.hashStrategy()
+	f := &flatTree{size: h.Size(), contents: slices.Clone(cs), arity: m.fanout()}
+	var leaves []byte
+	for i, c := range cs {
+		if c == nil {
+			return nil, fmt.Errorf("%w: index %d", ErrNilContent, i)
+		}
+		digest, err := c.CalculateHash()
+		if err != nil {
+			return nil, err
+		}
+		if m.rfc6962 {
+			leaves, err = m.appendLeafDigest(h, leaves, digest)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			if i == 0 {
+				f.leafSize = len(digest)
+			}
+			if err := f.checkLeaf(i, digest); err != nil {
+				return nil, err
+			}
+			leaves = append(leaves, digest...)
+		}
+	}
+	if m.rfc6962 {
+		f.leafSize = f.size
+	}
+	if m.padsLeaves(len(cs)) {
+		leaves = append(leaves, leaves[len(leaves)-f.leafSize:]...)
+		f.padded = true
+	}
+	f.levels = [][]byte{leaves}
+
+	if err := f.rehashFrom(m, h, 0); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// checkLeaf returns an error unless digest, the leaf digest for position i, has the
+// width every leaf of the tree must have.
+func (f *flatTree) checkLeaf(i int, digest []byte) error {
+	if len(digest) == 0 || len(digest) != f.leafSize {
+		return fmt.Errorf("error: the flat layout holds %d byte leaf digests, the content at index %d hashed to %d bytes", f.leafSize, i, len(digest))
+	}
+
+	return nil
+}
+
+// rehashNode computes node j of the level above level, which holds count nodes, into
+// up.
+func (f *flatTree) rehashNode(m *MerkleTree, h hash.Hash, level, j, count int, up []byte) error {
+	off := j * f.size
+	if f.arity > 2 {
+		// Only the write paths rehash, and they are never concurrent with one
+		// another, so one scratch slice serves every group.
+		f.group = f.appendGroup(f.group[:0], level, j, count)
+		_, err := m.appendGroupHash(h, up[off:off:off+f.size], f.group)
+
+		return err
+	}
+
+	left, right := f.node(level, 2*j), f.node(level, 2*j)
+	if 2*j+1 < count {
+		right = f.node(level, 2*j+1)
+	} else if m.rfc6962 {
+		copy(up[j*f.size:], left)
+
+		return nil
+	}
+	_, err := m.appendInteriorHash(h, up[off:off:off+f.size], left, right)
+
+	return err
+}
+
+// rehashFrom recomputes every node at or after position lo of level zero and above,
+// sizing each level to the one below first. It is how a build fills the tree and how
+// Append extends it: only the right hand part of each level, from the first node over
+// a changed leaf, is touched.
+func (f *flatTree) rehashFrom(m *MerkleTree, h hash.Hash, lo int) error {
+	k := f.arity
+	level, count := 0, f.count(0)
+	// A lone leaf of a k-ary tree is hashed with copies of itself, as a padded
+	// binary tree's is, so even the smallest tree has a level above the leaves.
+	for ; count > 1 || (level == 0 && k > 2); level, lo, count = level+1, lo/k, (count+k-1)/k {
+		next := (count + k - 1) / k
+		if level+1 == len(f.levels) {
+			f.levels = append(f.levels, nil)
+		}
+		up := f.levels[level+1]
+		if need := next * f.size; cap(up) < need {
+			up = append(make([]byte, 0, max(need, 2*cap(up))), up...)
+		}
+		up = up[:next*f.size]
+		for j := lo / k; j < next; j++ {
+			if err := f.rehashNode(m, h, level, j, count, up); err != nil {
+				return err
+			}
+		}
+		f.levels[level+1] = up
+	}
+	f.levels = f.levels[:level+1]
+
+	return nil
+}
+
+// rehashPositions recomputes the nodes above the leaves at positions, ascending, and
+// nothing else. Parents shared by several positions are computed once.
+func (f *flatTree) rehashPositions(m *MerkleTree, h hash.Hash, positions []int) error {
+	js := slices.Clone(positions)
+	for level := 0; level+1 < len(f.levels); level++ {
+		count := f.count(level)
+		parents := js[:0]
+		for _, j := range js {
+			if p := j / f.arity; len(parents) == 0 || parents[len(parents)-1] != p {
+				parents = append(parents, p)
+			}
+		}
+		for _, j := range parents {
+			if err := f.rehashNode(m, h, level, j, count, f.levels[level+1]); err != nil {
+				return err
+			}
+		}
+		js = parents
+	}
+
+	return nil
+}
+
+// appendPath appends the audit path for leaf i and the side of each sibling, as
+// appendPathFromLeaf does for the Node layout.
+func (f *flatTree) appendPath(path [][]byte, index []int64, i int, rfc6962 bool) ([][]byte, []int64) {
+	if f.arity > 2 {
+		return f.appendGroupPath(path, index, i)
+	}
+
+	buf := f.pathBuffer(1)
+	for level, j := 0, i; level+1 < len(f.levels); level, j = level+1, j/2 {
+		sibling := j ^ 1
+		if sibling >= f.count(level) {
+			if rfc6962 {
+				continue
+			}
+			sibling = j
+		}
+		var node []byte
+		buf, node = f.appendNode(buf, level, sibling)
+		path = append(path, node)
+		if j%2 == 0 {
+			index = append(index, 1) // right leaf
+		} else {
+			index = append(index, 0) // left leaf
+		}
+	}
+
+	return path, index
+}
+
+// appendFlat appends the leaves holding cs, whose leaf hashes are leafHashes, to a
+// flat tree.
+func (m *MerkleTree) appendFlat(cs []Content, leafHashes [][]byte) error {
+	f := m.flat
+	for i, digest := range leafHashes {
+		if err := f.checkLeaf(len(f.contents)+i, digest); err != nil {
+			return err
+		}
+	}
+
+	// The padding slot, when there is one, is where the first new leaf goes.
+	lo := len(f.contents)
+	f.levels[0] = f.levels[0][:lo*f.leafSize]
+	for _, digest := range leafHashes {
+		f.levels[0] = append(f.levels[0], digest...)
+	}
+	f.contents = append(f.contents, cs...)
+	f.padded = m.padsLeaves(len(f.contents))
+	if f.padded {
+		f.levels[0] = append(f.levels[0], leafHashes[len(leafHashes)-1]...)
+	}
+
+	if err := f.rehashFrom(m, m.hashStrategy(), lo); err != nil {
+		return err
+	}
+	m.merkleRoot = bytes.Clone(f.root())
+	for i := lo; i < len(f.contents); i++ {
+		m.indexAppendedLeaf(i)
+	}
+
+	return nil
+}
+
+// updateFlat replaces the leaves at positions, ascending, with the content updates
+// holds for them, whose leaf hashes are leafHashes.
+func (m *MerkleTree) updateFlat(updates map[int]Content, positions []int, leafHashes [][]byte) error {
+	f := m.flat
+	for k, i := range positions {
+		if err := f.checkLeaf(i, leafHashes[k]); err != nil {
+			return err
+		}
+	}
+
+	// The levels are overwritten in place, so the old hashes the leaf index needs
+	// have to be copied out first.
+	oldHashes := make([][]byte, len(positions))
+	for k, i := range positions {
+		oldHashes[k] = bytes.Clone(f.node(0, i))
+		copy(f.node(0, i), leafHashes[k])
+		f.contents[i] = updates[i]
+		if f.padded && i == len(f.contents)-1 {
+			copy(f.node(0, i+1), leafHashes[k])
+		}
+	}
+
+	if err := f.rehashPositions(m, m.hashStrategy(), positions); err != nil {
+		return err
+	}
+	m.merkleRoot = bytes.Clone(f.root())
+	m.reindexUpdatedLeaves(positions, oldHashes)
+
+	return nil
+}
+
+// verifyFlat is VerifyTree for a flat tree: it recomputes every leaf from its content
+// and every node from its children, and reports whether all of them, and the root the
+// tree advertises, match what is stored.
+func (m *MerkleTree) verifyFlat() (bool, error) {
+	f := m.flat
+	h := m.hashStrategy()
+	scratch := make([]byte, 0, max(f.leafSize, f.size))
+	var group [][]byte
+
+	matched := true
+	for i := 0; i < f.count(0); i++ {
+		digest, err := m.leafContent(i).CalculateHash()
+		if err != nil {
+			return false, err
+		}
+		if m.rfc6962 {
+			if digest, err = m.appendLeafDigest(h, scratch[:0], digest); err != nil {
+				return false, err
+			}
+		}
+		matched = matched && bytes.Equal(digest, f.node(0, i))
+	}
+	for level := 0; level+1 < len(f.levels); level++ {
+		count := f.count(level)
+		for j := 0; j < f.count(level+1); j++ {
+			if f.arity > 2 {
+				group = f.appendGroup(group[:0], level, j, count)
+				parent, err := m.appendGroupHash(h, scratch[:0], group)
+				if err != nil {
+					return false, err
+				}
+				matched = matched && bytes.Equal(parent, f.node(level+1, j))
+
+				continue
+			}
+			left, right := f.node(level, 2*j), f.node(level, 2*j)
+			if 2*j+1 < count {
+				right = f.node(level, 2*j+1)
+			} else if m.rfc6962 {
+				matched = matched && bytes.Equal(left, f.node(level+1, j))
+
+				continue
+			}
+			parent, err := m.appendInteriorHash(h, scratch[:0], left, right)
+			if err != nil {
+				return false, err
+			}
+			matched = matched && bytes.Equal(parent, f.node(level+1, j))
+		}
+	}
+
+	return matched && bytes.Equal(f.root(), m.merkleRoot), nil
+}
+
+// verifyFlatContent is the climb VerifyContent makes, for leaf i of a flat tree. Each
+// node on the path is recomputed from its children, the leaves among them from their
+// content, and compared with what is stored.
+func (m *MerkleTree) verifyFlatContent(i int) (bool, error) {
+	f := m.flat
+	h := m.hashStrategy()
+
+	// calculated recomputes node j of level, from content on level zero and from
+	// the stored children above it.
+	calculated := func(level, j int) ([]byte, error) {
+		if level > 0 {
+			return f.node(level, j), nil
+		}
+		digest, err := m.leafContent(j).CalculateHash()
+		if err != nil || !m.rfc6962 {
+			return digest, err
+		}
+
+		return m.appendLeafDigest(h, nil, digest)
+	}
+
+	if k := f.arity; k > 2 {
+		group := make([][]byte, k)
+		for level, j := 0, i; level+1 < len(f.levels); level, j = level+1, j/k {
+			count := f.count(level)
+			for p := range group {
+				var err error
+				if group[p], err = calculated(level, min(j/k*k+p, count-1)); err != nil {
+					return false, err
+				}
+			}
+			parent, err := m.appendGroupHash(h, nil, group)
+			if err != nil {
+				return false, err
+			}
+			if !bytes.Equal(parent, f.node(level+1, j/k)) {
+				return false, nil
+			}
+		}
+
+		return bytes.Equal(f.root(), m.merkleRoot), nil
+	}
+
+	for level, j := 0, i; level+1 < len(f.levels); level, j = level+1, j/2 {
+		left, right := j&^1, j|1
+		if right >= f.count(level) {
+			if m.rfc6962 {
+				continue
+			}
+			right = left
+		}
+		l, err := calculated(level, left)
+		if err != nil {
+			return false, err
+		}
+		r, err := calculated(level, right)
+		if err != nil {
+			return false, err
+		}
+		parent, err := m.appendInteriorHash(h, nil, l, r)
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(parent, f.node(level+1, j/2)) {
+			return false, nil
+		}
+	}
+
+	return bytes.Equal(f.root(), m.merkleRoot), nil
+}
+
+// flatMultiProof is the walk GetMultiProof makes, for a flat tree. sorted holds the
+// leaf positions to prove, ascending and distinct.
+func (m *MerkleTree) flatMultiProof(sorted []int) *MultiProof {
+	f := m.flat
+	p := &MultiProof{Indices: sorted, LeafCount: f.count(0)}
+	cur := slices.Clone(sorted)
+	var buf, node []byte
+	for level := 0; level+1 < len(f.levels); level++ {
+		count := f.count(level)
+		next := cur[:0]
+		for k := 0; k < len(cur); k++ {
+			j := cur[k]
+			switch {
+			case j == count-1 && j%2 == 0 && m.rfc6962:
+				// RFC 6962 carries an unpaired node up a level unchanged.
+			case j%2 == 0 && k+1 < len(cur) && cur[k+1] == j+1:
+				p.Flags = append(p.Flags, true)
+				k++
+			case j%2 == 0 && j+1 < count:
+				buf, node = f.appendNode(buf, level, j+1)
+				p.Proof = append(p.Proof, node)
+				p.Flags = append(p.Flags, false)
+			case j%2 == 0:
+				// A node closing an odd level meets itself.
+				buf, node = f.appendNode(buf, level, j)
+				p.Proof = append(p.Proof, node)
+				p.Flags = append(p.Flags, false)
+			default:
+				buf, node = f.appendNode(buf, level, j-1)
+				p.Proof = append(p.Proof, node)
+				p.Flags = append(p.Flags, false)
+			}
+			next = append(next, j/2)
+		}
+		cur = next
+	}
+
+	return p
+}
+
+// appendFlatSubproof is appendSubproof for the subtree of a flat RFC 6962 tree over the
+// count leaves from lo. Every range the recursion visits begins at a multiple of the
+// smallest power of two not below its length, which makes it the range beneath node
+// lo>>height of the level at that height.
+func (f *flatTree) appendFlatSubproof(proof [][]byte, lo, size, count int, complete bool) [][]byte {
+	subtree := func(lo, count int) []byte {
+		height := 0
+		for 1<<height < count {
+			height++
+		}
+
+		return bytes.Clone(f.node(height, lo>>height))
+	}
+
+	if size == count {
+		if !complete {
+			proof = append(proof, subtree(lo, count))
+		}
+
+		return proof
+	}
+	k := largestPowerOfTwoBelow(count)
+	if size <= k {
+		proof = f.appendFlatSubproof(proof, lo, size, k, complete)
+
+		return append(proof, subtree(lo+k, count-k))
+	}
+	proof = f.appendFlatSubproof(proof, lo+k, size-k, count-k, false)
+
+	return append(proof, subtree(lo, k))
+}
@@ -0,0 +1,351 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// flatOpts returns the options for mode with the flat layout added.
+func flatOpts(mode propMode) []TreeOption {
+	return append(optsFor(mode, sha256.New), WithFlatLayout())
+}
+
+// assertFlatMatches checks a flat tree answers every question the way the Node tree
+// built from the same content does.
+func assertFlatMatches(t *testing.T, label string, want, got *MerkleTree) {
+	t.Helper()
+
+	if !bytes.Equal(got.MerkleRoot(), want.MerkleRoot()) {
+		t.Fatalf("error: %s: root %x, Node layout %x", label, got.MerkleRoot(), want.MerkleRoot())
+	}
+	if got.Root != nil || got.Leafs != nil {
+		t.Fatalf("error: %s: a flat tree exposes Node values", label)
+	}
+	if got.String() != want.String() {
+		t.Errorf("error: %s: String differs from the Node layout's", label)
+	}
+	for i := range want.Leafs {
+		path, index, err := got.GetMerklePathByIndex(i)
+		if err != nil {
+			t.Fatalf("error: %s: GetMerklePathByIndex(%d): %v", label, i, err)
+		}
+		wantPath, wantIndex, err := want.GetMerklePathByIndex(i)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(path, wantPath) || !reflect.DeepEqual(index, wantIndex) {
+			t.Fatalf("error: %s: leaf %d: path differs from the Node layout's", label, i)
+		}
+		proof, err := got.GetProofByIndex(i)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		wantProof, err := want.GetProofByIndex(i)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(proof, wantProof) {
+			t.Fatalf("error: %s: leaf %d: Proof differs from the Node layout's", label, i)
+		}
+		if ok, err := got.VerifyContent(want.Leafs[i].C); err != nil || !ok {
+			t.Errorf("error: %s: leaf %d: VerifyContent returned %v, %v", label, i, ok, err)
+		}
+	}
+	if ok, err := got.VerifyTree(); err != nil || !ok {
+		t.Errorf("error: %s: VerifyTree returned %v, %v", label, ok, err)
+	}
+
+	n := len(want.Leafs)
+	for _, indices := range [][]int{{0}, {n - 1}, {0, n - 1}, {n / 3, n / 2, n - 1}} {
+		mp, err := got.GetMultiProof(indices)
+		if err != nil {
+			t.Fatalf("error: %s: GetMultiProof(%v): %v", label, indices, err)
+		}
+		wantMP, err := want.GetMultiProof(indices)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(mp, wantMP) {
+			t.Fatalf("error: %s: GetMultiProof(%v) differs from the Node layout's", label, indices)
+		}
+	}
+	if want.RFC6962() {
+		for size := 1; size <= n; size++ {
+			proof, err := got.ConsistencyProof(size)
+			if err != nil {
+				t.Fatalf("error: %s: ConsistencyProof(%d): %v", label, size, err)
+			}
+			wantProof, err := want.ConsistencyProof(size)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(proof, wantProof) {
+				t.Fatalf("error: %s: ConsistencyProof(%d) differs from the Node layout's", label, size)
+			}
+		}
+	}
+}
+
+// TestFlatLayoutMatchesNodes checks a flat tree serves what the Node tree serves for
+// every construction and size.
+func TestFlatLayoutMatchesNodes(t *testing.T) {
+	for _, mode := range propModes {
+		for _, n := range propSizes {
+			t.Run(fmt.Sprintf("%s/n=%d", mode.name, n), func(t *testing.T) {
+				contents := propSeries(n)
+				want, err := mode.build(contents, sha256.New)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				got, err := NewTreeWithOptions(contents, flatOpts(mode)...)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				assertFlatMatches(t, "build", want, got)
+
+				if err := got.RebuildTree(); err != nil {
+					t.Fatalf("error: RebuildTree: %v", err)
+				}
+				assertFlatMatches(t, "rebuild", want, got)
+			})
+		}
+	}
+}
+
+// TestFlatLayoutChanges checks Append and UpdateLeaves on a flat tree keep it the tree
+// the Node layout has after the same changes, leaf index included.
+func TestFlatLayoutChanges(t *testing.T) {
+	for _, mode := range propModes {
+		for _, n := range []int{1, 2, 5, 8, 13} {
+			t.Run(fmt.Sprintf("%s/n=%d", mode.name, n), func(t *testing.T) {
+				contents := propSeries(n + 9)
+				want, err := NewTreeWithOptions(contents[:n], append(optsFor(mode, sha256.New), WithLeafIndex())...)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				got, err := NewTreeWithOptions(contents[:n], append(flatOpts(mode), WithLeafIndex())...)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				root := got.MerkleRoot()
+				before := bytes.Clone(root)
+
+				for _, batch := range [][]Content{contents[n : n+1], contents[n+1 : n+4], contents[n+4:]} {
+					if err := want.Append(batch...); err != nil {
+						t.Fatalf("error: unexpected error: %v", err)
+					}
+					if err := got.Append(batch...); err != nil {
+						t.Fatalf("error: Append: %v", err)
+					}
+					assertFlatMatches(t, "append", want, got)
+				}
+				if !bytes.Equal(root, before) {
+					t.Error("error: Append changed a root returned before it")
+				}
+
+				updates := map[int]Content{0: propContent{x: "first"}, len(contents) - 1: propContent{x: "last"}, 3: contents[0]}
+				if err := want.UpdateLeaves(updates); err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				if err := got.UpdateLeaves(updates); err != nil {
+					t.Fatalf("error: UpdateLeaves: %v", err)
+				}
+				assertFlatMatches(t, "update", want, got)
+				if !reflect.DeepEqual(got.leafIndex, want.leafIndex) {
+					t.Error("error: leaf index differs from the Node layout's")
+				}
+				for _, c := range []Content{contents[0], contents[5], propContent{x: "last"}} {
+					wantPath, _, wantErr := want.GetMerklePath(c)
+					path, _, err := got.GetMerklePath(c)
+					if !reflect.DeepEqual(path, wantPath) || (err == nil) != (wantErr == nil) {
+						t.Errorf("error: GetMerklePath(%v) differs from the Node layout's: %v, %v", c, err, wantErr)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestFlatLayoutHashesOutliveChanges checks that the hashes a flat tree hands out keep
+// their values when Append and UpdateLeaf rewrite its levels, as the Node layout's do.
+func TestFlatLayoutHashesOutliveChanges(t *testing.T) {
+	for k, opts := range [][]TreeOption{nil, {WithFlatLayout()}, {WithFlatLayout(), WithRFC6962()}, {WithArity(3)}} {
+		tree, err := NewTreeWithOptions(propSeries(5), opts...)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		path, _, err := tree.GetMerklePathByIndex(0)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		node, err := tree.NodeHash(1, 0)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		handedOut := append(path, node)
+		if tree.Arity() == 2 {
+			mp, err := tree.GetMultiProof([]int{0, 3})
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			handedOut = append(handedOut, mp.Proof...)
+		}
+		if tree.RFC6962() {
+			proof, err := tree.ConsistencyProof(3)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			handedOut = append(handedOut, proof...)
+		}
+		before := make([][]byte, len(handedOut))
+		for i, h := range handedOut {
+			before[i] = bytes.Clone(h)
+		}
+
+		if err := tree.UpdateLeaf(4, propContent{x: "updated"}); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if err := tree.UpdateLeaf(1, propContent{x: "updated too"}); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if err := tree.Append(propSeries(3)...); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(handedOut, before) {
+			t.Errorf("error: options %d: hashes handed out before the changes were overwritten", k)
+		}
+	}
+}
+
+// TestFlatLayoutSortedContent checks the flat layout serves absence proofs for a sorted
+// content tree as the Node layout does.
+func TestFlatLayoutSortedContent(t *testing.T) {
+	contents := sortedKeys(11)
+	want, err := NewSortedContentTree(contents, compareProp)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	got, err := NewSortedContentTree(contents, compareProp, WithFlatLayout())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	assertFlatMatches(t, "sorted content", want, got)
+
+	for _, c := range []Content{propContent{x: "a"}, propContent{x: "k0003"}, propContent{x: "z"}} {
+		proof, err := got.ProveAbsence(c)
+		if err != nil {
+			t.Fatalf("error: ProveAbsence(%v): %v", c, err)
+		}
+		wantProof, err := want.ProveAbsence(c)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(proof, wantProof) {
+			t.Errorf("error: ProveAbsence(%v) differs from the Node layout's", c)
+		}
+	}
+	if _, err := got.ProveAbsence(contents[4]); !errors.Is(err, ErrContentPresent) {
+		t.Errorf("error: expected ErrContentPresent, got %v", err)
+	}
+}
+
+// TestFlatLayoutSerialization checks a flat tree encodes to the bytes the Node tree
+// encodes to, and that what it encodes decodes.
+func TestFlatLayoutSerialization(t *testing.T) {
+	contents := []Content{
+		TestSHA256Content{x: "Hello"},
+		TestSHA256Content{x: "Hi"},
+		TestSHA256Content{x: "Hey"},
+	}
+	want, err := NewTreeWithOptions(contents, WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	got, err := NewTreeWithOptions(contents, WithRFC6962(), WithFlatLayout())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	data, err := got.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error: MarshalBinary: %v", err)
+	}
+	wantData, err := want.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(data, wantData) {
+		t.Error("error: flat tree encodes differently from the Node tree")
+	}
+	var decoded MerkleTree
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("error: UnmarshalBinary: %v", err)
+	}
+	if !bytes.Equal(decoded.MerkleRoot(), want.MerkleRoot()) {
+		t.Error("error: decoded tree has a different root")
+	}
+
+	js, err := got.MarshalJSON()
+	if err != nil {
+		t.Fatalf("error: MarshalJSON: %v", err)
+	}
+	wantJS, err := want.MarshalJSON()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(js, wantJS) {
+		t.Error("error: flat tree encodes to different JSON from the Node tree")
+	}
+}
+
+// TestFlatLayoutErrors covers what the flat layout refuses, and checks VerifyTree and
+// VerifyContent notice a tampered level.
+func TestFlatLayoutErrors(t *testing.T) {
+	if _, err := NewTreeWithOptions(nil, WithFlatLayout()); !errors.Is(err, ErrNoContent) {
+		t.Errorf("error: expected ErrNoContent, got %v", err)
+	}
+	if _, err := NewTreeWithOptions([]Content{propContent{x: "a"}, nil}, WithFlatLayout()); !errors.Is(err, ErrNilContent) {
+		t.Errorf("error: expected ErrNilContent, got %v", err)
+	}
+	mixed := []Content{TestSHA256Content{x: "a"}, TestMD5Content{x: "b"}}
+	if _, err := NewTreeWithOptions(mixed, WithFlatLayout()); err == nil || !strings.Contains(err.Error(), "flat layout") {
+		t.Errorf("error: expected digests of different widths to be refused, got %v", err)
+	}
+
+	tree, err := NewTreeWithOptions(propSeries(6), WithFlatLayout())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	root := tree.MerkleRoot()
+	if err := tree.Append(TestMD5Content{x: "b"}); err == nil {
+		t.Error("error: expected Append of a narrower digest to be refused")
+	}
+	if err := tree.UpdateLeaf(2, TestMD5Content{x: "b"}); err == nil {
+		t.Error("error: expected UpdateLeaf with a narrower digest to be refused")
+	}
+	if !bytes.Equal(tree.MerkleRoot(), root) || tree.leafCount() != 6 {
+		t.Error("error: a refused change modified the tree")
+	}
+	if _, _, err := tree.GetMerklePathByIndex(6); !errors.Is(err, ErrContentNotFound) {
+		t.Errorf("error: expected ErrContentNotFound, got %v", err)
+	}
+
+	tree.flat.levels[1][0] ^= 1
+	if ok, err := tree.VerifyTree(); err != nil || ok {
+		t.Errorf("error: VerifyTree of a tampered tree returned %v, %v", ok, err)
+	}
+	if ok, err := tree.VerifyContent(propContent{x: "item-0"}); err != nil || ok {
+		t.Errorf("error: VerifyContent over a tampered node returned %v, %v", ok, err)
+	}
+	if ok, err := tree.VerifyContent(propContent{x: "item-5"}); err != nil || !ok {
+		t.Errorf("error: VerifyContent away from the tampered node returned %v, %v", ok, err)
+	}
+}
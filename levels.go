@@ -0,0 +1,120 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Len returns the number of content items the tree holds. Unlike len(Leafs) it does not
+// count the padding leaf, and it works for a tree built with WithFlatLayout, which has
+// no Leafs.
+func (m *MerkleTree) Len() int {
+	if m.empty() {
+		return 0
+	}
+
+	return m.contentCount()
+}
+
+// ContentAt returns the content of leaf i, for i below Len. Returns ErrContentNotFound
+// otherwise.
+func (m *MerkleTree) ContentAt(i int) (Content, error) {
+	if err := m.requireContent("ContentAt"); err != nil {
+		return nil, err
+	}
+	if i < 0 || i >= m.Len() {
+		return nil, fmt.Errorf("%w: no leaf at index %d, the tree has %d", ErrContentNotFound, i, m.Len())
+	}
+
+	return m.leafContent(i), nil
+}
+
+// Height returns the number of levels above the leaves, which is the length of the
+// longest audit path. A tree of one leaf under WithRFC6962 has height zero; under the
+// other constructions its leaf is paired with a padding copy, and it has height one.
+func (m *MerkleTree) Height() int {
+	if m.flat != nil {
+		return len(m.flat.levels) - 1
+	}
+
+	height := 0
+	for count := m.leafCount(); count > 1; count = (count + 1) / 2 {
+		height++
+	}
+
+	return height
+}
+
+// LevelSize returns the number of nodes on level, counting up from the leaves at level
+// zero, or zero for a level the tree does not have. The leaf level includes the padding
+// leaf, and every level above has half as many nodes as the one below, rounded up, or
+// a kth of them under WithArity(k).
+func (m *MerkleTree) LevelSize(level int) int {
+	if level < 0 || level > m.Height() {
+		return 0
+	}
+	if m.flat != nil {
+		return m.flat.count(level)
+	}
+	count := m.leafCount()
+	for ; level > 0; level-- {
+		count = (count + 1) / 2
+	}
+
+	return count
+}
+
+// NodeHash returns the hash of node index on level, counting levels up from the leaves
+// and nodes from the left. Node j of a level is the parent of nodes 2j and 2j+1 of the
+// level below, whatever the layout and construction, so two trees of the same size and
+// construction can be compared a level at a time. Under WithArity(k) it is the parent
+// of nodes kj to kj+k-1.
+//
+// Under WithRFC6962 the last node of an odd level has no sibling and appears unchanged
+// on the level above; under the other constructions it is paired with itself. Returns
+// an error wrapping ErrNodeNotFound for a position the tree does not have. Under the Node
+// layout the slice is the tree's own; treat it as read only.
+func (m *MerkleTree) NodeHash(level, index int) ([]byte, error) {
+	if index < 0 || index >= m.LevelSize(level) {
+		return nil, fmt.Errorf("%w: level %d index %d", ErrNodeNotFound, level, index)
+	}
+	if m.flat != nil {
+		return bytes.Clone(m.flat.node(level, index)), nil
+	}
+
+	if !m.rfc6962 {
+		// Every level pairs node 2j with 2j+1, so the node is the ancestor of its
+		// leftmost leaf exactly level steps up.
+		n := m.Leafs[index<<level]
+		for ; level > 0; level-- {
+			if n.Parent == nil {
+				return nil, fmt.Errorf("%w: leaf is missing an ancestor", ErrMalformedTree)
+			}
+			n = n.Parent
+		}
+
+		return n.Hash, nil
+	}
+
+	// An RFC 6962 tree has no node for a position carried up unchanged, so find the
+	// node over the same leaves by descending the splits from the root.
+	count := len(m.Leafs)
+	begin, end := index<<level, min((index+1)<<level, count)
+	n, lo, hi := m.Root, 0, count
+	for lo != begin || hi != end {
+		if n == nil || n.Left == nil || n.Right == nil {
+			return nil, fmt.Errorf("%w: interior node is missing a child", ErrMalformedTree)
+		}
+		k := largestPowerOfTwoBelow(hi - lo)
+		if begin < lo+k {
+			n, hi = n.Left, lo+k
+		} else {
+			n, lo = n.Right, lo+k
+		}
+	}
+
+	return n.Hash, nil
+}
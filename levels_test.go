@@ -0,0 +1,109 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TestNodeHashLevels checks the level-wise view of a tree: every node is the hash of
+// its two children, or of the one child it pairs with itself or carries up, the top
+// level is the root, and both layouts agree on all of it.
+func TestNodeHashLevels(t *testing.T) {
+	for _, mode := range propModes {
+		for _, n := range propSizes {
+			t.Run(fmt.Sprintf("%s/n=%d", mode.name, n), func(t *testing.T) {
+				contents := propSeries(n)
+				tree, err := NewTreeWithOptions(contents, optsFor(mode, sha256.New)...)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				flat, err := NewTreeWithOptions(contents, append(optsFor(mode, sha256.New), WithFlatLayout())...)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				if tree.Len() != n || flat.Len() != n {
+					t.Errorf("error: Len is %d and %d, want %d", tree.Len(), flat.Len(), n)
+				}
+				if tree.Height() != flat.Height() {
+					t.Fatalf("error: heights %d and %d differ", tree.Height(), flat.Height())
+				}
+
+				for level := 0; level <= tree.Height(); level++ {
+					size := tree.LevelSize(level)
+					if size != flat.LevelSize(level) {
+						t.Fatalf("error: level %d: sizes %d and %d differ", level, size, flat.LevelSize(level))
+					}
+					for j := 0; j < size; j++ {
+						got, err := tree.NodeHash(level, j)
+						if err != nil {
+							t.Fatalf("error: NodeHash(%d, %d): %v", level, j, err)
+						}
+						if other, err := flat.NodeHash(level, j); err != nil || !bytes.Equal(got, other) {
+							t.Fatalf("error: level %d node %d: layouts disagree (%v)", level, j, err)
+						}
+						if level == 0 {
+							continue
+						}
+						left, _ := tree.NodeHash(level-1, 2*j)
+						right, err := tree.NodeHash(level-1, 2*j+1)
+						var want []byte
+						switch {
+						case err == nil:
+							want, _ = tree.hashInterior(left, right)
+						case mode.rfc6962:
+							want = left
+						default:
+							want, _ = tree.hashInterior(left, left)
+						}
+						if !bytes.Equal(got, want) {
+							t.Fatalf("error: level %d node %d is not the hash of its children", level, j)
+						}
+					}
+				}
+				if top, _ := tree.NodeHash(tree.Height(), 0); !bytes.Equal(top, tree.MerkleRoot()) {
+					t.Error("error: the top level is not the root")
+				}
+				for i, c := range contents {
+					if got, err := flat.ContentAt(i); err != nil || got != c {
+						t.Fatalf("error: ContentAt(%d) returned %v, %v", i, got, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestNodeHashErrors covers the positions a tree does not have.
+func TestNodeHashErrors(t *testing.T) {
+	tree, err := NewTree(propSeries(5))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for _, at := range [][2]int{{0, 6}, {0, -1}, {-1, 0}, {3, 1}, {4, 0}} {
+		if _, err := tree.NodeHash(at[0], at[1]); !errors.Is(err, ErrNodeNotFound) {
+			t.Errorf("error: NodeHash(%d, %d): expected ErrNodeNotFound, got %v", at[0], at[1], err)
+		}
+	}
+	if tree.LevelSize(4) != 0 || tree.LevelSize(-1) != 0 {
+		t.Error("error: expected levels outside the tree to be empty")
+	}
+	for _, i := range []int{-1, 5} {
+		if _, err := tree.ContentAt(i); !errors.Is(err, ErrContentNotFound) {
+			t.Errorf("error: ContentAt(%d): expected ErrContentNotFound, got %v", i, err)
+		}
+	}
+
+	var empty MerkleTree
+	if empty.Len() != 0 || empty.Height() != 0 {
+		t.Error("error: expected an empty tree to have no leaves and no height")
+	}
+	if _, err := empty.NodeHash(0, 0); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("error: expected ErrNodeNotFound, got %v", err)
+	}
+}
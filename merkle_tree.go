@@ -67,6 +67,26 @@ type MerkleTree struct {
 	hashStrategy     func() hash.Hash
 	sort             bool
 	rfc6962          bool
+	// bitcoin records that WithBitcoinCompat was asked for. The construction it asks
+	// for is the default one over double SHA-256, so it changes no hash; it only
+	// leaves a lone transaction unpadded, as its own root, and like parallelism it is
+	// absent from the serialized form.
+	bitcoin bool
+	// digests records that the tree was built with NewTreeFromDigests, which makes the
+	// registry marshalers write each leaf's digest in place of its content. Unlike
+	// parallelism it is recorded in the serialized form, by the form itself.
+	digests bool
+	// discardContent records that WithDiscardContent or Compact released the content
+	// of the leaves, and that content added later is to be released too. Like
+	// parallelism it is absent from the serialized form, which cannot be written
+	// without content.
+	discardContent bool
+	// arity is the number of children WithArity asked each interior node to have, or
+	// zero for a binary tree; WithArity(2) is normalized to zero, so that a tree it
+	// builds is in every respect the default one. A tree of higher arity is always
+	// flat, and refuses everything the serialized form and the proof formats cannot
+	// describe.
+	arity int
 	// parallelism is the goroutine budget for building this tree, or zero to build
 	// serially. Unlike sort and rfc6962 it does not affect the root, so it is a
 	// property of how a tree is built rather than of the tree itself, and it is
@@ -76,6 +96,15 @@ type MerkleTree struct {
 	// regenerates the index rather than silently dropping it. Like parallelism it
 	// does not affect the root and is absent from the serialized form.
 	wantLeafIndex bool
+	// compare is the order a tree built with NewSortedContentTree keeps its content
+	// in, or nil. Like parallelism it does not affect the root, and a function value
+	// cannot be serialized in any case, so it is absent from the serialized form.
+	compare func(a, b Content) int
+	// flatLayout records that WithFlatLayout was asked for, and flat holds the tree
+	// when it was, in place of Root and Leafs. Like parallelism the layout does not
+	// affect the root and is absent from the serialized form.
+	flatLayout bool
+	flat       *flatTree
 	// leafIndex maps a leaf hash to the lowest index in Leafs holding it, or is nil
 	// when the tree was built without WithLeafIndex. It is written only while a tree
 	// is being built or rebuilt and is read only afterwards, so proof serving needs
@@ -542,19 +571,95 @@ func NewTreeWithOptions(cs []Content, opts ...TreeOption) (*MerkleTree, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	root, leafs, err := buildWithContent(cs, t)
-	if err != nil {
+	if err := t.build(cs); err != nil {
 		return nil, err
 	}
-	t.Root = root
-	t.Leafs = leafs
-	t.merkleRoot = root.Hash
-	t.buildLeafIndex()
 
 	return t, nil
 }
 
+// build replaces whatever the tree holds with the tree over cs, in the layout the tree
+// was configured with, and regenerates the leaf index. Nothing changes on error.
+func (m *MerkleTree) build(cs []Content) error {
+	if m.flatLayout {
+		f, err := m.buildFlat(cs)
+		if err != nil {
+			return err
+		}
+		m.Root, m.Leafs, m.flat = nil, nil, f
+		m.merkleRoot = bytes.Clone(f.root())
+		m.buildLeafIndex()
+		if m.discardContent {
+			m.dropContent()
+		}
+
+		return nil
+	}
+
+	root, leafs, err := buildWithContent(cs, m)
+	if err != nil {
+		return err
+	}
+	m.Root, m.Leafs, m.flat = root, leafs, nil
+	m.merkleRoot = root.Hash
+	m.buildLeafIndex()
+	if m.discardContent {
+		m.dropContent()
+	}
+
+	return nil
+}
+
+// empty reports whether the tree holds nothing, as a zero value MerkleTree does.
+func (m *MerkleTree) empty() bool {
+	return m.flat == nil && (m.Root == nil || len(m.Leafs) == 0)
+}
+
+// leafCount returns the number of leaves, counting the padding leaf as Leafs does.
+func (m *MerkleTree) leafCount() int {
+	if m.flat != nil {
+		return m.flat.count(0)
+	}
+
+	return len(m.Leafs)
+}
+
+// leafHash returns the hash of leaf i, which must be in range.
+func (m *MerkleTree) leafHash(i int) []byte {
+	if m.flat != nil {
+		return m.flat.node(0, i)
+	}
+
+	return m.Leafs[i].Hash
+}
+
+// leafContent returns the content of leaf i, which must be in range. The padding leaf
+// holds the content of the leaf it copies.
+func (m *MerkleTree) leafContent(i int) Content {
+	if m.flat != nil {
+		return m.flat.contents[min(i, len(m.flat.contents)-1)]
+	}
+
+	return m.Leafs[i].C
+}
+
+// padsLeaves reports whether a tree of n items ends its leaves with a padding copy of
+// the last one. An odd count does under the default and sorted constructions, except
+// the one item of a WithBitcoinCompat tree, which Bitcoin makes the root itself.
+func (m *MerkleTree) padsLeaves(n int) bool {
+	return !m.rfc6962 && m.fanout() == 2 && n%2 == 1 && !(m.bitcoin && n == 1)
+}
+
+// leafIsPadding reports whether leaf i, which must be in range, is the padding copy of
+// the leaf before it.
+func (m *MerkleTree) leafIsPadding(i int) bool {
+	if m.flat != nil {
+		return m.flat.padded && i == len(m.flat.contents)
+	}
+
+	return m.Leafs[i].dup
+}
+
 // buildLeafIndex regenerates leafIndex from the current leaves, or clears it when the
 // tree was not asked for one. It is called after every build, so a rebuilt tree neither
 // keeps a stale index nor silently loses the one it was asked for.
@@ -574,22 +679,23 @@ func (m *MerkleTree) buildLeafIndex() {
 	// the index that way cost one allocation per leaf - the single largest source of
 	// allocation in an indexed build. Every key is retained by the map anyway, so
 	// sharing one backing keeps the same bytes alive in one object instead of n.
+	n := m.leafCount()
 	total := 0
-	for _, l := range m.Leafs {
-		total += len(l.Hash)
+	for i := 0; i < n; i++ {
+		total += len(m.leafHash(i))
 	}
 	var b strings.Builder
 	b.Grow(total)
-	for _, l := range m.Leafs {
-		b.Write(l.Hash)
+	for i := 0; i < n; i++ {
+		b.Write(m.leafHash(i))
 	}
 	keys := b.String()
 
-	idx := make(map[string]int, len(m.Leafs))
+	idx := make(map[string]int, n)
 	off := 0
-	for i, l := range m.Leafs {
-		k := keys[off : off+len(l.Hash)]
-		off += len(l.Hash)
+	for i := 0; i < n; i++ {
+		k := keys[off : off+len(m.leafHash(i))]
+		off += len(m.leafHash(i))
 		if _, seen := idx[k]; !seen {
 			idx[k] = i
 		}
@@ -615,9 +721,23 @@ func (m *MerkleTree) findLeaf(content Content) (int, error) {
 
 		return -1, nil
 	}
+	if m.discardContent {
+		// With no content to compare, the leaf hashes are what is left to match.
+		digest, err := m.hashLeaf(content)
+		if err != nil {
+			return -1, err
+		}
+		for i := 0; i < m.leafCount(); i++ {
+			if bytes.Equal(m.leafHash(i), digest) {
+				return i, nil
+			}
+		}
+
+		return -1, nil
+	}
 
-	for i, l := range m.Leafs {
-		ok, err := l.C.Equals(content)
+	for i := 0; i < m.leafCount(); i++ {
+		ok, err := m.leafContent(i).Equals(content)
 		if err != nil {
 			return -1, err
 		}
@@ -629,22 +749,33 @@ func (m *MerkleTree) findLeaf(content Content) (int, error) {
 	return -1, nil
 }
 
-// pathFromLeaf walks from a leaf up to the root, collecting the sibling hash at each
-// level and which side it sits on.
-func (m *MerkleTree) pathFromLeaf(current *Node) ([][]byte, []int64) {
+// pathFromLeaf walks from leaf i up to the root, collecting the sibling hash at each
+// level and which side it sits on. i must be in range.
+func (m *MerkleTree) pathFromLeaf(i int) ([][]byte, []int64) {
+	if m.arity > 2 {
+		n := groupPathLen(m.leafCount(), m.arity)
+
+		return m.appendPathFromLeaf(make([][]byte, 0, n), make([]int64, 0, n), i)
+	}
+
 	// The walk takes one step per level, so the depth of the tree is how many entries
 	// the two slices end up holding. bits.Len of the highest leaf position is that
 	// depth exactly, for a power-of-two count as much as a padded or split one, so
 	// the slices come out exact-fit; this is the hottest allocation site in the
 	// package, and a spare entry per proof is measurable at proof-serving rates.
-	depth := bits.Len(uint(len(m.Leafs) - 1))
+	depth := bits.Len(uint(m.leafCount() - 1))
 
-	return m.appendPathFromLeaf(make([][]byte, 0, depth), make([]int64, 0, depth), current)
+	return m.appendPathFromLeaf(make([][]byte, 0, depth), make([]int64, 0, depth), i)
 }
 
 // appendPathFromLeaf is pathFromLeaf appending into caller supplied slices. It is the
 // walk both the slice-returning and the appending proof methods share.
-func (m *MerkleTree) appendPathFromLeaf(merklePath [][]byte, index []int64, current *Node) ([][]byte, []int64) {
+func (m *MerkleTree) appendPathFromLeaf(merklePath [][]byte, index []int64, i int) ([][]byte, []int64) {
+	if m.flat != nil {
+		return m.flat.appendPath(merklePath, index, i, m.rfc6962)
+	}
+
+	current := m.Leafs[i]
 	for currentParent := current.Parent; currentParent != nil; currentParent = current.Parent {
 		// Whether current sits on the left or the right is a structural question, so
 		// compare node identity rather than hashes. Comparing hashes gives the same
@@ -688,7 +819,7 @@ func (m *MerkleTree) GetMerklePath(content Content) ([][]byte, []int64, error) {
 		return nil, nil, ErrContentNotFound
 	}
 
-	merklePath, index := m.pathFromLeaf(m.Leafs[i])
+	merklePath, index := m.pathFromLeaf(i)
 
 	return merklePath, index, nil
 }
@@ -705,11 +836,11 @@ func (m *MerkleTree) GetMerklePath(content Content) ([][]byte, []int64, error) {
 //
 // Returns ErrContentNotFound if i is outside the range of Leafs.
 func (m *MerkleTree) GetMerklePathByIndex(i int) ([][]byte, []int64, error) {
-	if i < 0 || i >= len(m.Leafs) {
-		return nil, nil, fmt.Errorf("%w: no leaf at index %d, the tree has %d", ErrContentNotFound, i, len(m.Leafs))
+	if i < 0 || i >= m.leafCount() {
+		return nil, nil, fmt.Errorf("%w: no leaf at index %d, the tree has %d", ErrContentNotFound, i, m.leafCount())
 	}
 
-	merklePath, index := m.pathFromLeaf(m.Leafs[i])
+	merklePath, index := m.pathFromLeaf(i)
 
 	return merklePath, index, nil
 }
@@ -746,7 +877,7 @@ func (m *MerkleTree) AppendMerklePath(path [][]byte, index []int64, content Cont
 		return path, index, ErrContentNotFound
 	}
 
-	path, index = m.appendPathFromLeaf(path, index, m.Leafs[i])
+	path, index = m.appendPathFromLeaf(path, index, i)
 
 	return path, index, nil
 }
@@ -760,11 +891,11 @@ func (m *MerkleTree) AppendMerklePath(path [][]byte, index []int64, content Cont
 // Returns ErrContentNotFound and the slices unchanged if i is outside the range of
 // Leafs.
 func (m *MerkleTree) AppendMerklePathByIndex(path [][]byte, index []int64, i int) ([][]byte, []int64, error) {
-	if i < 0 || i >= len(m.Leafs) {
-		return path, index, fmt.Errorf("%w: no leaf at index %d, the tree has %d", ErrContentNotFound, i, len(m.Leafs))
+	if i < 0 || i >= m.leafCount() {
+		return path, index, fmt.Errorf("%w: no leaf at index %d, the tree has %d", ErrContentNotFound, i, m.leafCount())
 	}
 
-	path, index = m.appendPathFromLeaf(path, index, m.Leafs[i])
+	path, index = m.appendPathFromLeaf(path, index, i)
 
 	return path, index, nil
 }
@@ -891,7 +1022,7 @@ func buildWithContent(cs []Content, t *MerkleTree) (*Node, []*Node, error) {
 	// A default-construction tree pads an odd leaf count with a duplicate; an
 	// RFC 6962 tree splits instead and so holds exactly what the caller supplied.
 	leafCount := len(cs)
-	if !t.rfc6962 && leafCount%2 == 1 {
+	if t.padsLeaves(leafCount) {
 		leafCount++
 	}
 	slab := make([]Node, leafCount)
@@ -974,7 +1105,7 @@ func buildWithContent(cs []Content, t *MerkleTree) (*Node, []*Node, error) {
 
 		return root, leafs, nil
 	}
-	if len(cs)%2 == 1 {
+	if t.padsLeaves(len(cs)) {
 		last := leafs[len(leafs)-1]
 		n := &slab[len(cs)]
 		n.Hash = last.Hash
@@ -1241,9 +1372,28 @@ func (m *MerkleTree) RFC6962() bool {
 	return m.rfc6962
 }
 
+// IsRFC6962SHA256 reports whether the tree was built with WithRFC6962 and the default
+// hash strategy, SHA-256: the tree Certificate Transparency logs and Go's checksum
+// database build, and so the one their formats and tools describe. It reads the hash
+// strategy the tree records, registered as "sha256", and nothing from the leaves.
+func (m *MerkleTree) IsRFC6962SHA256() bool {
+	name := m.hashStrategyName
+	if name == "" {
+		name, _ = lookupHashStrategyName(m.hashStrategy)
+	}
+
+	return m.rfc6962 && name == "sha256"
+}
+
 // RebuildTree is a helper function that will rebuild the tree reusing only the content that
 // it holds in the leaves.
 func (m *MerkleTree) RebuildTree() error {
+	if err := m.requireContent("RebuildTree"); err != nil {
+		return err
+	}
+	if m.flat != nil {
+		return m.build(m.flat.contents)
+	}
 	// Sized to the leaf count up front; at most one entry, the padding copy, goes
 	// unused.
 	cs := make([]Content, 0, len(m.Leafs))
@@ -1259,30 +1409,23 @@ func (m *MerkleTree) RebuildTree() error {
 		}
 		cs = append(cs, c.C)
 	}
-	root, leafs, err := buildWithContent(cs, m)
-	if err != nil {
-		return err
-	}
-	m.Root = root
-	m.Leafs = leafs
-	m.merkleRoot = root.Hash
-	m.buildLeafIndex()
-	return nil
+	return m.build(cs)
 }
 
 // RebuildTreeWith replaces the content of the tree and does a complete rebuild; while the root of
 // the tree will be replaced the MerkleTree completely survives this operation. Returns an error if the
-// list of content cs contains no entries.
+// list of content cs contains no entries. A tree built with NewSortedContentTree sorts cs first, as
+// NewSortedContentTree does.
 func (m *MerkleTree) RebuildTreeWith(cs []Content) error {
-	root, leafs, err := buildWithContent(cs, m)
-	if err != nil {
-		return err
+	// A tree built with NewSortedContentTree keeps its order through a rebuild.
+	if m.compare != nil {
+		sorted, err := m.sortContent(cs)
+		if err != nil {
+			return err
+		}
+		cs = sorted
 	}
-	m.Root = root
-	m.Leafs = leafs
-	m.merkleRoot = root.Hash
-	m.buildLeafIndex()
-	return nil
+	return m.build(cs)
 }
 
 // VerifyTree verify tree validates the hashes at each level of the tree and returns true if the
@@ -1291,6 +1434,12 @@ func (m *MerkleTree) VerifyTree() (bool, error) {
 	// A zero value MerkleTree has no root to walk. Report it rather than faulting,
 	// so that a caller handed a tree from elsewhere can tell "never built" apart
 	// from "built and does not verify".
+	if err := m.requireContent("VerifyTree"); err != nil {
+		return false, err
+	}
+	if m.flat != nil {
+		return m.verifyFlat()
+	}
 	if m.Root == nil {
 		return false, fmt.Errorf("%w: tree has no root", ErrMalformedTree)
 	}
@@ -1315,6 +1464,9 @@ func (m *MerkleTree) VerifyTree() (bool, error) {
 // Returns true if the expected Merkle Root is equivalent to the Merkle root calculated on the critical path
 // for a given content. Returns true if valid and false otherwise.
 func (m *MerkleTree) VerifyContent(content Content) (bool, error) {
+	if err := m.requireContent("VerifyContent"); err != nil {
+		return false, err
+	}
 	// Locating the content carries the same O(n) scan as GetMerklePath unless the
 	// tree was built with WithLeafIndex.
 	i, err := m.findLeaf(content)
@@ -1324,6 +1476,9 @@ func (m *MerkleTree) VerifyContent(content Content) (bool, error) {
 	if i < 0 {
 		return false, nil
 	}
+	if m.flat != nil {
+		return m.verifyFlatContent(i)
+	}
 
 	// One hasher and one buffer serve the whole climb, the way verifyNode threads
 	// them through its walk. Recomputing a level takes three digests - each child
@@ -1383,6 +1538,13 @@ func (m *MerkleTree) String() string {
 	// One builder rather than repeated string concatenation, which re-copies the
 	// whole prefix on every leaf and turns printing a large tree quadratic.
 	var sb strings.Builder
+	if m.flat != nil {
+		// The same line a leaf Node prints, without a Node to print it.
+		for i := 0; i < m.leafCount(); i++ {
+			fmt.Fprintf(&sb, "%t %t %v %s\n", true, m.leafIsPadding(i), m.leafHash(i), m.leafContent(i))
+		}
+		return sb.String()
+	}
 	for _, l := range m.Leafs {
 		fmt.Fprintln(&sb, l)
 	}
@@ -0,0 +1,421 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+)
+
+// MMR is a Merkle Mountain Range: an append-only accumulator that holds a list of leaves
+// as a row of perfect binary trees, its peaks, of strictly decreasing size. Appending a
+// leaf adds a peak of one, and merges the two rightmost peaks for as long as they are
+// the same size, the way a binary counter carries, so an append costs O(1) hashes
+// amortized and never touches a node it has already written.
+//
+// Hashing is RFC 6962's, with the leaf and interior prefixes WithRFC6962 applies, and
+// the root bags the peaks from the right: the last two peaks are hashed together, then
+// the one before them with that, and so on. That makes the root of an MMR of n leaves
+// exactly the RFC 6962 root of a MerkleTree built from the same n leaves with
+// WithRFC6962, so the two can be checked against each other, and consistency proofs
+// between sizes are the RFC's own, verified with VerifyConsistency.
+//
+// Only hashes are kept, not the content appended. The zero value is not usable; create
+// ranges with NewMMR. An MMR is safe for concurrent reads, and Append must not run
+// concurrently with anything else.
+type MMR struct {
+	// cfg carries the hash strategy, with the RFC 6962 construction selected, and
+	// provides the leaf and interior hashing.
+	cfg *MerkleTree
+	// levels[h][j] is the hash of the perfect subtree of 2^h leaves beginning at leaf
+	// j<<h. A node is written once, when the last leaf beneath it is appended, and
+	// every peak of every earlier size is among them.
+	levels [][][]byte
+	root   []byte
+}
+
+// MMRProof proves that a leaf is held by an MMR of a given size. It is in two parts:
+// Path climbs from the leaf to the peak holding it, and Peaks are every peak of the
+// range, left to right, which the verifier bags into the root.
+//
+// Peaks never change once formed, so a proof stays valid against the root of the size
+// it was produced at however far the range grows. Once the leaf's peak has merged into
+// a larger one, the proof for a later size has the same Path with more siblings after
+// it; see MMR.UpgradeProof.
+type MMRProof struct {
+	LeafIndex int
+	Size      int
+	Path      [][]byte
+	Peaks     [][]byte
+}
+
+// NewMMR returns an empty Merkle Mountain Range. It takes the same options as
+// NewTreeWithOptions, of which WithHasher is the one that matters: the construction is
+// RFC 6962 whatever the options say, so they need not include WithRFC6962 and cannot
+// include WithSortedSiblings.
+func NewMMR(opts ...TreeOption) (*MMR, error) {
+	cfg, err := configFromOptions(append(opts[:len(opts):len(opts)], WithRFC6962()))
+	if err != nil {
+		return nil, err
+	}
+	m := &MMR{cfg: cfg}
+	// MTH({}) = HASH(), the hash of the empty string.
+	m.root = cfg.hashStrategy().Sum(nil)
+
+	return m, nil
+}
+
+// Len returns the number of leaves appended.
+func (m *MMR) Len() int {
+	if len(m.levels) == 0 {
+		return 0
+	}
+
+	return len(m.levels[0])
+}
+
+// Append adds cs to the end of the range, in order, and updates the root.
+//
+// Every item is hashed before the range is modified, so a nil entry or a failing
+// Content.CalculateHash leaves it exactly as it was, and so does an error from the hash
+// strategy while peaks are merged.
+func (m *MMR) Append(cs ...Content) error {
+	if len(cs) == 0 {
+		return nil
+	}
+	leafHashes := make([][]byte, len(cs))
+	for i, c := range cs {
+		if c == nil {
+			return fmt.Errorf("%w: index %d", ErrNilContent, i)
+		}
+		digest, err := m.cfg.hashLeaf(c)
+		if err != nil {
+			return err
+		}
+		leafHashes[i] = digest
+	}
+
+	// Nodes are only ever appended to their levels, so undoing a failed append is a
+	// matter of cutting each level back to its old length.
+	lengths := make([]int, len(m.levels))
+	for h, level := range m.levels {
+		lengths[h] = len(level)
+	}
+	err := m.appendLeaves(leafHashes)
+	var root []byte
+	if err == nil {
+		root, err = m.rootAt(m.Len())
+	}
+	if err != nil {
+		m.levels = m.levels[:len(lengths)]
+		for h, n := range lengths {
+			m.levels[h] = m.levels[h][:n]
+		}
+		return err
+	}
+	m.root = root
+
+	return nil
+}
+
+// appendLeaves adds the leaf hashes to the bottom level, merging peaks as it goes.
+func (m *MMR) appendLeaves(leafHashes [][]byte) error {
+	h := m.cfg.hashStrategy()
+	for _, leaf := range leafHashes {
+		if len(m.levels) == 0 {
+			m.levels = append(m.levels, nil)
+		}
+		m.levels[0] = append(m.levels[0], leaf)
+		for l := 0; len(m.levels[l])%2 == 0; l++ {
+			n := len(m.levels[l])
+			parent, err := m.cfg.appendInteriorHash(h, nil, m.levels[l][n-2], m.levels[l][n-1])
+			if err != nil {
+				return err
+			}
+			if l+1 == len(m.levels) {
+				m.levels = append(m.levels, nil)
+			}
+			m.levels[l+1] = append(m.levels[l+1], parent)
+		}
+	}
+
+	return nil
+}
+
+// Root returns the root of the range, the bagged peaks, which is the RFC 6962 root of
+// the same leaves. An empty range has the RFC's root for no leaves, the hash of the
+// empty string. The slice is the range's own; treat it as read only.
+func (m *MMR) Root() []byte {
+	return m.root
+}
+
+// RootAt returns the root the range had when it held size leaves, as Root returned
+// it then.
+func (m *MMR) RootAt(size int) ([]byte, error) {
+	if size < 0 || size > m.Len() {
+		return nil, fmt.Errorf("error: no root for size %d, the range has %d leaves", size, m.Len())
+	}
+	if size == 0 {
+		return m.cfg.hashStrategy().Sum(nil), nil
+	}
+
+	return m.rootAt(size)
+}
+
+// Peaks returns the peaks of the range, left to right. The slices are the range's own;
+// treat them as read only.
+func (m *MMR) Peaks() [][]byte {
+	return m.peaksAt(m.Len())
+}
+
+// peaksAt returns the peaks of the range as it was at size leaves: one for each set bit
+// of size, largest first.
+func (m *MMR) peaksAt(size int) [][]byte {
+	peaks := make([][]byte, 0, bits.OnesCount(uint(size)))
+	start := 0
+	for h := bits.Len(uint(size)) - 1; h >= 0; h-- {
+		if size>>h&1 == 1 {
+			peaks = append(peaks, m.levels[h][start>>h])
+			start += 1 << h
+		}
+	}
+
+	return peaks
+}
+
+// rootAt bags the peaks of size leaves, which must be at least one.
+func (m *MMR) rootAt(size int) ([]byte, error) {
+	return m.cfg.bagPeaks(m.peaksAt(size))
+}
+
+// bagPeaks folds peaks into a root from the right.
+func (m *MerkleTree) bagPeaks(peaks [][]byte) ([]byte, error) {
+	h := m.hashStrategy()
+	root := peaks[len(peaks)-1]
+	for j := len(peaks) - 2; j >= 0; j-- {
+		var err error
+		if root, err = m.appendInteriorHash(h, nil, peaks[j], root); err != nil {
+			return nil, err
+		}
+	}
+
+	return root, nil
+}
+
+// GetProof returns the proof that leaf i is held by the range at its current size.
+// Returns ErrContentNotFound if there is no leaf i. The hashes are the range's own, not
+// copies; treat them as read only.
+func (m *MMR) GetProof(i int) (*MMRProof, error) {
+	return m.GetProofAt(i, m.Len())
+}
+
+// GetProofAt returns the proof that leaf i was held by the range when it held size
+// leaves, which verifies against the root it had then.
+func (m *MMR) GetProofAt(i, size int) (*MMRProof, error) {
+	if size < 1 || size > m.Len() {
+		return nil, fmt.Errorf("error: no proof at size %d, the range has %d leaves", size, m.Len())
+	}
+	if i < 0 || i >= size {
+		return nil, fmt.Errorf("%w: no leaf at index %d, the range had %d", ErrContentNotFound, i, size)
+	}
+
+	_, height := mmrPeakOf(i, size)
+	path := make([][]byte, 0, height)
+	for l := 0; l < height; l++ {
+		path = append(path, m.levels[l][(i>>l)^1])
+	}
+
+	return &MMRProof{LeafIndex: i, Size: size, Path: path, Peaks: m.peaksAt(size)}, nil
+}
+
+// UpgradeProof returns the proof for the leaf p is for at the range's current size,
+// after checking that p is a proof this range produced. The new Path begins with p's,
+// so a holder that keeps a proof up to date need only store the siblings it gains.
+//
+// Returns an error wrapping ErrMalformedProof if p is not the right shape, or does not
+// match the range at the size it records.
+func (m *MMR) UpgradeProof(p *MMRProof) (*MMRProof, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	if p.Size > m.Len() {
+		return nil, fmt.Errorf("%w: proof is for size %d, the range has %d leaves", ErrMalformedProof, p.Size, m.Len())
+	}
+	old, err := m.GetProofAt(p.LeafIndex, p.Size)
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range [][2][][]byte{{p.Path, old.Path}, {p.Peaks, old.Peaks}} {
+		for k := range pair[0] {
+			if !bytes.Equal(pair[0][k], pair[1][k]) {
+				return nil, fmt.Errorf("%w: proof does not match the range at size %d", ErrMalformedProof, p.Size)
+			}
+		}
+	}
+
+	return m.GetProof(p.LeafIndex)
+}
+
+// ConsistencyProof returns the RFC 6962 consistency proof that the range of the first
+// size leaves is a prefix of the range now, to be checked with VerifyConsistency
+// under WithRFC6962. It is MerkleTree.ConsistencyProof for a range, and returns the
+// same proof a MerkleTree over the same leaves would.
+func (m *MMR) ConsistencyProof(size int) ([][]byte, error) {
+	n := m.Len()
+	if size < 1 || size > n {
+		return nil, fmt.Errorf("error: no consistency proof for size %d, the range has %d leaves", size, n)
+	}
+
+	return m.appendSubproof(make([][]byte, 0, consistencyProofLen(size, n, true)), size, 0, n, true)
+}
+
+// appendSubproof appends SUBPROOF(size, D[lo:hi], complete) to proof, as the function
+// of the same name does for a MerkleTree.
+func (m *MMR) appendSubproof(proof [][]byte, size, lo, hi int, complete bool) ([][]byte, error) {
+	if size == hi-lo {
+		if complete {
+			return proof, nil
+		}
+		mth, err := m.rangeHash(lo, hi)
+		if err != nil {
+			return nil, err
+		}
+		return append(proof, mth), nil
+	}
+
+	k := largestPowerOfTwoBelow(hi - lo)
+	var err error
+	var mth []byte
+	if size <= k {
+		if proof, err = m.appendSubproof(proof, size, lo, lo+k, complete); err != nil {
+			return nil, err
+		}
+		mth, err = m.rangeHash(lo+k, hi)
+	} else {
+		if proof, err = m.appendSubproof(proof, size-k, lo+k, hi, false); err != nil {
+			return nil, err
+		}
+		mth, err = m.rangeHash(lo, lo+k)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return append(proof, mth), nil
+}
+
+// rangeHash returns MTH(D[lo:hi]) for a range the RFC 6962 recursion visits. Such a
+// range of a power of two length always begins at a multiple of its length, so it is
+// one stored node, and any other range splits into ranges that are.
+func (m *MMR) rangeHash(lo, hi int) ([]byte, error) {
+	n := hi - lo
+	if n&(n-1) == 0 {
+		h := bits.TrailingZeros(uint(n))
+		return m.levels[h][lo>>h], nil
+	}
+
+	k := largestPowerOfTwoBelow(n)
+	left, err := m.rangeHash(lo, lo+k)
+	if err != nil {
+		return nil, err
+	}
+	right, err := m.rangeHash(lo+k, hi)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.cfg.hashInterior(left, right)
+}
+
+// mmrPeakOf returns the position among the peaks of a range of size leaves of the peak
+// holding leaf i, and that peak's height.
+func mmrPeakOf(i, size int) (int, int) {
+	start, k := 0, 0
+	for h := bits.Len(uint(size)) - 1; h >= 0; h-- {
+		if size>>h&1 == 0 {
+			continue
+		}
+		if i < start+1<<h {
+			return k, h
+		}
+		start += 1 << h
+		k++
+	}
+
+	return -1, -1
+}
+
+// validate checks the proof is the shape its leaf index and size give it.
+func (p *MMRProof) validate() error {
+	if p == nil {
+		return fmt.Errorf("%w: no proof", ErrMalformedProof)
+	}
+	if p.Size < 1 || p.LeafIndex < 0 || p.LeafIndex >= p.Size {
+		return fmt.Errorf("%w: no leaf %d in a range of size %d", ErrMalformedProof, p.LeafIndex, p.Size)
+	}
+	if want := bits.OnesCount(uint(p.Size)); len(p.Peaks) != want {
+		return fmt.Errorf("%w: a range of size %d has %d peaks, got %d", ErrMalformedProof, p.Size, want, len(p.Peaks))
+	}
+	if _, height := mmrPeakOf(p.LeafIndex, p.Size); len(p.Path) != height {
+		return fmt.Errorf("%w: leaf %d of a range of size %d has a path of %d hashes, got %d", ErrMalformedProof, p.LeafIndex, p.Size, height, len(p.Path))
+	}
+
+	return nil
+}
+
+// Verify reports whether the proof shows content to be held by a range whose root is
+// root. The options select the hash strategy as they do for NewMMR.
+//
+// Returns false when the proof is the right shape and does not reproduce root, and an
+// error wrapping ErrMalformedProof when its path or peaks are not the number its leaf
+// index and size give it.
+func (p *MMRProof) Verify(content Content, root []byte, opts ...TreeOption) (bool, error) {
+	if content == nil {
+		return false, ErrNilContent
+	}
+	digest, err := content.CalculateHash()
+	if err != nil {
+		return false, err
+	}
+
+	return p.VerifyDigest(digest, root, opts...)
+}
+
+// VerifyDigest is Verify for a verifier that holds the leaf digest, the value
+// Content.CalculateHash returns, rather than the content.
+func (p *MMRProof) VerifyDigest(digest, root []byte, opts ...TreeOption) (bool, error) {
+	if err := p.validate(); err != nil {
+		return false, err
+	}
+	cfg, err := configFromOptions(append(opts[:len(opts):len(opts)], WithRFC6962()))
+	if err != nil {
+		return false, err
+	}
+
+	h := cfg.hashStrategy()
+	cur, err := cfg.appendLeafDigest(h, nil, digest)
+	if err != nil {
+		return false, err
+	}
+	for l, sibling := range p.Path {
+		if p.LeafIndex>>l&1 == 0 {
+			cur, err = cfg.appendInteriorHash(h, nil, cur, sibling)
+		} else {
+			cur, err = cfg.appendInteriorHash(h, nil, sibling, cur)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	if k, _ := mmrPeakOf(p.LeafIndex, p.Size); !bytes.Equal(cur, p.Peaks[k]) {
+		return false, nil
+	}
+	bagged, err := cfg.bagPeaks(p.Peaks)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(bagged, root), nil
+}
@@ -0,0 +1,252 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TestMMRMatchesRFC6962Tree checks the bagged root of every size is the RFC 6962 root
+// of a tree over the same leaves, and that the roots of earlier sizes are kept.
+func TestMMRMatchesRFC6962Tree(t *testing.T) {
+	const n = 40
+	contents := propSeries(n)
+	mmr, err := NewMMR(WithHasher(sha512.New))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if want := sha512.New().Sum(nil); !bytes.Equal(mmr.Root(), want) {
+		t.Errorf("error: empty root is %x, want %x", mmr.Root(), want)
+	}
+
+	roots := make([][]byte, 0, n)
+	for size := 1; size <= n; size++ {
+		if err := mmr.Append(contents[size-1]); err != nil {
+			t.Fatalf("error: Append(%d): %v", size, err)
+		}
+		tree, err := NewTreeWithOptions(contents[:size], WithRFC6962(), WithHasher(sha512.New))
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if !bytes.Equal(mmr.Root(), tree.MerkleRoot()) {
+			t.Fatalf("error: size %d: root %x, RFC 6962 tree %x", size, mmr.Root(), tree.MerkleRoot())
+		}
+		roots = append(roots, tree.MerkleRoot())
+	}
+	for size, want := range roots {
+		got, err := mmr.RootAt(size + 1)
+		if err != nil {
+			t.Fatalf("error: RootAt(%d): %v", size+1, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("error: RootAt(%d) is %x, want %x", size+1, got, want)
+		}
+	}
+
+	batch, err := NewMMR(WithHasher(sha512.New))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := batch.Append(contents...); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(batch.Root(), mmr.Root()) || batch.Len() != n {
+		t.Error("error: appending in one batch gave a different range")
+	}
+	if got := len(mmr.Peaks()); got != 2 {
+		t.Errorf("error: a range of 40 leaves has %d peaks, want 2", got)
+	}
+}
+
+// TestMMRProofs checks every proof verifies against the root of the size it was made
+// at, stays valid as the range grows, and upgrades to a proof for the new size that
+// extends the old path.
+func TestMMRProofs(t *testing.T) {
+	const n = 33
+	contents := propSeries(n)
+	mmr, err := NewMMR()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	var held []*MMRProof
+	var heldRoots [][]byte
+	for size := 1; size <= n; size++ {
+		if err := mmr.Append(contents[size-1]); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		p, err := mmr.GetProof(size - 1)
+		if err != nil {
+			t.Fatalf("error: GetProof(%d): %v", size-1, err)
+		}
+		held = append(held, p)
+		heldRoots = append(heldRoots, mmr.Root())
+
+		for i := 0; i < size; i++ {
+			p, err := mmr.GetProof(i)
+			if err != nil {
+				t.Fatalf("error: GetProof(%d): %v", i, err)
+			}
+			if ok, err := p.Verify(contents[i], mmr.Root()); err != nil || !ok {
+				t.Fatalf("error: size %d leaf %d: proof did not verify (%v)", size, i, err)
+			}
+		}
+	}
+
+	for i, p := range held {
+		if ok, err := p.Verify(contents[i], heldRoots[i]); err != nil || !ok {
+			t.Errorf("error: leaf %d: proof no longer verifies against the root it was made for (%v)", i, err)
+		}
+		upgraded, err := mmr.UpgradeProof(p)
+		if err != nil {
+			t.Fatalf("error: UpgradeProof(%d): %v", i, err)
+		}
+		if upgraded.Size != n || len(upgraded.Path) < len(p.Path) {
+			t.Fatalf("error: leaf %d: upgraded proof has size %d and %d siblings", i, upgraded.Size, len(upgraded.Path))
+		}
+		for k := range p.Path {
+			if !bytes.Equal(upgraded.Path[k], p.Path[k]) {
+				t.Errorf("error: leaf %d: upgraded path does not extend the old one", i)
+			}
+		}
+		if ok, err := upgraded.Verify(contents[i], mmr.Root()); err != nil || !ok {
+			t.Errorf("error: leaf %d: upgraded proof did not verify (%v)", i, err)
+		}
+	}
+}
+
+// TestMMRProofRejects checks a proof binds its leaf, position and root, and that one of
+// the wrong shape is an error.
+func TestMMRProofRejects(t *testing.T) {
+	contents := propSeries(11)
+	mmr, err := NewMMR()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := mmr.Append(contents...); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	root := mmr.Root()
+	p, err := mmr.GetProof(4)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	if ok, _ := p.Verify(contents[5], root); ok {
+		t.Error("error: proof verified for different content")
+	}
+	if ok, _ := p.Verify(contents[4], contents[4].(propContent).mustHash(t)); ok {
+		t.Error("error: proof verified against a different root")
+	}
+	if ok, _ := p.Verify(contents[4], root, WithHasher(sha512.New)); ok {
+		t.Error("error: proof verified under a different hash strategy")
+	}
+	moved := *p
+	moved.LeafIndex = 5
+	if ok, _ := moved.Verify(contents[4], root); ok {
+		t.Error("error: proof verified at a different position")
+	}
+	peaks := *p
+	peaks.Peaks = append([][]byte{p.Peaks[1]}, p.Peaks[1:]...)
+	if ok, _ := peaks.Verify(contents[4], root); ok {
+		t.Error("error: proof verified with a changed peak")
+	}
+
+	for name, bad := range map[string]*MMRProof{
+		"nil":           nil,
+		"leaf outside":  {LeafIndex: 11, Size: 11, Path: p.Path, Peaks: p.Peaks},
+		"missing peak":  {LeafIndex: 4, Size: 11, Path: p.Path, Peaks: p.Peaks[1:]},
+		"extra sibling": {LeafIndex: 4, Size: 11, Path: append(p.Path[:len(p.Path):len(p.Path)], root), Peaks: p.Peaks},
+	} {
+		if _, err := bad.Verify(contents[4], root); !errors.Is(err, ErrMalformedProof) {
+			t.Errorf("error: %s: expected ErrMalformedProof, got %v", name, err)
+		}
+		if _, err := mmr.UpgradeProof(bad); !errors.Is(err, ErrMalformedProof) {
+			t.Errorf("error: %s: UpgradeProof expected ErrMalformedProof, got %v", name, err)
+		}
+	}
+	if _, err := mmr.UpgradeProof(&peaks); !errors.Is(err, ErrMalformedProof) {
+		t.Errorf("error: UpgradeProof of a foreign proof: expected ErrMalformedProof, got %v", err)
+	}
+	if _, err := mmr.GetProof(11); !errors.Is(err, ErrContentNotFound) {
+		t.Errorf("error: expected ErrContentNotFound, got %v", err)
+	}
+	if _, err := mmr.GetProofAt(0, 12); err == nil {
+		t.Error("error: expected GetProofAt to reject a size the range never had")
+	}
+}
+
+// TestMMRConsistency checks the prefix proofs are the ones a MerkleTree over the same
+// leaves gives, and that they verify with VerifyConsistency.
+func TestMMRConsistency(t *testing.T) {
+	const n = 21
+	contents := propSeries(n)
+	mmr, err := NewMMR()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := mmr.Append(contents...); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	tree, err := NewTreeWithOptions(contents, WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	for size := 1; size <= n; size++ {
+		proof, err := mmr.ConsistencyProof(size)
+		if err != nil {
+			t.Fatalf("error: ConsistencyProof(%d): %v", size, err)
+		}
+		want, err := tree.ConsistencyProof(size)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if fmt.Sprint(proof) != fmt.Sprint(want) {
+			t.Errorf("error: size %d: proof differs from the tree's", size)
+		}
+		oldRoot, err := mmr.RootAt(size)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if ok, err := VerifyConsistency(oldRoot, mmr.Root(), size, n, proof, WithRFC6962()); err != nil || !ok {
+			t.Errorf("error: size %d: consistency proof did not verify (%v)", size, err)
+		}
+	}
+	if _, err := mmr.ConsistencyProof(0); err == nil {
+		t.Error("error: expected ConsistencyProof to reject size 0")
+	}
+}
+
+// TestMMRAppendErrorsLeaveTheRangeAlone checks a refused append changes nothing, and
+// that the one construction option a range cannot use is refused.
+func TestMMRAppendErrorsLeaveTheRangeAlone(t *testing.T) {
+	if _, err := NewMMR(WithSortedSiblings()); err == nil {
+		t.Error("error: expected NewMMR to reject WithSortedSiblings")
+	}
+
+	mmr, err := NewMMR(WithHasher(sha256.New))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := mmr.Append(propSeries(3)...); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	root := mmr.Root()
+
+	if err := mmr.Append(propContent{x: "a"}, nil); !errors.Is(err, ErrNilContent) {
+		t.Errorf("error: expected ErrNilContent, got %v", err)
+	}
+	if err := mmr.Append(propContent{x: "a"}, failingContent{x: "b", failHash: true}); err == nil {
+		t.Error("error: expected a failing CalculateHash to be reported")
+	}
+	if mmr.Len() != 3 || !bytes.Equal(mmr.Root(), root) {
+		t.Error("error: a refused append modified the range")
+	}
+}
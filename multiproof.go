@@ -0,0 +1,307 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"slices"
+)
+
+// MultiProof proves that several leaves are all held by one tree. Interior nodes the
+// leaves' audit paths share are sent once, and a node the verifier can compute from the
+// leaves it already holds is not sent at all, so proving many leaves costs far less than
+// one audit path per leaf.
+//
+// Proof and Flags use the layout of OpenZeppelin's MerkleProof.multiProofVerify. The
+// tree is walked level by level from the leaves up, left to right, and every hashing
+// step is recorded as one flag: true when both children are nodes the verifier computes
+// itself, false when one of them is the next entry of Proof. A verifier working through
+// the leaves and then the hashes it produces, in order, as a queue needs nothing else.
+type MultiProof struct {
+	// Indices are the positions in Leafs the proof covers, ascending and distinct.
+	// The digests handed to VerifyMultiProof are taken in this order.
+	Indices []int
+	// LeafCount is the number of leaves in the tree the proof was taken from,
+	// len(Leafs), which under the default and sorted constructions counts the
+	// padding leaf of a tree holding an odd amount of content.
+	LeafCount int
+	// Proof holds the sibling hashes the verifier cannot compute, in the order they
+	// are consumed.
+	Proof [][]byte
+	// Flags holds one entry per hashing step, as described above.
+	Flags []bool
+}
+
+// GetMultiProof returns a proof that the leaves at the given positions in Leafs are all
+// held by this tree. The positions may be given in any order and may repeat; the proof
+// records them ascending and without repeats in MultiProof.Indices, and that is the
+// order VerifyMultiProof expects their digests in.
+//
+// A proof for a single position carries exactly the hashes of GetMerklePathByIndex for
+// it. Every further position only adds the siblings its path does not share with the
+// others, and positions next to each other need none at all for the levels where they
+// meet.
+//
+// Returns ErrContentNotFound if a position is outside the range of Leafs, as
+// GetMerklePathByIndex does, and an error if no position is given at all.
+//
+// The returned hashes are the tree's own, not copies; treat them as read only.
+func (m *MerkleTree) GetMultiProof(indices []int) (*MultiProof, error) {
+	if len(indices) == 0 {
+		return nil, errors.New("error: a multiproof needs at least one leaf")
+	}
+	if m.empty() {
+		return nil, fmt.Errorf("%w: tree has no root", ErrMalformedTree)
+	}
+	if err := m.requireBinary("a multiproof"); err != nil {
+		return nil, err
+	}
+
+	sorted := slices.Clone(indices)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+	if sorted[0] < 0 || sorted[len(sorted)-1] >= m.leafCount() {
+		bad := sorted[0]
+		if bad >= 0 {
+			bad = sorted[len(sorted)-1]
+		}
+
+		return nil, fmt.Errorf("%w: no leaf at index %d, the tree has %d", ErrContentNotFound, bad, m.leafCount())
+	}
+	if m.flat != nil {
+		return m.flatMultiProof(sorted), nil
+	}
+
+	type known struct {
+		j int
+		n *Node
+	}
+	cur := make([]known, len(sorted))
+	for k, i := range sorted {
+		cur[k] = known{i, m.Leafs[i]}
+	}
+	next := make([]known, 0, len(cur))
+
+	p := &MultiProof{Indices: sorted, LeafCount: len(m.Leafs)}
+	for count := len(m.Leafs); count > 1; count = (count + 1) / 2 {
+		next = next[:0]
+		for k := 0; k < len(cur); k++ {
+			j, n := cur[k].j, cur[k].n
+			parent := n.Parent
+			switch {
+			case j == count-1 && j%2 == 0 && m.rfc6962:
+				// RFC 6962 carries an unpaired node up a level unchanged.
+				next = append(next, known{j / 2, n})
+
+				continue
+			case parent == nil || parent.Left == nil || parent.Right == nil:
+				return nil, fmt.Errorf("%w: interior node is missing a child", ErrMalformedTree)
+			case j%2 == 0 && k+1 < len(cur) && cur[k+1].j == j+1:
+				p.Flags = append(p.Flags, true)
+				k++
+			case j%2 == 0:
+				// This is also where a node closing an odd level meets itself, its
+				// parent's right hand child being the node once more.
+				p.Proof = append(p.Proof, parent.Right.Hash)
+				p.Flags = append(p.Flags, false)
+			default:
+				p.Proof = append(p.Proof, parent.Left.Hash)
+				p.Flags = append(p.Flags, false)
+			}
+			next = append(next, known{j / 2, parent})
+		}
+		cur, next = next, cur
+	}
+
+	return p, nil
+}
+
+// VerifyMultiProof reports whether digests, the leaf digests at proof.Indices in that
+// order, together with proof reproduce root. It is the multiproof counterpart of
+// VerifyProofWithDigest and, like it, needs no tree. Each digest is the value
+// Content.CalculateHash returns for the leaf, under every construction.
+//
+// The options describe the construction the proof was produced under, exactly as they
+// do for VerifyProof. Under WithSortedSiblings the verification is OpenZeppelin's
+// multiProofVerify: the order of a pair does not matter to the hash, so Proof and Flags
+// alone determine the root and Indices and LeafCount are not consulted. A proof and
+// leaves produced by OpenZeppelin's own tooling therefore verify here too, given the
+// leaves in the order that tooling lists them. The other constructions hash a pair in
+// order, so they replay the walk GetMultiProof made, which Indices and LeafCount fix.
+//
+// Returns false when the proof is the right shape and does not reproduce root, and an
+// error wrapping ErrMalformedProof when it cannot be walked at all: a digest count that
+// does not match, indices out of order or outside the tree, or a proof or flag list of
+// the wrong length.
+func VerifyMultiProof(digests [][]byte, proof *MultiProof, root []byte, opts ...TreeOption) (bool, error) {
+	if proof == nil {
+		return false, fmt.Errorf("%w: no proof", ErrMalformedProof)
+	}
+	cfg, err := configFromOptions(opts)
+	if err != nil {
+		return false, err
+	}
+	if err := cfg.requireBinary("a multiproof"); err != nil {
+		return false, err
+	}
+	// Every hashing step consumes two known nodes and produces one, so whatever the
+	// construction a well formed proof leaves exactly one node over: the root.
+	if len(digests)+len(proof.Proof) != len(proof.Flags)+1 {
+		return false, fmt.Errorf("%w: %d leaves and %d proof hashes cannot take %d hashing steps", ErrMalformedProof, len(digests), len(proof.Proof), len(proof.Flags))
+	}
+
+	h := cfg.hashStrategy()
+	var computed []byte
+	if cfg.sort {
+		computed, err = cfg.multiProofRootByFlags(h, digests, proof)
+	} else {
+		computed, err = cfg.multiProofRootByIndex(h, digests, proof)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(computed, root), nil
+}
+
+// multiProofRootByFlags computes the root a sorted multiproof commits to, following
+// MerkleProof.processMultiProof statement for statement.
+// https://github.com/OpenZeppelin/openzeppelin-contracts/blob/master/contracts/utils/cryptography/MerkleProof.sol
+func (m *MerkleTree) multiProofRootByFlags(h hash.Hash, leaves [][]byte, proof *MultiProof) ([]byte, error) {
+	var (
+		hashes                     = make([][]byte, len(proof.Flags))
+		leafPos, hashPos, proofPos int
+	)
+	// next takes the next node off the queue: the leaves first, then the hashes in the
+	// order earlier steps produced them.
+	next := func(step int) ([]byte, error) {
+		if leafPos < len(leaves) {
+			leafPos++
+
+			return leaves[leafPos-1], nil
+		}
+		if hashPos >= step {
+			return nil, fmt.Errorf("%w: step %d consumes a hash that has not been produced", ErrMalformedProof, step)
+		}
+		hashPos++
+
+		return hashes[hashPos-1], nil
+	}
+
+	for i, flag := range proof.Flags {
+		a, err := next(i)
+		if err != nil {
+			return nil, err
+		}
+		var b []byte
+		if flag {
+			if b, err = next(i); err != nil {
+				return nil, err
+			}
+		} else {
+			if proofPos >= len(proof.Proof) {
+				return nil, fmt.Errorf("%w: proof runs out of hashes at step %d", ErrMalformedProof, i)
+			}
+			b = proof.Proof[proofPos]
+			proofPos++
+		}
+		if hashes[i], err = m.appendInteriorHash(h, nil, a, b); err != nil {
+			return nil, err
+		}
+	}
+	if proofPos != len(proof.Proof) {
+		return nil, fmt.Errorf("%w: %d proof hashes were not consumed", ErrMalformedProof, len(proof.Proof)-proofPos)
+	}
+
+	switch {
+	case len(proof.Flags) > 0:
+		return hashes[len(hashes)-1], nil
+	case len(leaves) > 0:
+		return leaves[0], nil
+	default:
+		return proof.Proof[0], nil
+	}
+}
+
+// multiProofRootByIndex computes the root an order sensitive multiproof commits to, by
+// replaying the level by level walk of GetMultiProof from the positions it recorded.
+// The flags are checked against the walk rather than followed, so a proof whose flags
+// disagree with its positions is reported as malformed.
+func (m *MerkleTree) multiProofRootByIndex(h hash.Hash, digests [][]byte, proof *MultiProof) ([]byte, error) {
+	if len(digests) == 0 || len(digests) != len(proof.Indices) {
+		return nil, fmt.Errorf("%w: %d digests for %d indices", ErrMalformedProof, len(digests), len(proof.Indices))
+	}
+	count := proof.LeafCount
+	if !m.rfc6962 && count%2 == 1 && !(m.bitcoin && count == 1) {
+		return nil, fmt.Errorf("%w: a padded tree cannot hold %d leaves", ErrMalformedProof, count)
+	}
+
+	type known struct {
+		j    int
+		hash []byte
+	}
+	cur := make([]known, len(digests))
+	for k, i := range proof.Indices {
+		if i < 0 || i >= count || (k > 0 && i <= proof.Indices[k-1]) {
+			return nil, fmt.Errorf("%w: index entry %d is %d, expected ascending positions below %d", ErrMalformedProof, k, i, count)
+		}
+		cur[k] = known{j: i, hash: digests[k]}
+		if m.rfc6962 {
+			leaf, err := m.appendLeafDigest(h, nil, digests[k])
+			if err != nil {
+				return nil, err
+			}
+			cur[k].hash = leaf
+		}
+	}
+	next := make([]known, 0, len(cur))
+
+	var step, proofPos int
+	for ; count > 1; count = (count + 1) / 2 {
+		next = next[:0]
+		for k := 0; k < len(cur); k++ {
+			j := cur[k].j
+			if j == count-1 && j%2 == 0 && m.rfc6962 {
+				next = append(next, known{j / 2, cur[k].hash})
+
+				continue
+			}
+
+			flag := j%2 == 0 && k+1 < len(cur) && cur[k+1].j == j+1
+			if step >= len(proof.Flags) || proof.Flags[step] != flag {
+				return nil, fmt.Errorf("%w: flag %d does not match the positions the proof covers", ErrMalformedProof, step)
+			}
+			step++
+
+			var left, right []byte
+			switch {
+			case flag:
+				left, right = cur[k].hash, cur[k+1].hash
+				k++
+			case proofPos >= len(proof.Proof):
+				return nil, fmt.Errorf("%w: proof runs out of hashes at step %d", ErrMalformedProof, step-1)
+			case j%2 == 0:
+				left, right = cur[k].hash, proof.Proof[proofPos]
+				proofPos++
+			default:
+				left, right = proof.Proof[proofPos], cur[k].hash
+				proofPos++
+			}
+			parent, err := m.appendInteriorHash(h, nil, left, right)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, known{j / 2, parent})
+		}
+		cur, next = next, cur
+	}
+	if step != len(proof.Flags) || proofPos != len(proof.Proof) {
+		return nil, fmt.Errorf("%w: proof is longer than the positions it covers require", ErrMalformedProof)
+	}
+
+	return cur[0].hash, nil
+}
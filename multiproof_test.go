@@ -0,0 +1,323 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// multiProofDigests returns the CalculateHash output of the leaves at indices, which is
+// what a verifier holding the content would hand VerifyMultiProof.
+func multiProofDigests(t *testing.T, tree *MerkleTree, indices []int) [][]byte {
+	t.Helper()
+
+	digests := make([][]byte, len(indices))
+	for k, i := range indices {
+		d, err := tree.Leafs[i].C.CalculateHash()
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		digests[k] = d
+	}
+
+	return digests
+}
+
+// multiProofSubsets returns the index sets a tree of n leaves is tested with: every
+// single leaf, every adjacent pair, the whole tree, and a handful of random subsets.
+func multiProofSubsets(r *rand.Rand, n int) [][]int {
+	var sets [][]int
+	for i := 0; i < n; i++ {
+		sets = append(sets, []int{i})
+		if i+1 < n {
+			sets = append(sets, []int{i, i + 1})
+		}
+	}
+	all := make([]int, n)
+	for i := range all {
+		all[i] = i
+	}
+	sets = append(sets, all)
+	for k := 0; k < 8; k++ {
+		var set []int
+		for i := 0; i < n; i++ {
+			if r.Intn(3) == 0 {
+				set = append(set, i)
+			}
+		}
+		if len(set) > 0 {
+			sets = append(sets, set)
+		}
+	}
+
+	return sets
+}
+
+// TestMultiProofRoundTrip checks every index set for every size and construction
+// verifies against the root it came from, and is never longer than the audit paths it
+// replaces put together.
+func TestMultiProofRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	for _, mode := range propModes {
+		for _, n := range propSizes {
+			t.Run(fmt.Sprintf("%s/n=%d", mode.name, n), func(t *testing.T) {
+				tree, err := mode.build(propSeries(n), sha256.New)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				opts := optsFor(mode, sha256.New)
+
+				for _, set := range multiProofSubsets(r, len(tree.Leafs)) {
+					proof, err := tree.GetMultiProof(set)
+					if err != nil {
+						t.Fatalf("error: GetMultiProof(%v): %v", set, err)
+					}
+					ok, err := VerifyMultiProof(multiProofDigests(t, tree, proof.Indices), proof, tree.MerkleRoot(), opts...)
+					if err != nil {
+						t.Fatalf("error: VerifyMultiProof(%v): %v", set, err)
+					}
+					if !ok {
+						t.Errorf("error: multiproof for %v did not verify", set)
+					}
+
+					total := 0
+					for _, i := range set {
+						path, _, err := tree.GetMerklePathByIndex(i)
+						if err != nil {
+							t.Fatalf("error: unexpected error: %v", err)
+						}
+						total += len(path)
+					}
+					if len(proof.Proof) > total {
+						t.Errorf("error: multiproof for %v has %d hashes, the separate paths %d", set, len(proof.Proof), total)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestMultiProofSingleLeafIsTheAuditPath pins the documented equivalence, which keeps
+// the multiproof walk honest against the audit path walk it must agree with.
+func TestMultiProofSingleLeafIsTheAuditPath(t *testing.T) {
+	for _, mode := range propModes {
+		for _, n := range propSizes {
+			t.Run(fmt.Sprintf("%s/n=%d", mode.name, n), func(t *testing.T) {
+				tree, err := mode.build(propSeries(n), sha256.New)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				for i := range tree.Leafs {
+					path, _, err := tree.GetMerklePathByIndex(i)
+					if err != nil {
+						t.Fatalf("error: unexpected error: %v", err)
+					}
+					proof, err := tree.GetMultiProof([]int{i})
+					if err != nil {
+						t.Fatalf("error: unexpected error: %v", err)
+					}
+					if len(proof.Proof) != len(path) {
+						t.Fatalf("error: leaf %d: multiproof has %d hashes, the path %d", i, len(proof.Proof), len(path))
+					}
+					for k := range path {
+						if !bytes.Equal(proof.Proof[k], path[k]) {
+							t.Errorf("error: leaf %d: hash %d differs from the audit path", i, k)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestMultiProofSharesNodes checks the deduplication the type exists for: an RFC 6962
+// tree proven whole needs no hashes at all, and the number of proof hashes for a
+// contiguous range does not grow with the range.
+func TestMultiProofSharesNodes(t *testing.T) {
+	tree, err := NewTreeWithOptions(propSeries(64), WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	all := make([]int, 64)
+	for i := range all {
+		all[i] = i
+	}
+	proof, err := tree.GetMultiProof(all)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(proof.Proof) != 0 {
+		t.Errorf("error: proving every leaf took %d hashes, want none", len(proof.Proof))
+	}
+
+	proof, err = tree.GetMultiProof(all[16:32])
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(proof.Proof) != 2 {
+		t.Errorf("error: proving an aligned quarter of the tree took %d hashes, want 2", len(proof.Proof))
+	}
+}
+
+// TestMultiProofNormalizesIndices checks positions given out of order and repeated are
+// recorded the way VerifyMultiProof reads them.
+func TestMultiProofNormalizesIndices(t *testing.T) {
+	tree, err := NewTreeWithOptions(propSeries(10))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	proof, err := tree.GetMultiProof([]int{7, 2, 7, 0})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if fmt.Sprint(proof.Indices) != "[0 2 7]" {
+		t.Errorf("error: expected indices [0 2 7], got %v", proof.Indices)
+	}
+	if proof.LeafCount != len(tree.Leafs) {
+		t.Errorf("error: expected a leaf count of %d, got %d", len(tree.Leafs), proof.LeafCount)
+	}
+}
+
+// TestMultiProofSortedNeedsNoPositions checks sorted proofs verify from Proof and Flags
+// alone, which is what makes them verifiable by OpenZeppelin's multiProofVerify, and
+// that the identity leaves-plus-proof equals flags-plus-one it requires holds.
+func TestMultiProofSortedNeedsNoPositions(t *testing.T) {
+	tree, err := NewTreeWithOptions(propSeries(17), WithSortedSiblings())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	proof, err := tree.GetMultiProof([]int{1, 4, 5, 16})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(proof.Indices)+len(proof.Proof) != len(proof.Flags)+1 {
+		t.Errorf("error: %d leaves, %d hashes and %d flags do not satisfy multiProofVerify", len(proof.Indices), len(proof.Proof), len(proof.Flags))
+	}
+
+	digests := multiProofDigests(t, tree, proof.Indices)
+	bare := &MultiProof{Proof: proof.Proof, Flags: proof.Flags}
+	ok, err := VerifyMultiProof(digests, bare, tree.MerkleRoot(), WithSortedSiblings())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !ok {
+		t.Error("error: a sorted multiproof without positions did not verify")
+	}
+}
+
+// TestMultiProofRejectsForgeries confirms the verifier is discriminating under every
+// construction: a tampered hash, a wrong digest or another tree's root must fail.
+func TestMultiProofRejectsForgeries(t *testing.T) {
+	for _, mode := range propModes {
+		t.Run(mode.name, func(t *testing.T) {
+			tree, err := mode.build(propSeries(21), sha256.New)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			other, err := mode.build(propSeries(20), sha256.New)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			opts := optsFor(mode, sha256.New)
+			proof, err := tree.GetMultiProof([]int{0, 3, 4, 11, 20})
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			digests := multiProofDigests(t, tree, proof.Indices)
+
+			for k := range proof.Proof {
+				forged := *proof
+				forged.Proof = append([][]byte(nil), proof.Proof...)
+				forged.Proof[k] = bytes.Clone(forged.Proof[k])
+				forged.Proof[k][0] ^= 0x01
+				if ok, err := VerifyMultiProof(digests, &forged, tree.MerkleRoot(), opts...); err != nil || ok {
+					t.Errorf("error: proof with hash %d flipped verified (%v)", k, err)
+				}
+			}
+			for k := range digests {
+				forged := append([][]byte(nil), digests...)
+				forged[k] = bytes.Clone(forged[k])
+				forged[k][0] ^= 0x01
+				if ok, err := VerifyMultiProof(forged, proof, tree.MerkleRoot(), opts...); err != nil || ok {
+					t.Errorf("error: proof verified with digest %d flipped (%v)", k, err)
+				}
+			}
+			if ok, err := VerifyMultiProof(digests, proof, other.MerkleRoot(), opts...); err != nil || ok {
+				t.Errorf("error: proof verified against another tree's root (%v)", err)
+			}
+		})
+	}
+}
+
+// TestMultiProofMalformed pins which mistakes are reported as errors rather than as a
+// false: those where the proof cannot be walked at all.
+func TestMultiProofMalformed(t *testing.T) {
+	for _, mode := range propModes {
+		t.Run(mode.name, func(t *testing.T) {
+			tree, err := mode.build(propSeries(12), sha256.New)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			opts := optsFor(mode, sha256.New)
+			proof, err := tree.GetMultiProof([]int{2, 3, 9})
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			digests := multiProofDigests(t, tree, proof.Indices)
+
+			cases := []struct {
+				name    string
+				digests [][]byte
+				proof   MultiProof
+			}{
+				{"missing digest", digests[:2], *proof},
+				{"extra hash", digests, MultiProof{Indices: proof.Indices, LeafCount: proof.LeafCount, Proof: append(append([][]byte(nil), proof.Proof...), digests[0]), Flags: proof.Flags}},
+				{"missing flag", digests, MultiProof{Indices: proof.Indices, LeafCount: proof.LeafCount, Proof: proof.Proof, Flags: proof.Flags[:len(proof.Flags)-1]}},
+			}
+			if !mode.sorted {
+				flipped := append([]bool(nil), proof.Flags...)
+				flipped[0] = !flipped[0]
+				cases = append(cases,
+					struct {
+						name    string
+						digests [][]byte
+						proof   MultiProof
+					}{"indices out of order", digests, MultiProof{Indices: []int{3, 2, 9}, LeafCount: proof.LeafCount, Proof: proof.Proof, Flags: proof.Flags}},
+					struct {
+						name    string
+						digests [][]byte
+						proof   MultiProof
+					}{"index beyond the tree", digests, MultiProof{Indices: []int{2, 3, 12}, LeafCount: proof.LeafCount, Proof: proof.Proof, Flags: proof.Flags}},
+					struct {
+						name    string
+						digests [][]byte
+						proof   MultiProof
+					}{"flags disagree with positions", digests, MultiProof{Indices: proof.Indices, LeafCount: proof.LeafCount, Proof: proof.Proof, Flags: flipped}},
+				)
+			}
+			for _, tc := range cases {
+				if _, err := VerifyMultiProof(tc.digests, &tc.proof, tree.MerkleRoot(), opts...); !errors.Is(err, ErrMalformedProof) {
+					t.Errorf("error: %s: expected ErrMalformedProof, got %v", tc.name, err)
+				}
+			}
+			if _, err := VerifyMultiProof(digests, nil, tree.MerkleRoot(), opts...); !errors.Is(err, ErrMalformedProof) {
+				t.Errorf("error: nil proof: expected ErrMalformedProof, got %v", err)
+			}
+
+			if _, err := tree.GetMultiProof(nil); err == nil {
+				t.Error("error: expected an error for a multiproof of no leaves")
+			}
+			for _, i := range []int{-1, len(tree.Leafs)} {
+				if _, err := tree.GetMultiProof([]int{0, i}); !errors.Is(err, ErrContentNotFound) {
+					t.Errorf("error: index %d: expected ErrContentNotFound, got %v", i, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,445 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"slices"
+)
+
+// Append adds cs to the end of the tree, in order, and updates the root.
+//
+// The result is byte for byte the tree NewTreeWithOptions would build from the old
+// content followed by cs under the same options - the same root, the same Leafs, the
+// same padding leaf, the same leaf index - but only the nodes on the right hand edge of
+// the tree are touched. Each appended leaf costs O(log n) interior hashes, where
+// RebuildTreeWith rehashes every node.
+//
+// Under the default and sorted constructions a tree with an odd content count holds a
+// padding copy of its last leaf. Appending to such a tree fills the padding slot with
+// the new content rather than growing Leafs, and appending to an even tree adds the new
+// leaf together with a fresh padding copy of it. Under WithRFC6962 there is no padding:
+// the perfect subtrees along the right edge are merged the way a binary counter carries,
+// and only the handful of nodes joining them are rebuilt.
+//
+// Every item is hashed before the tree is modified, so a nil entry or a failing
+// Content.CalculateHash leaves the tree exactly as it was. So does content out of order
+// for a tree built with NewSortedContentTree, which only grows at its end. An error from
+// the hash strategy itself while joining nodes can leave it part way through the append,
+// and such a tree should be rebuilt with RebuildTree before further use.
+//
+// Nodes that are replaced get fresh hash slices rather than being overwritten, so roots
+// and audit paths returned before the call keep the values they had.
+func (m *MerkleTree) Append(cs ...Content) error {
+	if len(cs) == 0 {
+		return nil
+	}
+	if m.empty() || m.hashStrategy == nil {
+		return fmt.Errorf("%w: cannot append to a tree that was never built", ErrMalformedTree)
+	}
+
+	// The leaves are slab allocated the way a build allocates them, and the padding
+	// copies share a second slab, which an append can need at most one per item of.
+	leafHashes := make([][]byte, len(cs))
+	for i, c := range cs {
+		if c == nil {
+			return fmt.Errorf("%w: index %d", ErrNilContent, i)
+		}
+		digest, err := m.hashLeaf(c)
+		if err != nil {
+			return err
+		}
+		leafHashes[i] = digest
+	}
+	if m.compare != nil {
+		prev := m.leafContent(m.contentCount() - 1)
+		for _, c := range cs {
+			if err := m.checkOrder(prev, c); err != nil {
+				return err
+			}
+			prev = c
+		}
+	}
+	if m.discardContent {
+		cs = make([]Content, len(cs))
+	}
+	if m.flat != nil {
+		return m.appendFlat(cs, leafHashes)
+	}
+	slab := make([]Node, len(cs))
+	for i := range slab {
+		slab[i] = Node{Tree: m, leaf: true, Hash: leafHashes[i], C: cs[i]}
+	}
+
+	h := m.hashStrategy()
+	var err error
+	if m.rfc6962 {
+		err = m.appendRFC6962(slab, h)
+	} else {
+		err = m.appendPadded(slab, h)
+	}
+	if err != nil {
+		return err
+	}
+	m.merkleRoot = m.Root.Hash
+
+	return nil
+}
+
+// appendPadded appends the leaves in slab to a tree built under the default or sorted
+// construction, one at a time.
+//
+// Every level of such a tree pairs node 2j with node 2j+1, and a level with an odd
+// count pairs its last node with itself; the leaf level is the exception, where the last
+// node is paired with a separate padding copy instead. So appending a node to a level
+// either completes a self-paired parent, which then only needs rehashing, or starts a
+// new self-paired parent, which is itself appended to the level above.
+func (m *MerkleTree) appendPadded(slab []Node, h hash.Hash) error {
+	for i := range slab {
+		n := &slab[i]
+		last := m.Leafs[len(m.Leafs)-1]
+
+		if last == m.Root {
+			// A lone unpadded leaf, which WithBitcoinCompat makes the root of a
+			// tree of one item, is paired with the new leaf.
+			m.Leafs = append(m.Leafs, n)
+			m.indexAppendedLeaf(len(m.Leafs) - 1)
+			root, err := m.joinNodes(last, n, h)
+			if err != nil {
+				return err
+			}
+			m.Root = root
+
+			continue
+		}
+
+		if last.dup {
+			// The padding slot becomes the new leaf. The level counts do not change,
+			// so neither does the shape; only the path above it needs rehashing.
+			last.C, last.Hash, last.dup = n.C, n.Hash, false
+			m.indexAppendedLeaf(len(m.Leafs) - 1)
+			if err := m.rehashFrom(last.Parent, h); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		pad := &Node{Tree: m, leaf: true, dup: true, Hash: n.Hash, C: n.C}
+		m.Leafs = append(m.Leafs, n, pad)
+		m.indexAppendedLeaf(len(m.Leafs) - 2)
+
+		parent, err := m.joinNodes(n, pad, h)
+		if err != nil {
+			return err
+		}
+		if err := m.appendPaddedNode(last.Parent, parent, h); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appendPaddedNode appends x to the level on which prev is currently the last node.
+func (m *MerkleTree) appendPaddedNode(prev, x *Node, h hash.Hash) error {
+	for {
+		parent := prev.Parent
+		switch {
+		case parent == nil:
+			// prev was the root, so the tree grows a level.
+			root, err := m.joinNodes(prev, x, h)
+			if err != nil {
+				return err
+			}
+			m.Root = root
+
+			return nil
+
+		case parent.Left == prev && parent.Right == prev:
+			// prev closed an odd level by pairing with itself; x takes the place of
+			// the copy.
+			parent.Right = x
+			x.Parent = parent
+
+			return m.rehashFrom(parent, h)
+
+		default:
+			// The level was even, so x starts a new pair of its own, and that pair's
+			// parent is appended to the level above.
+			p, err := m.joinNodes(x, x, h)
+			if err != nil {
+				return err
+			}
+			prev, x = parent, p
+		}
+	}
+}
+
+// appendRFC6962 appends the leaves in slab to a tree built under WithRFC6962.
+//
+// An RFC 6962 tree over n leaves is a chain of perfect subtrees, one for each set bit of
+// n from the largest down, joined right to left. Appending a leaf adds a perfect subtree
+// of size one and merges equal sizes from the right the way a binary counter carries,
+// so the perfect subtrees that survive are reused untouched and only the joins along the
+// spine are rebuilt - once for the whole batch rather than once per leaf.
+func (m *MerkleTree) appendRFC6962(slab []Node, h hash.Hash) error {
+	var (
+		peaks []*Node
+		sizes []int
+	)
+	n, count := m.Root, len(m.Leafs)
+	for count&(count-1) != 0 {
+		if n.Left == nil || n.Right == nil {
+			return fmt.Errorf("%w: interior node is missing a child", ErrMalformedTree)
+		}
+		k := largestPowerOfTwoBelow(count)
+		peaks = append(peaks, n.Left)
+		sizes = append(sizes, k)
+		n, count = n.Right, count-k
+	}
+	peaks = append(peaks, n)
+	sizes = append(sizes, count)
+
+	for i := range slab {
+		node, size := &slab[i], 1
+		m.Leafs = append(m.Leafs, node)
+		m.indexAppendedLeaf(len(m.Leafs) - 1)
+
+		for len(peaks) > 0 && sizes[len(sizes)-1] == size {
+			joined, err := m.joinNodes(peaks[len(peaks)-1], node, h)
+			if err != nil {
+				return err
+			}
+			node, size = joined, size*2
+			peaks, sizes = peaks[:len(peaks)-1], sizes[:len(sizes)-1]
+		}
+		peaks = append(peaks, node)
+		sizes = append(sizes, size)
+	}
+
+	root := peaks[len(peaks)-1]
+	for i := len(peaks) - 2; i >= 0; i-- {
+		joined, err := m.joinNodes(peaks[i], root, h)
+		if err != nil {
+			return err
+		}
+		root = joined
+	}
+	// A lone perfect subtree still points at the spine node it hung from before.
+	root.Parent = nil
+	m.Root = root
+
+	return nil
+}
+
+// UpdateLeaf replaces the content of the leaf at position i in Leafs with c, and
+// recomputes only the hashes on the path from that leaf up to the root. It is
+// UpdateLeaves for a single leaf; see there for the details.
+func (m *MerkleTree) UpdateLeaf(i int, c Content) error {
+	return m.UpdateLeaves(map[int]Content{i: c})
+}
+
+// UpdateLeaves replaces the content of several leaves at once, keyed by position in
+// Leafs, and recomputes the hashes above them. Ancestors shared by more than one updated
+// leaf are rehashed once rather than once per leaf, so a batch costs at most the union of
+// the paths involved, never more than a rebuild.
+//
+// The result is byte for byte the tree a full build over the updated content would
+// produce. Under the default and sorted constructions, updating the last leaf of a tree
+// with an odd content count updates its padding copy too; the padding leaf itself is not
+// a position that can be updated, and naming it is an error. The leaf index, when the
+// tree has one, is kept in step: a hash that has moved is found at its new position, and
+// one no longer held by any leaf is no longer found at all. Removing a hash from the
+// index may scan the leaves after it for another holder, so with WithLeafIndex a single
+// update is O(n) in comparisons of stored hashes, though still O(log n) in hashing.
+//
+// Every position is checked and every item hashed before the tree is modified, so an
+// out of range position, a nil entry or a failing Content.CalculateHash leaves the tree
+// exactly as it was, as does content that would put a tree built with
+// NewSortedContentTree out of order. Positions are checked in ascending order, so the
+// same batch always fails the same way. An out of range position wraps
+// ErrContentNotFound, as it does for GetMerklePathByIndex.
+//
+// Nodes that are rehashed get fresh hash slices rather than being overwritten, so roots
+// and audit paths returned before the call keep the values they had.
+func (m *MerkleTree) UpdateLeaves(updates map[int]Content) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	if m.empty() || m.hashStrategy == nil {
+		return fmt.Errorf("%w: cannot update a tree that was never built", ErrMalformedTree)
+	}
+
+	positions := make([]int, 0, len(updates))
+	for i := range updates {
+		positions = append(positions, i)
+	}
+	slices.Sort(positions)
+
+	leafHashes := make([][]byte, len(positions))
+	for k, i := range positions {
+		if i < 0 || i >= m.leafCount() {
+			return fmt.Errorf("%w: no leaf at index %d, the tree has %d", ErrContentNotFound, i, m.leafCount())
+		}
+		if m.leafIsPadding(i) {
+			return fmt.Errorf("error: leaf %d is the padding copy of leaf %d; update that leaf instead", i, i-1)
+		}
+		c := updates[i]
+		if c == nil {
+			return fmt.Errorf("%w: index %d", ErrNilContent, i)
+		}
+		digest, err := m.hashLeaf(c)
+		if err != nil {
+			return err
+		}
+		leafHashes[k] = digest
+	}
+	if m.compare != nil {
+		if err := m.checkUpdateOrder(updates, positions); err != nil {
+			return err
+		}
+	}
+	if m.discardContent {
+		updates = make(map[int]Content)
+	}
+	if m.flat != nil {
+		return m.updateFlat(updates, positions, leafHashes)
+	}
+
+	oldHashes := make([][]byte, len(positions))
+	dirty := make(map[*Node]bool)
+	for k, i := range positions {
+		l := m.Leafs[i]
+		oldHashes[k] = l.Hash
+		l.C, l.Hash = updates[i], leafHashes[k]
+		if i+1 < len(m.Leafs) && m.Leafs[i+1].dup {
+			pad := m.Leafs[i+1]
+			pad.C, pad.Hash = l.C, l.Hash
+		}
+		// Marking stops at the first ancestor already marked, since everything
+		// above it was marked by the leaf that got there first.
+		for p := l.Parent; p != nil && !dirty[p]; p = p.Parent {
+			dirty[p] = true
+		}
+	}
+
+	if err := m.rehashDirty(m.Root, dirty, m.hashStrategy()); err != nil {
+		return err
+	}
+	m.merkleRoot = m.Root.Hash
+	m.reindexUpdatedLeaves(positions, oldHashes)
+
+	return nil
+}
+
+// rehashDirty recomputes the hash of every node marked in dirty beneath and including n,
+// children before parents, and leaves every unmarked subtree alone.
+func (m *MerkleTree) rehashDirty(n *Node, dirty map[*Node]bool, h hash.Hash) error {
+	if !dirty[n] {
+		return nil
+	}
+	if n.Left == nil || n.Right == nil {
+		return fmt.Errorf("%w: interior node is missing a child", ErrMalformedTree)
+	}
+	if err := m.rehashDirty(n.Left, dirty, h); err != nil {
+		return err
+	}
+	// A node closing an odd level is its own right hand sibling, and has already
+	// been rehashed as the left one.
+	if n.Right != n.Left {
+		if err := m.rehashDirty(n.Right, dirty, h); err != nil {
+			return err
+		}
+	}
+	nodeHash, err := m.appendInteriorHash(h, nil, n.Left.Hash, n.Right.Hash)
+	if err != nil {
+		return err
+	}
+	n.Hash = nodeHash
+
+	return nil
+}
+
+// reindexUpdatedLeaves brings the leaf index up to date after the leaves at positions,
+// ascending, changed from oldHashes to their current hashes.
+//
+// Every stale entry is dealt with before any new one is recorded. An old hash whose entry
+// pointed at an updated leaf passes to the next leaf still holding it, or is dropped;
+// then each new hash is recorded unless a lower position already holds it. Doing the two
+// in separate passes is what keeps the "earliest leaf wins" rule when a batch swaps
+// hashes between leaves.
+func (m *MerkleTree) reindexUpdatedLeaves(positions []int, oldHashes [][]byte) {
+	if m.leafIndex == nil {
+		return
+	}
+
+	for k, i := range positions {
+		old := string(oldHashes[k])
+		if j, ok := m.leafIndex[old]; !ok || j != i {
+			continue
+		}
+		delete(m.leafIndex, old)
+		for j := i + 1; j < m.leafCount(); j++ {
+			if !m.leafIsPadding(j) && bytes.Equal(m.leafHash(j), oldHashes[k]) {
+				m.leafIndex[old] = j
+
+				break
+			}
+		}
+	}
+	for _, i := range positions {
+		k := string(m.leafHash(i))
+		if j, ok := m.leafIndex[k]; !ok || j > i {
+			m.leafIndex[k] = i
+		}
+	}
+}
+
+// joinNodes creates the interior node above left and right.
+func (m *MerkleTree) joinNodes(left, right *Node, h hash.Hash) (*Node, error) {
+	nodeHash, err := m.appendInteriorHash(h, nil, left.Hash, right.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{Tree: m, Left: left, Right: right, Hash: nodeHash}
+	left.Parent = n
+	right.Parent = n
+
+	return n, nil
+}
+
+// rehashFrom recomputes the hash of n and every ancestor of it, bottom up. Each gets a
+// freshly allocated hash rather than having its old one overwritten, so hashes handed
+// out earlier - in an audit path, or as the root - do not change underneath the caller.
+func (m *MerkleTree) rehashFrom(n *Node, h hash.Hash) error {
+	for ; n != nil; n = n.Parent {
+		if n.Left == nil || n.Right == nil {
+			return fmt.Errorf("%w: interior node is missing a child", ErrMalformedTree)
+		}
+		nodeHash, err := m.appendInteriorHash(h, nil, n.Left.Hash, n.Right.Hash)
+		if err != nil {
+			return err
+		}
+		n.Hash = nodeHash
+	}
+
+	return nil
+}
+
+// indexAppendedLeaf records the leaf at position i in the leaf index, when the tree has
+// one. A hash already present keeps its lower position, which is the rule a full build
+// applies.
+func (m *MerkleTree) indexAppendedLeaf(i int) {
+	if m.leafIndex == nil {
+		return
+	}
+	k := string(m.leafHash(i))
+	if _, seen := m.leafIndex[k]; !seen {
+		m.leafIndex[k] = i
+	}
+}
@@ -0,0 +1,410 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"maps"
+	"testing"
+)
+
+// Append and the leaf updates must produce exactly the tree a full build would, so every
+// test here holds the incrementally modified tree against one built from scratch with
+// assertTreesIdentical, which compares every node rather than just the root.
+
+// assertLeafIndexMatches requires got's leaf index to hold exactly what a fresh build of
+// the same content records.
+func assertLeafIndexMatches(t *testing.T, label string, want, got *MerkleTree) {
+	t.Helper()
+
+	if !maps.Equal(want.leafIndex, got.leafIndex) {
+		t.Errorf("%s: leaf index differs from a fresh build:\n got %v\nwant %v", label, got.leafIndex, want.leafIndex)
+	}
+}
+
+// TestAppendMatchesFullBuild appends in batches of several sizes onto trees of every
+// small size, under every construction, and compares against a build of the whole.
+func TestAppendMatchesFullBuild(t *testing.T) {
+	for _, mode := range propModes {
+		for _, start := range []int{1, 2, 3, 4, 5, 7, 8, 9, 16, 17} {
+			for _, batch := range []int{1, 2, 3, 5, 8, 13} {
+				t.Run(fmt.Sprintf("%s/start=%d/batch=%d", mode.name, start, batch), func(t *testing.T) {
+					contents := propSeries(start + 3*batch)
+					opts := append(optsFor(mode, sha256.New), WithLeafIndex())
+
+					tree, err := NewTreeWithOptions(contents[:start], opts...)
+					if err != nil {
+						t.Fatalf("error: unexpected error: %v", err)
+					}
+					for end := start; end < len(contents); end += batch {
+						if err := tree.Append(contents[end : end+batch]...); err != nil {
+							t.Fatalf("error: Append: %v", err)
+						}
+						want, err := NewTreeWithOptions(contents[:end+batch], opts...)
+						if err != nil {
+							t.Fatalf("error: unexpected error: %v", err)
+						}
+						label := fmt.Sprintf("after %d leaves", end+batch)
+						assertTreesIdentical(t, label, want, tree)
+						assertLeafIndexMatches(t, label, want, tree)
+					}
+
+					ok, err := tree.VerifyTree()
+					if err != nil {
+						t.Fatalf("error: unexpected error: %v", err)
+					}
+					if !ok {
+						t.Error("error: an appended tree did not verify")
+					}
+					for i, c := range contents {
+						path, index, err := tree.GetMerklePath(c)
+						if err != nil {
+							t.Fatalf("error: GetMerklePath(%d): %v", i, err)
+						}
+						if ok, err := VerifyProof(c, path, index, tree.MerkleRoot(), opts...); err != nil || !ok {
+							t.Errorf("error: leaf %d: proof from an appended tree did not verify (%v)", i, err)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestAppendOneAtATime grows a tree from a single leaf, one leaf per call, which walks
+// the right edge through every shape it can take on the way.
+func TestAppendOneAtATime(t *testing.T) {
+	for _, mode := range propModes {
+		t.Run(mode.name, func(t *testing.T) {
+			contents := propSeries(130)
+			tree, err := mode.build(contents[:1], sha256.New)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			for n := 2; n <= len(contents); n++ {
+				if err := tree.Append(contents[n-1]); err != nil {
+					t.Fatalf("error: Append at %d: %v", n, err)
+				}
+				want, err := mode.build(contents[:n], sha256.New)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				assertTreesIdentical(t, fmt.Sprintf("n=%d", n), want, tree)
+			}
+		})
+	}
+}
+
+// TestAppendLeavesEarlierResultsAlone checks that hashes handed out before an append,
+// which are the tree's own slices, still hold what they held when they were returned.
+func TestAppendLeavesEarlierResultsAlone(t *testing.T) {
+	for _, mode := range propModes {
+		t.Run(mode.name, func(t *testing.T) {
+			contents := propSeries(12)
+			tree, err := mode.build(contents[:5], sha256.New)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			root := tree.MerkleRoot()
+			rootCopy := bytes.Clone(root)
+			path, _, err := tree.GetMerklePathByIndex(4)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			pathCopy := make([][]byte, len(path))
+			for k := range path {
+				pathCopy[k] = bytes.Clone(path[k])
+			}
+
+			if err := tree.Append(contents[5:]...); err != nil {
+				t.Fatalf("error: Append: %v", err)
+			}
+			if !bytes.Equal(root, rootCopy) {
+				t.Error("error: the root returned before Append changed underneath the caller")
+			}
+			for k := range path {
+				if !bytes.Equal(path[k], pathCopy[k]) {
+					t.Errorf("error: audit path entry %d changed underneath the caller", k)
+				}
+			}
+		})
+	}
+}
+
+// TestAppendErrorsLeaveTheTreeAlone covers the failures that are detected before any
+// node is touched: the tree afterwards must be the tree before.
+func TestAppendErrorsLeaveTheTreeAlone(t *testing.T) {
+	for _, mode := range propModes {
+		t.Run(mode.name, func(t *testing.T) {
+			contents := propSeries(5)
+			tree, err := mode.build(contents, sha256.New)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			want, err := mode.build(contents, sha256.New)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			if err := tree.Append(propContent{x: "fine"}, nil); !errors.Is(err, ErrNilContent) {
+				t.Errorf("error: expected ErrNilContent, got %v", err)
+			}
+			assertTreesIdentical(t, "after a nil entry", want, tree)
+
+			if err := tree.Append(propContent{x: "fine"}, failingContent{failHash: true}); err == nil {
+				t.Error("error: expected the content's hash error")
+			}
+			assertTreesIdentical(t, "after a failing hash", want, tree)
+
+			if err := tree.Append(); err != nil {
+				t.Errorf("error: appending nothing should be a no-op, got %v", err)
+			}
+			assertTreesIdentical(t, "after an empty append", want, tree)
+		})
+	}
+
+	var zero MerkleTree
+	if err := zero.Append(propContent{x: "a"}); !errors.Is(err, ErrMalformedTree) {
+		t.Errorf("error: expected ErrMalformedTree appending to a zero tree, got %v", err)
+	}
+}
+
+// TestAppendedTreeSerializes checks an appended tree marshals to the payload of a tree
+// built whole, since the serialized form is meant to be canonical.
+func TestAppendedTreeSerializes(t *testing.T) {
+	for _, mode := range propModes {
+		t.Run(mode.name, func(t *testing.T) {
+			contents := make([]Content, 0, 9)
+			for i := 0; i < 9; i++ {
+				contents = append(contents, TestSHA256Content{x: fmt.Sprintf("item-%d", i)})
+			}
+			tree, err := mode.build(contents[:4], sha256.New)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if err := tree.Append(contents[4:]...); err != nil {
+				t.Fatalf("error: Append: %v", err)
+			}
+			want, err := mode.build(contents, sha256.New)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			got, err := tree.MarshalBinary()
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			wantBytes, err := want.MarshalBinary()
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, wantBytes) {
+				t.Error("error: an appended tree encodes differently from one built whole")
+			}
+		})
+	}
+}
+
+// TestUpdateLeafMatchesFullBuild updates every position of trees of every small size, one
+// at a time, and compares against a build over the updated content.
+func TestUpdateLeafMatchesFullBuild(t *testing.T) {
+	for _, mode := range propModes {
+		for n := 1; n <= 17; n++ {
+			t.Run(fmt.Sprintf("%s/n=%d", mode.name, n), func(t *testing.T) {
+				contents := propSeries(n)
+				opts := append(optsFor(mode, sha256.New), WithLeafIndex())
+				tree, err := NewTreeWithOptions(contents, opts...)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+
+				for i := 0; i < n; i++ {
+					contents[i] = propContent{x: fmt.Sprintf("updated-%d", i)}
+					if err := tree.UpdateLeaf(i, contents[i]); err != nil {
+						t.Fatalf("error: UpdateLeaf(%d): %v", i, err)
+					}
+					want, err := NewTreeWithOptions(contents, opts...)
+					if err != nil {
+						t.Fatalf("error: unexpected error: %v", err)
+					}
+					label := fmt.Sprintf("after updating leaf %d", i)
+					assertTreesIdentical(t, label, want, tree)
+					assertLeafIndexMatches(t, label, want, tree)
+				}
+
+				ok, err := tree.VerifyTree()
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				if !ok {
+					t.Error("error: an updated tree did not verify")
+				}
+			})
+		}
+	}
+}
+
+// TestUpdateLeavesMatchesFullBuild applies batches with overlapping paths, including
+// batches that swap content between leaves so that hashes move rather than disappear.
+func TestUpdateLeavesMatchesFullBuild(t *testing.T) {
+	for _, mode := range propModes {
+		for _, n := range []int{2, 3, 5, 8, 13, 21} {
+			t.Run(fmt.Sprintf("%s/n=%d", mode.name, n), func(t *testing.T) {
+				contents := propSeries(n)
+				opts := append(optsFor(mode, sha256.New), WithLeafIndex())
+				tree, err := NewTreeWithOptions(contents, opts...)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+
+				batches := []map[int]Content{
+					{0: contents[n-1], n - 1: contents[0]},
+					{0: propContent{x: "a"}, n / 2: propContent{x: "b"}, n - 1: propContent{x: "c"}},
+					{n - 1: propContent{x: "a"}, 0: propContent{x: "c"}},
+				}
+				for b, batch := range batches {
+					if err := tree.UpdateLeaves(batch); err != nil {
+						t.Fatalf("error: UpdateLeaves batch %d: %v", b, err)
+					}
+					for i, c := range batch {
+						contents[i] = c
+					}
+					want, err := NewTreeWithOptions(contents, opts...)
+					if err != nil {
+						t.Fatalf("error: unexpected error: %v", err)
+					}
+					label := fmt.Sprintf("after batch %d", b)
+					assertTreesIdentical(t, label, want, tree)
+					assertLeafIndexMatches(t, label, want, tree)
+				}
+			})
+		}
+	}
+}
+
+// TestUpdateLeafWithRepeatedContent covers a leaf index entry passing to a later leaf
+// that holds the same hash when the earliest holder is overwritten, and being dropped
+// when no other leaf holds it.
+func TestUpdateLeafWithRepeatedContent(t *testing.T) {
+	for _, mode := range propModes {
+		t.Run(mode.name, func(t *testing.T) {
+			same := propContent{x: "same"}
+			contents := []Content{propContent{x: "a"}, same, propContent{x: "b"}, same, same, propContent{x: "c"}, propContent{x: "d"}}
+			opts := append(optsFor(mode, sha256.New), WithLeafIndex())
+			tree, err := NewTreeWithOptions(contents, opts...)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			for _, i := range []int{1, 3, 4, 0} {
+				contents[i] = propContent{x: fmt.Sprintf("fresh-%d", i)}
+				if err := tree.UpdateLeaf(i, contents[i]); err != nil {
+					t.Fatalf("error: UpdateLeaf(%d): %v", i, err)
+				}
+				want, err := NewTreeWithOptions(contents, opts...)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				assertLeafIndexMatches(t, fmt.Sprintf("after updating leaf %d", i), want, tree)
+			}
+
+			if _, _, err := tree.GetMerklePath(same); !errors.Is(err, ErrContentNotFound) {
+				t.Errorf("error: expected ErrContentNotFound for content no leaf holds, got %v", err)
+			}
+			contents[6] = same
+			if err := tree.UpdateLeaf(6, same); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if _, index, err := tree.GetMerklePath(same); err != nil || len(index) == 0 {
+				t.Errorf("error: content written back into the tree was not found (%v)", err)
+			}
+		})
+	}
+}
+
+// TestUpdateLeafLeavesEarlierResultsAlone mirrors the Append test: hashes handed out
+// before an update still hold what they held when they were returned.
+func TestUpdateLeafLeavesEarlierResultsAlone(t *testing.T) {
+	for _, mode := range propModes {
+		t.Run(mode.name, func(t *testing.T) {
+			tree, err := mode.build(propSeries(7), sha256.New)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			root := tree.MerkleRoot()
+			rootCopy := bytes.Clone(root)
+			leafHash := tree.Leafs[6].Hash
+			leafCopy := bytes.Clone(leafHash)
+
+			if err := tree.UpdateLeaf(6, propContent{x: "replacement"}); err != nil {
+				t.Fatalf("error: UpdateLeaf: %v", err)
+			}
+			if bytes.Equal(tree.MerkleRoot(), rootCopy) {
+				t.Error("error: updating a leaf did not change the root")
+			}
+			if !bytes.Equal(root, rootCopy) {
+				t.Error("error: the root returned before UpdateLeaf changed underneath the caller")
+			}
+			if !bytes.Equal(leafHash, leafCopy) {
+				t.Error("error: the old leaf hash changed underneath the caller")
+			}
+		})
+	}
+}
+
+// TestUpdateLeafErrorsLeaveTheTreeAlone covers the failures that are detected before any
+// node is touched, including naming the padding leaf of the default construction.
+func TestUpdateLeafErrorsLeaveTheTreeAlone(t *testing.T) {
+	for _, mode := range propModes {
+		t.Run(mode.name, func(t *testing.T) {
+			contents := propSeries(5)
+			tree, err := mode.build(contents, sha256.New)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			want, err := mode.build(contents, sha256.New)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			fine := propContent{x: "fine"}
+			for _, i := range []int{-1, len(tree.Leafs)} {
+				if err := tree.UpdateLeaves(map[int]Content{0: fine, i: fine}); !errors.Is(err, ErrContentNotFound) {
+					t.Errorf("error: index %d: expected ErrContentNotFound, got %v", i, err)
+				}
+				assertTreesIdentical(t, fmt.Sprintf("after index %d", i), want, tree)
+			}
+
+			if err := tree.UpdateLeaves(map[int]Content{0: fine, 2: nil}); !errors.Is(err, ErrNilContent) {
+				t.Errorf("error: expected ErrNilContent, got %v", err)
+			}
+			assertTreesIdentical(t, "after a nil entry", want, tree)
+
+			if err := tree.UpdateLeaves(map[int]Content{0: fine, 2: failingContent{failHash: true}}); err == nil {
+				t.Error("error: expected the content's hash error")
+			}
+			assertTreesIdentical(t, "after a failing hash", want, tree)
+
+			if !mode.rfc6962 {
+				if err := tree.UpdateLeaf(5, fine); err == nil {
+					t.Error("error: expected an error updating the padding leaf")
+				}
+				assertTreesIdentical(t, "after naming the padding leaf", want, tree)
+			}
+
+			if err := tree.UpdateLeaves(nil); err != nil {
+				t.Errorf("error: an empty batch should be a no-op, got %v", err)
+			}
+			assertTreesIdentical(t, "after an empty batch", want, tree)
+		})
+	}
+
+	var zero MerkleTree
+	if err := zero.UpdateLeaf(0, propContent{x: "a"}); !errors.Is(err, ErrMalformedTree) {
+		t.Errorf("error: expected ErrMalformedTree updating a zero tree, got %v", err)
+	}
+}
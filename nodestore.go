@@ -0,0 +1,178 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrNodeNotFound is returned by a NodeStore asked for a node it does not hold, and by
+// MerkleTree.NodeHash for a position outside the tree. Test for it with errors.Is.
+var ErrNodeNotFound = errors.New("error: node not found in store")
+
+// NodeStore holds the hashes of a tree's nodes, addressed by level and by index within
+// the level, leaves being level zero. It is what a StoredTree keeps its nodes in instead
+// of a graph of Node values, so that where the hashes live - the heap, a file, a
+// database - is up to the implementation.
+//
+// GetNode returns an error wrapping ErrNodeNotFound for a node that was never put.
+// PutNode takes ownership of hash; the caller does not modify it afterwards.
+// Implementations must allow concurrent calls to GetNode, but need not allow PutNode to
+// run concurrently with anything.
+type NodeStore interface {
+	GetNode(level, index int) ([]byte, error)
+	PutNode(level, index int, hash []byte) error
+}
+
+// MemoryNodeStore is a NodeStore on the heap, one slice of hashes per level. Compared with
+// a MerkleTree it costs a slice header per node rather than a Node, and holds no content.
+type MemoryNodeStore struct {
+	levels [][][]byte
+}
+
+// NewMemoryNodeStore returns an empty MemoryNodeStore.
+func NewMemoryNodeStore() *MemoryNodeStore {
+	return &MemoryNodeStore{}
+}
+
+// GetNode implements NodeStore. The returned slice is the store's own; treat it as
+// read only.
+func (s *MemoryNodeStore) GetNode(level, index int) ([]byte, error) {
+	if level < 0 || level >= len(s.levels) || index < 0 || index >= len(s.levels[level]) || s.levels[level][index] == nil {
+		return nil, fmt.Errorf("%w: level %d index %d", ErrNodeNotFound, level, index)
+	}
+
+	return s.levels[level][index], nil
+}
+
+// PutNode implements NodeStore.
+func (s *MemoryNodeStore) PutNode(level, index int, hash []byte) error {
+	if level < 0 || index < 0 {
+		return fmt.Errorf("error: no node at level %d index %d", level, index)
+	}
+	for len(s.levels) <= level {
+		s.levels = append(s.levels, nil)
+	}
+	nodes := s.levels[level]
+	if index >= len(nodes) {
+		nodes = append(nodes, make([][]byte, index+1-len(nodes))...)
+	}
+	nodes[index] = hash
+	s.levels[level] = nodes
+
+	return nil
+}
+
+// FileNodeStore is a NodeStore backed by files in a directory, one per level, holding
+// fixed width records: node i of a level is the hashSize bytes at offset i*hashSize of
+// its file. A tree of n leaves costs about 2n*hashSize bytes of disk and nothing on the
+// heap, and the files can be reopened by a later process with OpenStoredTree.
+//
+// Every hash put must be exactly hashSize bytes, which under the default construction
+// includes the leaf digests Content.CalculateHash returns. Writes go through the
+// operating system's cache; call Close to release the files.
+type FileNodeStore struct {
+	dir      string
+	hashSize int
+
+	mu    sync.Mutex
+	files map[int]*os.File
+}
+
+// NewFileNodeStore returns a FileNodeStore keeping its files in dir, which is created if
+// it does not exist. Files already there from an earlier store are used as they are.
+func NewFileNodeStore(dir string, hashSize int) (*FileNodeStore, error) {
+	if hashSize < 1 {
+		return nil, fmt.Errorf("error: a file node store needs a hash size of at least one byte, got %d", hashSize)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileNodeStore{dir: dir, hashSize: hashSize, files: make(map[int]*os.File)}, nil
+}
+
+// file returns the open file for level, opening it if need be. A level with no file
+// yet is created only when create is set.
+func (s *FileNodeStore) file(level int, create bool) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.files == nil {
+		return nil, errors.New("error: file node store is closed")
+	}
+	if f, ok := s.files[level]; ok {
+		return f, nil
+	}
+	flag := os.O_RDWR
+	if create {
+		flag |= os.O_CREATE
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, fmt.Sprintf("level-%02d", level)), flag, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.files[level] = f
+
+	return f, nil
+}
+
+// GetNode implements NodeStore.
+func (s *FileNodeStore) GetNode(level, index int) ([]byte, error) {
+	if level < 0 || index < 0 {
+		return nil, fmt.Errorf("%w: level %d index %d", ErrNodeNotFound, level, index)
+	}
+	f, err := s.file(level, false)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: level %d index %d", ErrNodeNotFound, level, index)
+	}
+	if err != nil {
+		return nil, err
+	}
+	hash := make([]byte, s.hashSize)
+	if _, err := f.ReadAt(hash, int64(index)*int64(s.hashSize)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: level %d index %d", ErrNodeNotFound, level, index)
+		}
+		return nil, err
+	}
+
+	return hash, nil
+}
+
+// PutNode implements NodeStore.
+func (s *FileNodeStore) PutNode(level, index int, hash []byte) error {
+	if level < 0 || index < 0 {
+		return fmt.Errorf("error: no node at level %d index %d", level, index)
+	}
+	if len(hash) != s.hashSize {
+		return fmt.Errorf("error: file node store holds %d byte hashes, got %d bytes", s.hashSize, len(hash))
+	}
+	f, err := s.file(level, true)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt(hash, int64(index)*int64(s.hashSize))
+
+	return err
+}
+
+// Close closes the store's files. The store cannot be used afterwards.
+func (s *FileNodeStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, f := range s.files {
+		errs = append(errs, f.Close())
+	}
+	s.files = nil
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// TestNodeStores checks both stores return what was put, report what was not as
+// ErrNodeNotFound, and refuse negative addresses.
+func TestNodeStores(t *testing.T) {
+	files, err := NewFileNodeStore(t.TempDir(), 4)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	defer files.Close()
+
+	for name, store := range map[string]NodeStore{"memory": NewMemoryNodeStore(), "file": files} {
+		if _, err := store.GetNode(0, 0); !errors.Is(err, ErrNodeNotFound) {
+			t.Errorf("error: %s: empty store: expected ErrNodeNotFound, got %v", name, err)
+		}
+		if err := store.PutNode(2, 3, []byte("abcd")); err != nil {
+			t.Fatalf("error: %s: PutNode: %v", name, err)
+		}
+		if err := store.PutNode(2, 0, []byte("wxyz")); err != nil {
+			t.Fatalf("error: %s: PutNode: %v", name, err)
+		}
+		if got, err := store.GetNode(2, 3); err != nil || !bytes.Equal(got, []byte("abcd")) {
+			t.Errorf("error: %s: GetNode(2, 3) returned %q, %v", name, got, err)
+		}
+		if got, err := store.GetNode(2, 0); err != nil || !bytes.Equal(got, []byte("wxyz")) {
+			t.Errorf("error: %s: GetNode(2, 0) returned %q, %v", name, got, err)
+		}
+		for _, at := range [][2]int{{2, 4}, {1, 0}, {3, 0}, {-1, 0}, {0, -1}} {
+			if _, err := store.GetNode(at[0], at[1]); !errors.Is(err, ErrNodeNotFound) {
+				t.Errorf("error: %s: GetNode(%d, %d): expected ErrNodeNotFound, got %v", name, at[0], at[1], err)
+			}
+		}
+		if err := store.PutNode(-1, 0, []byte("abcd")); err == nil {
+			t.Errorf("error: %s: expected a negative level to be refused", name)
+		}
+	}
+}
+
+// TestFileNodeStore covers what is particular to the file store: the fixed hash width,
+// reopening a directory, and use after Close.
+func TestFileNodeStore(t *testing.T) {
+	if _, err := NewFileNodeStore(t.TempDir(), 0); err == nil {
+		t.Error("error: expected a zero hash size to be refused")
+	}
+
+	dir := t.TempDir()
+	store, err := NewFileNodeStore(dir, 4)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := store.PutNode(0, 0, []byte("abc")); err == nil {
+		t.Error("error: expected a hash of the wrong width to be refused")
+	}
+	if err := store.PutNode(1, 5, []byte("abcd")); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("error: Close: %v", err)
+	}
+	if _, err := store.GetNode(1, 5); err == nil || errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("error: expected a closed store to refuse reads, got %v", err)
+	}
+	if err := store.PutNode(1, 5, []byte("abcd")); err == nil {
+		t.Error("error: expected a closed store to refuse writes")
+	}
+
+	reopened, err := NewFileNodeStore(dir, 4)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	defer reopened.Close()
+	if got, err := reopened.GetNode(1, 5); err != nil || !bytes.Equal(got, []byte("abcd")) {
+		t.Errorf("error: reopened store returned %q, %v", got, err)
+	}
+}
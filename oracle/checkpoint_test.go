@@ -0,0 +1,144 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package oracle
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	mt "github.com/cbergoon/merkletree"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/merkle/testonly"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// The checkpoint tests use golang.org/x/mod/sumdb/note as the oracle for the signed note
+// format. It is the implementation the format was first written for, in the Go checksum
+// database, and the one the C2SP specification was drawn from. The checkpoints are
+// generated here, from trees the RFC 6962 oracle agrees with, and signed by each side
+// for the other to verify.
+
+const checkpointOrigin = "example.com/merkletree"
+
+// noteKeys returns an Ed25519 key pair and the same key encoded as the note package
+// encodes signer and verifier keys. The key is derived from name, by handing its hash
+// to note.GenerateKey as the randomness, so that the note package does the encoding.
+func noteKeys(t *testing.T, name string) (ed25519.PrivateKey, string, string) {
+	t.Helper()
+	seed := sha256.Sum256([]byte(name))
+	skey, vkey, err := note.GenerateKey(bytes.NewReader(seed[:]), name)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	return ed25519.NewKeyFromSeed(seed[:]), skey, vkey
+}
+
+func checkpointTree(t *testing.T, n int) *mt.MerkleTree {
+	t.Helper()
+	leaves := seriesLeaves(n)
+	tree, err := mt.NewTreeWithOptions(contentsFrom(leaves), mt.WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	want := testonly.New(rfc6962.DefaultHasher)
+	for _, l := range leaves {
+		want.AppendData(l)
+	}
+	if !bytes.Equal(tree.MerkleRoot(), want.Hash()) {
+		t.Fatalf("error: n=%d: root disagrees with the RFC 6962 oracle", n)
+	}
+
+	return tree
+}
+
+// TestCheckpointVerifierKeyMatchesNote checks the two implementations agree on how a key
+// is named, hashed and encoded, which every other exchange below depends on.
+func TestCheckpointVerifierKeyMatchesNote(t *testing.T) {
+	key, _, vkey := noteKeys(t, checkpointOrigin)
+	got, err := mt.CheckpointVerifierKey(checkpointOrigin, key.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if got != vkey {
+		t.Errorf("error: verifier key %q, note gives %q", got, vkey)
+	}
+	name, pub, err := mt.ParseCheckpointVerifierKey(vkey)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if name != checkpointOrigin || !bytes.Equal(pub, key.Public().(ed25519.PublicKey)) {
+		t.Errorf("error: parsed %q %x from the note key", name, pub)
+	}
+}
+
+// TestCheckpointsVerifyUnderNote signs checkpoints with merkletree and opens them with
+// the note package.
+func TestCheckpointsVerifyUnderNote(t *testing.T) {
+	key, _, vkey := noteKeys(t, checkpointOrigin)
+	verifier, err := note.NewVerifier(vkey)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for _, n := range oracleSizes {
+		c, err := checkpointTree(t, n).Checkpoint(checkpointOrigin)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		signed, err := mt.SignCheckpoint(c, checkpointOrigin, key)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		opened, err := note.Open(signed, note.VerifierList(verifier))
+		if err != nil {
+			t.Fatalf("error: n=%d: note does not open: %v\n%s", n, err, signed)
+		}
+		text, _ := mt.FormatCheckpoint(c)
+		if opened.Text != string(text) || len(opened.Sigs) != 1 {
+			t.Errorf("error: n=%d: note opened to %q with %d signatures", n, opened.Text, len(opened.Sigs))
+		}
+	}
+}
+
+// TestNoteCheckpointsVerify signs checkpoints with the note package, cosigned by a
+// second key, and verifies them with merkletree under each.
+func TestNoteCheckpointsVerify(t *testing.T) {
+	logKey, logSkey, _ := noteKeys(t, checkpointOrigin)
+	witnessKey, witnessSkey, _ := noteKeys(t, "witness.example.com")
+	var signers []note.Signer
+	for _, skey := range []string{logSkey, witnessSkey} {
+		s, err := note.NewSigner(skey)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		signers = append(signers, s)
+	}
+
+	for _, n := range oracleSizes {
+		tree := checkpointTree(t, n)
+		root := tree.MerkleRoot()
+		text := fmt.Sprintf("%s\n%d\n%s\nextension line\n", checkpointOrigin, n, base64.StdEncoding.EncodeToString(root))
+		signed, err := note.Sign(&note.Note{Text: text}, signers...)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+
+		for name, key := range map[string]ed25519.PrivateKey{checkpointOrigin: logKey, "witness.example.com": witnessKey} {
+			c, err := mt.VerifyCheckpoint(signed, name, key.Public().(ed25519.PublicKey))
+			if err != nil {
+				t.Fatalf("error: n=%d: %s: unexpected error: %v", n, name, err)
+			}
+			if c.Origin != checkpointOrigin || c.Size != n || !bytes.Equal(c.Hash, root) || len(c.Extensions) != 1 {
+				t.Errorf("error: n=%d: %s: checkpoint %+v does not describe the tree", n, name, c)
+			}
+		}
+		if _, err := mt.VerifyCheckpoint(bytes.Replace(signed, []byte("extension"), []byte("Extension"), 1), checkpointOrigin, logKey.Public().(ed25519.PublicKey)); err == nil {
+			t.Errorf("error: n=%d: altered note verified", n)
+		}
+	}
+}
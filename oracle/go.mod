@@ -13,4 +13,5 @@ replace github.com/cbergoon/merkletree => ../
 require (
 	github.com/cbergoon/merkletree v0.0.0-00010101000000-000000000000
 	github.com/transparency-dev/merkle v0.0.2
+	golang.org/x/mod v0.20.0
 )
@@ -24,6 +24,10 @@
 //     Transparency logs use and computes roots by an entirely different method,
 //     accumulating perfect subtrees rather than recursing over a node list.
 //
+// Checkpoints and tiles have oracles of their own in golang.org/x/mod/sumdb, the Go
+// checksum database's implementation of the formats: note, the signed note the C2SP
+// checkpoint format was drawn from, and tlog, which defines the tile layout.
+//
 // Note that google/certificate-transparency-go is not used as a second oracle. As of
 // v1.3.3 it no longer carries a Merkle tree of its own; it imports this same
 // transparency-dev/merkle for the purpose. Testing against both would be testing
@@ -37,6 +41,7 @@ import (
 
 	mt "github.com/cbergoon/merkletree"
 	"github.com/transparency-dev/merkle/compact"
+	"github.com/transparency-dev/merkle/proof"
 	"github.com/transparency-dev/merkle/rfc6962"
 	"github.com/transparency-dev/merkle/testonly"
 )
@@ -446,3 +451,182 @@ func TestOracleIsDiscriminating(t *testing.T) {
 		})
 	}
 }
+
+// TestConsistencyProofsMatchOracle checks consistency proofs the way the inclusion tests
+// above check audit paths: entry for entry against the oracle's, and then each way
+// round, so that the oracle's verifier accepts the proofs this package produces and this
+// package's verifier accepts the oracle's.
+func TestConsistencyProofsMatchOracle(t *testing.T) {
+	for _, n := range oracleSizes {
+		if n > 257 {
+			continue
+		}
+		t.Run(fmt.Sprintf("n=%d", n), func(t *testing.T) {
+			leaves := seriesLeaves(n)
+
+			ref := testonly.New(rfc6962.DefaultHasher)
+			ref.AppendData(leaves...)
+
+			tree, err := mt.NewTreeWithOptions(contentsFrom(leaves), mt.WithRFC6962())
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			for m := 1; m <= n; m++ {
+				want, err := ref.ConsistencyProof(uint64(m), uint64(n))
+				if err != nil {
+					t.Fatalf("error: oracle consistency proof from %d: %v", m, err)
+				}
+				got, err := tree.ConsistencyProof(m)
+				if err != nil {
+					t.Fatalf("error: ConsistencyProof(%d): %v", m, err)
+				}
+
+				if len(got) != len(want) {
+					t.Fatalf("error: m=%d: proof has %d entries, want %d", m, len(got), len(want))
+				}
+				for k := range want {
+					if !bytes.Equal(got[k], want[k]) {
+						t.Errorf("error: m=%d entry %d: %x, want %x", m, k, got[k], want[k])
+					}
+				}
+
+				oldRoot := ref.HashAt(uint64(m))
+				if err := proof.VerifyConsistency(rfc6962.DefaultHasher, uint64(m), uint64(n), got, oldRoot, ref.Hash()); err != nil {
+					t.Errorf("error: m=%d: the oracle rejected this package's proof: %v", m, err)
+				}
+				ok, err := mt.VerifyConsistency(oldRoot, ref.Hash(), m, n, want, mt.WithRFC6962())
+				if err != nil {
+					t.Fatalf("error: VerifyConsistency for m=%d: %v", m, err)
+				}
+				if !ok {
+					t.Errorf("error: m=%d: the oracle's own proof did not verify against the oracle's own roots", m)
+				}
+			}
+		})
+	}
+}
+
+// TestVerifyInclusionRFC6962AgainstOracle checks the index and size verifier the same
+// way round as the consistency test: the oracle's paths verify here exactly as the
+// oracle hands them over, with no side markers derived on this side, and the oracle's
+// verifier accepts the paths this package produces for the same leaf and size.
+func TestVerifyInclusionRFC6962AgainstOracle(t *testing.T) {
+	for _, n := range oracleSizes {
+		t.Run(fmt.Sprintf("n=%d", n), func(t *testing.T) {
+			leaves := seriesLeaves(n)
+
+			ref := testonly.New(rfc6962.DefaultHasher)
+			ref.AppendData(leaves...)
+			oracleRoot := ref.Hash()
+
+			tree, err := mt.NewTreeWithOptions(contentsFrom(leaves), mt.WithRFC6962())
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			for i := 0; i < n; i++ {
+				oraclePath, err := ref.InclusionProof(uint64(i), uint64(n))
+				if err != nil {
+					t.Fatalf("error: oracle inclusion proof for leaf %d: %v", i, err)
+				}
+				ok, err := mt.VerifyInclusionRFC6962(leaves[i], i, n, oraclePath, oracleRoot)
+				if err != nil {
+					t.Fatalf("error: VerifyInclusionRFC6962 for leaf %d: %v", i, err)
+				}
+				if !ok {
+					t.Errorf("error: leaf %d: the oracle's own path did not verify against the oracle's own root", i)
+				}
+
+				path, _, err := tree.GetMerklePathByIndex(i)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				leafHash := rfc6962.DefaultHasher.HashLeaf(leaves[i])
+				if err := proof.VerifyInclusion(rfc6962.DefaultHasher, uint64(i), uint64(n), leafHash, path, oracleRoot); err != nil {
+					t.Errorf("error: leaf %d: the oracle rejected this package's path: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+// TestCompactRangeAgainstOracle checks CompactRange against the compact package hash
+// for hash, not only at the root: both hold the same perfect subtrees for the same
+// range, as leaves are appended one at a time and as ranges split at arbitrary points
+// are merged back together.
+func TestCompactRangeAgainstOracle(t *testing.T) {
+	factory := &compact.RangeFactory{Hash: rfc6962.DefaultHasher.HashChildren}
+
+	for _, n := range oracleSizes {
+		t.Run(fmt.Sprintf("n=%d", n), func(t *testing.T) {
+			leaves := seriesLeaves(n)
+
+			oracle := factory.NewEmptyRange(0)
+			ours, err := mt.NewCompactRange()
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			for i, l := range leaves {
+				if err := oracle.Append(rfc6962.DefaultHasher.HashLeaf(l), nil); err != nil {
+					t.Fatalf("error: appending to the compact range: %v", err)
+				}
+				if err := ours.AppendDigest(l); err != nil {
+					t.Fatalf("error: AppendDigest(%d): %v", i, err)
+				}
+				if fmt.Sprint(ours.Hashes()) != fmt.Sprint(oracle.Hashes()) {
+					t.Fatalf("error: after %d leaves the ranges hold different hashes", i+1)
+				}
+			}
+			want, err := oracle.GetRootHash(nil)
+			if err != nil {
+				t.Fatalf("error: computing the compact range root: %v", err)
+			}
+			got, err := ours.Root()
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("error: root %x, want %x", got, want)
+			}
+
+			// Split at every third point and merge the halves, comparing the
+			// merged ranges before either side computes a root.
+			for mid := 1; mid < n; mid += 3 {
+				left, err := mt.NewCompactRange()
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				right, err := mt.NewCompactRangeAt(mid)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				oLeft, oRight := factory.NewEmptyRange(0), factory.NewEmptyRange(uint64(mid))
+				for i, l := range leaves {
+					r, o := left, oLeft
+					if i >= mid {
+						r, o = right, oRight
+					}
+					if err := r.AppendDigest(l); err != nil {
+						t.Fatalf("error: unexpected error: %v", err)
+					}
+					if err := o.Append(rfc6962.DefaultHasher.HashLeaf(l), nil); err != nil {
+						t.Fatalf("error: unexpected error: %v", err)
+					}
+				}
+				if fmt.Sprint(right.Hashes()) != fmt.Sprint(oRight.Hashes()) {
+					t.Fatalf("error: range [%d, %d) holds different hashes", mid, n)
+				}
+				if err := left.Merge(right); err != nil {
+					t.Fatalf("error: Merge at %d: %v", mid, err)
+				}
+				if err := oLeft.AppendRange(oRight, nil); err != nil {
+					t.Fatalf("error: merging oracle ranges at %d: %v", mid, err)
+				}
+				if fmt.Sprint(left.Hashes()) != fmt.Sprint(oLeft.Hashes()) {
+					t.Fatalf("error: ranges merged at %d hold different hashes", mid)
+				}
+			}
+		})
+	}
+}
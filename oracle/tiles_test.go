@@ -0,0 +1,110 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package oracle
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mt "github.com/cbergoon/merkletree"
+	"github.com/cbergoon/merkletree/tiles"
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// The tiles tests read the tiles merkletree writes with golang.org/x/mod/sumdb/tlog, the
+// implementation the tile layout comes from. tlog checks every tile it reads against
+// the tree hash it is given, so a tile at the wrong path, or holding the wrong hashes,
+// fails the read rather than producing a wrong answer quietly.
+
+// dirTileReader is a tlog.TileReader over a directory of tiles.
+type dirTileReader struct {
+	dir    string
+	height int
+}
+
+func (r dirTileReader) Height() int { return r.height }
+
+func (r dirTileReader) ReadTiles(ts []tlog.Tile) ([][]byte, error) {
+	data := make([][]byte, len(ts))
+	for i, t := range ts {
+		b, err := os.ReadFile(filepath.Join(r.dir, filepath.FromSlash(t.Path())))
+		if err != nil {
+			return nil, err
+		}
+		data[i] = b
+	}
+
+	return data, nil
+}
+
+func (r dirTileReader) SaveTiles([]tlog.Tile, [][]byte) {}
+
+func TestTilesReadByTlog(t *testing.T) {
+	for _, height := range []int{2, tiles.DefaultHeight} {
+		for _, n := range oracleSizes {
+			tree, err := mt.NewTreeWithOptions(contentsFrom(seriesLeaves(n)), mt.WithRFC6962())
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			dir := t.TempDir()
+			if err := tiles.Write(dir, tree, height); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			var root tlog.Hash
+			copy(root[:], tree.MerkleRoot())
+			hr := tlog.TileHashReader(tlog.Tree{N: int64(n), Hash: root}, dirTileReader{dir: dir, height: height})
+			got, err := tlog.TreeHash(int64(n), hr)
+			if err != nil {
+				t.Fatalf("error: height %d n=%d: tlog cannot read the tiles: %v", height, n, err)
+			}
+			if got != root {
+				t.Fatalf("error: height %d n=%d: tlog computes root %x, want %x", height, n, got, root)
+			}
+
+			r, _ := tiles.NewReader(dir, height)
+			for _, i := range []int{0, n / 2, n - 1} {
+				want, err := tlog.ProveRecord(int64(n), int64(i), hr)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				proof, err := r.InclusionProof(i, n)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				if !sameHashes(proof, want) {
+					t.Errorf("error: height %d n=%d leaf %d: inclusion proof differs from tlog's", height, n, i)
+				}
+			}
+			for _, m := range []int{1, (n + 1) / 2, n} {
+				want, err := tlog.ProveTree(int64(n), int64(m), hr)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				proof, err := r.ConsistencyProof(m, n)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				if !sameHashes(proof, want) {
+					t.Errorf("error: height %d n=%d from %d: consistency proof differs from tlog's", height, n, m)
+				}
+			}
+		}
+	}
+}
+
+func sameHashes(got [][]byte, want []tlog.Hash) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], want[i][:]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"hash"
+	"reflect"
 	"sync"
 )
 
@@ -63,7 +64,8 @@ var defaultProofConfig = &MerkleTree{
 //
 // It establishes that content sits in some tree whose root is root. It says nothing
 // about whether root is the root a verifier should be trusting; that has to arrive
-// through a channel the verifier already trusts, and this function cannot check it.
+// through a channel the verifier already trusts, and this function cannot check it. A
+// SignedTreeHead is one such channel; see SignedTreeHead.VerifyInclusion.
 //
 // Under the default construction it establishes less than it appears to. Leaf and
 // interior hashes are computed the same way, so nothing distinguishes a leaf digest from
@@ -112,6 +114,43 @@ func VerifyProofWithDigest(digest []byte, path [][]byte, index []int64, root []b
 	return cfg.proofReproducesRoot(digest, path, index, root)
 }
 
+// VerifyInclusionRFC6962 reports whether path, the RFC 6962 audit path for the leaf at
+// position leafIndex of a tree of treeSize leaves, reproduces root from the leaf digest.
+//
+// This is inclusion verification the way Certificate Transparency clients and other
+// RFC 6962 implementations do it: the proof is a leaf index, a tree size and a list of
+// hashes, and which side each hash sits on follows from the first two. A path from any
+// implementation of the RFC verifies here as it arrives, with no side markers to
+// reconstruct.
+//
+// The construction is RFC 6962 whatever the options say, so they need not include
+// WithRFC6962 and cannot include WithSortedSiblings; WithHasher selects the hash
+// strategy as usual. As with VerifyProofWithDigest, digest is the value
+// Content.CalculateHash returns, and the leaf prefix is applied here. Note that RFC 6962
+// hashes leaf data directly, so for a path from another implementation that is the leaf
+// data itself; see WithRFC6962.
+//
+// Returns false when the path does not reproduce root, and an error wrapping
+// ErrMalformedProof when leafIndex is not a leaf of a tree of treeSize leaves or path is
+// not the length the RFC gives that leaf's path.
+//
+// https://datatracker.ietf.org/doc/html/rfc9162#section-2.1.3.2
+func VerifyInclusionRFC6962(digest []byte, leafIndex, treeSize int, path [][]byte, root []byte, opts ...TreeOption) (bool, error) {
+	cfg, err := configFromOptions(append(opts[:len(opts):len(opts)], WithRFC6962()))
+	if err != nil {
+		return false, err
+	}
+	if treeSize < 1 || leafIndex < 0 || leafIndex >= treeSize {
+		return false, fmt.Errorf("%w: no leaf %d in a tree of size %d", ErrMalformedProof, leafIndex, treeSize)
+	}
+	index := pathSides(make([]int64, 0, len(path)), leafIndex, treeSize, true)
+	if len(index) != len(path) {
+		return false, fmt.Errorf("%w: leaf %d of a tree of size %d has a path of %d hashes, got %d", ErrMalformedProof, leafIndex, treeSize, len(index), len(path))
+	}
+
+	return cfg.proofReproducesRoot(digest, path, index, root)
+}
+
 // proofReproducesRoot replays a proof from the leaf upwards and reports whether it
 // arrives at root.
 //
@@ -125,6 +164,10 @@ func VerifyProofWithDigest(digest []byte, path [][]byte, index []int64, root []b
 // appropriate; there is no secret here whose length or content a timing difference
 // could leak.
 func (m *MerkleTree) proofReproducesRoot(digest []byte, path [][]byte, index []int64, root []byte) (bool, error) {
+	if m.arity > 2 {
+		return m.groupProofReproducesRoot(digest, path, index, root)
+	}
+
 	// One hasher and one buffer serve the whole replay, recycled through the pool
 	// when the configuration carries one. Each level appends its hash and then
 	// slides it down over the previous one, so the buffer stays the width of a
@@ -203,6 +246,12 @@ func configFromOptions(opts []TreeOption) (*MerkleTree, error) {
 	if m.rfc6962 && m.sort {
 		return nil, errors.New("error: WithRFC6962 and WithSortedSiblings cannot be combined; RFC 6962 specifies its own sibling ordering")
 	}
+	if err := m.checkBitcoinOptions(); err != nil {
+		return nil, err
+	}
+	if err := m.checkArityOptions(); err != nil {
+		return nil, err
+	}
 
 	return m, nil
 }
@@ -228,3 +277,198 @@ func (m *MerkleTree) VerifyProof(content Content, path [][]byte, index []int64)
 
 	return m.proofReproducesRoot(digest, path, index, m.merkleRoot)
 }
+
+// ErrConstructionMismatch is returned when two things that have to describe the same
+// construction do not: a Proof recording one construction or hash strategy checked
+// under options naming another. Test for it with errors.Is.
+var ErrConstructionMismatch = errors.New("error: construction does not match")
+
+// Proof is an audit path together with everything needed to interpret it: which leaf it
+// is for, how large the tree was, and how the tree was built. It is the self-describing
+// form of the path and index pair GetMerklePath returns, and the one to store or send.
+//
+// There are no side markers. Which side each sibling sits on follows from LeafIndex and
+// TreeSize under the recorded construction, so carrying them as well would only give a
+// proof two ways to disagree with itself. Path recovers them for code written against
+// the pair form.
+type Proof struct {
+	// LeafIndex is the position in Leafs of the leaf the proof is for. Under the
+	// default and sorted constructions a tree with an odd TreeSize also has a padding
+	// leaf at position TreeSize, and a proof for it is valid.
+	LeafIndex int
+	// TreeSize is the number of content items the tree held, not counting any
+	// padding leaf.
+	TreeSize int
+	// Sorted and RFC6962 record the construction, as MerkleTree.Sorted and
+	// MerkleTree.RFC6962 report it.
+	Sorted  bool
+	RFC6962 bool
+	// HashStrategy is the name the tree's hash strategy is registered under with
+	// RegisterHashStrategy, or empty when it has none.
+	HashStrategy string
+	// Siblings are the sibling hashes from the leaf up to the root.
+	Siblings [][]byte
+}
+
+// GetProof returns the audit path for the leaf holding content as a Proof. Content is
+// located exactly as GetMerklePath locates it, and the returned error wraps
+// ErrContentNotFound if no leaf holds it.
+//
+// The siblings are the tree's own hashes, not copies; treat them as read only.
+func (m *MerkleTree) GetProof(content Content) (*Proof, error) {
+	if err := m.requireBinary("a Proof"); err != nil {
+		return nil, err
+	}
+	i, err := m.findLeaf(content)
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 {
+		return nil, ErrContentNotFound
+	}
+
+	return m.proofForLeaf(i), nil
+}
+
+// GetProofByIndex returns the audit path for the leaf at position i in Leafs as a
+// Proof. It is to GetProof what GetMerklePathByIndex is to GetMerklePath, and returns
+// ErrContentNotFound if i is outside the range of Leafs.
+func (m *MerkleTree) GetProofByIndex(i int) (*Proof, error) {
+	if err := m.requireBinary("a Proof"); err != nil {
+		return nil, err
+	}
+	if i < 0 || i >= m.leafCount() {
+		return nil, fmt.Errorf("%w: no leaf at index %d, the tree has %d", ErrContentNotFound, i, m.leafCount())
+	}
+
+	return m.proofForLeaf(i), nil
+}
+
+// proofForLeaf assembles the Proof for the leaf at position i, which must be in range.
+func (m *MerkleTree) proofForLeaf(i int) *Proof {
+	name := m.hashStrategyName
+	if name == "" {
+		name, _ = lookupHashStrategyName(m.hashStrategy)
+	}
+	siblings, _ := m.pathFromLeaf(i)
+
+	return &Proof{
+		LeafIndex:    i,
+		TreeSize:     m.contentCount(),
+		Sorted:       m.sort,
+		RFC6962:      m.rfc6962,
+		HashStrategy: name,
+		Siblings:     siblings,
+	}
+}
+
+// Path returns the proof in the form GetMerklePath returns it, an audit path and the
+// side each sibling sits on, for use with VerifyProof and the code already written
+// against it. The path is p.Siblings itself, not a copy.
+//
+// Returns an error wrapping ErrMalformedProof if the leaf index and tree size do not
+// describe a leaf, or the number of siblings is not the number that leaf's path has.
+func (p *Proof) Path() ([][]byte, []int64, error) {
+	index, err := p.sides()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return p.Siblings, index, nil
+}
+
+// Verify reports whether the proof shows content to be held by a tree whose root is
+// root. It is VerifyProof for a Proof, and the options describe the construction the
+// verifier expects exactly as they do there.
+//
+// The construction comes from the options, never from the proof, because a proof is
+// exactly the thing a verifier does not trust. The construction the proof records must
+// agree with them, and so must its hash strategy when it names one registered here;
+// otherwise the returned error wraps ErrConstructionMismatch.
+func (p *Proof) Verify(content Content, root []byte, opts ...TreeOption) (bool, error) {
+	if content == nil {
+		return false, ErrNilContent
+	}
+	digest, err := content.CalculateHash()
+	if err != nil {
+		return false, err
+	}
+
+	return p.VerifyDigest(digest, root, opts...)
+}
+
+// VerifyDigest is Verify for a verifier that holds the leaf digest rather than the
+// content, as VerifyProofWithDigest is for VerifyProof. The digest is the value
+// Content.CalculateHash returns for the leaf under every construction.
+func (p *Proof) VerifyDigest(digest, root []byte, opts ...TreeOption) (bool, error) {
+	cfg, err := configFromOptions(opts)
+	if err != nil {
+		return false, err
+	}
+	if err := cfg.requireBinary("a Proof"); err != nil {
+		return false, err
+	}
+	if p.Sorted != cfg.sort || p.RFC6962 != cfg.rfc6962 {
+		return false, fmt.Errorf("%w: the proof records sorted=%t rfc6962=%t, the options sorted=%t rfc6962=%t", ErrConstructionMismatch, p.Sorted, p.RFC6962, cfg.sort, cfg.rfc6962)
+	}
+	if p.HashStrategy != "" {
+		if strategy, ok := lookupHashStrategy(p.HashStrategy); ok && reflect.ValueOf(strategy).Pointer() != reflect.ValueOf(cfg.hashStrategy).Pointer() {
+			return false, fmt.Errorf("%w: the proof was produced with hash strategy %q, which the options do not select", ErrConstructionMismatch, p.HashStrategy)
+		}
+	}
+	index, err := p.sidesFor(cfg.bitcoin)
+	if err != nil {
+		return false, err
+	}
+
+	return cfg.proofReproducesRoot(digest, p.Siblings, index, root)
+}
+
+// sides derives the side markers of the proof's path from its leaf index and tree size,
+// and checks the sibling count against them. A proof for the one leaf of a tree of one
+// item with no siblings is taken to come from WithBitcoinCompat, the construction in
+// which that leaf is the root; sidesFor checks it against the construction a verifier
+// expects.
+func (p *Proof) sides() ([]int64, error) {
+	return p.sidesFor(p.TreeSize == 1 && len(p.Siblings) == 0)
+}
+
+// sidesFor is sides for a proof from a tree built with WithBitcoinCompat when bitcoin
+// is set, whose single item is not padded.
+func (p *Proof) sidesFor(bitcoin bool) ([]int64, error) {
+	count := p.TreeSize
+	if !p.RFC6962 && !(bitcoin && count == 1) {
+		count += count % 2
+	}
+	if p.TreeSize < 1 || p.LeafIndex < 0 || p.LeafIndex >= count {
+		return nil, fmt.Errorf("%w: no leaf %d in a tree of size %d", ErrMalformedProof, p.LeafIndex, p.TreeSize)
+	}
+	index := pathSides(make([]int64, 0, len(p.Siblings)), p.LeafIndex, count, p.RFC6962)
+	if len(index) != len(p.Siblings) {
+		return nil, fmt.Errorf("%w: leaf %d of a tree of size %d has a path of %d hashes, got %d", ErrMalformedProof, p.LeafIndex, p.TreeSize, len(index), len(p.Siblings))
+	}
+
+	return index, nil
+}
+
+// pathSides appends to dst the side markers GetMerklePath would report for the leaf at
+// position leaf of a tree with count leaves, padding included, without the tree.
+//
+// Every construction pairs node 2j with node 2j+1 on each level, so a node at an even
+// position has its sibling on the right and one at an odd position on the left. The
+// last node of a level with an odd count is the exception: the default and sorted
+// constructions pair it with itself, which is a sibling on the right like any other,
+// while RFC 6962 carries it up a level unpaired, contributing no sibling at all.
+func pathSides(dst []int64, leaf, count int, rfc6962 bool) []int64 {
+	for j := leaf; count > 1; j, count = j/2, (count+1)/2 {
+		switch {
+		case rfc6962 && j == count-1 && j%2 == 0:
+		case j%2 == 0:
+			dst = append(dst, 1)
+		default:
+			dst = append(dst, 0)
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,239 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// A serialized proof follows the conventions of a serialized tree - a magic prefix,
+// a version, uvarint lengths with the shortest encoding required - under a magic of
+// its own, so that neither payload can be mistaken for the other.
+const (
+	// proofSerializationMagic prefixes every binary proof.
+	proofSerializationMagic = "MPROOF"
+	// proofSerializationVersion is the proof wire format version written by this
+	// package.
+	proofSerializationVersion = 1
+)
+
+// Bits of the construction byte of a binary proof. The remaining bits are reserved and
+// must be zero.
+const (
+	proofFlagSorted  = 1 << 0
+	proofFlagRFC6962 = 1 << 1
+)
+
+// proofData is the JSON form of a Proof.
+type proofData struct {
+	Version      int      `json:"version"`
+	LeafIndex    int      `json:"leafIndex"`
+	TreeSize     int      `json:"treeSize"`
+	Sorted       bool     `json:"sorted,omitempty"`
+	RFC6962      bool     `json:"rfc6962,omitempty"`
+	HashStrategy string   `json:"hashStrategy,omitempty"`
+	Siblings     [][]byte `json:"siblings"`
+}
+
+// MarshalBinary encodes the proof, implementing encoding.BinaryMarshaler:
+//
+//	magic         "MPROOF"
+//	version       uvarint
+//	construction  one byte: bit 0 sorted, bit 1 RFC 6962
+//	strategy      uvarint length + bytes
+//	leafIndex     uvarint
+//	treeSize      uvarint
+//	count         uvarint
+//	  sibling     uvarint length + bytes   (repeated count times)
+//
+// Each sibling costs its own length in bytes plus one, where the path and index pair
+// costs eight more for the side marker. Encoding the same proof twice always produces
+// identical bytes. A proof that could not be verified - a leaf index outside the tree, a
+// sibling count its path does not have - is refused rather than written.
+func (p Proof) MarshalBinary() ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	size := len(proofSerializationMagic) +
+		uvarintLen(proofSerializationVersion) +
+		1 +
+		uvarintLen(uint64(len(p.HashStrategy))) + len(p.HashStrategy) +
+		uvarintLen(uint64(p.LeafIndex)) +
+		uvarintLen(uint64(p.TreeSize)) +
+		uvarintLen(uint64(len(p.Siblings)))
+	for _, s := range p.Siblings {
+		size += uvarintLen(uint64(len(s))) + len(s)
+	}
+
+	var flags byte
+	if p.Sorted {
+		flags |= proofFlagSorted
+	}
+	if p.RFC6962 {
+		flags |= proofFlagRFC6962
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(size)
+	buf.WriteString(proofSerializationMagic)
+	writeUvarint(&buf, proofSerializationVersion)
+	buf.WriteByte(flags)
+	writeBytes(&buf, []byte(p.HashStrategy))
+	writeUvarint(&buf, uint64(p.LeafIndex))
+	writeUvarint(&buf, uint64(p.TreeSize))
+	writeUvarint(&buf, uint64(len(p.Siblings)))
+	for _, s := range p.Siblings {
+		writeBytes(&buf, s)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a proof written by MarshalBinary, implementing
+// encoding.BinaryUnmarshaler. Malformed input, including a proof whose sibling count
+// does not match its leaf index and tree size, is rejected with an error wrapping
+// ErrCorruptData, and the receiver is left untouched. The decoded siblings do not alias
+// data.
+func (p *Proof) UnmarshalBinary(data []byte) error {
+	if len(data) < len(proofSerializationMagic) || string(data[:len(proofSerializationMagic)]) != proofSerializationMagic {
+		return fmt.Errorf("%w: missing %q header", ErrCorruptData, proofSerializationMagic)
+	}
+	r := &binaryReader{data: data[len(proofSerializationMagic):]}
+
+	version, err := r.uvarint()
+	if err != nil {
+		return fmt.Errorf("%w: reading version: %w", ErrCorruptData, err)
+	}
+	if version != proofSerializationVersion {
+		return fmt.Errorf("%w: got proof version %d, this build writes and reads %d", ErrUnsupportedVersion, version, proofSerializationVersion)
+	}
+
+	flags, err := r.readByte()
+	if err != nil {
+		return fmt.Errorf("%w: reading construction: %w", ErrCorruptData, err)
+	}
+	if flags&^(proofFlagSorted|proofFlagRFC6962) != 0 {
+		return fmt.Errorf("%w: construction byte is %#x, which sets reserved bits", ErrCorruptData, flags)
+	}
+
+	strategy, err := r.view()
+	if err != nil {
+		return fmt.Errorf("%w: reading hash strategy: %w", ErrCorruptData, err)
+	}
+	leafIndex, err := r.uvarint()
+	if err != nil {
+		return fmt.Errorf("%w: reading leaf index: %w", ErrCorruptData, err)
+	}
+	treeSize, err := r.uvarint()
+	if err != nil {
+		return fmt.Errorf("%w: reading tree size: %w", ErrCorruptData, err)
+	}
+	// Both have to survive conversion to int, and a tree size beyond the remaining
+	// bytes is still sensible - a proof is logarithmic in it - so they are bounded
+	// only by what an int holds.
+	if leafIndex > maxProofInt || treeSize > maxProofInt {
+		return fmt.Errorf("%w: leaf index %d or tree size %d is out of range", ErrCorruptData, leafIndex, treeSize)
+	}
+	count, err := r.uvarint()
+	if err != nil {
+		return fmt.Errorf("%w: reading sibling count: %w", ErrCorruptData, err)
+	}
+	// Each sibling costs at least its length byte, so a count larger than the bytes
+	// remaining cannot be honest. This bounds the allocation below.
+	if count > uint64(r.remaining()) {
+		return fmt.Errorf("%w: sibling count %d exceeds the %d bytes remaining", ErrCorruptData, count, r.remaining())
+	}
+
+	// As in unmarshalTreeData, one arena holds every sibling so the result does not
+	// alias data, at one allocation for the whole decode.
+	arena := make([]byte, 0, r.remaining())
+	siblings := make([][]byte, 0, count)
+	for i := uint64(0); i < count; i++ {
+		view, err := r.view()
+		if err != nil {
+			return fmt.Errorf("%w: reading sibling %d: %w", ErrCorruptData, i, err)
+		}
+		off := len(arena)
+		arena = append(arena, view...)
+		siblings = append(siblings, arena[off:len(arena):len(arena)])
+	}
+	if r.remaining() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes after the last sibling", ErrCorruptData, r.remaining())
+	}
+
+	decoded := Proof{
+		LeafIndex:    int(leafIndex),
+		TreeSize:     int(treeSize),
+		Sorted:       flags&proofFlagSorted != 0,
+		RFC6962:      flags&proofFlagRFC6962 != 0,
+		HashStrategy: string(strategy),
+		Siblings:     siblings,
+	}
+	if err := decoded.validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrCorruptData, err)
+	}
+	*p = decoded
+	return nil
+}
+
+// MarshalJSON encodes the proof, implementing json.Marshaler. Siblings are base64
+// encoded by encoding/json as usual, and the payload carries a version like the binary
+// form does.
+func (p Proof) MarshalJSON() ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(proofData{
+		Version:      proofSerializationVersion,
+		LeafIndex:    p.LeafIndex,
+		TreeSize:     p.TreeSize,
+		Sorted:       p.Sorted,
+		RFC6962:      p.RFC6962,
+		HashStrategy: p.HashStrategy,
+		Siblings:     p.Siblings,
+	})
+}
+
+// UnmarshalJSON decodes a proof written by MarshalJSON, implementing json.Unmarshaler.
+// As with UnmarshalBinary, malformed input wraps ErrCorruptData and the receiver is left
+// untouched on failure.
+func (p *Proof) UnmarshalJSON(data []byte) error {
+	var pd proofData
+	if err := json.Unmarshal(data, &pd); err != nil {
+		return fmt.Errorf("%w: %w", ErrCorruptData, err)
+	}
+	if pd.Version != proofSerializationVersion {
+		return fmt.Errorf("%w: got proof version %d, this build writes and reads %d", ErrUnsupportedVersion, pd.Version, proofSerializationVersion)
+	}
+
+	decoded := Proof{
+		LeafIndex:    pd.LeafIndex,
+		TreeSize:     pd.TreeSize,
+		Sorted:       pd.Sorted,
+		RFC6962:      pd.RFC6962,
+		HashStrategy: pd.HashStrategy,
+		Siblings:     pd.Siblings,
+	}
+	if err := decoded.validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrCorruptData, err)
+	}
+	*p = decoded
+	return nil
+}
+
+// maxProofInt is the largest leaf index or tree size a proof may carry, the largest
+// value an int holds on the platform decoding it.
+const maxProofInt = uint64(^uint(0) >> 1)
+
+// validate reports whether the proof is one that could have come from a tree: a
+// construction that exists, and a leaf index, tree size and sibling count that agree.
+func (p *Proof) validate() error {
+	if p.Sorted && p.RFC6962 {
+		return fmt.Errorf("%w: proof records both the sorted and RFC 6962 constructions, which no tree can be built with", ErrMalformedProof)
+	}
+	_, err := p.sides()
+	return err
+}
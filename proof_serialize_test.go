@@ -0,0 +1,267 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// goldenProof is the binary encoding of the proof for leaf 2 of the RFC 6962 tree over
+// five TestSHA256Content items named "item-0" to "item-4". Like the golden tree
+// payloads it pins the wire format: a change that alters these bytes breaks every proof
+// already stored or sent.
+const goldenProof = "4d50524f4f46010206736861323536020503203aa1754f4d1aac016470f0ecc25b329665666cec4496d631766691a2549d0058202cc48cb60f755f56376fa75d3dea9adbc093d339ef3ccc29557dd4c528e2902820879653c5f920149ba78b5a222b4d1fad2b917dc1d3a73b0dcc8bc3a372303b47"
+
+// goldenProofTree builds the tree goldenProof was taken from.
+func goldenProofTree(t *testing.T) *MerkleTree {
+	t.Helper()
+
+	contents := make([]Content, 0, 5)
+	for i := 0; i < 5; i++ {
+		contents = append(contents, TestSHA256Content{x: fmt.Sprintf("item-%d", i)})
+	}
+	tree, err := NewTreeWithOptions(contents, WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	return tree
+}
+
+// TestProofGoldenPayload checks the encoder still writes, and the decoder still reads,
+// the pinned bytes.
+func TestProofGoldenPayload(t *testing.T) {
+	tree := goldenProofTree(t)
+	p, err := tree.GetProofByIndex(2)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	data, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if got := hex.EncodeToString(data); got != goldenProof {
+		t.Fatalf("error: proof encoding changed:\n got %s\nwant %s", got, goldenProof)
+	}
+
+	want, err := hex.DecodeString(goldenProof)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	var decoded Proof
+	if err := decoded.UnmarshalBinary(want); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	ok, err := decoded.Verify(tree.Leafs[2].C, tree.MerkleRoot(), WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !ok {
+		t.Error("error: the golden proof no longer verifies")
+	}
+}
+
+// TestProofEncodingRoundTrip checks both encodings reproduce the proof exactly, for
+// every leaf of every construction, and that what is decoded still verifies.
+func TestProofEncodingRoundTrip(t *testing.T) {
+	for _, mode := range propModes {
+		for _, n := range []int{1, 2, 5, 8, 13} {
+			t.Run(fmt.Sprintf("%s/n=%d", mode.name, n), func(t *testing.T) {
+				tree, err := mode.build(propSeries(n), sha256.New)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				for i, l := range tree.Leafs {
+					p, err := tree.GetProofByIndex(i)
+					if err != nil {
+						t.Fatalf("error: unexpected error: %v", err)
+					}
+
+					data, err := p.MarshalBinary()
+					if err != nil {
+						t.Fatalf("error: MarshalBinary(%d): %v", i, err)
+					}
+					var fromBinary Proof
+					if err := fromBinary.UnmarshalBinary(data); err != nil {
+						t.Fatalf("error: UnmarshalBinary(%d): %v", i, err)
+					}
+
+					js, err := json.Marshal(p)
+					if err != nil {
+						t.Fatalf("error: MarshalJSON(%d): %v", i, err)
+					}
+					var fromJSON Proof
+					if err := json.Unmarshal(js, &fromJSON); err != nil {
+						t.Fatalf("error: UnmarshalJSON(%d): %v", i, err)
+					}
+
+					for name, got := range map[string]Proof{"binary": fromBinary, "json": fromJSON} {
+						if !reflect.DeepEqual(got, *p) {
+							t.Fatalf("error: leaf %d: %s round trip gave %+v, want %+v", i, name, got, *p)
+						}
+						ok, err := got.Verify(l.C, tree.MerkleRoot(), optsFor(mode, sha256.New)...)
+						if err != nil || !ok {
+							t.Errorf("error: leaf %d: %s decoded proof did not verify (%v)", i, name, err)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestProofUnmarshalBinaryDoesNotAlias checks the decoded siblings are the proof's own,
+// so a caller reusing its read buffer cannot change a proof it already decoded.
+func TestProofUnmarshalBinaryDoesNotAlias(t *testing.T) {
+	tree := goldenProofTree(t)
+	p, err := tree.GetProofByIndex(2)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	data, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	var decoded Proof
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for i := range data {
+		data[i] = 0
+	}
+	if !reflect.DeepEqual(decoded, *p) {
+		t.Error("error: decoded proof changed when its input buffer was overwritten")
+	}
+}
+
+// TestProofUnmarshalBinaryRejectsMalformed covers the ways a payload can be wrong. Each
+// must fail with the documented error and leave the receiver as it was.
+func TestProofUnmarshalBinaryRejectsMalformed(t *testing.T) {
+	valid, err := hex.DecodeString(goldenProof)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	header := len(proofSerializationMagic)
+	edit := func(f func(b []byte) []byte) []byte {
+		return f(bytes.Clone(valid))
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrCorruptData},
+		{"tree payload", []byte(serializationMagic + "\x02"), ErrCorruptData},
+		{"newer version", edit(func(b []byte) []byte { b[header] = 2; return b }), ErrUnsupportedVersion},
+		{"reserved construction bit", edit(func(b []byte) []byte { b[header+1] |= 0x04; return b }), ErrCorruptData},
+		{"both constructions", edit(func(b []byte) []byte { b[header+1] = proofFlagSorted | proofFlagRFC6962; return b }), ErrCorruptData},
+		{"tree size changes the path length", edit(func(b []byte) []byte { b[header+10] = 4; return b }), ErrCorruptData},
+		{"padded version", append(append([]byte(proofSerializationMagic), 0x81, 0x00), valid[header+1:]...), ErrCorruptData},
+		{"trailing byte", append(bytes.Clone(valid), 0), ErrCorruptData},
+	}
+	for n := header; n < len(valid); n++ {
+		cases = append(cases, struct {
+			name string
+			data []byte
+			want error
+		}{fmt.Sprintf("truncated to %d", n), valid[:n], ErrCorruptData})
+	}
+
+	sentinel := Proof{LeafIndex: 42}
+	for _, tc := range cases {
+		p := sentinel
+		if err := p.UnmarshalBinary(tc.data); !errors.Is(err, tc.want) {
+			t.Errorf("error: %s: expected %v, got %v", tc.name, tc.want, err)
+		}
+		if !reflect.DeepEqual(p, sentinel) {
+			t.Errorf("error: %s: receiver was modified by a failed decode", tc.name)
+		}
+	}
+}
+
+// TestProofUnmarshalJSONRejectsMalformed is the JSON counterpart.
+func TestProofUnmarshalJSONRejectsMalformed(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		want error
+	}{
+		{"not json", `{`, ErrCorruptData},
+		{"wrong type", `{"version":1,"leafIndex":"0"}`, ErrCorruptData},
+		{"newer version", `{"version":2,"leafIndex":0,"treeSize":1,"siblings":[]}`, ErrUnsupportedVersion},
+		{"leaf outside the tree", `{"version":1,"leafIndex":3,"treeSize":2,"siblings":["AA=="]}`, ErrCorruptData},
+		{"missing sibling", `{"version":1,"leafIndex":0,"treeSize":2,"siblings":[]}`, ErrCorruptData},
+		{"both constructions", `{"version":1,"leafIndex":0,"treeSize":1,"sorted":true,"rfc6962":true,"siblings":[]}`, ErrCorruptData},
+	}
+	for _, tc := range cases {
+		// Called directly, because encoding/json rejects invalid syntax itself before
+		// the method is reached.
+		var p Proof
+		if err := p.UnmarshalJSON([]byte(tc.data)); !errors.Is(err, tc.want) {
+			t.Errorf("error: %s: expected %v, got %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+// TestProofMarshalRefusesMalformed checks a proof that could not be verified is not
+// written, so every payload this package produces is one it will also accept.
+func TestProofMarshalRefusesMalformed(t *testing.T) {
+	p := Proof{LeafIndex: 0, TreeSize: 4, Siblings: [][]byte{{1}}}
+	if _, err := p.MarshalBinary(); !errors.Is(err, ErrMalformedProof) {
+		t.Errorf("error: MarshalBinary: expected ErrMalformedProof, got %v", err)
+	}
+	if _, err := json.Marshal(p); !errors.Is(err, ErrMalformedProof) {
+		t.Errorf("error: MarshalJSON: expected ErrMalformedProof, got %v", err)
+	}
+}
+
+// FuzzProofUnmarshalBinary holds the proof decoder to the properties the tree decoder
+// is held to: it never faults, and anything it accepts is the canonical encoding of
+// what it decoded.
+func FuzzProofUnmarshalBinary(f *testing.F) {
+	golden, err := hex.DecodeString(goldenProof)
+	if err != nil {
+		f.Fatalf("error: unexpected error: %v", err)
+	}
+	f.Add(golden)
+	for _, mode := range propModes {
+		tree, err := mode.build(propSeries(7), sha256.New)
+		if err != nil {
+			f.Fatalf("error: unexpected error: %v", err)
+		}
+		for i := range tree.Leafs {
+			p, err := tree.GetProofByIndex(i)
+			if err != nil {
+				f.Fatalf("error: unexpected error: %v", err)
+			}
+			data, err := p.MarshalBinary()
+			if err != nil {
+				f.Fatalf("error: unexpected error: %v", err)
+			}
+			f.Add(data)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var p Proof
+		if err := p.UnmarshalBinary(data); err != nil {
+			return
+		}
+		again, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatalf("error: a decoded proof does not encode: %v", err)
+		}
+		if !bytes.Equal(again, data) {
+			t.Fatalf("error: payload is not canonical:\n got %x\nwant %x", again, data)
+		}
+	})
+}
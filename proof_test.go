@@ -5,6 +5,7 @@ package merkletree
 
 import (
 	"crypto/sha256"
+	"crypto/sha512"
 	"errors"
 	"fmt"
 	"hash"
@@ -397,3 +398,284 @@ func clonePath(path [][]byte) [][]byte {
 
 	return out
 }
+
+// TestProofStructMatchesPath checks a Proof is the pair GetMerklePathByIndex returns,
+// side markers included, for every leaf of every size and construction - the padding
+// leaf too - and that it verifies under the options describing its tree.
+func TestProofStructMatchesPath(t *testing.T) {
+	for _, mode := range propModes {
+		for _, n := range propSizes {
+			t.Run(fmt.Sprintf("%s/n=%d", mode.name, n), func(t *testing.T) {
+				tree, err := mode.build(propSeries(n), sha256.New)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				opts := optsFor(mode, sha256.New)
+
+				for i, l := range tree.Leafs {
+					p, err := tree.GetProofByIndex(i)
+					if err != nil {
+						t.Fatalf("error: GetProofByIndex(%d): %v", i, err)
+					}
+					if p.TreeSize != n || p.LeafIndex != i || p.Sorted != mode.sorted || p.RFC6962 != mode.rfc6962 || p.HashStrategy != "sha256" {
+						t.Fatalf("error: leaf %d: unexpected proof header %+v", i, *p)
+					}
+
+					wantPath, wantIndex, err := tree.GetMerklePathByIndex(i)
+					if err != nil {
+						t.Fatalf("error: unexpected error: %v", err)
+					}
+					path, index, err := p.Path()
+					if err != nil {
+						t.Fatalf("error: Path(%d): %v", i, err)
+					}
+					if fmt.Sprint(index) != fmt.Sprint(wantIndex) || len(path) != len(wantPath) {
+						t.Fatalf("error: leaf %d: derived sides %v, the tree walk gives %v", i, index, wantIndex)
+					}
+
+					ok, err := p.Verify(l.C, tree.MerkleRoot(), opts...)
+					if err != nil {
+						t.Fatalf("error: Verify(%d): %v", i, err)
+					}
+					if !ok {
+						t.Errorf("error: leaf %d: proof did not verify against the root it came from", i)
+					}
+				}
+
+				p, err := tree.GetProof(tree.Leafs[0].C)
+				if err != nil {
+					t.Fatalf("error: GetProof: %v", err)
+				}
+				if p.LeafIndex != 0 {
+					t.Errorf("error: GetProof located leaf %d, expected 0", p.LeafIndex)
+				}
+			})
+		}
+	}
+}
+
+// TestProofStructRejectsTampering confirms a proof checked against the wrong content or
+// root, or with a sibling altered, is a false rather than an error.
+func TestProofStructRejectsTampering(t *testing.T) {
+	for _, mode := range propModes {
+		t.Run(mode.name, func(t *testing.T) {
+			contents := propSeries(11)
+			tree, err := mode.build(contents, sha256.New)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			opts := optsFor(mode, sha256.New)
+			p, err := tree.GetProofByIndex(6)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			if ok, err := p.Verify(contents[5], tree.MerkleRoot(), opts...); err != nil || ok {
+				t.Errorf("error: proof verified for the wrong content (%v)", err)
+			}
+			if ok, err := p.Verify(contents[6], contents[6].(propContent).mustHash(t), opts...); err != nil || ok {
+				t.Errorf("error: proof verified against the wrong root (%v)", err)
+			}
+			forged := *p
+			forged.Siblings = append([][]byte(nil), p.Siblings...)
+			forged.Siblings[1] = append([]byte{0x01}, forged.Siblings[1][1:]...)
+			if ok, err := forged.Verify(contents[6], tree.MerkleRoot(), opts...); err != nil || ok {
+				t.Errorf("error: proof with a sibling altered verified (%v)", err)
+			}
+			// Sorted siblings make the sides irrelevant to the hash, so moving the leaf
+			// there changes nothing the verifier can see.
+			if mode.sorted {
+				return
+			}
+			moved := *p
+			moved.LeafIndex = 7
+			if ok, err := moved.Verify(contents[6], tree.MerkleRoot(), opts...); err != nil || ok {
+				t.Errorf("error: proof verified with its leaf index changed (%v)", err)
+			}
+		})
+	}
+}
+
+// TestProofStructConstructionMismatch checks the options decide the construction and a
+// proof recording a different one is refused rather than checked under either.
+func TestProofStructConstructionMismatch(t *testing.T) {
+	contents := propSeries(6)
+	for _, mode := range propModes {
+		tree, err := mode.build(contents, sha256.New)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		p, err := tree.GetProofByIndex(2)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		for _, other := range propModes {
+			if other.name == mode.name {
+				continue
+			}
+			if _, err := p.Verify(contents[2], tree.MerkleRoot(), optsFor(other, sha256.New)...); !errors.Is(err, ErrConstructionMismatch) {
+				t.Errorf("error: %s proof checked as %s: expected ErrConstructionMismatch, got %v", mode.name, other.name, err)
+			}
+		}
+	}
+
+	tree, err := NewTreeWithOptions(contents, WithHasher(sha512.New))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	p, err := tree.GetProofByIndex(2)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if p.HashStrategy != "sha512" {
+		t.Fatalf("error: expected the strategy to be recorded as sha512, got %q", p.HashStrategy)
+	}
+	if _, err := p.Verify(contents[2], tree.MerkleRoot()); !errors.Is(err, ErrConstructionMismatch) {
+		t.Errorf("error: sha512 proof checked under sha256: expected ErrConstructionMismatch, got %v", err)
+	}
+	if ok, err := p.Verify(contents[2], tree.MerkleRoot(), WithHasher(sha512.New)); err != nil || !ok {
+		t.Errorf("error: sha512 proof did not verify under sha512 (%v)", err)
+	}
+}
+
+// TestProofStructMalformed pins which mistakes are errors: a leaf the tree size cannot
+// hold, or a sibling count that leaf's path does not have.
+func TestProofStructMalformed(t *testing.T) {
+	for _, mode := range propModes {
+		t.Run(mode.name, func(t *testing.T) {
+			contents := propSeries(9)
+			tree, err := mode.build(contents, sha256.New)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			opts := optsFor(mode, sha256.New)
+			p, err := tree.GetProofByIndex(4)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			cases := map[string]func(q *Proof){
+				"negative index":  func(q *Proof) { q.LeafIndex = -1 },
+				"index too large": func(q *Proof) { q.LeafIndex = 10 },
+				"zero tree size":  func(q *Proof) { q.TreeSize = 0 },
+				"missing sibling": func(q *Proof) { q.Siblings = q.Siblings[:len(q.Siblings)-1] },
+				"extra sibling":   func(q *Proof) { q.Siblings = append(append([][]byte(nil), q.Siblings...), q.Siblings[0]) },
+			}
+			for name, mutate := range cases {
+				q := *p
+				mutate(&q)
+				if _, err := q.Verify(contents[4], tree.MerkleRoot(), opts...); !errors.Is(err, ErrMalformedProof) {
+					t.Errorf("error: %s: expected ErrMalformedProof, got %v", name, err)
+				}
+				if _, _, err := q.Path(); !errors.Is(err, ErrMalformedProof) {
+					t.Errorf("error: %s: Path: expected ErrMalformedProof, got %v", name, err)
+				}
+			}
+
+			if _, err := tree.GetProofByIndex(len(tree.Leafs)); !errors.Is(err, ErrContentNotFound) {
+				t.Errorf("error: expected ErrContentNotFound, got %v", err)
+			}
+			if _, err := tree.GetProof(propContent{x: "absent"}); !errors.Is(err, ErrContentNotFound) {
+				t.Errorf("error: expected ErrContentNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+// mustHash returns the content's digest, for tests that need a well formed but wrong
+// root.
+func (t propContent) mustHash(tb testing.TB) []byte {
+	tb.Helper()
+
+	h, err := t.CalculateHash()
+	if err != nil {
+		tb.Fatalf("error: unexpected error: %v", err)
+	}
+
+	return h
+}
+
+// TestVerifyInclusionRFC6962 checks the index and size form accepts every path an RFC
+// 6962 tree produces, for every hash strategy, without being told the construction.
+func TestVerifyInclusionRFC6962(t *testing.T) {
+	for _, s := range propStrategies {
+		for _, n := range propSizes {
+			t.Run(fmt.Sprintf("%s/n=%d", s.name, n), func(t *testing.T) {
+				contents := propSeries(n)
+				tree, err := NewTreeWithOptions(contents, WithRFC6962(), WithHasher(s.fn))
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				for i, c := range contents {
+					path, _, err := tree.GetMerklePathByIndex(i)
+					if err != nil {
+						t.Fatalf("error: unexpected error: %v", err)
+					}
+					digest := c.(propContent).mustHash(t)
+
+					ok, err := VerifyInclusionRFC6962(digest, i, n, path, tree.MerkleRoot(), WithHasher(s.fn))
+					if err != nil {
+						t.Fatalf("error: VerifyInclusionRFC6962(%d): %v", i, err)
+					}
+					if !ok {
+						t.Errorf("error: leaf %d: path did not verify", i)
+					}
+					if n > 1 {
+						if ok, err := VerifyInclusionRFC6962(digest, (i+1)%n, n, path, tree.MerkleRoot(), WithHasher(s.fn)); err == nil && ok {
+							t.Errorf("error: leaf %d: path verified for leaf %d", i, (i+1)%n)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestVerifyInclusionRFC6962Malformed pins which mistakes are errors rather than a
+// false: a leaf the size cannot hold, a path of the wrong length, and options that
+// contradict RFC 6962.
+func TestVerifyInclusionRFC6962Malformed(t *testing.T) {
+	contents := propSeries(11)
+	tree, err := NewTreeWithOptions(contents, WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	root := tree.MerkleRoot()
+	path, _, err := tree.GetMerklePathByIndex(9)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	digest := contents[9].(propContent).mustHash(t)
+
+	cases := []struct {
+		name        string
+		index, size int
+		path        [][]byte
+	}{
+		{"negative index", -1, 11, path},
+		{"index at size", 11, 11, path},
+		{"zero size", 0, 0, nil},
+		{"missing hash", 9, 11, path[:len(path)-1]},
+		{"extra hash", 9, 11, append(append([][]byte(nil), path...), root)},
+		{"size with a shorter path", 9, 10, path},
+	}
+	for _, tc := range cases {
+		if _, err := VerifyInclusionRFC6962(digest, tc.index, tc.size, tc.path, root); !errors.Is(err, ErrMalformedProof) {
+			t.Errorf("error: %s: expected ErrMalformedProof, got %v", tc.name, err)
+		}
+	}
+
+	if _, err := VerifyInclusionRFC6962(digest, 9, 11, path, root, WithSortedSiblings()); err == nil {
+		t.Error("error: expected an error combining RFC 6962 with sorted siblings")
+	}
+	// The RFC 6962 option is added to the caller's list, and must not be written into
+	// spare capacity the caller still owns.
+	opts := make([]TreeOption, 1, 2)
+	opts[0] = WithHasher(sha256.New)
+	if _, err := VerifyInclusionRFC6962(digest, 9, 11, path, root, opts...); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if opts[:2][1] != nil {
+		t.Error("error: the caller's option slice was written to")
+	}
+}
@@ -38,6 +38,9 @@ const (
 	// serializationMagic prefixes every binary blob so a truncated or foreign
 	// payload is rejected before any length is trusted.
 	serializationMagic = "MTREE"
+	// digestsMagic prefixes the blob of a tree built with NewTreeFromDigests, which
+	// holds leaf digests in place of content records.
+	digestsMagic = "MTDGS"
 	// serializationVersion is the wire format version written by this package.
 	// Version 2 added the RFC 6962 flag after the sort flag.
 	serializationVersion = 2
@@ -83,6 +86,7 @@ func init() {
 	RegisterHashStrategy("sha512_256", sha512.New512_256)
 	RegisterHashStrategy("sha1", sha1.New)
 	RegisterHashStrategy("md5", md5.New)
+	RegisterHashStrategy("sha256d", NewDoubleSHA256)
 }
 
 // RegisterHashStrategy makes a hash strategy serializable under the given name. The
@@ -398,7 +402,10 @@ type treeData struct {
 	Sort         bool            `json:"sort"`
 	RFC6962      bool            `json:"rfc6962,omitempty"`
 	MerkleRoot   []byte          `json:"merkleRoot"`
-	Contents     []contentRecord `json:"contents"`
+	Contents     []contentRecord `json:"contents,omitempty"`
+	// Digests holds the leaf digests of a tree built with NewTreeFromDigests, in
+	// place of Contents.
+	Digests [][]byte `json:"digests,omitempty"`
 }
 
 // contentRecord is one leaf's content. Type is empty for payloads written by
@@ -417,9 +424,18 @@ type contentRecord struct {
 // encoding/gob, which is precisely the unbounded recursion these methods exist to
 // prevent. Marshaling only reads, so the copy costs nothing that matters.
 func (m MerkleTree) snapshot(enc ContentMarshalFunc, opts ...MarshalOption) (*treeData, error) {
-	if m.Root == nil || len(m.Leafs) == 0 {
+	if m.empty() {
 		return nil, errors.New("merkletree: cannot marshal an empty tree")
 	}
+	if err := m.requireContent("serialization"); err != nil {
+		return nil, err
+	}
+	if err := m.requireBinary("serialization"); err != nil {
+		return nil, err
+	}
+	if m.bitcoin && m.contentCount() == 1 {
+		return nil, fmt.Errorf("%w: a tree of one transaction under WithBitcoinCompat is unpadded, and the serialized form does not record the option", ErrConstructionMismatch)
+	}
 
 	var cfg marshalConfig
 	for _, opt := range opts {
@@ -443,28 +459,35 @@ func (m MerkleTree) snapshot(enc ContentMarshalFunc, opts ...MarshalOption) (*tr
 		Sort:         m.sort,
 		RFC6962:      m.rfc6962,
 		MerkleRoot:   bytes.Clone(m.merkleRoot),
-		// Sized to the leaf count up front; at most one entry, the padding copy, goes
-		// unused, where growing by append reallocates log n times on a large tree.
-		Contents: make([]contentRecord, 0, len(m.Leafs)),
 	}
+	if enc == nil && m.digests {
+		if err := m.snapshotDigests(td); err != nil {
+			return nil, err
+		}
+
+		return td, nil
+	}
+	// Sized to the leaf count up front; at most one entry, the padding copy, goes
+	// unused, where growing by append reallocates log n times on a large tree.
+	td.Contents = make([]contentRecord, 0, m.leafCount())
 
 	var cache contentTypeCache
-	for _, l := range m.Leafs {
+	for i := 0; i < m.leafCount(); i++ {
 		// Skip the padding copy buildWithContent appends for an odd content count.
 		// It is regenerated on rebuild; encoding it would promote it to real content
 		// and the decoded tree would report one more item than was put in.
-		if l.dup {
+		if m.leafIsPadding(i) {
 			continue
 		}
 		if enc != nil {
-			payload, err := enc(l.C)
+			payload, err := enc(m.leafContent(i))
 			if err != nil {
 				return nil, fmt.Errorf("merkletree: marshaling content: %w", err)
 			}
 			td.Contents = append(td.Contents, contentRecord{Payload: payload})
 			continue
 		}
-		typeName, payload, err := marshalRegisteredContent(l.C, &cache)
+		typeName, payload, err := marshalRegisteredContent(m.leafContent(i), &cache)
 		if err != nil {
 			return nil, err
 		}
@@ -497,11 +520,20 @@ func (td *treeData) tree(dec ContentUnmarshalFunc, opts ...UnmarshalOption) (*Me
 		}
 	}
 
-	if len(td.Contents) == 0 {
+	var cs []Content
+	switch {
+	case td.Digests != nil && len(td.Contents) != 0:
+		return nil, fmt.Errorf("%w: the payload holds both digests and content", ErrCorruptData)
+	case td.Digests != nil:
+		var err error
+		if cs, err = digestContents(td.Digests); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCorruptData, err)
+		}
+	case len(td.Contents) == 0:
 		return nil, errors.New("merkletree: serialized tree contains no content")
+	default:
+		cs = make([]Content, 0, len(td.Contents))
 	}
-
-	cs := make([]Content, 0, len(td.Contents))
 	var cache contentTypeCache
 	for i, record := range td.Contents {
 		var (
@@ -530,6 +562,7 @@ func (td *treeData) tree(dec ContentUnmarshalFunc, opts ...UnmarshalOption) (*Me
 		hashStrategyName: td.HashStrategy,
 		sort:             td.Sort,
 		rfc6962:          td.RFC6962,
+		digests:          td.Digests != nil,
 	}
 	root, leafs, err := buildWithContent(cs, t)
 	if err != nil {
@@ -673,6 +706,13 @@ func (m *MerkleTree) adoptNodes() {
 //	  type      uvarint length + bytes   (repeated count times)
 //	  payload   uvarint length + bytes
 //
+// A tree built with NewTreeFromDigests is written with the magic "MTDGS" instead, and
+// after the root holds its leaf digests rather than content records:
+//
+//	count       uvarint
+//	width       uvarint
+//	digests     count × width bytes, back to back
+//
 // Encoding the same tree twice always produces identical bytes, so payloads can be
 // compared or content-addressed directly.
 func (td *treeData) marshalBinary() []byte {
@@ -684,16 +724,22 @@ func (td *treeData) marshalBinary() []byte {
 		uvarintLen(uint64(td.Version)) +
 		uvarintLen(uint64(len(td.HashStrategy))) + len(td.HashStrategy) +
 		2 +
-		uvarintLen(uint64(len(td.MerkleRoot))) + len(td.MerkleRoot) +
-		uvarintLen(uint64(len(td.Contents)))
-	for _, record := range td.Contents {
-		size += uvarintLen(uint64(len(record.Type))) + len(record.Type) +
-			uvarintLen(uint64(len(record.Payload))) + len(record.Payload)
+		uvarintLen(uint64(len(td.MerkleRoot))) + len(td.MerkleRoot)
+	magic := serializationMagic
+	if td.Digests != nil {
+		magic = digestsMagic
+		size += uvarintLen(uint64(len(td.Digests))) + uvarintLen(uint64(len(td.Digests[0]))) + len(td.Digests)*len(td.Digests[0])
+	} else {
+		size += uvarintLen(uint64(len(td.Contents)))
+		for _, record := range td.Contents {
+			size += uvarintLen(uint64(len(record.Type))) + len(record.Type) +
+				uvarintLen(uint64(len(record.Payload))) + len(record.Payload)
+		}
 	}
 
 	var buf bytes.Buffer
 	buf.Grow(size)
-	buf.WriteString(serializationMagic)
+	buf.WriteString(magic)
 	writeUvarint(&buf, uint64(td.Version))
 	writeBytes(&buf, []byte(td.HashStrategy))
 	if td.Sort {
@@ -707,6 +753,11 @@ func (td *treeData) marshalBinary() []byte {
 		buf.WriteByte(0)
 	}
 	writeBytes(&buf, td.MerkleRoot)
+	if td.Digests != nil {
+		td.writeDigests(&buf)
+
+		return buf.Bytes()
+	}
 	writeUvarint(&buf, uint64(len(td.Contents)))
 	for _, record := range td.Contents {
 		writeBytes(&buf, []byte(record.Type))
@@ -719,7 +770,8 @@ func (td *treeData) marshalBinary() []byte {
 // against the bytes actually remaining before it is used to allocate, so a corrupt or
 // hostile payload fails rather than exhausting memory.
 func unmarshalTreeData(data []byte) (*treeData, error) {
-	if len(data) < len(serializationMagic) || string(data[:len(serializationMagic)]) != serializationMagic {
+	digests := bytes.HasPrefix(data, []byte(digestsMagic))
+	if !digests && !bytes.HasPrefix(data, []byte(serializationMagic)) {
 		return nil, fmt.Errorf("%w: missing %q header", ErrCorruptData, serializationMagic)
 	}
 	r := &binaryReader{data: data[len(serializationMagic):]}
@@ -763,6 +815,9 @@ func unmarshalTreeData(data []byte) (*treeData, error) {
 	if td.MerkleRoot, err = r.view(); err != nil {
 		return nil, fmt.Errorf("%w: reading Merkle root: %w", ErrCorruptData, err)
 	}
+	if digests {
+		return td, td.readDigests(r)
+	}
 
 	count, err := r.uvarint()
 	if err != nil {
@@ -416,6 +416,7 @@ func TestBuiltinHashStrategiesRoundTrip(t *testing.T) {
 		"sha512_256": sha512.New512_256,
 		"sha1":       sha1.New,
 		"md5":        md5.New,
+		"sha256d":    NewDoubleSHA256,
 	}
 
 	registered := HashStrategyNames()
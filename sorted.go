@@ -0,0 +1,289 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// ErrNotSortedContent is returned by ProveAbsence when the tree was not built with
+// NewSortedContentTree, and so has no order in which an item could be missing. Test for
+// it with errors.Is.
+var ErrNotSortedContent = errors.New("error: operation requires a tree built with NewSortedContentTree")
+
+// ErrContentPresent is returned by ProveAbsence when the content it was asked to prove
+// absent is in the tree. Test for it with errors.Is.
+var ErrContentPresent = errors.New("error: content is present in tree")
+
+// NewSortedContentTree creates a Merkle Tree holding cs in the order cmp gives, which
+// makes absence provable: an item that is not in the tree falls between two adjacent
+// leaves, or before the first or after the last, and proofs for those leaves show it.
+// See ProveAbsence.
+//
+// cmp returns a negative number when a orders before b, a positive number when it
+// orders after, and zero when they are the same item, as slices.SortFunc expects. Items
+// that compare equal are rejected, since a key can only be absent from an order that
+// holds each key once. cs itself is not reordered.
+//
+// The options are those of NewTreeWithOptions, except that WithSortedSiblings is
+// rejected: it discards the sides of a path, and with them the leaf positions that
+// adjacency is checked by.
+//
+// The tree keeps its order. Append and UpdateLeaf reject content that would break it,
+// and RebuildTreeWith sorts the content it is given. The comparator cannot be
+// serialized, so a tree decoded from a payload is an ordinary tree, and ProveAbsence
+// on it returns ErrNotSortedContent.
+func NewSortedContentTree(cs []Content, cmp func(a, b Content) int, opts ...TreeOption) (*MerkleTree, error) {
+	if cmp == nil {
+		return nil, errors.New("error: NewSortedContentTree requires a comparator")
+	}
+	t, err := configFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.requireBinary("a sorted content tree"); err != nil {
+		return nil, err
+	}
+	if t.discardContent {
+		return nil, errors.New("error: a sorted content tree cannot discard its content; absence proofs carry it")
+	}
+	if t.sort {
+		return nil, errors.New("error: a sorted content tree cannot use WithSortedSiblings; adjacency is checked by leaf position")
+	}
+	t.compare = cmp
+
+	sorted, err := t.sortContent(cs)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.build(sorted); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// sortContent returns a copy of cs in the tree's order, or an error if any two items
+// compare equal.
+func (m *MerkleTree) sortContent(cs []Content) ([]Content, error) {
+	for i, c := range cs {
+		if c == nil {
+			return nil, fmt.Errorf("%w: index %d", ErrNilContent, i)
+		}
+	}
+	sorted := slices.Clone(cs)
+	slices.SortStableFunc(sorted, m.compare)
+	for i := 1; i < len(sorted); i++ {
+		if m.compare(sorted[i-1], sorted[i]) == 0 {
+			return nil, fmt.Errorf("error: content %v and %v compare equal; a sorted content tree holds each item once", sorted[i-1], sorted[i])
+		}
+	}
+
+	return sorted, nil
+}
+
+// checkOrder returns an error unless a orders strictly before b.
+func (m *MerkleTree) checkOrder(a, b Content) error {
+	if m.compare(a, b) >= 0 {
+		return fmt.Errorf("error: content %v does not order before %v; a sorted content tree keeps its order", a, b)
+	}
+
+	return nil
+}
+
+// checkUpdateOrder returns an error unless the tree would still be in order with
+// updates applied, checking each updated leaf against its neighbours as they would
+// then be. positions are the keys of updates in ascending order.
+func (m *MerkleTree) checkUpdateOrder(updates map[int]Content, positions []int) error {
+	at := func(i int) Content {
+		if c, ok := updates[i]; ok {
+			return c
+		}
+		return m.leafContent(i)
+	}
+	n := m.contentCount()
+	for _, i := range positions {
+		if i > 0 {
+			if err := m.checkOrder(at(i-1), at(i)); err != nil {
+				return err
+			}
+		}
+		if i+1 < n {
+			if err := m.checkOrder(at(i), at(i+1)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// contentCount returns the number of content items the tree holds, not counting any
+// padding leaf.
+func (m *MerkleTree) contentCount() int {
+	n := m.leafCount()
+	if n > 0 && m.leafIsPadding(n-1) {
+		n--
+	}
+
+	return n
+}
+
+// AbsenceProof shows that an item is not held by a tree built with
+// NewSortedContentTree, by proving the leaves on either side of where it would be. Left
+// is the last item ordering before it and Right the first ordering after it; one of the
+// two is nil when the item would sort before every leaf or after every leaf.
+type AbsenceProof struct {
+	Left       Content
+	LeftProof  *Proof
+	Right      Content
+	RightProof *Proof
+}
+
+// ProveAbsence returns the proof that c is not in the tree, to be checked with
+// VerifyAbsence. The tree must have been built with NewSortedContentTree, otherwise the
+// returned error wraps ErrNotSortedContent, and if c is in the tree the returned error
+// wraps ErrContentPresent.
+//
+// The neighbours are found by binary search, so this costs O(log n) comparisons rather
+// than the scan GetMerklePath makes. The siblings in the proofs are the tree's own
+// hashes, not copies; treat them as read only.
+func (m *MerkleTree) ProveAbsence(c Content) (*AbsenceProof, error) {
+	if m.compare == nil {
+		return nil, ErrNotSortedContent
+	}
+	if c == nil {
+		return nil, ErrNilContent
+	}
+	if m.empty() {
+		return nil, fmt.Errorf("%w: tree has no root", ErrMalformedTree)
+	}
+
+	n := m.contentCount()
+	i, found := sort.Find(n, func(i int) int {
+		return m.compare(c, m.leafContent(i))
+	})
+	if found {
+		return nil, fmt.Errorf("%w: at index %d", ErrContentPresent, i)
+	}
+
+	proof := &AbsenceProof{}
+	if i > 0 {
+		proof.Left, proof.LeftProof = m.leafContent(i-1), m.proofForLeaf(i-1)
+	}
+	if i < n {
+		proof.Right, proof.RightProof = m.leafContent(i), m.proofForLeaf(i)
+	}
+
+	return proof, nil
+}
+
+// VerifyAbsence reports whether proof shows c to be absent from the sorted content tree
+// whose root is root, without the tree. cmp must be the comparator the tree was built
+// with, and the options describe its construction as they do for VerifyProof.
+//
+// The proof holds when each neighbour it names is proven to be in the tree, c orders
+// strictly between them, and the leaf positions the proofs are bound to show nothing
+// lies between them: the two leaves are adjacent, or the one neighbour is the first or
+// the last leaf of the tree.
+//
+// Returns false when the proof is well formed and does not show absence, and an error
+// when it is malformed, when WithSortedSiblings is among the options, or when a
+// neighbour's proof records a different construction, as Proof.Verify does.
+func VerifyAbsence(c Content, proof *AbsenceProof, root []byte, cmp func(a, b Content) int, opts ...TreeOption) (bool, error) {
+	if c == nil {
+		return false, ErrNilContent
+	}
+	if cmp == nil {
+		return false, errors.New("error: VerifyAbsence requires a comparator")
+	}
+	if proof == nil || (proof.Left == nil && proof.Right == nil) {
+		return false, fmt.Errorf("%w: an absence proof needs at least one neighbour", ErrMalformedProof)
+	}
+	if (proof.Left == nil) != (proof.LeftProof == nil) || (proof.Right == nil) != (proof.RightProof == nil) {
+		return false, fmt.Errorf("%w: each neighbour needs both its content and its proof", ErrMalformedProof)
+	}
+	cfg, err := configFromOptions(opts)
+	if err != nil {
+		return false, err
+	}
+	if err := cfg.requireBinary("an absence proof"); err != nil {
+		return false, err
+	}
+	if cfg.sort {
+		return false, errors.New("error: absence cannot be verified under WithSortedSiblings; it binds no leaf positions")
+	}
+
+	left, right := proof.LeftProof, proof.RightProof
+	for _, side := range []struct {
+		c Content
+		p *Proof
+	}{{proof.Left, left}, {proof.Right, right}} {
+		if side.p == nil {
+			continue
+		}
+		ok, err := side.p.Verify(side.c, root, opts...)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	if proof.Left != nil && cmp(proof.Left, c) >= 0 {
+		return false, nil
+	}
+	if proof.Right != nil && cmp(c, proof.Right) >= 0 {
+		return false, nil
+	}
+	switch {
+	case left != nil && right != nil:
+		return left.TreeSize == right.TreeSize && left.LeafIndex+1 == right.LeafIndex, nil
+	case right != nil:
+		return right.LeafIndex == 0, nil
+	default:
+		if left.LeafIndex != left.TreeSize-1 {
+			return false, nil
+		}
+		return cfg.provesLastLeaf(proof.Left, left)
+	}
+}
+
+// provesLastLeaf reports whether p, a proof for c claiming to be the last leaf, pairs
+// the leaf's path only where the last node of a level would be paired.
+//
+// Under RFC 6962 a proof's tree size is bound by the root: only the last leaf's path
+// follows the right hand edge down. The default construction pairs the last node of an
+// odd level with itself instead, which a proof for a leaf further in cannot tell from a
+// real right hand sibling, so a proof for leaf i of a larger tree can claim any tree
+// size whose path has the same shape. Requiring every such sibling to equal the node it
+// pairs with, as a self pair does, rules that out.
+func (m *MerkleTree) provesLastLeaf(c Content, p *Proof) (bool, error) {
+	if m.rfc6962 {
+		return true, nil
+	}
+	cur, err := m.hashLeaf(c)
+	if err != nil {
+		return false, err
+	}
+	j := p.LeafIndex
+	for _, sibling := range p.Siblings {
+		if j%2 == 0 {
+			if !bytes.Equal(sibling, cur) {
+				return false, nil
+			}
+			cur, err = m.hashInterior(cur, sibling)
+		} else {
+			cur, err = m.hashInterior(sibling, cur)
+		}
+		if err != nil {
+			return false, err
+		}
+		j /= 2
+	}
+
+	return true, nil
+}
@@ -0,0 +1,263 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// compareProp orders propContent by its string.
+func compareProp(a, b Content) int {
+	return strings.Compare(a.(propContent).x, b.(propContent).x)
+}
+
+// sortedKeys returns the content of a sorted content tree of n items: the even keys,
+// so that every odd key falls in a gap.
+func sortedKeys(n int) []Content {
+	cs := make([]Content, 0, n)
+	for i := n - 1; i >= 0; i-- {
+		cs = append(cs, propContent{x: fmt.Sprintf("k%04d", 2*i+2)})
+	}
+
+	return cs
+}
+
+// sortedModes are the constructions a sorted content tree can be built with.
+var sortedModes = []propMode{
+	{name: "default"},
+	{name: "rfc6962", rfc6962: true},
+}
+
+// TestSortedContentTreeMatchesSortedBuild checks the tree is the ordinary tree over the
+// content in order, and that cs is left as it was given.
+func TestSortedContentTreeMatchesSortedBuild(t *testing.T) {
+	cs := sortedKeys(9)
+	first := cs[0]
+	tree, err := NewSortedContentTree(cs, compareProp, WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if cs[0] != first {
+		t.Error("error: NewSortedContentTree reordered its argument")
+	}
+
+	ordered := make([]Content, len(cs))
+	for i, c := range cs {
+		ordered[len(cs)-1-i] = c
+	}
+	want, err := NewTreeWithOptions(ordered, WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if string(tree.MerkleRoot()) != string(want.MerkleRoot()) {
+		t.Error("error: root differs from the tree over the sorted content")
+	}
+}
+
+// TestProveAbsence checks every gap of trees of several sizes, before the first leaf,
+// between each pair and after the last, yields a proof that verifies, and that present
+// content is refused.
+func TestProveAbsence(t *testing.T) {
+	for _, mode := range sortedModes {
+		for _, n := range []int{1, 2, 3, 5, 8, 13} {
+			t.Run(fmt.Sprintf("%s/n=%d", mode.name, n), func(t *testing.T) {
+				opts := optsFor(mode, sha256.New)
+				tree, err := NewSortedContentTree(sortedKeys(n), compareProp, opts...)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				root := tree.MerkleRoot()
+
+				for k := 0; k <= 2*n+2; k++ {
+					c := propContent{x: fmt.Sprintf("k%04d", k)}
+					proof, err := tree.ProveAbsence(c)
+					if k%2 == 0 && k > 0 && k <= 2*n {
+						if !errors.Is(err, ErrContentPresent) {
+							t.Errorf("error: key %d: expected ErrContentPresent, got %v", k, err)
+						}
+						continue
+					}
+					if err != nil {
+						t.Fatalf("error: ProveAbsence(%d): %v", k, err)
+					}
+					if (proof.Left == nil) != (k == 1 || k == 0) || (proof.Right == nil) != (k > 2*n) {
+						t.Errorf("error: key %d: unexpected neighbours %v and %v", k, proof.Left, proof.Right)
+					}
+					ok, err := VerifyAbsence(c, proof, root, compareProp, opts...)
+					if err != nil || !ok {
+						t.Errorf("error: key %d: absence proof did not verify (%v)", k, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestVerifyAbsenceRejectsForgeries checks a verifier cannot be shown a gap that is not
+// there: proofs for a present item, for leaves that are not adjacent, or for a leaf
+// claimed to be last in a tree that goes on past it.
+func TestVerifyAbsenceRejectsForgeries(t *testing.T) {
+	for _, mode := range sortedModes {
+		t.Run(mode.name, func(t *testing.T) {
+			opts := optsFor(mode, sha256.New)
+			tree, err := NewSortedContentTree(sortedKeys(8), compareProp, opts...)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			root := tree.MerkleRoot()
+			proofAt := func(i int) *Proof {
+				p, err := tree.GetProofByIndex(i)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				return p
+			}
+			leaf := func(i int) Content { return tree.Leafs[i].C }
+
+			cases := []struct {
+				name  string
+				c     Content
+				proof *AbsenceProof
+			}{
+				{"present item between its neighbours", leaf(3), &AbsenceProof{leaf(2), proofAt(2), leaf(4), proofAt(4)}},
+				{"neighbours not adjacent", propContent{x: "k0005"}, &AbsenceProof{leaf(1), proofAt(1), leaf(3), proofAt(3)}},
+				{"neighbours swapped", propContent{x: "k0005"}, &AbsenceProof{leaf(2), proofAt(2), leaf(1), proofAt(1)}},
+				{"right neighbour is not first", propContent{x: "k0003"}, &AbsenceProof{nil, nil, leaf(1), proofAt(1)}},
+				{"left neighbour is not last", propContent{x: "k0011"}, &AbsenceProof{leaf(4), proofAt(4), nil, nil}},
+				{"wrong neighbour content", propContent{x: "k0005"}, &AbsenceProof{propContent{x: "k0003"}, proofAt(1), leaf(2), proofAt(2)}},
+			}
+
+			// Leaf 4 of 8 has a path of the same shape as the last leaf of a tree of
+			// 5, so its proof with the tree size rewritten still verifies. Claiming the
+			// leaf is last must not.
+			truncated := *proofAt(4)
+			truncated.TreeSize = 5
+			if ok, err := truncated.Verify(leaf(4), root, opts...); err == nil && ok {
+				cases = append(cases, struct {
+					name  string
+					c     Content
+					proof *AbsenceProof
+				}{"left neighbour claims a smaller tree", propContent{x: "k0011"}, &AbsenceProof{leaf(4), &truncated, nil, nil}})
+			} else if !mode.rfc6962 {
+				t.Fatal("error: expected the rewritten tree size to verify under the default construction")
+			}
+
+			for _, tc := range cases {
+				ok, err := VerifyAbsence(tc.c, tc.proof, root, compareProp, opts...)
+				if err != nil {
+					t.Errorf("error: %s: unexpected error: %v", tc.name, err)
+				}
+				if ok {
+					t.Errorf("error: %s: forged absence proof verified", tc.name)
+				}
+			}
+		})
+	}
+}
+
+// TestVerifyAbsenceMalformed covers the proofs and options that are errors rather than
+// a false result.
+func TestVerifyAbsenceMalformed(t *testing.T) {
+	tree, err := NewSortedContentTree(sortedKeys(4), compareProp)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	root := tree.MerkleRoot()
+	c := propContent{x: "k0003"}
+	proof, err := tree.ProveAbsence(c)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	for name, p := range map[string]*AbsenceProof{
+		"nil proof":             nil,
+		"no neighbours":         {},
+		"content without proof": {Left: proof.Left},
+		"proof without content": {Right: nil, RightProof: proof.RightProof},
+	} {
+		if _, err := VerifyAbsence(c, p, root, compareProp); !errors.Is(err, ErrMalformedProof) {
+			t.Errorf("error: %s: expected ErrMalformedProof, got %v", name, err)
+		}
+	}
+	if _, err := VerifyAbsence(c, proof, root, compareProp, WithRFC6962()); !errors.Is(err, ErrConstructionMismatch) {
+		t.Errorf("error: expected ErrConstructionMismatch, got %v", err)
+	}
+	if _, err := VerifyAbsence(c, proof, root, compareProp, WithSortedSiblings()); err == nil {
+		t.Error("error: expected VerifyAbsence to reject WithSortedSiblings")
+	}
+	if _, err := VerifyAbsence(c, proof, root, nil); err == nil {
+		t.Error("error: expected VerifyAbsence to reject a nil comparator")
+	}
+	if _, err := VerifyAbsence(nil, proof, root, compareProp); !errors.Is(err, ErrNilContent) {
+		t.Errorf("error: expected ErrNilContent, got %v", err)
+	}
+}
+
+// TestSortedContentTreeKeepsOrder checks the tree refuses what would break its order
+// and leaves itself untouched when it does, and that ordinary trees do not prove
+// absence at all.
+func TestSortedContentTreeKeepsOrder(t *testing.T) {
+	if _, err := NewSortedContentTree(sortedKeys(3), compareProp, WithSortedSiblings()); err == nil {
+		t.Error("error: expected NewSortedContentTree to reject WithSortedSiblings")
+	}
+	if _, err := NewSortedContentTree(sortedKeys(3), nil); err == nil {
+		t.Error("error: expected NewSortedContentTree to reject a nil comparator")
+	}
+	if _, err := NewSortedContentTree(append(sortedKeys(3), propContent{x: "k0004"}), compareProp); err == nil {
+		t.Error("error: expected NewSortedContentTree to reject duplicate content")
+	}
+	if _, err := NewSortedContentTree([]Content{propContent{x: "a"}, nil}, compareProp); !errors.Is(err, ErrNilContent) {
+		t.Errorf("error: expected ErrNilContent, got %v", err)
+	}
+
+	tree, err := NewSortedContentTree(sortedKeys(5), compareProp)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	root := string(tree.MerkleRoot())
+	for name, err := range map[string]error{
+		"append before the end":   tree.Append(propContent{x: "k0003"}),
+		"append a duplicate":      tree.Append(propContent{x: "k0010"}),
+		"append out of order":     tree.Append(propContent{x: "k0020"}, propContent{x: "k0015"}),
+		"update past a neighbour": tree.UpdateLeaf(1, propContent{x: "k0007"}),
+		"update batch crossing":   tree.UpdateLeaves(map[int]Content{1: propContent{x: "k0005"}, 2: propContent{x: "k0005"}}),
+	} {
+		if err == nil {
+			t.Errorf("error: %s: expected an error", name)
+		}
+	}
+	if string(tree.MerkleRoot()) != root {
+		t.Fatal("error: a refused change modified the tree")
+	}
+
+	if err := tree.Append(propContent{x: "k0011"}, propContent{x: "k0012"}); err != nil {
+		t.Fatalf("error: Append in order: %v", err)
+	}
+	if err := tree.UpdateLeaves(map[int]Content{1: propContent{x: "k0005"}, 2: propContent{x: "k0006"}}); err != nil {
+		t.Fatalf("error: UpdateLeaves in order: %v", err)
+	}
+	if err := tree.RebuildTreeWith(sortedKeys(6)); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	c := propContent{x: "k0007"}
+	proof, err := tree.ProveAbsence(c)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if ok, err := VerifyAbsence(c, proof, tree.MerkleRoot(), compareProp); err != nil || !ok {
+		t.Errorf("error: absence proof after a rebuild did not verify (%v)", err)
+	}
+
+	plain, err := NewTree(sortedKeys(3))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if _, err := plain.ProveAbsence(c); !errors.Is(err, ErrNotSortedContent) {
+		t.Errorf("error: expected ErrNotSortedContent, got %v", err)
+	}
+}
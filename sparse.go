@@ -0,0 +1,529 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"math/bits"
+)
+
+// sparseDepth is the height of every sparse Merkle tree: one level per bit of a key.
+const sparseDepth = 256
+
+// SparseMerkleTree is a Merkle tree over every 256-bit key at once, most of them empty.
+// Where a MerkleTree commits to a sequence of content by position, a SparseMerkleTree
+// commits to a mapping from keys to values, and because every key has a fixed place in
+// it whether or not it holds a value, it can prove that a key is absent as readily as
+// that one is present.
+//
+// The tree is the complete binary tree of height 256 whose leaf at position k holds the
+// value set for key k. Bit 0 of the key, its most significant, chooses the side at the
+// root, bit 1 the side below that, and so on. Hashing is domain separated with the RFC
+// 6962 prefixes:
+//
+//	leaf      H(0x00 || key || value)
+//	interior  H(0x01 || left || right)
+//	empty     zero bytes, the width of the hash
+//
+// Every empty subtree of a given height therefore has the same hash, the default hash
+// for that height, and those are computed once when the tree is created. Only the
+// subtrees holding keys are stored, a subtree holding a single key is stored as that one
+// leaf rather than as a chain of nodes, and an empty sibling is never hashed, so a
+// tree holding n keys costs O(n) memory and an update costs one hash per level of the
+// path plus the levels a lone leaf is carried up through.
+//
+// Keys are fixed width. To key the tree by something else, hash it to 32 bytes first;
+// the tree does no hashing of keys itself.
+//
+// The zero value is not usable; create trees with NewSparseMerkleTree. A
+// SparseMerkleTree is safe for concurrent reads, and writes must not run concurrently
+// with anything else.
+type SparseMerkleTree struct {
+	root  *sparseNode
+	count int
+	// hashStrategyName is the name hashStrategy is registered under, when it is
+	// known, set only by UnmarshalBinary; see the field of the same name on
+	// MerkleTree.
+	hashStrategyName string
+	hashStrategy     func() hash.Hash
+	// defaults[h] is the hash of an empty subtree of height h.
+	defaults [][]byte
+}
+
+// sparseNode is a stored subtree: an interior node with at least two keys beneath it,
+// or a leaf standing for the only key in its subtree, however far above the bottom of
+// the tree that subtree starts. Either way hash is the hash of the whole subtree at the
+// position the node occupies.
+type sparseNode struct {
+	left, right *sparseNode
+	leaf        bool
+	key         [32]byte
+	value       []byte
+	hash        []byte
+}
+
+// NewSparseMerkleTree returns an empty sparse Merkle tree.
+//
+// It takes the same options as NewTreeWithOptions, of which WithHasher is the one that
+// matters: the tree always separates leaf and interior hashes the way WithRFC6962 does,
+// and options that only concern how a MerkleTree is built are accepted and ignored so
+// that an option list can be shared. WithSortedSiblings is rejected, because a sorted
+// pair no longer records which side a key's path went.
+func NewSparseMerkleTree(opts ...TreeOption) (*SparseMerkleTree, error) {
+	cfg, err := sparseConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSparseMerkleTree(cfg.hashStrategy)
+}
+
+// sparseConfig resolves opts for a sparse tree or verifier.
+func sparseConfig(opts []TreeOption) (*MerkleTree, error) {
+	cfg, err := configFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.requireBinary("a sparse Merkle tree"); err != nil {
+		return nil, err
+	}
+	if cfg.sort {
+		return nil, errors.New("error: a sparse Merkle tree cannot use WithSortedSiblings; the order of a pair is what records a key's path")
+	}
+
+	return cfg, nil
+}
+
+// newSparseMerkleTree returns an empty tree hashing with strategy, computing its
+// default hashes.
+func newSparseMerkleTree(strategy func() hash.Hash) (*SparseMerkleTree, error) {
+	h := strategy()
+	defaults := make([][]byte, sparseDepth+1)
+	defaults[0] = make([]byte, h.Size())
+	for i := 1; i <= sparseDepth; i++ {
+		d, err := sparseInterior(h, defaults[i-1], defaults[i-1])
+		if err != nil {
+			return nil, err
+		}
+		defaults[i] = d
+	}
+
+	return &SparseMerkleTree{hashStrategy: strategy, defaults: defaults}, nil
+}
+
+// sparseLeaf returns the hash of the leaf holding value at key.
+func sparseLeaf(h hash.Hash, key *[32]byte, value []byte) ([]byte, error) {
+	h.Reset()
+	if _, err := h.Write(rfc6962LeafPrefixBytes); err != nil {
+		return nil, err
+	}
+	if _, err := h.Write(key[:]); err != nil {
+		return nil, err
+	}
+	if _, err := h.Write(value); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+// sparseInterior returns the hash of the interior node above left and right.
+func sparseInterior(h hash.Hash, left, right []byte) ([]byte, error) {
+	h.Reset()
+	if _, err := h.Write(rfc6962InteriorPrefixBytes); err != nil {
+		return nil, err
+	}
+	if _, err := h.Write(left); err != nil {
+		return nil, err
+	}
+	if _, err := h.Write(right); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+// sparseBit returns bit d of key, counting from the most significant bit of key[0].
+func sparseBit(key *[32]byte, d int) byte {
+	return key[d/8] >> (7 - d%8) & 1
+}
+
+// sparseCommonPrefix returns how many leading bits a and b share.
+func sparseCommonPrefix(a, b *[32]byte) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+
+	return sparseDepth
+}
+
+// fold carries the subtree hash sub of the node at depth from on key's path up to depth
+// to, every sibling on the way being empty.
+func (t *SparseMerkleTree) fold(h hash.Hash, key *[32]byte, sub []byte, from, to int) ([]byte, error) {
+	var err error
+	for d := from - 1; d >= to; d-- {
+		empty := t.defaults[sparseDepth-d-1]
+		if sparseBit(key, d) == 0 {
+			sub, err = sparseInterior(h, sub, empty)
+		} else {
+			sub, err = sparseInterior(h, empty, sub)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sub, nil
+}
+
+// newLeaf returns a leaf node for key and value placed at depth.
+func (t *SparseMerkleTree) newLeaf(h hash.Hash, key *[32]byte, value []byte, depth int) (*sparseNode, error) {
+	leafHash, err := sparseLeaf(h, key, value)
+	if err != nil {
+		return nil, err
+	}
+	subtree, err := t.fold(h, key, leafHash, sparseDepth, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sparseNode{leaf: true, key: *key, value: value, hash: subtree}, nil
+}
+
+// childHash returns the hash of n, or the default hash for a subtree of height when n
+// is nil.
+func (t *SparseMerkleTree) childHash(n *sparseNode, height int) []byte {
+	if n == nil {
+		return t.defaults[height]
+	}
+
+	return n.hash
+}
+
+// rehash recomputes the hash of the interior node n at depth from its children.
+func (t *SparseMerkleTree) rehash(h hash.Hash, n *sparseNode, depth int) error {
+	height := sparseDepth - depth - 1
+	nodeHash, err := sparseInterior(h, t.childHash(n.left, height), t.childHash(n.right, height))
+	if err != nil {
+		return err
+	}
+	n.hash = nodeHash
+
+	return nil
+}
+
+// Len returns the number of keys holding a value.
+func (t *SparseMerkleTree) Len() int {
+	return t.count
+}
+
+// Root returns the root hash. An empty tree has the default hash for the full height,
+// not nil. The slice is the tree's own; treat it as read only.
+func (t *SparseMerkleTree) Root() []byte {
+	return t.childHash(t.root, sparseDepth)
+}
+
+// Get returns the value set for key, and whether one is. The slice is the tree's own;
+// treat it as read only.
+func (t *SparseMerkleTree) Get(key [32]byte) ([]byte, bool) {
+	n := t.root
+	for d := 0; n != nil && !n.leaf; d++ {
+		if sparseBit(&key, d) == 0 {
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	if n == nil || n.key != key {
+		return nil, false
+	}
+
+	return n.value, true
+}
+
+// Set sets the value for key, replacing any value already set, and updates the root.
+// The tree keeps its own copy of value. A nil value is rejected, since it would read as
+// an absent key; an empty one is a value like any other, and Delete removes a key.
+//
+// Nodes on the path are given fresh hash slices, so a root returned before the call
+// keeps the value it had.
+func (t *SparseMerkleTree) Set(key [32]byte, value []byte) error {
+	if value == nil {
+		return fmt.Errorf("%w: use Delete to remove a key", ErrNilContent)
+	}
+	root, added, err := t.set(t.hashStrategy(), t.root, &key, bytes.Clone(value), 0)
+	if err != nil {
+		return err
+	}
+	t.root = root
+	if added {
+		t.count++
+	}
+
+	return nil
+}
+
+// set stores value at key in the subtree n at depth, returning the subtree that
+// replaces n and whether key is new to it.
+func (t *SparseMerkleTree) set(h hash.Hash, n *sparseNode, key *[32]byte, value []byte, depth int) (*sparseNode, bool, error) {
+	if n == nil {
+		leaf, err := t.newLeaf(h, key, value, depth)
+		return leaf, true, err
+	}
+	if n.leaf {
+		if n.key == *key {
+			leaf, err := t.newLeaf(h, key, value, depth)
+			return leaf, false, err
+		}
+		split, err := t.split(h, n, key, value, depth)
+		return split, true, err
+	}
+
+	next := &sparseNode{left: n.left, right: n.right}
+	var (
+		added bool
+		err   error
+	)
+	if sparseBit(key, depth) == 0 {
+		next.left, added, err = t.set(h, n.left, key, value, depth+1)
+	} else {
+		next.right, added, err = t.set(h, n.right, key, value, depth+1)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return next, added, t.rehash(h, next, depth)
+}
+
+// split replaces the lone leaf n at depth with the subtree holding both it and a new
+// leaf for key: a chain of interior nodes down to the first bit the two keys differ
+// in, and the two leaves beneath it.
+func (t *SparseMerkleTree) split(h hash.Hash, n *sparseNode, key *[32]byte, value []byte, depth int) (*sparseNode, error) {
+	p := sparseCommonPrefix(&n.key, key)
+
+	existing, err := t.newLeaf(h, &n.key, n.value, p+1)
+	if err != nil {
+		return nil, err
+	}
+	added, err := t.newLeaf(h, key, value, p+1)
+	if err != nil {
+		return nil, err
+	}
+	fork := &sparseNode{left: existing, right: added}
+	if sparseBit(key, p) == 0 {
+		fork.left, fork.right = added, existing
+	}
+	if err := t.rehash(h, fork, p); err != nil {
+		return nil, err
+	}
+
+	for d := p - 1; d >= depth; d-- {
+		parent := &sparseNode{left: fork}
+		if sparseBit(key, d) == 1 {
+			parent.left, parent.right = nil, fork
+		}
+		if err := t.rehash(h, parent, d); err != nil {
+			return nil, err
+		}
+		fork = parent
+	}
+
+	return fork, nil
+}
+
+// Delete removes key and its value, and updates the root. It reports whether the key
+// held a value; deleting an absent key changes nothing.
+//
+// The tree is left exactly as though the key had never been set, root included, and
+// as with Set a root returned before the call keeps the value it had.
+func (t *SparseMerkleTree) Delete(key [32]byte) (bool, error) {
+	root, removed, err := t.delete(t.hashStrategy(), t.root, &key, 0)
+	if err != nil || !removed {
+		return false, err
+	}
+	t.root = root
+	t.count--
+
+	return true, nil
+}
+
+// delete removes key from the subtree n at depth, returning the subtree that replaces
+// n and whether key was there.
+func (t *SparseMerkleTree) delete(h hash.Hash, n *sparseNode, key *[32]byte, depth int) (*sparseNode, bool, error) {
+	if n == nil {
+		return nil, false, nil
+	}
+	if n.leaf {
+		if n.key != *key {
+			return n, false, nil
+		}
+
+		return nil, true, nil
+	}
+
+	next := &sparseNode{left: n.left, right: n.right}
+	var (
+		removed bool
+		err     error
+	)
+	if sparseBit(key, depth) == 0 {
+		next.left, removed, err = t.delete(h, n.left, key, depth+1)
+	} else {
+		next.right, removed, err = t.delete(h, n.right, key, depth+1)
+	}
+	if err != nil || !removed {
+		return n, false, err
+	}
+
+	// A subtree left holding a single key is stored as that key's leaf, so a leaf
+	// with no sibling moves up to take its parent's place.
+	if lone := next.left; next.right == nil && lone != nil && lone.leaf {
+		leaf, err := t.newLeaf(h, &lone.key, lone.value, depth)
+		return leaf, true, err
+	}
+	if lone := next.right; next.left == nil && lone != nil && lone.leaf {
+		leaf, err := t.newLeaf(h, &lone.key, lone.value, depth)
+		return leaf, true, err
+	}
+
+	return next, true, t.rehash(h, next, depth)
+}
+
+// SparseProof is a compressed audit path through a SparseMerkleTree, proving either that
+// a key holds a given value or that it holds none. The two are the same proof: that a
+// key is absent is proven by showing its leaf is empty.
+//
+// Most siblings on a path are empty subtrees whose hashes the verifier can compute for
+// itself, so only the others are sent. Bit d of Bitmap, counting from the most
+// significant bit of Bitmap[0] as key bits are counted, is set when the sibling where
+// the path takes bit d of the key is not empty, and Siblings holds those siblings from
+// the bottom of the tree up.
+type SparseProof struct {
+	Bitmap   [32]byte
+	Siblings [][]byte
+}
+
+// Prove returns the proof for key: of inclusion, to be checked with
+// VerifySparseInclusion, when the key holds a value, and of non-inclusion, to be checked
+// with VerifySparseNonInclusion, when it holds none.
+//
+// The siblings are the tree's own hashes where the tree stores them; treat them as read
+// only.
+func (t *SparseMerkleTree) Prove(key [32]byte) (*SparseProof, error) {
+	var (
+		siblings [sparseDepth][]byte
+		n        = t.root
+		depth    = 0
+	)
+	for ; n != nil && !n.leaf; depth++ {
+		sibling := n.left
+		if sparseBit(&key, depth) == 0 {
+			sibling, n = n.right, n.left
+		} else {
+			n = n.right
+		}
+		if sibling != nil {
+			siblings[depth] = sibling.hash
+		}
+	}
+	// A different key's leaf standing for its whole subtree is the one non-empty
+	// sibling below this point, found where the two keys part.
+	if n != nil && n.key != key {
+		p := sparseCommonPrefix(&n.key, &key)
+		other, err := t.newLeaf(t.hashStrategy(), &n.key, n.value, p+1)
+		if err != nil {
+			return nil, err
+		}
+		siblings[p] = other.hash
+	}
+
+	proof := &SparseProof{}
+	for d := sparseDepth - 1; d >= 0; d-- {
+		if siblings[d] != nil {
+			proof.Bitmap[d/8] |= 1 << (7 - d%8)
+			proof.Siblings = append(proof.Siblings, siblings[d])
+		}
+	}
+
+	return proof, nil
+}
+
+// VerifySparseInclusion reports whether proof shows key holding value in the sparse
+// Merkle tree whose root is root. Like VerifyProof it needs no tree, and the options
+// describe the tree the proof came from as they do for NewSparseMerkleTree.
+//
+// Returns false when the proof is the right shape and does not reproduce root, and an
+// error wrapping ErrMalformedProof when the bitmap and the sibling count disagree.
+func VerifySparseInclusion(key [32]byte, value []byte, proof *SparseProof, root []byte, opts ...TreeOption) (bool, error) {
+	if value == nil {
+		return false, fmt.Errorf("%w: use VerifySparseNonInclusion to check that a key is absent", ErrNilContent)
+	}
+
+	return verifySparse(&key, value, proof, root, opts)
+}
+
+// VerifySparseNonInclusion reports whether proof shows key holding no value in the sparse
+// Merkle tree whose root is root. It is VerifySparseInclusion for the empty leaf, and
+// the notes there apply.
+func VerifySparseNonInclusion(key [32]byte, proof *SparseProof, root []byte, opts ...TreeOption) (bool, error) {
+	return verifySparse(&key, nil, proof, root, opts)
+}
+
+// verifySparse replays proof from key's leaf, which holds value or is empty when value
+// is nil, and reports whether it arrives at root.
+func verifySparse(key *[32]byte, value []byte, proof *SparseProof, root []byte, opts []TreeOption) (bool, error) {
+	if proof == nil {
+		return false, fmt.Errorf("%w: no proof", ErrMalformedProof)
+	}
+	cfg, err := sparseConfig(opts)
+	if err != nil {
+		return false, err
+	}
+	set := 0
+	for _, b := range proof.Bitmap {
+		set += bits.OnesCount8(b)
+	}
+	if set != len(proof.Siblings) {
+		return false, fmt.Errorf("%w: bitmap marks %d siblings, the proof has %d", ErrMalformedProof, set, len(proof.Siblings))
+	}
+
+	// The verifier needs the default hashes as much as the tree does, and computing
+	// them is most of what creating an empty tree costs.
+	t, err := newSparseMerkleTree(cfg.hashStrategy)
+	if err != nil {
+		return false, err
+	}
+	h := t.hashStrategy()
+
+	cur := t.defaults[0]
+	if value != nil {
+		if cur, err = sparseLeaf(h, key, value); err != nil {
+			return false, err
+		}
+	}
+	next := 0
+	for d := sparseDepth - 1; d >= 0; d-- {
+		sibling := t.defaults[sparseDepth-d-1]
+		if sparseBit(&proof.Bitmap, d) == 1 {
+			sibling = proof.Siblings[next]
+			next++
+		}
+		if sparseBit(key, d) == 0 {
+			cur, err = sparseInterior(h, cur, sibling)
+		} else {
+			cur, err = sparseInterior(h, sibling, cur)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return bytes.Equal(cur, root), nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// A serialized sparse tree records what it is rebuilt from, in the same way and for the
+// same reasons as a serialized MerkleTree: the hash strategy by name, every key and its
+// value, and the root the encoder observed, which the decoder checks its rebuild against.
+const (
+	// sparseSerializationMagic prefixes every binary sparse tree.
+	sparseSerializationMagic = "MSPARSE"
+	// sparseSerializationVersion is the sparse tree wire format version written by
+	// this package.
+	sparseSerializationVersion = 1
+)
+
+// MarshalBinary encodes the tree, implementing encoding.BinaryMarshaler:
+//
+//	magic       "MSPARSE"
+//	version     uvarint
+//	strategy    uvarint length + bytes
+//	root        uvarint length + bytes
+//	count       uvarint
+//	  key       32 bytes                 (repeated count times, ascending)
+//	  value     uvarint length + bytes
+//
+// The entries are written in key order, so encoding the same mapping always produces
+// identical bytes however it was built. The hash strategy must have been registered with
+// RegisterHashStrategy, and the returned error wraps ErrNoHashStrategy if it was not.
+func (t SparseMerkleTree) MarshalBinary() ([]byte, error) {
+	name := t.hashStrategyName
+	if name == "" {
+		var ok bool
+		if name, ok = lookupHashStrategyName(t.hashStrategy); !ok {
+			return nil, fmt.Errorf("%w: the tree's hash strategy has no registered name; call merkletree.RegisterHashStrategy for it", ErrNoHashStrategy)
+		}
+	}
+	root := t.Root()
+
+	var leaves []*sparseNode
+	var walk func(n *sparseNode)
+	walk = func(n *sparseNode) {
+		switch {
+		case n == nil:
+		case n.leaf:
+			leaves = append(leaves, n)
+		default:
+			walk(n.left)
+			walk(n.right)
+		}
+	}
+	walk(t.root)
+
+	size := len(sparseSerializationMagic) +
+		uvarintLen(sparseSerializationVersion) +
+		uvarintLen(uint64(len(name))) + len(name) +
+		uvarintLen(uint64(len(root))) + len(root) +
+		uvarintLen(uint64(len(leaves)))
+	for _, l := range leaves {
+		size += len(l.key) + uvarintLen(uint64(len(l.value))) + len(l.value)
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(size)
+	buf.WriteString(sparseSerializationMagic)
+	writeUvarint(&buf, sparseSerializationVersion)
+	writeBytes(&buf, []byte(name))
+	writeBytes(&buf, root)
+	writeUvarint(&buf, uint64(len(leaves)))
+	for _, l := range leaves {
+		buf.Write(l.key[:])
+		writeBytes(&buf, l.value)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary rebuilds the tree from a payload written by MarshalBinary,
+// implementing encoding.BinaryUnmarshaler. The hash strategy is resolved by name through
+// the package registry, and the rebuilt root is checked against the recorded one, so a
+// tampered payload or one written under a different strategy fails with ErrRootMismatch.
+// Malformed input, including keys out of order, wraps ErrCorruptData. The receiver is
+// left untouched if decoding fails for any reason.
+func (t *SparseMerkleTree) UnmarshalBinary(data []byte) error {
+	if len(data) < len(sparseSerializationMagic) || string(data[:len(sparseSerializationMagic)]) != sparseSerializationMagic {
+		return fmt.Errorf("%w: missing %q header", ErrCorruptData, sparseSerializationMagic)
+	}
+	r := &binaryReader{data: data[len(sparseSerializationMagic):]}
+
+	version, err := r.uvarint()
+	if err != nil {
+		return fmt.Errorf("%w: reading version: %w", ErrCorruptData, err)
+	}
+	if version != sparseSerializationVersion {
+		return fmt.Errorf("%w: got sparse tree version %d, this build writes and reads %d", ErrUnsupportedVersion, version, sparseSerializationVersion)
+	}
+
+	name, err := r.view()
+	if err != nil {
+		return fmt.Errorf("%w: reading hash strategy: %w", ErrCorruptData, err)
+	}
+	root, err := r.view()
+	if err != nil {
+		return fmt.Errorf("%w: reading root: %w", ErrCorruptData, err)
+	}
+	count, err := r.uvarint()
+	if err != nil {
+		return fmt.Errorf("%w: reading entry count: %w", ErrCorruptData, err)
+	}
+	// Each entry costs at least its key and a length byte, which bounds the count
+	// before anything is built from it.
+	if count > uint64(r.remaining()/(len(sparseNode{}.key)+1)) {
+		return fmt.Errorf("%w: entry count %d exceeds what the %d bytes remaining can hold", ErrCorruptData, count, r.remaining())
+	}
+
+	strategy, ok := lookupHashStrategy(string(name))
+	if !ok {
+		return fmt.Errorf("%w: %q; call merkletree.RegisterHashStrategy for it before unmarshaling", ErrNoHashStrategy, name)
+	}
+	decoded, err := newSparseMerkleTree(strategy)
+	if err != nil {
+		return err
+	}
+	decoded.hashStrategyName = string(name)
+
+	var prev [32]byte
+	for i := uint64(0); i < count; i++ {
+		if r.remaining() < len(prev) {
+			return fmt.Errorf("%w: reading key %d: %w", ErrCorruptData, i, errors.New("unexpected end of data"))
+		}
+		var key [32]byte
+		copy(key[:], r.data[r.off:])
+		r.off += len(key)
+		// Requiring ascending keys makes the encoding canonical: a payload cannot
+		// be reordered into different bytes that decode to the same tree.
+		if i > 0 && bytes.Compare(key[:], prev[:]) <= 0 {
+			return fmt.Errorf("%w: key %d is not greater than the key before it", ErrCorruptData, i)
+		}
+		prev = key
+
+		value, err := r.view()
+		if err != nil {
+			return fmt.Errorf("%w: reading value %d: %w", ErrCorruptData, i, err)
+		}
+		if err := decoded.Set(key, value); err != nil {
+			return err
+		}
+	}
+	if r.remaining() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes after the last entry", ErrCorruptData, r.remaining())
+	}
+	if !bytes.Equal(decoded.Root(), root) {
+		return fmt.Errorf("%w: rebuilt %x, encoded %x", ErrRootMismatch, decoded.Root(), root)
+	}
+
+	*t = *decoded
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"testing"
+)
+
+// TestSparseMerkleTreeSerialization checks a tree survives the round trip, that the
+// encoding is canonical, and that bad payloads fail with the documented errors and
+// leave the receiver alone.
+func TestSparseMerkleTreeSerialization(t *testing.T) {
+	build := func(order []int) *SparseMerkleTree {
+		tree, err := NewSparseMerkleTree(WithHasher(sha512.New))
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		for _, i := range order {
+			if err := tree.Set(sparseKey(i), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+		}
+		return tree
+	}
+	tree := build([]int{0, 1, 2, 3, 4})
+	data, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	again, err := build([]int{4, 2, 0, 3, 1}).MarshalBinary()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Error("error: the same mapping built in another order encoded differently")
+	}
+
+	var decoded SparseMerkleTree
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(decoded.Root(), tree.Root()) || decoded.Len() != tree.Len() {
+		t.Fatal("error: decoded tree differs from the original")
+	}
+	for i := 0; i < 5; i++ {
+		if got, ok := decoded.Get(sparseKey(i)); !ok || string(got) != fmt.Sprintf("value-%d", i) {
+			t.Errorf("error: decoded tree returned %q, %v for key %d", got, ok, i)
+		}
+	}
+	if err := decoded.Set(sparseKey(9), []byte("more")); err != nil {
+		t.Errorf("error: decoded tree cannot be written to: %v", err)
+	}
+
+	header := len(sparseSerializationMagic)
+	edit := func(f func(b []byte) []byte) []byte {
+		return f(bytes.Clone(data))
+	}
+	// The entries start after the version, the "sha512" name and the 64 byte root,
+	// each length prefixed, and the count.
+	entries := header + 1 + 1 + len("sha512") + 1 + sha512.Size + 1
+	cases := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrCorruptData},
+		{"tree payload", []byte(serializationMagic + "\x02"), ErrCorruptData},
+		{"newer version", edit(func(b []byte) []byte { b[header] = 2; return b }), ErrUnsupportedVersion},
+		{"unknown strategy", edit(func(b []byte) []byte { b[header+2] = 'x'; return b }), ErrNoHashStrategy},
+		{"changed value", edit(func(b []byte) []byte { b[len(b)-1] ^= 1; return b }), ErrRootMismatch},
+		{"keys out of order", edit(func(b []byte) []byte {
+			first := bytes.Clone(b[entries : entries+40])
+			copy(b[entries:], b[entries+40:entries+80])
+			copy(b[entries+40:], first)
+			return b
+		}), ErrCorruptData},
+		{"count too large", edit(func(b []byte) []byte { b[entries-1] = 0x7f; return b }), ErrCorruptData},
+		{"trailing byte", append(bytes.Clone(data), 0), ErrCorruptData},
+	}
+	for n := header; n < len(data); n++ {
+		cases = append(cases, struct {
+			name string
+			data []byte
+			want error
+		}{fmt.Sprintf("truncated to %d", n), data[:n], ErrCorruptData})
+	}
+
+	for _, tc := range cases {
+		got := build([]int{7})
+		want := bytes.Clone(got.Root())
+		if err := got.UnmarshalBinary(tc.data); !errors.Is(err, tc.want) {
+			t.Errorf("error: %s: expected %v, got %v", tc.name, tc.want, err)
+		}
+		if !bytes.Equal(got.Root(), want) || got.Len() != 1 {
+			t.Errorf("error: %s: receiver was modified by a failed decode", tc.name)
+		}
+	}
+
+	unregistered, err := NewSparseMerkleTree(WithHasher(func() hash.Hash { return sha256.New() }))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if _, err := unregistered.MarshalBinary(); !errors.Is(err, ErrNoHashStrategy) {
+		t.Errorf("error: expected ErrNoHashStrategy for an unregistered strategy, got %v", err)
+	}
+}
@@ -0,0 +1,365 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"math/rand"
+	"testing"
+)
+
+// sparseKey returns the key used for entry i of the test trees: a hashed name, as a
+// caller keying the tree by something other than 32 bytes would produce.
+func sparseKey(i int) [32]byte {
+	return sha256.Sum256([]byte(fmt.Sprintf("key-%d", i)))
+}
+
+// sparseReferenceRoot computes the root of the sparse tree holding entries the slow
+// way, descending all 256 levels of every populated path and splitting the entries by
+// key bit at each, so it shares none of the compression it is checked against.
+func sparseReferenceRoot(tb testing.TB, hs func() hash.Hash, entries map[[32]byte][]byte) []byte {
+	tb.Helper()
+
+	empty, err := newSparseMerkleTree(hs)
+	if err != nil {
+		tb.Fatalf("error: unexpected error: %v", err)
+	}
+	h := hs()
+
+	var subtree func(keys [][32]byte, depth int) []byte
+	subtree = func(keys [][32]byte, depth int) []byte {
+		if len(keys) == 0 {
+			return empty.defaults[sparseDepth-depth]
+		}
+		if depth == sparseDepth {
+			leaf, err := sparseLeaf(h, &keys[0], entries[keys[0]])
+			if err != nil {
+				tb.Fatalf("error: unexpected error: %v", err)
+			}
+			return leaf
+		}
+		var left, right [][32]byte
+		for _, k := range keys {
+			if sparseBit(&k, depth) == 0 {
+				left = append(left, k)
+			} else {
+				right = append(right, k)
+			}
+		}
+		node, err := sparseInterior(h, subtree(left, depth+1), subtree(right, depth+1))
+		if err != nil {
+			tb.Fatalf("error: unexpected error: %v", err)
+		}
+		return node
+	}
+
+	keys := make([][32]byte, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	return subtree(keys, 0)
+}
+
+// sparseNeighbours returns keys that share all but the last bit, or all but the first,
+// which put the fork at the bottom and the top of the tree.
+func sparseNeighbours() [][32]byte {
+	var low, high, top [32]byte
+	high[31] = 1
+	top[0] = 0x80
+	return [][32]byte{low, high, top}
+}
+
+// TestSparseMerkleTreeMatchesReference checks the stored, compressed tree has the root
+// the uncompressed definition gives, as keys are set, replaced and deleted.
+func TestSparseMerkleTreeMatchesReference(t *testing.T) {
+	for _, s := range []struct {
+		name string
+		fn   func() hash.Hash
+	}{{"sha256", sha256.New}, {"sha512", sha512.New}} {
+		t.Run(s.name, func(t *testing.T) {
+			tree, err := NewSparseMerkleTree(WithHasher(s.fn))
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			entries := map[[32]byte][]byte{}
+			check := func(step string) {
+				t.Helper()
+				if want := sparseReferenceRoot(t, s.fn, entries); !bytes.Equal(tree.Root(), want) {
+					t.Fatalf("error: %s: root %x, reference %x", step, tree.Root(), want)
+				}
+				if tree.Len() != len(entries) {
+					t.Fatalf("error: %s: Len is %d, want %d", step, tree.Len(), len(entries))
+				}
+			}
+			check("empty")
+
+			keys := append(sparseNeighbours(), sparseKey(0), sparseKey(1), sparseKey(2), sparseKey(3))
+			for i, k := range keys {
+				v := []byte(fmt.Sprintf("value-%d", i))
+				if err := tree.Set(k, v); err != nil {
+					t.Fatalf("error: Set(%d): %v", i, err)
+				}
+				entries[k] = v
+				check(fmt.Sprintf("set %d", i))
+			}
+
+			if err := tree.Set(keys[1], []byte{}); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			entries[keys[1]] = []byte{}
+			check("replace with empty value")
+
+			for i, k := range keys {
+				removed, err := tree.Delete(k)
+				if err != nil {
+					t.Fatalf("error: Delete(%d): %v", i, err)
+				}
+				if !removed {
+					t.Fatalf("error: Delete(%d) reported the key absent", i)
+				}
+				delete(entries, k)
+				check(fmt.Sprintf("delete %d", i))
+			}
+		})
+	}
+}
+
+// TestSparseMerkleTreeOrderIndependence checks the root depends only on the mapping,
+// not on the order it was built in, and that deleting a key restores the root the tree
+// had before it was set.
+func TestSparseMerkleTreeOrderIndependence(t *testing.T) {
+	const n = 64
+	rng := rand.New(rand.NewSource(7))
+
+	var want []byte
+	for round := 0; round < 5; round++ {
+		tree, err := NewSparseMerkleTree()
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		for _, i := range rng.Perm(n) {
+			if err := tree.Set(sparseKey(i), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+		}
+		if round == 0 {
+			want = bytes.Clone(tree.Root())
+		} else if !bytes.Equal(tree.Root(), want) {
+			t.Fatalf("error: round %d: root %x, want %x", round, tree.Root(), want)
+		}
+
+		before := tree.Root()
+		extra := sparseKey(n + round)
+		if err := tree.Set(extra, []byte("extra")); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if bytes.Equal(tree.Root(), before) {
+			t.Fatal("error: setting a new key did not change the root")
+		}
+		if _, err := tree.Delete(extra); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if !bytes.Equal(tree.Root(), before) {
+			t.Fatalf("error: round %d: deleting the key gave %x, want %x", round, tree.Root(), before)
+		}
+	}
+}
+
+// TestSparseMerkleTreeGetSetDelete covers the map behaviour, including the edge cases
+// of each method.
+func TestSparseMerkleTreeGetSetDelete(t *testing.T) {
+	tree, err := NewSparseMerkleTree()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	emptyRoot := bytes.Clone(tree.Root())
+
+	key := sparseKey(0)
+	if _, ok := tree.Get(key); ok {
+		t.Error("error: an empty tree reported a value")
+	}
+	if removed, err := tree.Delete(key); err != nil || removed {
+		t.Errorf("error: Delete on an empty tree returned %v, %v", removed, err)
+	}
+	if err := tree.Set(key, nil); !errors.Is(err, ErrNilContent) {
+		t.Errorf("error: Set with a nil value returned %v, want ErrNilContent", err)
+	}
+
+	value := []byte("value")
+	if err := tree.Set(key, value); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	value[0] = 'X'
+	if got, ok := tree.Get(key); !ok || string(got) != "value" {
+		t.Errorf("error: Get returned %q, %v; the tree should keep its own copy", got, ok)
+	}
+	if _, ok := tree.Get(sparseKey(1)); ok {
+		t.Error("error: Get found a key that was never set")
+	}
+
+	root := tree.Root()
+	if err := tree.Set(sparseKey(1), []byte("other")); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := tree.Set(key, []byte("changed")); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if tree.Len() != 2 {
+		t.Errorf("error: Len is %d after replacing a value, want 2", tree.Len())
+	}
+	if got := sparseReferenceRoot(t, sha256.New, map[[32]byte][]byte{key: []byte("value")}); !bytes.Equal(root, got) {
+		t.Error("error: a root returned before later writes changed")
+	}
+
+	for _, k := range [][32]byte{key, sparseKey(1)} {
+		if removed, err := tree.Delete(k); err != nil || !removed {
+			t.Fatalf("error: Delete returned %v, %v", removed, err)
+		}
+	}
+	if !bytes.Equal(tree.Root(), emptyRoot) || tree.Len() != 0 {
+		t.Error("error: deleting every key did not restore the empty tree")
+	}
+}
+
+// TestSparseMerkleTreeRejectsSortedSiblings checks the one construction option that
+// cannot describe a sparse tree is refused, by the tree and by its verifiers alike.
+func TestSparseMerkleTreeRejectsSortedSiblings(t *testing.T) {
+	if _, err := NewSparseMerkleTree(WithSortedSiblings()); err == nil {
+		t.Error("error: expected NewSparseMerkleTree to reject WithSortedSiblings")
+	}
+	if _, err := NewSparseMerkleTree(WithHasher(nil)); err == nil {
+		t.Error("error: expected NewSparseMerkleTree to reject a nil hasher")
+	}
+	if _, err := VerifySparseNonInclusion(sparseKey(0), &SparseProof{}, nil, WithSortedSiblings()); err == nil {
+		t.Error("error: expected VerifySparseNonInclusion to reject WithSortedSiblings")
+	}
+}
+
+// TestSparseProofs checks every key present has an inclusion proof and every key absent
+// a non-inclusion proof, that neither passes for the other, and that each is compressed
+// to the siblings the tree actually stores.
+func TestSparseProofs(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 17} {
+		t.Run(fmt.Sprintf("n=%d", n), func(t *testing.T) {
+			tree, err := NewSparseMerkleTree()
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			var present [][32]byte
+			if n <= len(sparseNeighbours()) {
+				present = sparseNeighbours()[:n]
+			} else {
+				for i := 0; i < n; i++ {
+					present = append(present, sparseKey(i))
+				}
+			}
+			for i, k := range present {
+				if err := tree.Set(k, []byte(fmt.Sprintf("value-%d", i))); err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+			}
+			root := tree.Root()
+
+			for i, k := range present {
+				value, _ := tree.Get(k)
+				proof, err := tree.Prove(k)
+				if err != nil {
+					t.Fatalf("error: Prove(%d): %v", i, err)
+				}
+				if len(proof.Siblings) >= n {
+					t.Errorf("error: key %d: proof carries %d siblings for %d keys", i, len(proof.Siblings), n)
+				}
+				if ok, err := VerifySparseInclusion(k, value, proof, root); err != nil || !ok {
+					t.Errorf("error: key %d: inclusion proof did not verify (%v)", i, err)
+				}
+				if ok, _ := VerifySparseInclusion(k, []byte("wrong"), proof, root); ok {
+					t.Errorf("error: key %d: inclusion proof verified a different value", i)
+				}
+				if ok, _ := VerifySparseNonInclusion(k, proof, root); ok {
+					t.Errorf("error: key %d: inclusion proof passed as non-inclusion", i)
+				}
+			}
+
+			// Absent keys: ones that were never near the tree, and ones sharing all
+			// but their last bit with a key that is present.
+			absent := [][32]byte{sparseKey(1000), sparseKey(1001)}
+			for _, k := range present {
+				k[31] ^= 1
+				if _, ok := tree.Get(k); !ok {
+					absent = append(absent, k)
+				}
+			}
+			for i, k := range absent {
+				proof, err := tree.Prove(k)
+				if err != nil {
+					t.Fatalf("error: Prove(absent %d): %v", i, err)
+				}
+				if ok, err := VerifySparseNonInclusion(k, proof, root); err != nil || !ok {
+					t.Errorf("error: absent key %d: non-inclusion proof did not verify (%v)", i, err)
+				}
+				if ok, _ := VerifySparseInclusion(k, []byte("value-0"), proof, root); ok {
+					t.Errorf("error: absent key %d: non-inclusion proof passed as inclusion", i)
+				}
+			}
+		})
+	}
+}
+
+// TestSparseProofRejectsTampering checks the proof binds the key, the root and the
+// siblings, and that a proof whose bitmap disagrees with its siblings is reported as
+// malformed rather than simply failing.
+func TestSparseProofRejectsTampering(t *testing.T) {
+	tree, err := NewSparseMerkleTree()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for i := 0; i < 8; i++ {
+		if err := tree.Set(sparseKey(i), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+	}
+	root := tree.Root()
+	key := sparseKey(3)
+	value, _ := tree.Get(key)
+	proof, err := tree.Prove(key)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	if ok, _ := VerifySparseInclusion(sparseKey(4), value, proof, root); ok {
+		t.Error("error: proof verified for a different key")
+	}
+	other := sparseKey(99)
+	if ok, _ := VerifySparseInclusion(key, value, proof, other[:]); ok {
+		t.Error("error: proof verified against a different root")
+	}
+	if ok, _ := VerifySparseInclusion(key, value, proof, root, WithHasher(sha512.New)); ok {
+		t.Error("error: proof verified under a different hash strategy")
+	}
+
+	tampered := &SparseProof{Bitmap: proof.Bitmap, Siblings: make([][]byte, len(proof.Siblings))}
+	for i, s := range proof.Siblings {
+		tampered.Siblings[i] = bytes.Clone(s)
+	}
+	tampered.Siblings[0][0] ^= 1
+	if ok, _ := VerifySparseInclusion(key, value, tampered, root); ok {
+		t.Error("error: proof verified with a changed sibling")
+	}
+
+	short := &SparseProof{Bitmap: proof.Bitmap, Siblings: proof.Siblings[1:]}
+	if _, err := VerifySparseInclusion(key, value, short, root); !errors.Is(err, ErrMalformedProof) {
+		t.Errorf("error: expected ErrMalformedProof for a missing sibling, got %v", err)
+	}
+	if _, err := VerifySparseInclusion(key, value, nil, root); !errors.Is(err, ErrMalformedProof) {
+		t.Errorf("error: expected ErrMalformedProof for a nil proof, got %v", err)
+	}
+	if _, err := VerifySparseInclusion(key, nil, proof, root); !errors.Is(err, ErrNilContent) {
+		t.Errorf("error: expected ErrNilContent for a nil value, got %v", err)
+	}
+}
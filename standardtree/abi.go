@@ -0,0 +1,358 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package standardtree
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// The leaves of a StandardMerkleTree are Solidity ABI encoded, as abi.encode encodes its
+// arguments, so that a contract can rebuild a leaf from calldata. This file implements
+// as much of the ABI specification as leaf encodings use: the elementary types, bytes,
+// string, and arrays of any of them. Tuples are not supported.
+//
+// https://docs.soliditylang.org/en/latest/abi-spec.html
+
+type abiKind int
+
+const (
+	abiAddress abiKind = iota
+	abiBool
+	abiUint
+	abiInt
+	abiFixedBytes
+	abiBytes
+	abiString
+	abiSlice
+	abiArray
+)
+
+// abiType is a parsed ABI type. size is the width in bits of an integer, in bytes of a
+// bytesN, or the length of a fixed size array.
+type abiType struct {
+	kind abiKind
+	size int
+	elem *abiType
+}
+
+// parseTypes parses a leaf encoding.
+func parseTypes(encoding []string) ([]*abiType, error) {
+	if len(encoding) == 0 {
+		return nil, fmt.Errorf("standardtree: leaf encoding has no types")
+	}
+	types := make([]*abiType, len(encoding))
+	for i, s := range encoding {
+		t, err := parseType(s)
+		if err != nil {
+			return nil, err
+		}
+		types[i] = t
+	}
+
+	return types, nil
+}
+
+func parseType(s string) (*abiType, error) {
+	if i := strings.LastIndexByte(s, '['); i >= 0 && strings.HasSuffix(s, "]") {
+		elem, err := parseType(s[:i])
+		if err != nil {
+			return nil, err
+		}
+		n := s[i+1 : len(s)-1]
+		if n == "" {
+			return &abiType{kind: abiSlice, elem: elem}, nil
+		}
+		length, err := strconv.Atoi(n)
+		if err != nil || length < 1 {
+			return nil, fmt.Errorf("standardtree: invalid array length in type %q", s)
+		}
+
+		return &abiType{kind: abiArray, size: length, elem: elem}, nil
+	}
+
+	switch {
+	case s == "address":
+		return &abiType{kind: abiAddress}, nil
+	case s == "bool":
+		return &abiType{kind: abiBool}, nil
+	case s == "bytes":
+		return &abiType{kind: abiBytes}, nil
+	case s == "string":
+		return &abiType{kind: abiString}, nil
+	case s == "uint" || s == "int":
+		return parseType(s + "256")
+	case strings.HasPrefix(s, "uint"), strings.HasPrefix(s, "int"):
+		kind, digits := abiUint, strings.TrimPrefix(s, "uint")
+		if !strings.HasPrefix(s, "uint") {
+			kind, digits = abiInt, strings.TrimPrefix(s, "int")
+		}
+		bits, err := strconv.Atoi(digits)
+		if err != nil || bits < 8 || bits > 256 || bits%8 != 0 || digits[0] == '0' {
+			return nil, fmt.Errorf("standardtree: invalid integer type %q", s)
+		}
+
+		return &abiType{kind: kind, size: bits}, nil
+	case strings.HasPrefix(s, "bytes"):
+		n, err := strconv.Atoi(strings.TrimPrefix(s, "bytes"))
+		if err != nil || n < 1 || n > 32 || s[5] == '0' {
+			return nil, fmt.Errorf("standardtree: invalid fixed bytes type %q", s)
+		}
+
+		return &abiType{kind: abiFixedBytes, size: n}, nil
+	}
+
+	return nil, fmt.Errorf("standardtree: unsupported type %q", s)
+}
+
+// dynamic reports whether values of t are encoded out of line, behind an offset.
+func (t *abiType) dynamic() bool {
+	switch t.kind {
+	case abiBytes, abiString, abiSlice:
+		return true
+	case abiArray:
+		return t.elem.dynamic()
+	}
+
+	return false
+}
+
+// headSize returns the number of bytes t occupies in the head of a tuple.
+func (t *abiType) headSize() int {
+	if t.kind == abiArray && !t.dynamic() {
+		return t.size * t.elem.headSize()
+	}
+
+	return 32
+}
+
+// encodeTuple ABI encodes values as the tuple types, which is what abi.encode produces
+// for its arguments.
+func encodeTuple(types []*abiType, values []any) ([]byte, error) {
+	if len(values) != len(types) {
+		return nil, fmt.Errorf("standardtree: value has %d fields, the encoding %d", len(values), len(types))
+	}
+	headSize := 0
+	for _, t := range types {
+		headSize += t.headSize()
+	}
+
+	head := make([]byte, 0, headSize)
+	var tail []byte
+	for i, t := range types {
+		enc, err := t.encode(values[i])
+		if err != nil {
+			return nil, err
+		}
+		if t.dynamic() {
+			head = append(head, word(big.NewInt(int64(headSize+len(tail))))...)
+			tail = append(tail, enc...)
+		} else {
+			head = append(head, enc...)
+		}
+	}
+
+	return append(head, tail...), nil
+}
+
+func (t *abiType) encode(v any) ([]byte, error) {
+	switch t.kind {
+	case abiAddress:
+		b, err := toBytes(v)
+		if err != nil || len(b) != 20 {
+			return nil, fmt.Errorf("standardtree: invalid address %v", v)
+		}
+
+		return leftPad(b), nil
+	case abiBool:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("standardtree: invalid bool %v", v)
+		}
+		if b {
+			return word(big.NewInt(1)), nil
+		}
+
+		return word(new(big.Int)), nil
+	case abiUint, abiInt:
+		x, err := toBig(v)
+		if err != nil {
+			return nil, err
+		}
+		lo, hi := new(big.Int), new(big.Int).Lsh(big.NewInt(1), uint(t.size))
+		if t.kind == abiInt {
+			hi.Rsh(hi, 1)
+			lo.Neg(hi)
+		}
+		if x.Cmp(lo) < 0 || x.Cmp(hi) >= 0 {
+			return nil, fmt.Errorf("standardtree: %v out of range for %d bit integer", v, t.size)
+		}
+		if x.Sign() < 0 {
+			x = new(big.Int).Add(x, new(big.Int).Lsh(big.NewInt(1), 256))
+		}
+
+		return word(x), nil
+	case abiFixedBytes:
+		b, err := toBytes(v)
+		if err != nil || len(b) != t.size {
+			return nil, fmt.Errorf("standardtree: invalid bytes%d %v", t.size, v)
+		}
+
+		return rightPad(b), nil
+	case abiBytes:
+		b, err := toBytes(v)
+		if err != nil {
+			return nil, fmt.Errorf("standardtree: invalid bytes %v", v)
+		}
+
+		return append(word(big.NewInt(int64(len(b)))), rightPad(b)...), nil
+	case abiString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("standardtree: invalid string %v", v)
+		}
+
+		return append(word(big.NewInt(int64(len(s)))), rightPad([]byte(s))...), nil
+	}
+
+	elems, err := toSlice(v)
+	if err != nil {
+		return nil, err
+	}
+	types := make([]*abiType, len(elems))
+	for i := range types {
+		types[i] = t.elem
+	}
+	if t.kind == abiArray {
+		if len(elems) != t.size {
+			return nil, fmt.Errorf("standardtree: array has %d elements, the type %d", len(elems), t.size)
+		}
+
+		return encodeTuple(types, elems)
+	}
+	enc, err := encodeTuple(types, elems)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(word(big.NewInt(int64(len(elems)))), enc...), nil
+}
+
+// jsonValue returns v as it is written to a dump: strings are kept as given, integers
+// become decimal strings and byte strings 0x prefixed hex, as the JavaScript library
+// takes them.
+func (t *abiType) jsonValue(v any) any {
+	switch t.kind {
+	case abiBool, abiString:
+		return v
+	case abiUint, abiInt:
+		if s, ok := v.(string); ok {
+			return s
+		}
+		x, _ := toBig(v)
+		return x.String()
+	case abiAddress, abiFixedBytes, abiBytes:
+		if s, ok := v.(string); ok {
+			return s
+		}
+		b, _ := toBytes(v)
+		return "0x" + hex.EncodeToString(b)
+	}
+
+	elems, _ := toSlice(v)
+	out := make([]any, len(elems))
+	for i, e := range elems {
+		out[i] = t.elem.jsonValue(e)
+	}
+
+	return out
+}
+
+// toBig converts the integer forms a value may take: Go integers, *big.Int, and
+// decimal or 0x prefixed hex strings.
+func toBig(v any) (*big.Int, error) {
+	switch x := v.(type) {
+	case *big.Int:
+		if x != nil {
+			return x, nil
+		}
+	case json.Number:
+		return toBig(string(x))
+	case string:
+		s, base := x, 10
+		if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+			s, base = s[2:], 16
+		}
+		if n, ok := new(big.Int).SetString(s, base); ok {
+			return n, nil
+		}
+	default:
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return big.NewInt(rv.Int()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return new(big.Int).SetUint64(rv.Uint()), nil
+		}
+	}
+
+	return nil, fmt.Errorf("standardtree: invalid integer %v", v)
+}
+
+// toBytes converts the byte string forms a value may take: []byte, byte arrays, and 0x
+// prefixed hex strings.
+func toBytes(v any) ([]byte, error) {
+	if s, ok := v.(string); ok {
+		if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+			return nil, fmt.Errorf("standardtree: %q is not 0x prefixed hex", s)
+		}
+
+		return hex.DecodeString(s[2:])
+	}
+	rv := reflect.ValueOf(v)
+	if (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() == reflect.Uint8 {
+		b := make([]byte, rv.Len())
+		reflect.Copy(reflect.ValueOf(b), rv)
+
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("standardtree: invalid byte string %v", v)
+}
+
+// toSlice converts an array value, of any slice or array type, to its elements.
+func toSlice(v any) ([]any, error) {
+	if s, ok := v.([]any); ok {
+		return s, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("standardtree: invalid array %v", v)
+	}
+	s := make([]any, rv.Len())
+	for i := range s {
+		s[i] = rv.Index(i).Interface()
+	}
+
+	return s, nil
+}
+
+// word returns x, which must fit, as a 32 byte big-endian word.
+func word(x *big.Int) []byte {
+	return x.FillBytes(make([]byte, 32))
+}
+
+func leftPad(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+// rightPad pads b with zeros to a multiple of 32 bytes.
+func rightPad(b []byte) []byte {
+	return append(b[:len(b):len(b)], make([]byte, (32-len(b)%32)%32)...)
+}
@@ -0,0 +1,194 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package standardtree
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, words ...string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.Join(words, ""))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	return b
+}
+
+// TestEncodeSpecExamples checks the encoder against the worked examples of the Solidity
+// ABI specification.
+func TestEncodeSpecExamples(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		encoding []string
+		values   []any
+		want     []string
+	}{
+		{
+			name:     "baz",
+			encoding: []string{"uint32", "bool"},
+			values:   []any{69, true},
+			want: []string{
+				"0000000000000000000000000000000000000000000000000000000000000045",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+			},
+		},
+		{
+			name:     "bar",
+			encoding: []string{"bytes3[2]"},
+			values:   []any{[]any{[]byte("abc"), []byte("def")}},
+			want: []string{
+				"6162630000000000000000000000000000000000000000000000000000000000",
+				"6465660000000000000000000000000000000000000000000000000000000000",
+			},
+		},
+		{
+			name:     "sam",
+			encoding: []string{"bytes", "bool", "uint[]"},
+			values:   []any{[]byte("dave"), true, []any{1, 2, 3}},
+			want: []string{
+				"0000000000000000000000000000000000000000000000000000000000000060",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+				"00000000000000000000000000000000000000000000000000000000000000a0",
+				"0000000000000000000000000000000000000000000000000000000000000004",
+				"6461766500000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000003",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+				"0000000000000000000000000000000000000000000000000000000000000002",
+				"0000000000000000000000000000000000000000000000000000000000000003",
+			},
+		},
+		{
+			name:     "f",
+			encoding: []string{"uint", "uint32[]", "bytes10", "bytes"},
+			values:   []any{"0x123", []uint32{0x456, 0x789}, "0x31323334353637383930", []byte("Hello, world!")},
+			want: []string{
+				"0000000000000000000000000000000000000000000000000000000000000123",
+				"0000000000000000000000000000000000000000000000000000000000000080",
+				"3132333435363738393000000000000000000000000000000000000000000000",
+				"00000000000000000000000000000000000000000000000000000000000000e0",
+				"0000000000000000000000000000000000000000000000000000000000000002",
+				"0000000000000000000000000000000000000000000000000000000000000456",
+				"0000000000000000000000000000000000000000000000000000000000000789",
+				"000000000000000000000000000000000000000000000000000000000000000d",
+				"48656c6c6f2c20776f726c642100000000000000000000000000000000000000",
+			},
+		},
+		{
+			name:     "g",
+			encoding: []string{"uint[][]", "string[]"},
+			values:   []any{[]any{[]any{1, 2}, []any{3}}, []any{"one", "two", "three"}},
+			want: []string{
+				"0000000000000000000000000000000000000000000000000000000000000040",
+				"0000000000000000000000000000000000000000000000000000000000000140",
+				"0000000000000000000000000000000000000000000000000000000000000002",
+				"0000000000000000000000000000000000000000000000000000000000000040",
+				"00000000000000000000000000000000000000000000000000000000000000a0",
+				"0000000000000000000000000000000000000000000000000000000000000002",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+				"0000000000000000000000000000000000000000000000000000000000000002",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+				"0000000000000000000000000000000000000000000000000000000000000003",
+				"0000000000000000000000000000000000000000000000000000000000000003",
+				"0000000000000000000000000000000000000000000000000000000000000060",
+				"00000000000000000000000000000000000000000000000000000000000000a0",
+				"00000000000000000000000000000000000000000000000000000000000000e0",
+				"0000000000000000000000000000000000000000000000000000000000000003",
+				"6f6e650000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000003",
+				"74776f0000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000005",
+				"7468726565000000000000000000000000000000000000000000000000000000",
+			},
+		},
+	} {
+		types, err := parseTypes(tc.encoding)
+		if err != nil {
+			t.Fatalf("error: %s: unexpected error: %v", tc.name, err)
+		}
+		got, err := encodeTuple(types, tc.values)
+		if err != nil {
+			t.Fatalf("error: %s: unexpected error: %v", tc.name, err)
+		}
+		if want := mustHex(t, tc.want...); !bytes.Equal(got, want) {
+			t.Errorf("error: %s: got\n%x\nwant\n%x", tc.name, got, want)
+		}
+	}
+}
+
+func TestEncodeIntegers(t *testing.T) {
+	for _, tc := range []struct {
+		typ  string
+		v    any
+		want string
+		ok   bool
+	}{
+		{"int8", -1, strings.Repeat("ff", 32), true},
+		{"int256", "-2", strings.Repeat("ff", 31) + "fe", true},
+		{"int8", 127, strings.Repeat("00", 31) + "7f", true},
+		{"int8", 128, "", false},
+		{"int8", -129, "", false},
+		{"uint8", 255, strings.Repeat("00", 31) + "ff", true},
+		{"uint8", 256, "", false},
+		{"uint256", -1, "", false},
+		{"uint256", new(big.Int).Lsh(big.NewInt(1), 255), "80" + strings.Repeat("00", 31), true},
+		{"uint64", uint64(1) << 63, strings.Repeat("00", 24) + "8000000000000000", true},
+		{"uint256", "12ab", "", false},
+		{"uint256", 1.5, "", false},
+	} {
+		typ, err := parseType(tc.typ)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		got, err := typ.encode(tc.v)
+		if (err == nil) != tc.ok {
+			t.Errorf("error: %s %v: got error %v, want ok=%v", tc.typ, tc.v, err, tc.ok)
+			continue
+		}
+		if tc.ok && hex.EncodeToString(got) != tc.want {
+			t.Errorf("error: %s %v: got %x, want %s", tc.typ, tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestParseTypeRejects(t *testing.T) {
+	for _, s := range []string{"", "uint7", "uint264", "uint08", "int0", "bytes0", "bytes33", "bytes01", "address[0]", "address[x]", "(address,uint256)", "fixed128x18"} {
+		if _, err := parseType(s); err == nil {
+			t.Errorf("error: %q: expected an error", s)
+		}
+	}
+	for _, s := range []string{"uint", "int", "uint8", "int256", "bytes1", "bytes32", "address[]", "string[2][]", "bool[3]"} {
+		if _, err := parseType(s); err != nil {
+			t.Errorf("error: %q: unexpected error: %v", s, err)
+		}
+	}
+}
+
+func TestEncodeRejects(t *testing.T) {
+	for _, tc := range []struct {
+		typ string
+		v   any
+	}{
+		{"address", "0x11"},
+		{"address", "1111111111111111111111111111111111111111"},
+		{"bool", "true"},
+		{"bytes2", "0x010203"},
+		{"bytes", "hello"},
+		{"string", []byte("hello")},
+		{"uint8[2]", []any{1}},
+		{"uint8[]", 1},
+	} {
+		typ, err := parseType(tc.typ)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if _, err := typ.encode(tc.v); err == nil {
+			t.Errorf("error: %s %v: expected an error", tc.typ, tc.v)
+		}
+	}
+}
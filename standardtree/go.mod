@@ -0,0 +1,19 @@
+// This is a separate module on purpose. StandardMerkleTree hashes with keccak256, which
+// the standard library does not provide, and merkletree itself has no dependencies and
+// is meant to keep it that way. A nested module is excluded from the parent's package
+// list, so `go build ./...` and `go test ./...` at the root neither see this directory
+// nor acquire anything it requires.
+module github.com/cbergoon/merkletree/standardtree
+
+go 1.21
+
+// The package always builds against the working tree it sits in, never a published
+// version.
+replace github.com/cbergoon/merkletree => ../
+
+require (
+	github.com/cbergoon/merkletree v0.0.0-00010101000000-000000000000
+	golang.org/x/crypto v0.33.0
+)
+
+require golang.org/x/sys v0.30.0 // indirect
@@ -0,0 +1,494 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+// Package standardtree builds the trees of OpenZeppelin's StandardMerkleTree, the
+// JavaScript library most Solidity airdrops and allowlists are built with, so that a Go
+// service can produce the roots, proofs and multiproofs its contracts check.
+//
+// merkletree.WithSortedSiblings already hashes pairs the way OpenZeppelin's MerkleProof
+// verifies them, but a StandardMerkleTree differs from a merkletree.MerkleTree in three
+// more ways, and all three change the root:
+//
+//   - A leaf is the keccak256 of the keccak256 of the value's Solidity ABI encoding,
+//     abi.encode of its fields, rather than a digest the caller computes. Hashing twice
+//     keeps a 64 byte leaf from being mistaken for a pair of interior hashes.
+//   - The leaves are sorted by hash before the tree is built, unless the tree is built
+//     with WithoutLeafSorting.
+//   - The tree is a complete binary tree stored as a heap, with the leaves filling the
+//     last positions in reverse order. Nothing is padded: a tree of n leaves has n-1
+//     interior nodes, and a lone leaf is its own root.
+//
+// Values are given as []any, one entry per type of the leaf encoding, in the forms
+// the JavaScript library accepts: addresses, byte strings and bytesN as 0x prefixed hex
+// or []byte, integers as decimal or 0x prefixed hex strings, Go integers or *big.Int,
+// and arrays as slices. Tuples are not supported.
+//
+// A Tree marshals to the library's "standard-v1" dump format, so trees can be moved
+// between the two with StandardMerkleTree.load and dump.
+package standardtree
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/cbergoon/merkletree"
+	"golang.org/x/crypto/sha3"
+)
+
+// Tree is a StandardMerkleTree.
+type Tree struct {
+	encoding []string
+	types    []*abiType
+	// tree is the heap: the children of node i are nodes 2i+1 and 2i+2, and the
+	// leaves are its last len(values) entries.
+	tree   [][]byte
+	values []entry
+	// valueAt maps a leaf's position among the leaves, counting from the first leaf
+	// in tree, to the index of its value.
+	valueAt []int
+	// lookup maps a leaf hash to the index of its value.
+	lookup map[string]int
+}
+
+type entry struct {
+	value     []any
+	treeIndex int
+}
+
+// Option configures a tree built by Of.
+type Option func(*options)
+
+type options struct {
+	sortLeaves bool
+}
+
+// WithoutLeafSorting keeps the leaves in the order the values are given, as the
+// JavaScript library's sortLeaves: false does. Sorting is what makes a multiproof
+// cheap to verify on chain, so leave it on unless a contract depends on the order.
+func WithoutLeafSorting() Option {
+	return func(o *options) {
+		o.sortLeaves = false
+	}
+}
+
+// Of builds the tree over values with the given leaf encoding, a list of Solidity types
+// such as []string{"address", "uint256"}. It is StandardMerkleTree.of.
+//
+// Returns merkletree.ErrNoContent if values is empty.
+func Of(values [][]any, encoding []string, opts ...Option) (*Tree, error) {
+	o := options{sortLeaves: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	types, err := parseTypes(encoding)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, merkletree.ErrNoContent
+	}
+
+	hashes := make([][]byte, len(values))
+	for i, v := range values {
+		if hashes[i], err = leafHash(types, v); err != nil {
+			return nil, fmt.Errorf("standardtree: value %d: %w", i, err)
+		}
+	}
+	order := make([]int, len(values))
+	for i := range order {
+		order[i] = i
+	}
+	if o.sortLeaves {
+		// Stable, as JavaScript's sort is, so equal values keep their order.
+		slices.SortStableFunc(order, func(a, b int) int {
+			return bytes.Compare(hashes[a], hashes[b])
+		})
+	}
+
+	t := &Tree{
+		encoding: slices.Clone(encoding),
+		types:    types,
+		tree:     make([][]byte, 2*len(values)-1),
+		values:   make([]entry, len(values)),
+		valueAt:  make([]int, len(values)),
+		lookup:   make(map[string]int, len(values)),
+	}
+	for leaf, i := range order {
+		ti := len(t.tree) - 1 - leaf
+		t.tree[ti] = hashes[i]
+		t.values[i] = entry{value: values[i], treeIndex: ti}
+	}
+	t.index()
+	for i := len(t.tree) - 1 - len(values); i >= 0; i-- {
+		t.tree[i] = hashPair(t.tree[2*i+1], t.tree[2*i+2])
+	}
+
+	return t, nil
+}
+
+// index fills valueAt and lookup from values.
+func (t *Tree) index() {
+	first := len(t.tree) - len(t.values)
+	for i, e := range t.values {
+		t.valueAt[e.treeIndex-first] = i
+		t.lookup[string(t.tree[e.treeIndex])] = i
+	}
+}
+
+// Root returns the root of the tree, the value a contract stores.
+func (t *Tree) Root() []byte {
+	return t.tree[0]
+}
+
+// Len returns the number of values in the tree.
+func (t *Tree) Len() int {
+	return len(t.values)
+}
+
+// Value returns value i, in the order the values were given, as it was given.
+func (t *Tree) Value(i int) []any {
+	return t.values[i].value
+}
+
+// LeafEncoding returns the Solidity types the values are encoded as.
+func (t *Tree) LeafEncoding() []string {
+	return slices.Clone(t.encoding)
+}
+
+// LeafHash returns the leaf hash of value under the tree's encoding.
+func (t *Tree) LeafHash(value []any) ([]byte, error) {
+	return leafHash(t.types, value)
+}
+
+// Proof returns the proof for value i, in the order the values were given, that
+// MerkleProof.verify checks. It is StandardMerkleTree.getProof.
+//
+// Returns merkletree.ErrContentNotFound if i is out of range.
+func (t *Tree) Proof(i int) ([][]byte, error) {
+	if i < 0 || i >= len(t.values) {
+		return nil, fmt.Errorf("%w: no value %d in a tree of %d", merkletree.ErrContentNotFound, i, len(t.values))
+	}
+
+	var proof [][]byte
+	for j := t.values[i].treeIndex; j > 0; j = (j - 1) / 2 {
+		proof = append(proof, t.tree[sibling(j)])
+	}
+
+	return proof, nil
+}
+
+// ProofFor returns the proof for value, located by its leaf hash.
+//
+// Returns merkletree.ErrContentNotFound if the tree does not hold value.
+func (t *Tree) ProofFor(value []any) ([][]byte, error) {
+	i, err := t.find(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Proof(i)
+}
+
+func (t *Tree) find(value []any) (int, error) {
+	h, err := leafHash(t.types, value)
+	if err != nil {
+		return 0, err
+	}
+	i, ok := t.lookup[string(h)]
+	if !ok {
+		return 0, fmt.Errorf("%w: value is not in the tree", merkletree.ErrContentNotFound)
+	}
+
+	return i, nil
+}
+
+// MultiProof proves several values of one tree at once, in the form
+// MerkleProof.multiProofVerify checks.
+type MultiProof struct {
+	// Leaves are the values proven, in the order the proof consumes them, which is
+	// not in general the order they were asked for in.
+	Leaves [][]any
+	// Proof holds the hashes the verifier cannot compute, in the order they are
+	// consumed.
+	Proof [][]byte
+	// ProofFlags holds one entry per hashing step: true when both inputs are hashes
+	// the verifier has computed, false when one is the next entry of Proof.
+	ProofFlags []bool
+}
+
+// MultiProof returns a proof for the values at the given indices, in the order the
+// values were given. It is StandardMerkleTree.getMultiProof.
+//
+// Returns merkletree.ErrContentNotFound if an index is out of range, and an error if one
+// repeats. No indices at all gives a proof holding only the root, as in the library.
+func (t *Tree) MultiProof(indices []int) (*MultiProof, error) {
+	stack := make([]int, len(indices))
+	for k, i := range indices {
+		if i < 0 || i >= len(t.values) {
+			return nil, fmt.Errorf("%w: no value %d in a tree of %d", merkletree.ErrContentNotFound, i, len(t.values))
+		}
+		stack[k] = t.values[i].treeIndex
+	}
+	slices.SortFunc(stack, func(a, b int) int { return b - a })
+	for k := 1; k < len(stack); k++ {
+		if stack[k] == stack[k-1] {
+			return nil, errors.New("standardtree: cannot prove a repeated index")
+		}
+	}
+
+	first := len(t.tree) - len(t.values)
+	mp := &MultiProof{Leaves: make([][]any, len(stack))}
+	for k, ti := range stack {
+		mp.Leaves[k] = t.values[t.valueAt[ti-first]].value
+	}
+	// The stack is a queue of tree indices in descending order, so a node and its
+	// sibling, when both are known, are always next to each other at its front.
+	for len(stack) > 0 && stack[0] > 0 {
+		j := stack[0]
+		stack = stack[1:]
+		s := sibling(j)
+		if len(stack) > 0 && stack[0] == s {
+			mp.ProofFlags = append(mp.ProofFlags, true)
+			stack = stack[1:]
+		} else {
+			mp.ProofFlags = append(mp.ProofFlags, false)
+			mp.Proof = append(mp.Proof, t.tree[s])
+		}
+		stack = append(stack, (j-1)/2)
+	}
+	if len(indices) == 0 {
+		mp.Proof = append(mp.Proof, t.tree[0])
+	}
+
+	return mp, nil
+}
+
+// Verify reports whether proof proves value is in the tree with the given root and leaf
+// encoding. It is StandardMerkleTree.verify, and checks what MerkleProof.verify checks.
+func Verify(root []byte, encoding []string, value []any, proof [][]byte) (bool, error) {
+	types, err := parseTypes(encoding)
+	if err != nil {
+		return false, err
+	}
+	h, err := leafHash(types, value)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range proof {
+		h = hashPair(h, p)
+	}
+
+	return bytes.Equal(h, root), nil
+}
+
+// VerifyMultiProof reports whether mp proves its leaves are in the tree with the given
+// root and leaf encoding. It is StandardMerkleTree.verifyMultiProof, and checks what
+// MerkleProof.multiProofVerify checks.
+//
+// Returns merkletree.ErrMalformedProof if the proof's parts cannot belong together.
+func VerifyMultiProof(root []byte, encoding []string, mp *MultiProof) (bool, error) {
+	types, err := parseTypes(encoding)
+	if err != nil {
+		return false, err
+	}
+	if mp == nil || len(mp.Leaves)+len(mp.Proof) != len(mp.ProofFlags)+1 {
+		return false, fmt.Errorf("%w: leaves and proof do not match the flags", merkletree.ErrMalformedProof)
+	}
+
+	queue := make([][]byte, 0, len(mp.Leaves)+len(mp.ProofFlags))
+	for _, v := range mp.Leaves {
+		h, err := leafHash(types, v)
+		if err != nil {
+			return false, err
+		}
+		queue = append(queue, h)
+	}
+	proof := mp.Proof
+	for _, flag := range mp.ProofFlags {
+		if len(queue) == 0 || (!flag && len(proof) == 0) || (flag && len(queue) < 2) {
+			return false, fmt.Errorf("%w: proof runs out of hashes", merkletree.ErrMalformedProof)
+		}
+		a := queue[0]
+		queue = queue[1:]
+		var b []byte
+		if flag {
+			b, queue = queue[0], queue[1:]
+		} else {
+			b, proof = proof[0], proof[1:]
+		}
+		queue = append(queue, hashPair(a, b))
+	}
+	if len(queue)+len(proof) != 1 {
+		return false, fmt.Errorf("%w: proof leaves hashes unused", merkletree.ErrMalformedProof)
+	}
+	if len(queue) == 1 {
+		return bytes.Equal(queue[0], root), nil
+	}
+
+	return bytes.Equal(proof[0], root), nil
+}
+
+// LeafHash returns the leaf hash of value under encoding: the keccak256 of the
+// keccak256 of its ABI encoding. It is StandardMerkleTree.leafHash.
+func LeafHash(encoding []string, value []any) ([]byte, error) {
+	types, err := parseTypes(encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	return leafHash(types, value)
+}
+
+func leafHash(types []*abiType, value []any) ([]byte, error) {
+	enc, err := encodeTuple(types, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return keccak256(keccak256(enc)), nil
+}
+
+// hashPair is the interior hash MerkleProof uses: the keccak256 of the pair, smaller
+// first.
+func hashPair(a, b []byte) []byte {
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+
+	return keccak256(a, b)
+}
+
+func keccak256(data ...[]byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	for _, d := range data {
+		h.Write(d)
+	}
+
+	return h.Sum(nil)
+}
+
+// sibling returns the index of the other child of node i's parent.
+func sibling(i int) int {
+	if i%2 == 1 {
+		return i + 1
+	}
+
+	return i - 1
+}
+
+// dump is the "standard-v1" format of the JavaScript library's dump and load.
+type dump struct {
+	Format       string      `json:"format"`
+	Tree         []string    `json:"tree"`
+	Values       []dumpValue `json:"values"`
+	LeafEncoding []string    `json:"leafEncoding"`
+}
+
+type dumpValue struct {
+	Value     []any `json:"value"`
+	TreeIndex int   `json:"treeIndex"`
+}
+
+const dumpFormat = "standard-v1"
+
+// MarshalJSON encodes the tree as StandardMerkleTree.dump does.
+func (t *Tree) MarshalJSON() ([]byte, error) {
+	d := dump{
+		Format:       dumpFormat,
+		Tree:         make([]string, len(t.tree)),
+		Values:       make([]dumpValue, len(t.values)),
+		LeafEncoding: t.encoding,
+	}
+	for i, h := range t.tree {
+		d.Tree[i] = "0x" + hex.EncodeToString(h)
+	}
+	for i, e := range t.values {
+		v := make([]any, len(e.value))
+		for k, field := range e.value {
+			v[k] = t.types[k].jsonValue(field)
+		}
+		d.Values[i] = dumpValue{Value: v, TreeIndex: e.treeIndex}
+	}
+
+	return json.Marshal(d)
+}
+
+// UnmarshalJSON decodes a tree written by StandardMerkleTree.dump or MarshalJSON. Unlike
+// StandardMerkleTree.load it validates what it reads, as the library's validate does:
+// every value must hash to the leaf it names and every node to the hash of its
+// children, or merkletree.ErrMalformedTree is returned.
+//
+// Integers are read back as the strings or JSON numbers the dump holds them as, and byte
+// strings as hex.
+func (t *Tree) UnmarshalJSON(data []byte) error {
+	var d dump
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&d); err != nil {
+		return err
+	}
+	if d.Format != dumpFormat {
+		return fmt.Errorf("standardtree: unknown dump format %q", d.Format)
+	}
+	types, err := parseTypes(d.LeafEncoding)
+	if err != nil {
+		return err
+	}
+	n := len(d.Values)
+	if n == 0 || len(d.Tree) != 2*n-1 {
+		return fmt.Errorf("%w: %d values need %d nodes, the dump has %d", merkletree.ErrMalformedTree, n, 2*n-1, len(d.Tree))
+	}
+
+	u := &Tree{
+		encoding: d.LeafEncoding,
+		types:    types,
+		tree:     make([][]byte, len(d.Tree)),
+		values:   make([]entry, n),
+		valueAt:  make([]int, n),
+		lookup:   make(map[string]int, n),
+	}
+	for i, s := range d.Tree {
+		b, err := hex.DecodeString(trimHexPrefix(s))
+		if err != nil || len(b) != 32 {
+			return fmt.Errorf("%w: node %d is not a 32 byte hash", merkletree.ErrMalformedTree, i)
+		}
+		u.tree[i] = b
+	}
+	seen := make([]bool, n)
+	for i, v := range d.Values {
+		leaf := v.TreeIndex - (n - 1)
+		if leaf < 0 || leaf >= n || seen[leaf] {
+			return fmt.Errorf("%w: value %d has tree index %d", merkletree.ErrMalformedTree, i, v.TreeIndex)
+		}
+		seen[leaf] = true
+		h, err := leafHash(types, v.Value)
+		if err != nil {
+			return fmt.Errorf("standardtree: value %d: %w", i, err)
+		}
+		if !bytes.Equal(h, u.tree[v.TreeIndex]) {
+			return fmt.Errorf("%w: value %d does not hash to its leaf", merkletree.ErrMalformedTree, i)
+		}
+		u.values[i] = entry{value: v.Value, treeIndex: v.TreeIndex}
+	}
+	for i := n - 2; i >= 0; i-- {
+		if !bytes.Equal(u.tree[i], hashPair(u.tree[2*i+1], u.tree[2*i+2])) {
+			return fmt.Errorf("%w: node %d is not the hash of its children", merkletree.ErrMalformedTree, i)
+		}
+	}
+	u.index()
+	*t = *u
+
+	return nil
+}
+
+func trimHexPrefix(s string) string {
+	if len(s) >= 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		return s[2:]
+	}
+
+	return s
+}
@@ -0,0 +1,585 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package standardtree
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/cbergoon/merkletree"
+	"golang.org/x/crypto/sha3"
+)
+
+var airdrop = []string{"address", "uint256"}
+
+func airdropValues(n int) [][]any {
+	values := make([][]any, n)
+	for i := range values {
+		values[i] = []any{fmt.Sprintf("0x%040x", 0x1000+i), fmt.Sprint(1000 * (i + 1))}
+	}
+	return values
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(trimHexPrefix(s))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	return b
+}
+
+// TestReadmeExample reproduces the example the JavaScript library documents, and reads
+// the library's dump of it.
+func TestReadmeExample(t *testing.T) {
+	data, err := os.ReadFile("testdata/readme_example.json")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	var golden struct {
+		Values       [][]any         `json:"values"`
+		LeafEncoding []string        `json:"leafEncoding"`
+		Root         string          `json:"root"`
+		Proof        []string        `json:"proof"`
+		Dump         json.RawMessage `json:"dump"`
+	}
+	if err := json.Unmarshal(data, &golden); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	tree, err := Of(golden.Values, golden.LeafEncoding)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	root := decodeHex(t, golden.Root)
+	if !bytes.Equal(tree.Root(), root) {
+		t.Fatalf("error: root %x, want %s", tree.Root(), golden.Root)
+	}
+	proof, err := tree.Proof(0)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(proof) != len(golden.Proof) {
+		t.Fatalf("error: proof has %d hashes, want %d", len(proof), len(golden.Proof))
+	}
+	for i := range proof {
+		if !bytes.Equal(proof[i], decodeHex(t, golden.Proof[i])) {
+			t.Errorf("error: proof hash %d is %x, want %s", i, proof[i], golden.Proof[i])
+		}
+	}
+	if ok, err := Verify(root, golden.LeafEncoding, golden.Values[0], proof); err != nil || !ok {
+		t.Errorf("error: got %v, %v, want true", ok, err)
+	}
+
+	var loaded Tree
+	if err := json.Unmarshal(golden.Dump, &loaded); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(loaded.Root(), root) {
+		t.Errorf("error: loaded root %x, want %s", loaded.Root(), golden.Root)
+	}
+	dumped, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	var got, want any
+	json.Unmarshal(dumped, &got)
+	json.Unmarshal(golden.Dump, &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("error: dump differs from the library's:\n%s", dumped)
+	}
+}
+
+// TestPerfectTreesMatchSortedSiblings checks the heap layout against merkletree. When the
+// leaf count is a power of two the heap is a perfect tree, and since sorted pairs do not
+// care which side a hash is on, it is the tree WithSortedSiblings builds over the sorted
+// leaf hashes.
+func TestPerfectTreesMatchSortedSiblings(t *testing.T) {
+	for _, n := range []int{1, 2, 4, 8, 16, 64} {
+		tree, err := Of(airdropValues(n), airdrop)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		first := len(tree.tree) - n
+		leaves := make([]merkletree.Content, n)
+		for i := range leaves {
+			leaves[i] = digest(tree.tree[len(tree.tree)-1-i])
+		}
+		if n == 1 {
+			if !bytes.Equal(tree.Root(), tree.tree[first]) {
+				t.Errorf("error: a lone leaf is not its own root")
+			}
+			continue
+		}
+		mt, err := merkletree.NewTreeWithOptions(leaves, merkletree.WithSortedSiblings(), merkletree.WithHasher(sha3.NewLegacyKeccak256))
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if !bytes.Equal(tree.Root(), mt.MerkleRoot()) {
+			t.Errorf("error: n=%d: root %x, merkletree builds %x", n, tree.Root(), mt.MerkleRoot())
+		}
+	}
+}
+
+// digest is a Content whose digest is fixed.
+type digest []byte
+
+func (d digest) CalculateHash() ([]byte, error) { return d, nil }
+
+func (d digest) Equals(other merkletree.Content) (bool, error) {
+	o, ok := other.(digest)
+	if !ok {
+		return false, errors.New("error: value is not of type digest")
+	}
+	return bytes.Equal(d, o), nil
+}
+
+func TestProofs(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		for _, sorted := range []bool{true, false} {
+			var opts []Option
+			if !sorted {
+				opts = append(opts, WithoutLeafSorting())
+			}
+			values := airdropValues(n)
+			tree, err := Of(values, airdrop, opts...)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !sorted && n > 1 && tree.values[0].treeIndex != len(tree.tree)-1 {
+				t.Errorf("error: n=%d: unsorted first value is not the first leaf", n)
+			}
+			for i, v := range values {
+				proof, err := tree.Proof(i)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				if ok, err := Verify(tree.Root(), airdrop, v, proof); err != nil || !ok {
+					t.Errorf("error: n=%d sorted=%v value %d: got %v, %v, want true", n, sorted, i, ok, err)
+				}
+				if byValue, _ := tree.ProofFor(v); !reflect.DeepEqual(byValue, proof) {
+					t.Errorf("error: n=%d value %d: ProofFor differs from Proof", n, i)
+				}
+				if ok, _ := Verify(tree.Root(), airdrop, airdropValues(n + 1)[n], proof); ok {
+					t.Errorf("error: n=%d value %d: proof verified a value not in the tree", n, i)
+				}
+			}
+		}
+	}
+}
+
+func TestMultiProofs(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		values := airdropValues(n)
+		tree, err := Of(values, airdrop)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		for set := 0; set < 1<<n; set++ {
+			var indices []int
+			for i := 0; i < n; i++ {
+				if set>>i&1 == 1 {
+					indices = append(indices, i)
+				}
+			}
+			mp, err := tree.MultiProof(indices)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if len(mp.Leaves) != len(indices) {
+				t.Fatalf("error: n=%d %v: proof holds %d leaves", n, indices, len(mp.Leaves))
+			}
+			if ok, err := VerifyMultiProof(tree.Root(), airdrop, mp); err != nil || !ok {
+				t.Errorf("error: n=%d %v: got %v, %v, want true", n, indices, ok, err)
+			}
+			if len(indices) > 0 {
+				mp.Leaves[0] = airdropValues(n + 1)[n]
+				if ok, _ := VerifyMultiProof(tree.Root(), airdrop, mp); ok {
+					t.Errorf("error: n=%d %v: proof verified a value not in the tree", n, indices)
+				}
+			}
+		}
+	}
+
+	tree, _ := Of(airdropValues(4), airdrop)
+	if _, err := tree.MultiProof([]int{1, 1}); err == nil {
+		t.Errorf("error: repeated index: expected an error")
+	}
+	if _, err := tree.MultiProof([]int{4}); !errors.Is(err, merkletree.ErrContentNotFound) {
+		t.Errorf("error: index out of range: got %v, want ErrContentNotFound", err)
+	}
+	mp, _ := tree.MultiProof([]int{0, 2})
+	mp.ProofFlags = append(mp.ProofFlags, true)
+	if _, err := VerifyMultiProof(tree.Root(), airdrop, mp); !errors.Is(err, merkletree.ErrMalformedProof) {
+		t.Errorf("error: extra flag: got %v, want ErrMalformedProof", err)
+	}
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	values := [][]any{
+		{[20]byte{1}, 5, []byte{0xde, 0xad}, "alice", true},
+		{"0x0200000000000000000000000000000000000000", "0x10", "0x", "bob", false},
+		{[]byte{3, 19: 0}, uint64(7), []byte{}, "", true},
+	}
+	encoding := []string{"address", "uint256", "bytes", "string", "bool"}
+	tree, err := Of(values, encoding)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	data, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	var loaded Tree
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(loaded.Root(), tree.Root()) || loaded.Len() != tree.Len() {
+		t.Fatalf("error: loaded tree differs")
+	}
+	for i := range values {
+		want, _ := tree.Proof(i)
+		got, err := loaded.ProofFor(loaded.Value(i))
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("error: value %d: proof from the loaded tree differs: %v", i, err)
+		}
+	}
+
+	for name, tamper := range map[string]func(d map[string]any){
+		"format": func(d map[string]any) { d["format"] = "standard-v2" },
+		"node": func(d map[string]any) {
+			d["tree"].([]any)[0] = "0x" + hex.EncodeToString(make([]byte, 32))
+		},
+		"value": func(d map[string]any) {
+			d["values"].([]any)[0].(map[string]any)["value"].([]any)[3] = "mallory"
+		},
+		"tree index": func(d map[string]any) {
+			d["values"].([]any)[0].(map[string]any)["treeIndex"] = 0
+		},
+		"short tree": func(d map[string]any) { d["tree"] = d["tree"].([]any)[1:] },
+	} {
+		var d map[string]any
+		json.Unmarshal(data, &d)
+		tamper(d)
+		b, _ := json.Marshal(d)
+		if err := json.Unmarshal(b, &loaded); err == nil {
+			t.Errorf("error: %s: expected an error", name)
+		}
+	}
+}
+
+func TestOfRejects(t *testing.T) {
+	if _, err := Of(nil, airdrop); !errors.Is(err, merkletree.ErrNoContent) {
+		t.Errorf("error: no values: got %v, want ErrNoContent", err)
+	}
+	if _, err := Of(airdropValues(2), []string{"address", "tuple"}); err == nil {
+		t.Errorf("error: unsupported type: expected an error")
+	}
+	if _, err := Of([][]any{{"0x1111111111111111111111111111111111111111"}}, airdrop); err == nil {
+		t.Errorf("error: missing field: expected an error")
+	}
+	tree, _ := Of(airdropValues(3), airdrop)
+	if _, err := tree.Proof(3); !errors.Is(err, merkletree.ErrContentNotFound) {
+		t.Errorf("error: index out of range: got %v, want ErrContentNotFound", err)
+	}
+	if _, err := tree.ProofFor(airdropValues(4)[3]); !errors.Is(err, merkletree.ErrContentNotFound) {
+		t.Errorf("error: value not in tree: got %v, want ErrContentNotFound", err)
+	}
+}
+
+// libraryVector is one tree testdata/generate_vectors.mjs builds with the JavaScript
+// library, and what the library gives for it.
+type libraryVector struct {
+	Name         string          `json:"name"`
+	LeafEncoding []string        `json:"leafEncoding"`
+	SortLeaves   bool            `json:"sortLeaves"`
+	Values       [][]any         `json:"values"`
+	Root         string          `json:"root"`
+	Dump         json.RawMessage `json:"dump"`
+	Proofs       [][]string      `json:"proofs"`
+	MultiProofs  []struct {
+		Indices    []int    `json:"indices"`
+		Leaves     [][]any  `json:"leaves"`
+		Proof      []string `json:"proof"`
+		ProofFlags []bool   `json:"proofFlags"`
+	} `json:"multiProofs"`
+}
+
+// TestLibraryVectors checks roots, dumps, proofs and multiproofs against the JavaScript
+// library's own output, for trees of 3, 5 and 7 leaves, sorted and unsorted, over an
+// address and uint256 encoding and one mixing bool, int8, bytes, string, bytes4 and
+// uint16[].
+func TestLibraryVectors(t *testing.T) {
+	data, err := os.ReadFile("testdata/library_vectors.json")
+	if errors.Is(err, os.ErrNotExist) {
+		t.Skip("testdata/library_vectors.json has not been generated; run testdata/generate_vectors.mjs")
+	}
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	var vectors []libraryVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	hexes := func(hs [][]byte) []string {
+		s := make([]string, len(hs))
+		for i, h := range hs {
+			s[i] = "0x" + hex.EncodeToString(h)
+		}
+		return s
+	}
+
+	for _, v := range vectors {
+		var opts []Option
+		if !v.SortLeaves {
+			opts = append(opts, WithoutLeafSorting())
+		}
+		tree, err := Of(v.Values, v.LeafEncoding, opts...)
+		if err != nil {
+			t.Fatalf("error: %s: unexpected error: %v", v.Name, err)
+		}
+		if got := "0x" + hex.EncodeToString(tree.Root()); got != v.Root {
+			t.Errorf("error: %s: root %s, library gives %s", v.Name, got, v.Root)
+		}
+
+		dumped, err := json.Marshal(tree)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		var got, want any
+		json.Unmarshal(dumped, &got)
+		json.Unmarshal(v.Dump, &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("error: %s: dump differs from the library's:\n%s", v.Name, dumped)
+		}
+		var loaded Tree
+		if err := json.Unmarshal(v.Dump, &loaded); err != nil {
+			t.Errorf("error: %s: library dump does not load: %v", v.Name, err)
+		}
+
+		for i, want := range v.Proofs {
+			proof, err := tree.Proof(i)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got := hexes(proof); !reflect.DeepEqual(got, want) {
+				t.Errorf("error: %s value %d: proof %v, library gives %v", v.Name, i, got, want)
+			}
+		}
+
+		for _, want := range v.MultiProofs {
+			mp, err := tree.MultiProof(want.Indices)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(mp.Leaves, want.Leaves) {
+				t.Errorf("error: %s %v: leaves %v, library gives %v", v.Name, want.Indices, mp.Leaves, want.Leaves)
+			}
+			if got := hexes(mp.Proof); !reflect.DeepEqual(got, want.Proof) {
+				t.Errorf("error: %s %v: proof %v, library gives %v", v.Name, want.Indices, got, want.Proof)
+			}
+			if fmt.Sprint(mp.ProofFlags) != fmt.Sprint(want.ProofFlags) {
+				t.Errorf("error: %s %v: flags %v, library gives %v", v.Name, want.Indices, mp.ProofFlags, want.ProofFlags)
+			}
+		}
+	}
+}
+
+// ozTree is the tree the JavaScript library builds, transcribed from makeMerkleTree,
+// getProof and getMultiProof in its core.ts and from StandardMerkleTree.of in
+// standard.ts. It is no substitute for the library's own output, which
+// TestLibraryVectors checks against, and it shares LeafHash with Tree, so it checks
+// nothing of the encoding; what it adds is the heap layout and proof order at every
+// size and for every set of indices, which the vectors cannot cover.
+type ozTree struct {
+	tree      [][]byte
+	treeIndex []int
+	// hashLookup maps a leaf hash to the index of its value.
+	hashLookup map[string]int
+}
+
+func newOZTree(t *testing.T, values [][]any, encoding []string, sortLeaves bool) *ozTree {
+	t.Helper()
+	type hashedValue struct {
+		valueIndex int
+		hash       []byte
+	}
+	hashed := make([]hashedValue, len(values))
+	for i, v := range values {
+		h, err := LeafHash(encoding, v)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		hashed[i] = hashedValue{i, h}
+	}
+	if sortLeaves {
+		sort.SliceStable(hashed, func(a, b int) bool {
+			return bytes.Compare(hashed[a].hash, hashed[b].hash) < 0
+		})
+	}
+
+	oz := &ozTree{
+		tree:       make([][]byte, 2*len(values)-1),
+		treeIndex:  make([]int, len(values)),
+		hashLookup: make(map[string]int, len(values)),
+	}
+	for i, hv := range hashed {
+		oz.tree[len(oz.tree)-1-i] = hv.hash
+		oz.treeIndex[hv.valueIndex] = len(oz.tree) - 1 - i
+		oz.hashLookup[string(hv.hash)] = hv.valueIndex
+	}
+	for i := len(oz.tree) - 1 - len(values); i >= 0; i-- {
+		a, b := oz.tree[2*i+1], oz.tree[2*i+2]
+		if bytes.Compare(a, b) > 0 {
+			a, b = b, a
+		}
+		h := sha3.NewLegacyKeccak256()
+		h.Write(a)
+		h.Write(b)
+		oz.tree[i] = h.Sum(nil)
+	}
+
+	return oz
+}
+
+// ozSibling is siblingIndex: i - (-1) ** (i % 2).
+func ozSibling(i int) int {
+	if i%2 == 1 {
+		return i + 1
+	}
+	return i - 1
+}
+
+func (oz *ozTree) proof(valueIndex int) [][]byte {
+	var proof [][]byte
+	for i := oz.treeIndex[valueIndex]; i > 0; i = (i - 1) / 2 {
+		proof = append(proof, oz.tree[ozSibling(i)])
+	}
+	return proof
+}
+
+// multiProof returns the leaf hashes, proof and flags getMultiProof gives.
+func (oz *ozTree) multiProof(valueIndices []int) (leaves, proof [][]byte, flags []bool) {
+	indices := make([]int, len(valueIndices))
+	for k, i := range valueIndices {
+		indices[k] = oz.treeIndex[i]
+	}
+	sort.Slice(indices, func(a, b int) bool { return indices[a] > indices[b] })
+	stack := append([]int(nil), indices...)
+	for len(stack) > 0 && stack[0] > 0 {
+		j := stack[0]
+		stack = stack[1:]
+		s := ozSibling(j)
+		if len(stack) > 0 && s == stack[0] {
+			flags = append(flags, true)
+			stack = stack[1:]
+		} else {
+			flags = append(flags, false)
+			proof = append(proof, oz.tree[s])
+		}
+		stack = append(stack, (j-1)/2)
+	}
+	if len(indices) == 0 {
+		proof = append(proof, oz.tree[0])
+	}
+	for _, i := range indices {
+		leaves = append(leaves, oz.tree[i])
+	}
+	return leaves, proof, flags
+}
+
+// dump returns what StandardMerkleTree.dump gives, decoded from JSON.
+func (oz *ozTree) dump(values [][]any, encoding []string) any {
+	tree := make([]any, len(oz.tree))
+	for i, h := range oz.tree {
+		tree[i] = "0x" + hex.EncodeToString(h)
+	}
+	vs := make([]any, len(values))
+	for i, v := range values {
+		vs[i] = map[string]any{"value": v, "treeIndex": oz.treeIndex[i]}
+	}
+	var d any
+	b, _ := json.Marshal(map[string]any{
+		"format":       "standard-v1",
+		"leafEncoding": encoding,
+		"tree":         tree,
+		"values":       vs,
+	})
+	json.Unmarshal(b, &d)
+	return d
+}
+
+// TestMatchesLibraryAlgorithm checks dumps, proofs and multiproofs against ozTree, with
+// and without leaf sorting, at sizes whose heaps are not perfect trees.
+func TestMatchesLibraryAlgorithm(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5, 6, 7, 9} {
+		for _, sorted := range []bool{true, false} {
+			var opts []Option
+			if !sorted {
+				opts = append(opts, WithoutLeafSorting())
+			}
+			values := airdropValues(n)
+			tree, err := Of(values, airdrop, opts...)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			oz := newOZTree(t, values, airdrop, sorted)
+
+			data, err := json.Marshal(tree)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			var got any
+			json.Unmarshal(data, &got)
+			if want := oz.dump(values, airdrop); !reflect.DeepEqual(got, want) {
+				t.Errorf("error: n=%d sorted=%v: dump differs from the library's:\n%s", n, sorted, data)
+			}
+
+			for i := range values {
+				proof, err := tree.Proof(i)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				if want := oz.proof(i); !reflect.DeepEqual(proof, want) {
+					t.Errorf("error: n=%d sorted=%v value %d: proof %x, library gives %x", n, sorted, i, proof, want)
+				}
+			}
+
+			for set := 0; set < 1<<n; set++ {
+				// Asked for in descending value order, so that the order the proof
+				// consumes the leaves in is not simply the order asked for.
+				var indices []int
+				for i := n - 1; i >= 0; i-- {
+					if set>>i&1 == 1 {
+						indices = append(indices, i)
+					}
+				}
+				mp, err := tree.MultiProof(indices)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				leaves, proof, flags := oz.multiProof(indices)
+				if len(mp.Leaves) != len(leaves) {
+					t.Fatalf("error: n=%d sorted=%v %v: proof holds %d leaves, library gives %d", n, sorted, indices, len(mp.Leaves), len(leaves))
+				}
+				for k, v := range mp.Leaves {
+					// getMultiProof maps each leaf hash back to its value.
+					if want := values[oz.hashLookup[string(leaves[k])]]; !reflect.DeepEqual(v, want) {
+						t.Errorf("error: n=%d sorted=%v %v: leaf %d is %v, library gives %v", n, sorted, indices, k, v, want)
+					}
+				}
+				if !reflect.DeepEqual(mp.Proof, proof) || !reflect.DeepEqual(mp.ProofFlags, flags) {
+					t.Errorf("error: n=%d sorted=%v %v: multiproof %x %v, library gives %x %v", n, sorted, indices, mp.Proof, mp.ProofFlags, proof, flags)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Writes library_vectors.json, the golden vectors TestLibraryVectors checks the package
+// against, from @openzeppelin/merkle-tree itself. Run it from this directory:
+//
+//	npm install --no-save @openzeppelin/merkle-tree
+//	node generate_vectors.mjs
+//
+// Every value is written as a string, boolean or array so that it reads back into Go
+// exactly as the library was given it.
+
+import { writeFileSync } from "node:fs";
+import { StandardMerkleTree } from "@openzeppelin/merkle-tree";
+
+const encodings = {
+  airdrop: {
+    leafEncoding: ["address", "uint256"],
+    value: (i) => ["0x" + (0x1000 + i).toString(16).padStart(40, "0"), String(1000 * (i + 1))],
+  },
+  mixed: {
+    leafEncoding: ["bool", "int8", "bytes", "string", "bytes4", "uint16[]"],
+    value: (i) => [
+      i % 2 === 0,
+      String(i - 3),
+      "0x" + "ab".repeat(i),
+      "value " + i,
+      "0x" + (0xdead0000 + i).toString(16),
+      Array.from({ length: i % 3 }, (_, k) => String(k + i)),
+    ],
+  },
+};
+
+// multiProofIndices returns the index sets each tree is asked for a multiproof of, in
+// the order they are asked for, which is deliberately not ascending.
+function multiProofIndices(n) {
+  const all = Array.from({ length: n }, (_, i) => i);
+  return [
+    [],
+    [0],
+    [n - 1, 0],
+    [2, 1],
+    all.filter((i) => i % 2 === 0).reverse(),
+    all.slice().reverse(),
+  ];
+}
+
+const vectors = [];
+for (const [name, { leafEncoding, value }] of Object.entries(encodings)) {
+  for (const n of [3, 5, 7]) {
+    for (const sortLeaves of [true, false]) {
+      const values = Array.from({ length: n }, (_, i) => value(i));
+      const tree = StandardMerkleTree.of(values, leafEncoding, { sortLeaves });
+      vectors.push({
+        name: `${name}/n=${n}/sortLeaves=${sortLeaves}`,
+        leafEncoding,
+        sortLeaves,
+        values,
+        root: tree.root,
+        dump: tree.dump(),
+        proofs: values.map((_, i) => tree.getProof(i)),
+        multiProofs: multiProofIndices(n).map((indices) => ({
+          indices,
+          ...tree.getMultiProof(indices.slice()),
+        })),
+      });
+    }
+  }
+}
+
+writeFileSync("library_vectors.json", JSON.stringify(vectors, null, "\t") + "\n");
@@ -0,0 +1,264 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"errors"
+	"fmt"
+)
+
+// StoredTree is a Merkle tree whose node hashes live in a NodeStore rather than in a
+// graph of Node values. It has the root a MerkleTree built from the same content with
+// the same options would have, and serves the same audit paths, but holds nothing
+// itself beyond its size, its construction and its root: no Node, no Parent, Left or
+// Right pointer, no Content. With a FileNodeStore a tree of any size costs the heap
+// nothing per leaf.
+//
+// Level zero of the store holds the leaf hashes and each level above holds the parents
+// of the one below, node j being the parent of nodes 2j and 2j+1. The last node of an
+// odd level is paired with itself under the default and sorted constructions, which is
+// what the padding leaf of a MerkleTree amounts to, and carried up unchanged under
+// WithRFC6962. The padding leaf itself is not stored.
+//
+// A StoredTree is safe for concurrent reads when its store is.
+type StoredTree struct {
+	// cfg carries the construction and hash strategy, and provides the hashing.
+	cfg   *MerkleTree
+	store NodeStore
+	size  int
+	root  []byte
+}
+
+// NewStoredTree builds the tree over size leaves into store and returns it, calling leaf
+// for the content of each in turn, from 0 to size-1. It takes the same options as
+// NewTreeWithOptions; WithParallelism and WithLeafIndex have no effect.
+//
+// Each leaf is hashed and written as soon as leaf returns it, and nothing it returns is
+// retained, so leaf can read the content from a file or a database one item at a time.
+// Each level is then computed by reading the one below back from the store, so building
+// holds a few hashes at a time whatever the leaf count. Returns ErrNoContent if size is
+// less than one, ErrNilContent if leaf returns nil content, and any error leaf returns.
+func NewStoredTree(store NodeStore, size int, leaf func(i int) (Content, error), opts ...TreeOption) (*StoredTree, error) {
+	cfg, err := configFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.requireBinary("a StoredTree"); err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return nil, errors.New("error: NewStoredTree requires a NodeStore")
+	}
+	if leaf == nil {
+		return nil, errors.New("error: NewStoredTree requires a leaf function")
+	}
+	if size < 1 {
+		return nil, ErrNoContent
+	}
+
+	for i := 0; i < size; i++ {
+		c, err := leaf(i)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			return nil, fmt.Errorf("%w: index %d", ErrNilContent, i)
+		}
+		digest, err := cfg.hashLeaf(c)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.PutNode(0, i, digest); err != nil {
+			return nil, err
+		}
+	}
+
+	h := cfg.hashStrategy()
+	height := cfg.storedHeight(size)
+	for level, count := 0, size; level < height; level, count = level+1, (count+1)/2 {
+		for j := 0; j < (count+1)/2; j++ {
+			left, err := store.GetNode(level, 2*j)
+			if err != nil {
+				return nil, err
+			}
+			var parent []byte
+			switch {
+			case 2*j+1 < count:
+				right, err := store.GetNode(level, 2*j+1)
+				if err != nil {
+					return nil, err
+				}
+				parent, err = cfg.appendInteriorHash(h, nil, left, right)
+				if err != nil {
+					return nil, err
+				}
+			case cfg.rfc6962:
+				parent = left
+			default:
+				parent, err = cfg.appendInteriorHash(h, nil, left, left)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if err := store.PutNode(level+1, j, parent); err != nil {
+				return nil, err
+			}
+		}
+	}
+	root, err := store.GetNode(height, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoredTree{cfg: cfg, store: store, size: size, root: root}, nil
+}
+
+// OpenStoredTree returns the tree of size leaves already built into store, as by an
+// earlier NewStoredTree with the same options, reading its root from the store. The
+// store is trusted: nothing below the root is read or checked until a path is asked
+// for.
+func OpenStoredTree(store NodeStore, size int, opts ...TreeOption) (*StoredTree, error) {
+	cfg, err := configFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.requireBinary("a StoredTree"); err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return nil, errors.New("error: OpenStoredTree requires a NodeStore")
+	}
+	if size < 1 {
+		return nil, ErrNoContent
+	}
+	root, err := store.GetNode(cfg.storedHeight(size), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoredTree{cfg: cfg, store: store, size: size, root: root}, nil
+}
+
+// storedHeight returns the level of the root of a stored tree of size leaves. A single
+// leaf is the root under WithRFC6962 and WithBitcoinCompat, but is paired with its
+// padding copy otherwise, as in a MerkleTree.
+func (m *MerkleTree) storedHeight(size int) int {
+	height := 0
+	for count := size; count > 1; count = (count + 1) / 2 {
+		height++
+	}
+	if height == 0 && m.padsLeaves(size) {
+		height = 1
+	}
+
+	return height
+}
+
+// Len returns the number of content items the tree was built over.
+func (t *StoredTree) Len() int {
+	return t.size
+}
+
+// MerkleRoot returns the root hash. The slice is the tree's own; treat it as read only.
+func (t *StoredTree) MerkleRoot() []byte {
+	return t.root
+}
+
+// Sorted reports whether the tree was built with WithSortedSiblings.
+func (t *StoredTree) Sorted() bool {
+	return t.cfg.sort
+}
+
+// RFC6962 reports whether the tree was built with WithRFC6962.
+func (t *StoredTree) RFC6962() bool {
+	return t.cfg.rfc6962
+}
+
+// GetMerklePathByIndex returns the audit path for leaf i and the side each sibling sits
+// on, exactly as MerkleTree.GetMerklePathByIndex returns them for the same tree. It
+// reads one node per level from the store.
+//
+// Returns ErrContentNotFound if there is no leaf i. The padding leaf of a MerkleTree is
+// not addressable here.
+func (t *StoredTree) GetMerklePathByIndex(i int) ([][]byte, []int64, error) {
+	if i < 0 || i >= t.size {
+		return nil, nil, fmt.Errorf("%w: no leaf at index %d, the tree has %d", ErrContentNotFound, i, t.size)
+	}
+
+	height := t.cfg.storedHeight(t.size)
+	path := make([][]byte, 0, height)
+	index := make([]int64, 0, height)
+	for level, j, count := 0, i, t.size; level < height; level, j, count = level+1, j/2, (count+1)/2 {
+		sibling := j ^ 1
+		if sibling >= count {
+			if t.cfg.rfc6962 {
+				continue
+			}
+			sibling = j
+		}
+		hash, err := t.store.GetNode(level, sibling)
+		if err != nil {
+			return nil, nil, err
+		}
+		path = append(path, hash)
+		if j%2 == 0 {
+			index = append(index, 1)
+		} else {
+			index = append(index, 0)
+		}
+	}
+
+	return path, index, nil
+}
+
+// GetProofByIndex returns the audit path for leaf i as a Proof, as
+// MerkleTree.GetProofByIndex does.
+func (t *StoredTree) GetProofByIndex(i int) (*Proof, error) {
+	path, _, err := t.GetMerklePathByIndex(i)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := lookupHashStrategyName(t.cfg.hashStrategy)
+
+	return &Proof{
+		LeafIndex:    i,
+		TreeSize:     t.size,
+		Sorted:       t.cfg.sort,
+		RFC6962:      t.cfg.rfc6962,
+		HashStrategy: name,
+		Siblings:     path,
+	}, nil
+}
+
+// VerifyProof reports whether path and index prove content to be held by this tree. It
+// is MerkleTree.VerifyProof for a stored tree, and checks against the tree's root
+// without reading the store.
+func (t *StoredTree) VerifyProof(content Content, path [][]byte, index []int64) (bool, error) {
+	if content == nil {
+		return false, ErrNilContent
+	}
+	if len(path) != len(index) {
+		return false, fmt.Errorf("%w: path has %d entries and the index has %d", ErrMalformedProof, len(path), len(index))
+	}
+	digest, err := content.CalculateHash()
+	if err != nil {
+		return false, err
+	}
+
+	return t.cfg.proofReproducesRoot(digest, path, index, t.root)
+}
+
+// VerifyContent reports whether leaf i holds content, by replaying the path the store
+// gives for the leaf against the root.
+func (t *StoredTree) VerifyContent(i int, content Content) (bool, error) {
+	if content == nil {
+		return false, ErrNilContent
+	}
+	path, index, err := t.GetMerklePathByIndex(i)
+	if err != nil {
+		return false, err
+	}
+
+	return t.VerifyProof(content, path, index)
+}
@@ -0,0 +1,234 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// TestStoredTreeMatchesMerkleTree checks a stored tree has the root of the MerkleTree
+// built from the same content and serves the same paths, for every construction and in
+// both stores.
+func TestStoredTreeMatchesMerkleTree(t *testing.T) {
+	for _, mode := range propModes {
+		for _, n := range propSizes {
+			t.Run(fmt.Sprintf("%s/n=%d", mode.name, n), func(t *testing.T) {
+				contents := propSeries(n)
+				tree, err := mode.build(contents, sha256.New)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				files, err := NewFileNodeStore(t.TempDir(), sha256.Size)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				defer files.Close()
+
+				for name, store := range map[string]NodeStore{"memory": NewMemoryNodeStore(), "file": files} {
+					stored, err := NewStoredTree(store, n, contentAt(contents), optsFor(mode, sha256.New)...)
+					if err != nil {
+						t.Fatalf("error: %s: NewStoredTree: %v", name, err)
+					}
+					if !bytes.Equal(stored.MerkleRoot(), tree.MerkleRoot()) {
+						t.Fatalf("error: %s: root %x, MerkleTree %x", name, stored.MerkleRoot(), tree.MerkleRoot())
+					}
+					if stored.Len() != n || stored.Sorted() != mode.sorted || stored.RFC6962() != mode.rfc6962 {
+						t.Errorf("error: %s: tree reports the wrong size or construction", name)
+					}
+
+					for i, c := range contents {
+						path, index, err := stored.GetMerklePathByIndex(i)
+						if err != nil {
+							t.Fatalf("error: %s: GetMerklePathByIndex(%d): %v", name, i, err)
+						}
+						wantPath, wantIndex, err := tree.GetMerklePathByIndex(i)
+						if err != nil {
+							t.Fatalf("error: unexpected error: %v", err)
+						}
+						if !reflect.DeepEqual(path, wantPath) || !reflect.DeepEqual(index, wantIndex) {
+							t.Fatalf("error: %s: leaf %d: path differs from the MerkleTree's", name, i)
+						}
+						if ok, err := stored.VerifyContent(i, c); err != nil || !ok {
+							t.Errorf("error: %s: leaf %d: VerifyContent returned %v, %v", name, i, ok, err)
+						}
+						proof, err := stored.GetProofByIndex(i)
+						if err != nil {
+							t.Fatalf("error: unexpected error: %v", err)
+						}
+						if ok, err := proof.Verify(c, tree.MerkleRoot(), optsFor(mode, sha256.New)...); err != nil || !ok {
+							t.Errorf("error: %s: leaf %d: Proof did not verify (%v)", name, i, err)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestStoredTreeReopen checks a tree built into files can be opened again from them
+// alone and serves the same proofs.
+func TestStoredTreeReopen(t *testing.T) {
+	dir := t.TempDir()
+	contents := propSeries(37)
+
+	store, err := NewFileNodeStore(dir, sha256.Size)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	built, err := NewStoredTree(store, len(contents), contentAt(contents), WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	want, _, err := built.GetMerklePathByIndex(20)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	reopened, err := NewFileNodeStore(dir, sha256.Size)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	defer reopened.Close()
+	tree, err := OpenStoredTree(reopened, len(contents), WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: OpenStoredTree: %v", err)
+	}
+	if !bytes.Equal(tree.MerkleRoot(), built.MerkleRoot()) {
+		t.Fatal("error: reopened tree has a different root")
+	}
+	path, index, err := tree.GetMerklePathByIndex(20)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(path, want) {
+		t.Error("error: reopened tree serves a different path")
+	}
+	if ok, err := tree.VerifyProof(contents[20], path, index); err != nil || !ok {
+		t.Errorf("error: VerifyProof returned %v, %v", ok, err)
+	}
+	if ok, _ := tree.VerifyProof(contents[21], path, index); ok {
+		t.Error("error: VerifyProof accepted the path for different content")
+	}
+
+	if _, err := OpenStoredTree(reopened, 1000, WithRFC6962()); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("error: opening at a size never built: expected ErrNodeNotFound, got %v", err)
+	}
+}
+
+// TestStoredTreeErrors covers the inputs a stored tree refuses.
+func TestStoredTreeErrors(t *testing.T) {
+	store := NewMemoryNodeStore()
+	if _, err := NewStoredTree(store, 0, contentAt(nil)); !errors.Is(err, ErrNoContent) {
+		t.Errorf("error: expected ErrNoContent, got %v", err)
+	}
+	if _, err := NewStoredTree(store, 2, contentAt([]Content{propContent{x: "a"}, nil})); !errors.Is(err, ErrNilContent) {
+		t.Errorf("error: expected ErrNilContent, got %v", err)
+	}
+	if _, err := NewStoredTree(nil, 2, contentAt(propSeries(2))); err == nil {
+		t.Error("error: expected a nil store to be rejected")
+	}
+	if _, err := NewStoredTree(store, 2, nil); err == nil {
+		t.Error("error: expected a nil leaf function to be rejected")
+	}
+	errRead := errors.New("error: read failed")
+	failing := func(i int) (Content, error) {
+		if i == 3 {
+			return nil, errRead
+		}
+		return propContent{x: fmt.Sprint(i)}, nil
+	}
+	if _, err := NewStoredTree(store, 5, failing); !errors.Is(err, errRead) {
+		t.Errorf("error: expected the leaf function's error, got %v", err)
+	}
+	if _, err := OpenStoredTree(store, 0); !errors.Is(err, ErrNoContent) {
+		t.Errorf("error: expected ErrNoContent, got %v", err)
+	}
+
+	tree, err := NewStoredTree(store, 5, contentAt(propSeries(5)))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for _, i := range []int{-1, 5} {
+		if _, _, err := tree.GetMerklePathByIndex(i); !errors.Is(err, ErrContentNotFound) {
+			t.Errorf("error: index %d: expected ErrContentNotFound, got %v", i, err)
+		}
+	}
+	if _, err := tree.VerifyProof(propContent{x: "a"}, [][]byte{{1}}, nil); !errors.Is(err, ErrMalformedProof) {
+		t.Errorf("error: expected ErrMalformedProof, got %v", err)
+	}
+
+	// The default construction stores whatever CalculateHash returns as the leaf, so
+	// a file store sized for the hash strategy refuses a digest of another width.
+	files, err := NewFileNodeStore(t.TempDir(), sha256.Size)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	defer files.Close()
+	if _, err := NewStoredTree(files, 2, contentAt([]Content{TestSHA256Content{x: "a"}, TestSHA256Content{x: "b"}}), WithHasher(sha256.New)); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if _, err := NewStoredTree(files, 1, contentAt([]Content{shortContent{}})); err == nil {
+		t.Error("error: expected a digest of the wrong width to be refused")
+	}
+}
+
+// TestStoredTreeStreamsLeaves checks NewStoredTree asks for each leaf once, in order,
+// and builds the tree it would from the same content held as a slice.
+func TestStoredTreeStreamsLeaves(t *testing.T) {
+	const n = 1000
+	var asked []int
+	leaf := func(i int) (Content, error) {
+		asked = append(asked, i)
+		return propContent{x: fmt.Sprint(i)}, nil
+	}
+	tree, err := NewStoredTree(NewMemoryNodeStore(), n, leaf, WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for i, j := range asked {
+		if i != j {
+			t.Fatalf("error: call %d asked for leaf %d", i, j)
+		}
+	}
+	if len(asked) != n {
+		t.Fatalf("error: leaf was called %d times, want %d", len(asked), n)
+	}
+
+	contents := make([]Content, n)
+	for i := range contents {
+		contents[i] = propContent{x: fmt.Sprint(i)}
+	}
+	want, err := NewTreeWithOptions(contents, WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(tree.MerkleRoot(), want.MerkleRoot()) {
+		t.Errorf("error: root %x, MerkleTree %x", tree.MerkleRoot(), want.MerkleRoot())
+	}
+}
+
+// contentAt returns a leaf function for NewStoredTree that serves cs.
+func contentAt(cs []Content) func(i int) (Content, error) {
+	return func(i int) (Content, error) {
+		return cs[i], nil
+	}
+}
+
+// shortContent hashes to a digest narrower than any hash strategy's.
+type shortContent struct{}
+
+func (shortContent) CalculateHash() ([]byte, error) { return []byte{1, 2, 3}, nil }
+
+func (shortContent) Equals(other Content) (bool, error) {
+	_, ok := other.(shortContent)
+	return ok, nil
+}
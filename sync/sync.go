@@ -0,0 +1,629 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+// Package sync reconciles two replicas of a merkletree.MerkleTree over a byte stream,
+// shipping only the leaves on which they differ.
+//
+// One replica calls Serve with its tree and the other Pull or Sync with its own, on the
+// two ends of any io.ReadWriter: a net.Conn across the network, or net.Pipe in a test.
+// Pull compares roots first, then asks for the hashes of every node on a chosen level of
+// the remote tree, then for the children of just those nodes that differ from its own,
+// and so down to the leaves, so each round trip descends a level and the traffic is
+// proportional to the differences rather than to the tree. Last it asks for the content
+// of the differing leaves, which Serve encodes with a merkletree.ContentMarshalFunc
+// and Pull decodes with the matching merkletree.ContentUnmarshalFunc.
+//
+// Both trees must hold the same number of items under the same construction and hash
+// strategy, which is what gives them the same shape; replicas of a fixed set of slots,
+// say. The protocol checks the item count and the construction flags and refuses to go
+// on if they differ. It cannot check the hash strategy, and replicas hashing differently
+// simply differ everywhere.
+//
+// The package shares its name with the standard library's sync, so importing both in
+// one file needs a name for one of them:
+//
+//	import merklesync "github.com/cbergoon/merkletree/sync"
+package sync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cbergoon/merkletree"
+)
+
+// protocolVersion is sent in the opening exchange. A peer speaking another version is
+// refused rather than guessed at.
+const protocolVersion = 1
+
+// Message types. Every request is answered by a message of the same type, or by
+// msgError when the server cannot answer it.
+const (
+	msgHello byte = iota + 1
+	msgHashes
+	msgLeaves
+	msgDone
+	msgError
+)
+
+const (
+	// maxMessage bounds the payload of a single message, so that a corrupt or
+	// hostile length prefix cannot make the reader allocate without limit.
+	maxMessage = 64 << 20
+	// maxHashBatch and maxLeafBatch bound how many nodes and leaves one request asks
+	// for. Larger sets are fetched over several requests.
+	maxHashBatch = 4096
+	maxLeafBatch = 256
+	// defaultStartNodes is the most nodes the level Pull starts on holds, when no
+	// level is chosen with WithLevel.
+	defaultStartNodes = 256
+)
+
+var (
+	// ErrProtocol is returned when the peer sends something the protocol does not
+	// allow: a malformed or oversized message, or one out of turn. Test for it with
+	// errors.Is.
+	ErrProtocol = errors.New("sync: protocol error")
+	// ErrRemote is returned when the peer reports that it could not answer a request.
+	// The error carries the peer's message. Test for it with errors.Is.
+	ErrRemote = errors.New("sync: remote error")
+	// ErrSizeMismatch is returned when the two trees hold different numbers of items
+	// and so cannot be compared node for node. Test for it with errors.Is.
+	ErrSizeMismatch = errors.New("sync: trees hold different numbers of items")
+	// ErrDiverged is returned by Sync when the tree does not have the remote root
+	// after the differing leaves are applied, which means the remote tree changed
+	// during the exchange. Test for it with errors.Is.
+	ErrDiverged = errors.New("sync: tree does not match the remote root after syncing")
+)
+
+// Option adjusts how Pull and Sync reconcile.
+type Option func(*config)
+
+type config struct {
+	level    int
+	levelSet bool
+}
+
+// WithLevel starts the comparison at level, counting up from the leaves at level zero,
+// rather than at the lowest level of at most 256 nodes. A lower level costs a larger
+// first exchange and saves round trips; the root's level costs the fewest hashes and the
+// most round trips. A level above the root is taken as the root's.
+func WithLevel(level int) Option {
+	return func(c *config) {
+		c.level, c.levelSet = level, true
+	}
+}
+
+// Serve answers the requests a peer running Pull or Sync makes about tree, encoding
+// leaf content with enc, until the peer finishes or closes the stream. It returns nil
+// when the peer finishes or hangs up between requests, and an error if the stream
+// fails or the peer breaks the protocol.
+//
+// The tree must not change while it is being served.
+func Serve(rw io.ReadWriter, tree *merkletree.MerkleTree, enc merkletree.ContentMarshalFunc) error {
+	if tree == nil || tree.Len() == 0 {
+		return fmt.Errorf("%w: tree has no root", merkletree.ErrMalformedTree)
+	}
+	if enc == nil {
+		return errors.New("sync: Serve requires a ContentMarshalFunc")
+	}
+	if err := checkArity(tree); err != nil {
+		return err
+	}
+
+	c := newConn(rw)
+	for {
+		typ, payload, err := c.read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var reply []byte
+		switch typ {
+		case msgHello:
+			reply = appendHello(nil, tree)
+		case msgHashes:
+			reply, err = answerHashes(tree, payload)
+		case msgLeaves:
+			reply, err = answerLeaves(tree, payload, enc)
+		case msgDone:
+			return c.write(msgDone, nil)
+		default:
+			err = fmt.Errorf("%w: unexpected message type %d", ErrProtocol, typ)
+		}
+		if err != nil {
+			// Tell the peer why before giving up, so it does not wait on a reply
+			// that is never coming.
+			return errors.Join(err, c.write(msgError, []byte(err.Error())))
+		}
+		if err := c.write(typ, reply); err != nil {
+			return err
+		}
+	}
+}
+
+// answerHashes answers a request for the hashes of a set of nodes on one level.
+func answerHashes(tree *merkletree.MerkleTree, payload []byte) ([]byte, error) {
+	r := &reader{data: payload}
+	level := r.int()
+	indices := r.runs(maxHashBatch)
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+
+	reply := binary.AppendUvarint(nil, uint64(len(indices)))
+	for _, j := range indices {
+		hash, err := tree.NodeHash(level, j)
+		if err != nil {
+			return nil, err
+		}
+		reply = appendBytes(reply, hash)
+	}
+
+	return reply, nil
+}
+
+// answerLeaves answers a request for the content of a set of leaves.
+func answerLeaves(tree *merkletree.MerkleTree, payload []byte, enc merkletree.ContentMarshalFunc) ([]byte, error) {
+	r := &reader{data: payload}
+	indices := r.runs(maxLeafBatch)
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+
+	reply := binary.AppendUvarint(nil, uint64(len(indices)))
+	for _, i := range indices {
+		c, err := tree.ContentAt(i)
+		if err != nil {
+			return nil, err
+		}
+		data, err := enc(c)
+		if err != nil {
+			return nil, fmt.Errorf("sync: marshaling content at index %d: %w", i, err)
+		}
+		reply = appendBytes(reply, data)
+	}
+
+	return reply, nil
+}
+
+// Pull compares tree with the tree served at the other end of rw and returns the
+// remote content of every leaf on which the two differ, keyed by position and decoded
+// with dec. tree is not modified; the result is in the form UpdateLeaves takes, and
+// applying it gives tree the remote root:
+//
+//	updates, err := merklesync.Pull(conn, tree, dec)
+//	...
+//	err = tree.UpdateLeaves(updates)
+//
+// Sync does both. Returns an empty result without descending when the roots agree, an
+// error wrapping ErrSizeMismatch when the trees hold different numbers of items, and
+// one wrapping merkletree.ErrConstructionMismatch when they are built differently.
+func Pull(rw io.ReadWriter, tree *merkletree.MerkleTree, dec merkletree.ContentUnmarshalFunc, opts ...Option) (map[int]merkletree.Content, error) {
+	updates, _, err := pull(rw, tree, dec, opts)
+
+	return updates, err
+}
+
+// Sync pulls the leaves on which tree differs from the tree served at the other end of
+// rw and applies them with UpdateLeaves, so that tree ends up identical to the remote
+// one. It returns the number of leaves changed. If tree does not then have the remote
+// root, the remote tree changed during the exchange, and the returned error wraps
+// ErrDiverged; tree holds the leaves as they were sent, and syncing again converges.
+func Sync(rw io.ReadWriter, tree *merkletree.MerkleTree, dec merkletree.ContentUnmarshalFunc, opts ...Option) (int, error) {
+	updates, root, err := pull(rw, tree, dec, opts)
+	if err != nil {
+		return 0, err
+	}
+	if err := tree.UpdateLeaves(updates); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(tree.MerkleRoot(), root) {
+		return len(updates), ErrDiverged
+	}
+
+	return len(updates), nil
+}
+
+// pull is Pull, also returning the remote root.
+func pull(rw io.ReadWriter, tree *merkletree.MerkleTree, dec merkletree.ContentUnmarshalFunc, opts []Option) (map[int]merkletree.Content, []byte, error) {
+	if tree == nil || tree.Len() == 0 {
+		return nil, nil, fmt.Errorf("%w: tree has no root", merkletree.ErrMalformedTree)
+	}
+	if dec == nil {
+		return nil, nil, errors.New("sync: Pull requires a ContentUnmarshalFunc")
+	}
+	if err := checkArity(tree); err != nil {
+		return nil, nil, err
+	}
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	c := newConn(rw)
+	payload, err := c.call(msgHello, appendHello(nil, tree))
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := checkHello(tree, payload)
+	if err != nil {
+		return nil, nil, errors.Join(err, c.finish())
+	}
+	updates := make(map[int]merkletree.Content)
+	if bytes.Equal(root, tree.MerkleRoot()) {
+		return updates, root, c.finish()
+	}
+
+	level := startLevel(tree, cfg)
+	indices := make([]int, tree.LevelSize(level))
+	for j := range indices {
+		indices[j] = j
+	}
+	for {
+		differ, err := c.differingNodes(tree, level, indices)
+		if err != nil {
+			return nil, nil, err
+		}
+		if level == 0 {
+			indices = differ
+
+			break
+		}
+		level--
+		indices = indices[:0]
+		for _, j := range differ {
+			indices = append(indices, 2*j)
+			if 2*j+1 < tree.LevelSize(level) {
+				indices = append(indices, 2*j+1)
+			}
+		}
+	}
+
+	// The padding leaf differs exactly when the leaf it copies does, and is not
+	// content of its own.
+	for len(indices) > 0 && indices[len(indices)-1] >= tree.Len() {
+		indices = indices[:len(indices)-1]
+	}
+	for lo := 0; lo < len(indices); lo += maxLeafBatch {
+		batch := indices[lo:min(lo+maxLeafBatch, len(indices))]
+		payload, err := c.call(msgLeaves, appendRuns(nil, batch))
+		if err != nil {
+			return nil, nil, err
+		}
+		r := &reader{data: payload}
+		if n := r.int(); r.err == nil && n != len(batch) {
+			return nil, nil, fmt.Errorf("%w: asked for %d leaves and got %d", ErrProtocol, len(batch), n)
+		}
+		for _, i := range batch {
+			data := r.bytes()
+			if r.err != nil {
+				return nil, nil, r.err
+			}
+			content, err := dec(data)
+			if err != nil {
+				return nil, nil, fmt.Errorf("sync: unmarshaling content at index %d: %w", i, err)
+			}
+			updates[i] = content
+		}
+		if err := r.done(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return updates, root, c.finish()
+}
+
+// startLevel returns the level Pull begins comparing on.
+func startLevel(tree *merkletree.MerkleTree, cfg config) int {
+	level := tree.Height()
+	if cfg.levelSet {
+		return max(0, min(cfg.level, level))
+	}
+	for level > 0 && tree.LevelSize(level-1) <= defaultStartNodes {
+		level--
+	}
+
+	return level
+}
+
+// differingNodes fetches the remote hashes of the nodes at indices, ascending, on level,
+// and returns the indices at which they differ from tree's.
+func (c *conn) differingNodes(tree *merkletree.MerkleTree, level int, indices []int) ([]int, error) {
+	var differ []int
+	for lo := 0; lo < len(indices); lo += maxHashBatch {
+		batch := indices[lo:min(lo+maxHashBatch, len(indices))]
+		req := binary.AppendUvarint(nil, uint64(level))
+		payload, err := c.call(msgHashes, appendRuns(req, batch))
+		if err != nil {
+			return nil, err
+		}
+		r := &reader{data: payload}
+		if n := r.int(); r.err == nil && n != len(batch) {
+			return nil, fmt.Errorf("%w: asked for %d hashes and got %d", ErrProtocol, len(batch), n)
+		}
+		for _, j := range batch {
+			remote := r.bytes()
+			if r.err != nil {
+				return nil, r.err
+			}
+			local, err := tree.NodeHash(level, j)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(local, remote) {
+				differ = append(differ, j)
+			}
+		}
+		if err := r.done(); err != nil {
+			return nil, err
+		}
+	}
+
+	return differ, nil
+}
+
+// appendHello appends the opening message describing tree: the protocol version, the
+// construction flags, the item count and the root.
+func appendHello(dst []byte, tree *merkletree.MerkleTree) []byte {
+	var flags byte
+	if tree.Sorted() {
+		flags |= 1
+	}
+	if tree.RFC6962() {
+		flags |= 2
+	}
+	dst = binary.AppendUvarint(dst, protocolVersion)
+	dst = append(dst, flags)
+	dst = binary.AppendUvarint(dst, uint64(tree.Len()))
+
+	return appendBytes(dst, tree.MerkleRoot())
+}
+
+// checkArity returns an error wrapping merkletree.ErrConstructionMismatch for a tree
+// built with merkletree.WithArity, whose nodes the protocol's binary descent cannot
+// address.
+func checkArity(tree *merkletree.MerkleTree) error {
+	if k := tree.Arity(); k != 2 {
+		return fmt.Errorf("%w: sync is only defined for binary trees, and this one has arity %d", merkletree.ErrConstructionMismatch, k)
+	}
+
+	return nil
+}
+
+// checkHello parses the remote's opening message, checks it describes a tree that can
+// be compared with tree, and returns the remote root.
+func checkHello(tree *merkletree.MerkleTree, payload []byte) ([]byte, error) {
+	r := &reader{data: payload}
+	version := r.int()
+	flags := r.byte()
+	size := r.int()
+	root := r.bytes()
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case version != protocolVersion:
+		return nil, fmt.Errorf("%w: peer speaks version %d, this build speaks %d", ErrProtocol, version, protocolVersion)
+	case flags&1 != 0 != tree.Sorted():
+		return nil, fmt.Errorf("%w: one tree sorts siblings and the other does not", merkletree.ErrConstructionMismatch)
+	case flags&2 != 0 != tree.RFC6962():
+		return nil, fmt.Errorf("%w: one tree was built with WithRFC6962 and the other was not", merkletree.ErrConstructionMismatch)
+	case size != tree.Len():
+		return nil, fmt.Errorf("%w: %d here and %d at the peer", ErrSizeMismatch, tree.Len(), size)
+	}
+
+	return root, nil
+}
+
+// conn frames messages on a stream: a type byte, a uvarint payload length and the
+// payload.
+type conn struct {
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func newConn(rw io.ReadWriter) *conn {
+	return &conn{r: bufio.NewReader(rw), w: bufio.NewWriter(rw)}
+}
+
+// write sends one message.
+func (c *conn) write(typ byte, payload []byte) error {
+	if len(payload) > maxMessage {
+		return fmt.Errorf("%w: a %d byte message exceeds the %d byte limit", ErrProtocol, len(payload), maxMessage)
+	}
+	header := binary.AppendUvarint([]byte{typ}, uint64(len(payload)))
+	if _, err := c.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(payload); err != nil {
+		return err
+	}
+
+	return c.w.Flush()
+}
+
+// read receives one message. It returns io.EOF only when the stream ends cleanly
+// before a message begins.
+func (c *conn) read() (byte, []byte, error) {
+	typ, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: reading message length: %w", ErrProtocol, noEOF(err))
+	}
+	if n > maxMessage {
+		return 0, nil, fmt.Errorf("%w: a %d byte message exceeds the %d byte limit", ErrProtocol, n, maxMessage)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, fmt.Errorf("%w: reading message: %w", ErrProtocol, noEOF(err))
+	}
+
+	return typ, payload, nil
+}
+
+// noEOF reports a stream that ends inside a message as the truncation it is, rather
+// than as the clean end io.EOF signals.
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// call sends a request and returns the payload of the reply, which must be of the same
+// type.
+func (c *conn) call(typ byte, payload []byte) ([]byte, error) {
+	if err := c.write(typ, payload); err != nil {
+		return nil, err
+	}
+	replyType, reply, err := c.read()
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	switch replyType {
+	case typ:
+		return reply, nil
+	case msgError:
+		return nil, fmt.Errorf("%w: %s", ErrRemote, reply)
+	default:
+		return nil, fmt.Errorf("%w: sent message type %d and got type %d in reply", ErrProtocol, typ, replyType)
+	}
+}
+
+// finish tells the server the exchange is over and waits for it to agree.
+func (c *conn) finish() error {
+	_, err := c.call(msgDone, nil)
+
+	return err
+}
+
+// appendBytes appends b with a uvarint length prefix.
+func appendBytes(dst, b []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(b)))
+
+	return append(dst, b...)
+}
+
+// appendRuns appends indices, ascending, as runs of consecutive positions: a count of
+// runs, then each run's first position and length. The children of differing nodes come
+// in pairs and a first level comes whole, so runs are far shorter than the positions.
+func appendRuns(dst []byte, indices []int) []byte {
+	var runs [][2]int
+	for _, i := range indices {
+		if k := len(runs) - 1; k >= 0 && runs[k][0]+runs[k][1] == i {
+			runs[k][1]++
+
+			continue
+		}
+		runs = append(runs, [2]int{i, 1})
+	}
+	dst = binary.AppendUvarint(dst, uint64(len(runs)))
+	for _, run := range runs {
+		dst = binary.AppendUvarint(dst, uint64(run[0]))
+		dst = binary.AppendUvarint(dst, uint64(run[1]))
+	}
+
+	return dst
+}
+
+// reader decodes a message payload. The first failure is kept in err and every read
+// after it returns zero values, so a parse can run to the end and check once.
+type reader struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (r *reader) fail(format string, args ...any) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: "+format, append([]any{ErrProtocol}, args...)...)
+	}
+}
+
+// int reads a uvarint that must fit in an int.
+func (r *reader) int() int {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data[r.off:])
+	if n <= 0 || v > uint64(maxMessage)*8 {
+		r.fail("malformed integer at offset %d", r.off)
+
+		return 0
+	}
+	r.off += n
+
+	return int(v)
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if r.off >= len(r.data) {
+		r.fail("message ends at offset %d", r.off)
+
+		return 0
+	}
+	r.off++
+
+	return r.data[r.off-1]
+}
+
+// bytes reads a length-prefixed byte string. The result aliases the payload.
+func (r *reader) bytes() []byte {
+	n := r.int()
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.data)-r.off {
+		r.fail("a %d byte field overruns the message at offset %d", n, r.off)
+
+		return nil
+	}
+	r.off += n
+
+	return r.data[r.off-n : r.off : r.off]
+}
+
+// runs reads what appendRuns writes and returns the positions, refusing more than limit
+// of them.
+func (r *reader) runs(limit int) []int {
+	count := r.int()
+	var indices []int
+	for k := 0; k < count && r.err == nil; k++ {
+		begin, length := r.int(), r.int()
+		if len(indices)+length > limit {
+			r.fail("request for more than %d positions", limit)
+
+			return nil
+		}
+		for i := begin; i < begin+length; i++ {
+			indices = append(indices, i)
+		}
+	}
+
+	return indices
+}
+
+// done returns the first failure, or an error if anything is left unread.
+func (r *reader) done() error {
+	if r.err == nil && r.off != len(r.data) {
+		r.fail("%d bytes left over", len(r.data)-r.off)
+	}
+
+	return r.err
+}
@@ -0,0 +1,272 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package sync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/cbergoon/merkletree"
+)
+
+// item is a content type holding an opaque value.
+type item struct {
+	x string
+}
+
+func (t item) CalculateHash() ([]byte, error) {
+	h := sha256.Sum256([]byte(t.x))
+	return h[:], nil
+}
+
+func (t item) Equals(other merkletree.Content) (bool, error) {
+	o, ok := other.(item)
+	if !ok {
+		return false, errors.New("error: value is not of type item")
+	}
+	return t.x == o.x, nil
+}
+
+func encItem(c merkletree.Content) ([]byte, error) { return []byte(c.(item).x), nil }
+
+func decItem(data []byte) (merkletree.Content, error) { return item{x: string(data)}, nil }
+
+var modes = []struct {
+	name string
+	opts []merkletree.TreeOption
+}{
+	{"default", nil},
+	{"sorted", []merkletree.TreeOption{merkletree.WithSortedSiblings()}},
+	{"rfc6962", []merkletree.TreeOption{merkletree.WithRFC6962()}},
+}
+
+func buildTree(t *testing.T, cs []merkletree.Content, opts ...merkletree.TreeOption) *merkletree.MerkleTree {
+	t.Helper()
+	tree, err := merkletree.NewTreeWithOptions(cs, opts...)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	return tree
+}
+
+func items(n int, prefix string) []merkletree.Content {
+	cs := make([]merkletree.Content, n)
+	for i := range cs {
+		cs[i] = item{x: fmt.Sprintf("%s%d", prefix, i)}
+	}
+	return cs
+}
+
+func seq(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
+
+// exchange runs Serve on remote against fn on the other end of a pipe and returns the
+// errors of both.
+func exchange(remote *merkletree.MerkleTree, fn func(net.Conn) error) (serveErr, err error) {
+	a, b := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		defer a.Close()
+		done <- Serve(a, remote, encItem)
+	}()
+	err = fn(b)
+	b.Close()
+	return <-done, err
+}
+
+func TestSyncConverges(t *testing.T) {
+	rng := rand.New(rand.NewSource(15))
+	for _, mode := range modes {
+		for _, n := range []int{1, 2, 3, 7, 8, 9, 100, 1000} {
+			for _, flat := range []bool{false, true} {
+				opts := mode.opts
+				if flat {
+					opts = append(opts[:len(opts):len(opts)], merkletree.WithFlatLayout())
+				}
+				local := items(n, "v")
+				remote := items(n, "v")
+				changed := map[int]bool{}
+				for k := 0; k < 1+n/20; k++ {
+					i := rng.Intn(n)
+					remote[i] = item{x: fmt.Sprintf("w%d", rng.Int())}
+					changed[i] = true
+				}
+				lt := buildTree(t, local, opts...)
+				rt := buildTree(t, remote, opts...)
+
+				var updates map[int]merkletree.Content
+				serveErr, err := exchange(rt, func(c net.Conn) error {
+					var err error
+					updates, err = Pull(c, lt, decItem)
+					return err
+				})
+				if err != nil || serveErr != nil {
+					t.Fatalf("error: %s n=%d flat=%v: Pull %v, Serve %v", mode.name, n, flat, err, serveErr)
+				}
+				if len(updates) != len(changed) {
+					t.Errorf("error: %s n=%d flat=%v: got %d updates, want %d", mode.name, n, flat, len(updates), len(changed))
+				}
+				for i, c := range updates {
+					if !changed[i] || c.(item).x != remote[i].(item).x {
+						t.Errorf("error: %s n=%d flat=%v: unexpected update at %d: %v", mode.name, n, flat, i, c)
+					}
+				}
+				if err := lt.UpdateLeaves(updates); err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				if !bytes.Equal(lt.MerkleRoot(), rt.MerkleRoot()) {
+					t.Errorf("error: %s n=%d flat=%v: roots differ after applying updates", mode.name, n, flat)
+				}
+			}
+		}
+	}
+}
+
+func TestSyncApplies(t *testing.T) {
+	local := items(500, "v")
+	remote := items(500, "v")
+	remote[0], remote[250], remote[499] = item{x: "a"}, item{x: "b"}, item{x: "c"}
+	lt := buildTree(t, local)
+	rt := buildTree(t, remote)
+
+	for _, want := range []int{3, 0} {
+		var n int
+		serveErr, err := exchange(rt, func(c net.Conn) error {
+			var err error
+			n, err = Sync(c, lt, decItem)
+			return err
+		})
+		if err != nil || serveErr != nil {
+			t.Fatalf("error: Sync %v, Serve %v", err, serveErr)
+		}
+		if n != want {
+			t.Errorf("error: Sync changed %d leaves, want %d", n, want)
+		}
+		if !bytes.Equal(lt.MerkleRoot(), rt.MerkleRoot()) {
+			t.Errorf("error: roots differ after Sync")
+		}
+	}
+}
+
+func TestPullLevels(t *testing.T) {
+	local := items(300, "v")
+	remote := items(300, "v")
+	remote[17] = item{x: "changed"}
+	lt := buildTree(t, local)
+	rt := buildTree(t, remote)
+
+	for _, level := range []int{-1, 0, 3, lt.Height(), lt.Height() + 5} {
+		var updates map[int]merkletree.Content
+		serveErr, err := exchange(rt, func(c net.Conn) error {
+			var err error
+			updates, err = Pull(c, lt, decItem, WithLevel(level))
+			return err
+		})
+		if err != nil || serveErr != nil {
+			t.Fatalf("error: level %d: Pull %v, Serve %v", level, err, serveErr)
+		}
+		if len(updates) != 1 || updates[17] == nil {
+			t.Errorf("error: level %d: got updates %v, want leaf 17 alone", level, updates)
+		}
+	}
+}
+
+func TestPullMismatch(t *testing.T) {
+	tree := buildTree(t, items(10, "v"))
+	for _, tc := range []struct {
+		name   string
+		remote *merkletree.MerkleTree
+		want   error
+	}{
+		{"size", buildTree(t, items(11, "v")), ErrSizeMismatch},
+		{"sorted", buildTree(t, items(10, "v"), merkletree.WithSortedSiblings()), merkletree.ErrConstructionMismatch},
+		{"rfc6962", buildTree(t, items(10, "v"), merkletree.WithRFC6962()), merkletree.ErrConstructionMismatch},
+	} {
+		serveErr, err := exchange(tc.remote, func(c net.Conn) error {
+			_, err := Pull(c, tree, decItem)
+			return err
+		})
+		if !errors.Is(err, tc.want) {
+			t.Errorf("error: %s: got %v, want %v", tc.name, err, tc.want)
+		}
+		if serveErr != nil {
+			t.Errorf("error: %s: Serve returned %v", tc.name, serveErr)
+		}
+	}
+}
+
+func TestServeRejectsBadRequests(t *testing.T) {
+	tree := buildTree(t, items(10, "v"))
+	for _, tc := range []struct {
+		name    string
+		typ     byte
+		payload []byte
+	}{
+		{"unknown type", 0x7f, nil},
+		{"node out of range", msgHashes, appendRuns([]byte{0}, []int{10, 11, 12})},
+		{"leaf out of range", msgLeaves, appendRuns(nil, []int{9, 10})},
+		{"too many leaves", msgLeaves, appendRuns(nil, seq(maxLeafBatch+1))},
+		{"trailing bytes", msgLeaves, append(appendRuns(nil, []int{0}), 0)},
+		{"truncated", msgHashes, []byte{0, 1}},
+	} {
+		serveErr, err := exchange(tree, func(rw net.Conn) error {
+			_, err := newConn(rw).call(tc.typ, tc.payload)
+			return err
+		})
+		if !errors.Is(err, ErrRemote) {
+			t.Errorf("error: %s: client got %v, want ErrRemote", tc.name, err)
+		}
+		if serveErr == nil {
+			t.Errorf("error: %s: Serve returned nil", tc.name)
+		}
+	}
+}
+
+func TestPullRejectsBadReplies(t *testing.T) {
+	tree := buildTree(t, items(10, "v"))
+	for _, tc := range []struct {
+		name  string
+		reply []byte
+	}{
+		{"wrong type", []byte{msgLeaves, 0}},
+		{"oversized", []byte{msgHello, 0xff, 0xff, 0xff, 0xff, 0x7f}},
+		{"truncated", []byte{msgHello, 10, 1}},
+	} {
+		a, b := net.Pipe()
+		go func() {
+			defer a.Close()
+			if _, _, err := newConn(a).read(); err == nil {
+				a.Write(tc.reply)
+			}
+		}()
+		_, err := Pull(b, tree, decItem)
+		b.Close()
+		if !errors.Is(err, ErrProtocol) {
+			t.Errorf("error: %s: got %v, want ErrProtocol", tc.name, err)
+		}
+	}
+}
+
+func TestRunsRoundTrip(t *testing.T) {
+	for _, indices := range [][]int{nil, {0}, {0, 1, 2, 3}, {1, 2, 5, 6, 7, 100}} {
+		r := &reader{data: appendRuns(nil, indices)}
+		got := r.runs(maxHashBatch)
+		if err := r.done(); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(indices) && len(got)+len(indices) != 0 {
+			t.Errorf("error: got %v, want %v", got, indices)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+// Package tiles stores the hashes of an RFC 6962 merkletree.MerkleTree as tiles, and
+// serves roots, inclusion proofs and consistency proofs from the tiles alone.
+//
+// A tile of height h holds 2^h consecutive hashes of one level of the tree: tile n at
+// tile level l holds the roots of the perfect subtrees of 2^(l*h) leaves numbered from
+// n*2^h. Only whole subtrees are stored, and a whole subtree never changes as leaves are
+// appended, so a full tile is written once and never again. The tile at the growing
+// right hand edge is written partial, named by its width, and is superseded by wider
+// ones as the tree grows, each left in place for readers of the sizes it served. Files
+// that never change can be served by any static file server and cached without limit,
+// which is why Go's checksum database and static-ct logs publish their trees this way.
+//
+// The layout is the one golang.org/x/mod/sumdb/tlog defines, so the tiles of a tree
+// built here can be read by tlog and by other tools that speak it:
+//
+//	tile/8/0/001         the full tile 1 of leaf hashes, of height 8
+//	tile/8/1/x001/234    the full tile 1234 of tile level 1
+//	tile/8/0/002.p/17    a partial tile of the first 17 hashes of tile 2
+//
+// Tiles hold SHA-256 hashes under the RFC 6962 construction, as tlog's do, so Write
+// takes only trees built with WithRFC6962 and the default hash strategy.
+package tiles
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cbergoon/merkletree"
+)
+
+const (
+	// DefaultHeight is the tile height Go's checksum database and static-ct logs use:
+	// 256 hashes, or 8 KiB, per tile.
+	DefaultHeight = 8
+	// maxHeight is the tallest tile tlog accepts.
+	maxHeight = 30
+)
+
+// ErrShortTile is returned when a tile file holds fewer hashes than its name says, or
+// than a proof needs from it. Test for it with errors.Is.
+var ErrShortTile = errors.New("tiles: tile is shorter than expected")
+
+// Write writes the tiles of tree, of the given height, under dir. Full tiles already in
+// dir are taken to be right and left alone, so writing again after the tree has grown
+// costs the new tiles only. Each file is written to a temporary name and renamed into
+// place, so a reader never sees a tile half written.
+//
+// The tree must be built with WithRFC6962 over SHA-256; otherwise the returned error
+// wraps merkletree.ErrConstructionMismatch.
+func Write(dir string, tree *merkletree.MerkleTree, height int) error {
+	if err := checkHeight(height); err != nil {
+		return err
+	}
+	if err := checkTree(tree); err != nil {
+		return err
+	}
+
+	size := tree.Len()
+	width := 1 << height
+	for level := 0; size>>(level*height) > 0; level++ {
+		count := size >> (level * height)
+		for n := 0; n*width < count; n++ {
+			w := min(width, count-n*width)
+			path := filepath.Join(dir, filepath.FromSlash(tilePath(height, level, n, w)))
+			if w == width {
+				if _, err := os.Stat(path); err == nil {
+					continue
+				}
+			}
+			data := make([]byte, 0, w*sha256.Size)
+			for i := 0; i < w; i++ {
+				hash, err := tree.NodeHash(level*height, n*width+i)
+				if err != nil {
+					return err
+				}
+				data = append(data, hash...)
+			}
+			if err := writeFile(path, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkTree reports whether tree is an RFC 6962 tree over SHA-256, the only kind tiles
+// hold.
+func checkTree(tree *merkletree.MerkleTree) error {
+	if tree == nil || tree.Len() == 0 {
+		return fmt.Errorf("%w: tree has no root", merkletree.ErrMalformedTree)
+	}
+	if !tree.IsRFC6962SHA256() {
+		return fmt.Errorf("%w: tiles hold RFC 6962 trees over SHA-256, build with WithRFC6962 and the default hash strategy", merkletree.ErrConstructionMismatch)
+	}
+
+	return nil
+}
+
+// writeFile writes data to path by way of a temporary file in the same directory.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tile-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// tilePath returns the slash separated path of tile n of tile level level, of the given
+// height, holding width hashes. The tile number is written in groups of three digits,
+// each but the last prefixed with x, so no directory holds more than a thousand entries.
+func tilePath(height, level, n, width int) string {
+	name := fmt.Sprintf("%03d", n%1000)
+	for n /= 1000; n > 0; n /= 1000 {
+		name = fmt.Sprintf("x%03d/%s", n%1000, name)
+	}
+	if width != 1<<height {
+		name += ".p/" + strconv.Itoa(width)
+	}
+
+	return fmt.Sprintf("tile/%d/%d/%s", height, level, name)
+}
+
+func checkHeight(height int) error {
+	if height < 1 || height > maxHeight {
+		return fmt.Errorf("tiles: tile height %d is outside 1 to %d", height, maxHeight)
+	}
+
+	return nil
+}
+
+// Reader serves roots and proofs for a tree from its tiles. It needs nothing but the
+// tile files: not the tree, not its content, and not the leaf count, which every
+// method takes as the size of the tree to answer for, so one set of tiles serves every
+// size the tree has passed through. That size usually comes from a signed checkpoint.
+//
+// Tiles once read are cached, since they never change. A Reader is safe for
+// concurrent use.
+type Reader struct {
+	dir    string
+	height int
+
+	mu    sync.Mutex
+	cache map[tileKey][]byte
+}
+
+// tileKey identifies a tile file by its position and the number of hashes in it.
+type tileKey struct {
+	level, n, width int
+}
+
+// NewReader returns a Reader for the tiles of the given height under dir.
+func NewReader(dir string, height int) (*Reader, error) {
+	if err := checkHeight(height); err != nil {
+		return nil, err
+	}
+
+	return &Reader{dir: dir, height: height, cache: make(map[tileKey][]byte)}, nil
+}
+
+// Root returns the root of the tree of size leaves.
+func (r *Reader) Root(size int) ([]byte, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("tiles: no root for a tree of size %d", size)
+	}
+
+	return r.subtreeHash(0, size, size)
+}
+
+// InclusionProof returns the RFC 6962 inclusion proof for leaf index of the tree of size
+// leaves, as merkletree.VerifyInclusionRFC6962 takes it.
+func (r *Reader) InclusionProof(index, size int) ([][]byte, error) {
+	if index < 0 || index >= size {
+		return nil, fmt.Errorf("tiles: no leaf %d in a tree of size %d", index, size)
+	}
+	lo, hi := 0, size
+	var sides [][2]int
+	for hi-lo > 1 {
+		k := largestPowerOfTwoBelow(hi - lo)
+		if index < lo+k {
+			sides = append(sides, [2]int{lo + k, hi})
+			hi = lo + k
+		} else {
+			sides = append(sides, [2]int{lo, lo + k})
+			lo += k
+		}
+	}
+	// The path runs from the leaf up, the reverse of the descent.
+	proof := make([][]byte, 0, len(sides))
+	for i := len(sides) - 1; i >= 0; i-- {
+		hash, err := r.subtreeHash(sides[i][0], sides[i][1], size)
+		if err != nil {
+			return nil, err
+		}
+		proof = append(proof, hash)
+	}
+
+	return proof, nil
+}
+
+// ConsistencyProof returns the RFC 6962 consistency proof that the tree of oldSize
+// leaves is a prefix of the tree of size leaves, as merkletree.VerifyConsistency takes
+// it. Equal sizes yield an empty proof.
+func (r *Reader) ConsistencyProof(oldSize, size int) ([][]byte, error) {
+	if oldSize < 1 || oldSize > size {
+		return nil, fmt.Errorf("tiles: no consistency proof from size %d to size %d", oldSize, size)
+	}
+
+	return r.appendSubproof(nil, oldSize, 0, size, size, true)
+}
+
+// appendSubproof appends SUBPROOF(m, D[lo:hi], complete) of RFC 6962 section 2.1.2 to
+// proof, reading each subtree hash it needs from the tiles of the tree of size leaves.
+func (r *Reader) appendSubproof(proof [][]byte, m, lo, hi, size int, complete bool) ([][]byte, error) {
+	if m == hi-lo {
+		if complete {
+			return proof, nil
+		}
+		hash, err := r.subtreeHash(lo, hi, size)
+
+		return append(proof, hash), err
+	}
+
+	k := largestPowerOfTwoBelow(hi - lo)
+	var sibling [2]int
+	var err error
+	if m <= k {
+		proof, err = r.appendSubproof(proof, m, lo, lo+k, size, complete)
+		sibling = [2]int{lo + k, hi}
+	} else {
+		proof, err = r.appendSubproof(proof, m-k, lo+k, hi, size, false)
+		sibling = [2]int{lo, lo + k}
+	}
+	if err != nil {
+		return nil, err
+	}
+	hash, err := r.subtreeHash(sibling[0], sibling[1], size)
+
+	return append(proof, hash), err
+}
+
+// subtreeHash returns the RFC 6962 hash of leaves [lo, hi) of the tree of size leaves,
+// where lo is aligned to the largest power of two no greater than hi-lo, as every range
+// a proof needs is. A perfect range is a stored hash; any other is split as RFC 6962
+// splits it.
+func (r *Reader) subtreeHash(lo, hi, size int) ([]byte, error) {
+	n := hi - lo
+	if n&(n-1) == 0 {
+		level := bits.TrailingZeros(uint(n))
+		return r.storedHash(level, lo>>level, size)
+	}
+	k := largestPowerOfTwoBelow(n)
+	left, err := r.subtreeHash(lo, lo+k, size)
+	if err != nil {
+		return nil, err
+	}
+	right, err := r.subtreeHash(lo+k, hi, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return hashChildren(left, right), nil
+}
+
+// storedHash returns the hash of the perfect subtree index of the given level, reading
+// the hashes beneath it from the tile level at or below it and hashing up within the
+// tile.
+func (r *Reader) storedHash(level, index, size int) ([]byte, error) {
+	tileLevel, rise := level/r.height, level%r.height
+	first := index << rise
+	n, offset := first>>r.height, first&(1<<r.height-1)
+	// The widest the tile can be for a tree of size leaves.
+	width := min(1<<r.height, size>>(tileLevel*r.height)-n<<r.height)
+	tile, err := r.readTile(tileLevel, n, width, offset+1<<rise)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([][]byte, 1<<rise)
+	for i := range hashes {
+		hashes[i] = tile[(offset+i)*sha256.Size : (offset+i+1)*sha256.Size]
+	}
+	for len(hashes) > 1 {
+		for i := 0; i < len(hashes)/2; i++ {
+			hashes[i] = hashChildren(hashes[2*i], hashes[2*i+1])
+		}
+		hashes = hashes[:len(hashes)/2]
+	}
+
+	return hashes[0], nil
+}
+
+// readTile returns tile n of tileLevel, as wide as a tree of the size being served has
+// it, or, if that file is not there, any wider one, since a tile's hashes never change
+// as it widens. need is the number of hashes the caller needs from it.
+func (r *Reader) readTile(tileLevel, n, width, need int) ([]byte, error) {
+	if width < need {
+		return nil, fmt.Errorf("%w: tile %d of level %d needs %d hashes and the tree has %d", ErrShortTile, n, tileLevel, need, width)
+	}
+	widths := []int{width}
+	if width != 1<<r.height {
+		widths = append(widths, 1<<r.height)
+		widths = append(widths, r.partialWidths(tileLevel, n, width)...)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var firstErr error
+	for _, w := range widths {
+		key := tileKey{level: tileLevel, n: n, width: w}
+		if tile, ok := r.cache[key]; ok {
+			return tile, nil
+		}
+		path := filepath.Join(r.dir, filepath.FromSlash(tilePath(r.height, tileLevel, n, w)))
+		tile, err := os.ReadFile(path)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if len(tile) != w*sha256.Size {
+			return nil, fmt.Errorf("%w: %s holds %d bytes, want %d", ErrShortTile, path, len(tile), w*sha256.Size)
+		}
+		r.cache[key] = tile
+
+		return tile, nil
+	}
+
+	return nil, fmt.Errorf("tiles: reading tile %d of level %d: %w", n, tileLevel, firstErr)
+}
+
+// partialWidths returns the widths above width of the partial tiles written for tile n
+// of tileLevel, narrowest first.
+func (r *Reader) partialWidths(tileLevel, n, width int) []int {
+	dir := filepath.Join(r.dir, filepath.FromSlash(tilePath(r.height, tileLevel, n, 1<<r.height)+".p"))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var widths []int
+	for _, e := range entries {
+		w, err := strconv.Atoi(e.Name())
+		if err == nil && w > width && w < 1<<r.height && !strings.HasPrefix(e.Name(), "0") {
+			widths = append(widths, w)
+		}
+	}
+	// ReadDir sorts by name, which is not numeric order.
+	slices.Sort(widths)
+
+	return widths
+}
+
+// hashChildren returns the RFC 6962 interior hash of two children under SHA-256.
+func hashChildren(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+
+	return h.Sum(nil)
+}
+
+// largestPowerOfTwoBelow returns the largest power of two strictly less than n, for n
+// of at least two.
+func largestPowerOfTwoBelow(n int) int {
+	return 1 << (bits.Len(uint(n-1)) - 1)
+}
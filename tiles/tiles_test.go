@@ -0,0 +1,223 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package tiles
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cbergoon/merkletree"
+)
+
+// item is a content type holding an opaque value.
+type item struct {
+	x string
+}
+
+func (t item) CalculateHash() ([]byte, error) {
+	h := sha256.Sum256([]byte(t.x))
+	return h[:], nil
+}
+
+func (t item) Equals(other merkletree.Content) (bool, error) {
+	o, ok := other.(item)
+	if !ok {
+		return false, errors.New("error: value is not of type item")
+	}
+	return t.x == o.x, nil
+}
+
+func items(from, to int) []merkletree.Content {
+	cs := make([]merkletree.Content, 0, to-from)
+	for i := from; i < to; i++ {
+		cs = append(cs, item{x: fmt.Sprintf("leaf-%d", i)})
+	}
+	return cs
+}
+
+func buildTree(t *testing.T, n int, opts ...merkletree.TreeOption) *merkletree.MerkleTree {
+	t.Helper()
+	tree, err := merkletree.NewTreeWithOptions(items(0, n), append([]merkletree.TreeOption{merkletree.WithRFC6962()}, opts...)...)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	return tree
+}
+
+// checkReader checks every root, inclusion proof and consistency proof the reader gives
+// for size against tree, which holds exactly size leaves.
+func checkReader(t *testing.T, r *Reader, tree *merkletree.MerkleTree, size int) {
+	t.Helper()
+	root, err := r.Root(size)
+	if err != nil {
+		t.Fatalf("error: size %d: unexpected error: %v", size, err)
+	}
+	if !bytes.Equal(root, tree.MerkleRoot()) {
+		t.Fatalf("error: size %d: root from tiles differs from the tree's", size)
+	}
+	for i := 0; i < size; i++ {
+		proof, err := r.InclusionProof(i, size)
+		if err != nil {
+			t.Fatalf("error: size %d leaf %d: unexpected error: %v", size, i, err)
+		}
+		want, _ := tree.GetProofByIndex(i)
+		if len(proof) != len(want.Siblings) || (len(proof) > 0 && !reflect.DeepEqual(proof, want.Siblings)) {
+			t.Fatalf("error: size %d leaf %d: inclusion proof differs from the tree's", size, i)
+		}
+	}
+	for m := 1; m <= size; m++ {
+		proof, err := r.ConsistencyProof(m, size)
+		if err != nil {
+			t.Fatalf("error: size %d from %d: unexpected error: %v", size, m, err)
+		}
+		want, _ := tree.ConsistencyProof(m)
+		if len(proof) != len(want) || (len(proof) > 0 && !reflect.DeepEqual(proof, want)) {
+			t.Fatalf("error: size %d from %d: consistency proof differs from the tree's", size, m)
+		}
+	}
+}
+
+func TestTilesServeProofs(t *testing.T) {
+	for _, height := range []int{1, 2, 3, DefaultHeight} {
+		for _, n := range []int{1, 2, 3, 5, 8, 9, 17, 64, 100, 257} {
+			for _, flat := range []bool{false, true} {
+				var opts []merkletree.TreeOption
+				if flat {
+					opts = append(opts, merkletree.WithFlatLayout())
+				}
+				tree := buildTree(t, n, opts...)
+				dir := t.TempDir()
+				if err := Write(dir, tree, height); err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				r, err := NewReader(dir, height)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				checkReader(t, r, tree, n)
+			}
+		}
+	}
+}
+
+// TestTilesWithoutContent checks the trees that hold hashes alone, from
+// NewTreeFromDigests or with their content discarded, are written like any other.
+func TestTilesWithoutContent(t *testing.T) {
+	cs := items(0, 9)
+	digests := make([][]byte, len(cs))
+	for i, c := range cs {
+		digests[i], _ = c.CalculateHash()
+	}
+	fromDigests, err := merkletree.NewTreeFromDigests(digests, merkletree.WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for name, tree := range map[string]*merkletree.MerkleTree{
+		"from digests": fromDigests,
+		"discarded":    buildTree(t, len(cs), merkletree.WithDiscardContent()),
+	} {
+		dir := t.TempDir()
+		if err := Write(dir, tree, 2); err != nil {
+			t.Fatalf("error: %s: unexpected error: %v", name, err)
+		}
+		r, _ := NewReader(dir, 2)
+		checkReader(t, r, tree, len(cs))
+	}
+}
+
+// TestTilesGrow writes the tiles of a tree at several sizes into one directory and
+// checks every size is still served, from the partial tiles written for it or the
+// wider ones written since.
+func TestTilesGrow(t *testing.T) {
+	const height = 2
+	dir := t.TempDir()
+	sizes := []int{1, 3, 6, 7, 21, 40}
+	tree := buildTree(t, sizes[0])
+	for k, size := range sizes {
+		if k > 0 {
+			if err := tree.Append(items(sizes[k-1], size)...); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+		}
+		if err := Write(dir, tree, height); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+	}
+
+	r, _ := NewReader(dir, height)
+	for size := 1; size <= sizes[len(sizes)-1]; size++ {
+		checkReader(t, r, buildTree(t, size), size)
+	}
+}
+
+func TestTilePath(t *testing.T) {
+	for _, tc := range []struct {
+		height, level, n, width int
+		want                    string
+	}{
+		{8, 0, 1, 256, "tile/8/0/001"},
+		{8, 1, 1234, 256, "tile/8/1/x001/234"},
+		{8, 0, 2, 17, "tile/8/0/002.p/17"},
+		{8, 2, 1234067, 256, "tile/8/2/x001/x234/067"},
+		{2, 0, 0, 4, "tile/2/0/000"},
+	} {
+		if got := tilePath(tc.height, tc.level, tc.n, tc.width); got != tc.want {
+			t.Errorf("error: got %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestWriteRejects(t *testing.T) {
+	dir := t.TempDir()
+	for name, tree := range map[string]*merkletree.MerkleTree{
+		"default": func() *merkletree.MerkleTree {
+			tree, _ := merkletree.NewTreeWithOptions(items(0, 4))
+			return tree
+		}(),
+		"sha512": buildTree(t, 4, merkletree.WithHasher(sha512.New)),
+	} {
+		if err := Write(dir, tree, DefaultHeight); !errors.Is(err, merkletree.ErrConstructionMismatch) {
+			t.Errorf("error: %s: got %v, want ErrConstructionMismatch", name, err)
+		}
+	}
+	if err := Write(dir, buildTree(t, 4), 0); err == nil {
+		t.Errorf("error: height 0: expected an error")
+	}
+	if _, err := NewReader(dir, 31); err == nil {
+		t.Errorf("error: height 31: expected an error")
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := Write(dir, buildTree(t, 10), 2); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	r, _ := NewReader(dir, 2)
+	if _, err := r.Root(11); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("error: size beyond the tiles: got %v, want fs.ErrNotExist", err)
+	}
+	if _, err := r.InclusionProof(10, 10); err == nil {
+		t.Errorf("error: leaf out of range: expected an error")
+	}
+	if _, err := r.ConsistencyProof(11, 10); err == nil {
+		t.Errorf("error: old size beyond size: expected an error")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "tile", "2", "0", "000"), []byte("short"), 0o644); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	r, _ = NewReader(dir, 2)
+	if _, err := r.InclusionProof(0, 10); !errors.Is(err, ErrShortTile) {
+		t.Errorf("error: corrupt tile: got %v, want ErrShortTile", err)
+	}
+}
@@ -0,0 +1,17 @@
+// This is a separate module on purpose. The adapter's whole job is to speak the types
+// of golang.org/x/mod/sumdb/tlog, and merkletree itself has no dependencies and is
+// meant to keep it that way. A nested module is excluded from the parent's package
+// list, so `go build ./...` and `go test ./...` at the root neither see this directory
+// nor acquire anything it requires.
+module github.com/cbergoon/merkletree/tlogcompat
+
+go 1.21
+
+// The adapter always builds against the working tree it sits in, never a published
+// version.
+replace github.com/cbergoon/merkletree => ../
+
+require (
+	github.com/cbergoon/merkletree v0.0.0-00010101000000-000000000000
+	golang.org/x/mod v0.20.0
+)
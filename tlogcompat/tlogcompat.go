@@ -0,0 +1,178 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+// Package tlogcompat converts between merkletree and golang.org/x/mod/sumdb/tlog, the
+// transparent log implementation behind Go's checksum database.
+//
+// tlog builds the RFC 6962 tree over SHA-256, so a merkletree.MerkleTree built with
+// WithRFC6962 and the default hash strategy is the same tree, hash for hash. What
+// differs is the vocabulary. tlog addresses the hashes it stores by a single stored hash
+// index, reads them through a HashReader, and passes proofs as RecordProof and TreeProof
+// values of fixed size Hash arrays. This package lets each side use the other's:
+//
+//   - HashReader serves a tree's hashes to tlog, so tlog.TreeHash, tlog.ProveRecord and
+//     tlog.ProveTree run over a MerkleTree.
+//   - RecordProof and TreeProof produce tlog's proof types from a MerkleTree.
+//   - VerifyRecordProof and VerifyTreeProof check tlog's proof types with merkletree's
+//     verifiers, so a client can check a checksum database's proofs without tlog.
+//
+// Note that tlog hashes records directly: its leaf hash is the RFC 6962 hash of the
+// record data. merkletree hashes what Content.CalculateHash returns, so the trees agree
+// only when CalculateHash returns the record data itself. See merkletree.WithRFC6962.
+package tlogcompat
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cbergoon/merkletree"
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// Tree returns the tlog description of tree: its size and root.
+func Tree(tree *merkletree.MerkleTree) (tlog.Tree, error) {
+	if err := checkTree(tree); err != nil {
+		return tlog.Tree{}, err
+	}
+	root, err := toHash(tree.MerkleRoot())
+	if err != nil {
+		return tlog.Tree{}, err
+	}
+
+	return tlog.Tree{N: int64(tree.Len()), Hash: root}, nil
+}
+
+// HashReader returns a tlog.HashReader serving the stored hashes of tree, as tlog indexes
+// them, for use with tlog's functions. tlog stores the hash of every perfect subtree, so
+// the hash at a stored index is the node tree.NodeHash returns for the level and position
+// tlog.SplitStoredHashIndex gives it. Indices of subtrees the tree does not yet complete
+// are an error.
+//
+// The reader reads the tree at each call, so the tree must not change while it is in use.
+func HashReader(tree *merkletree.MerkleTree) (tlog.HashReader, error) {
+	if err := checkTree(tree); err != nil {
+		return nil, err
+	}
+
+	return tlog.HashReaderFunc(func(indexes []int64) ([]tlog.Hash, error) {
+		hashes := make([]tlog.Hash, len(indexes))
+		for i, index := range indexes {
+			if index < 0 {
+				return nil, fmt.Errorf("tlogcompat: stored hash index %d is negative", index)
+			}
+			level, n := tlog.SplitStoredHashIndex(index)
+			if (n+1)<<level > int64(tree.Len()) {
+				return nil, fmt.Errorf("%w: stored hash %d covers leaves beyond the tree's %d", merkletree.ErrNodeNotFound, index, tree.Len())
+			}
+			hash, err := tree.NodeHash(level, int(n))
+			if err != nil {
+				return nil, err
+			}
+			if hashes[i], err = toHash(hash); err != nil {
+				return nil, err
+			}
+		}
+
+		return hashes, nil
+	}), nil
+}
+
+// RecordProof returns the tlog proof that record n is in tree: the RFC 6962 inclusion
+// proof tlog.ProveRecord gives and tlog.CheckRecord checks.
+func RecordProof(tree *merkletree.MerkleTree, n int64) (tlog.RecordProof, error) {
+	if err := checkTree(tree); err != nil {
+		return nil, err
+	}
+	if n < 0 || n >= int64(tree.Len()) {
+		return nil, fmt.Errorf("%w: no record %d in a tree of %d", merkletree.ErrContentNotFound, n, tree.Len())
+	}
+	p, err := tree.GetProofByIndex(int(n))
+	if err != nil {
+		return nil, err
+	}
+
+	return toHashes(p.Siblings)
+}
+
+// TreeProof returns the tlog proof that the tree of the first n records is a prefix of
+// tree: the RFC 6962 consistency proof tlog.ProveTree gives and tlog.CheckTree checks.
+func TreeProof(tree *merkletree.MerkleTree, n int64) (tlog.TreeProof, error) {
+	if err := checkTree(tree); err != nil {
+		return nil, err
+	}
+	if n < 1 || n > int64(tree.Len()) {
+		return nil, fmt.Errorf("tlogcompat: no tree proof for %d records in a tree of %d", n, tree.Len())
+	}
+	proof, err := tree.ConsistencyProof(int(n))
+	if err != nil {
+		return nil, err
+	}
+
+	return toHashes(proof)
+}
+
+// VerifyRecordProof reports whether p proves that record n, whose data is data, is in
+// the tree t describes. It checks what tlog.CheckRecord checks, given the record data
+// rather than its hash, with merkletree.VerifyInclusionRFC6962.
+func VerifyRecordProof(p tlog.RecordProof, t tlog.Tree, n int64, data []byte) (bool, error) {
+	if n < 0 || n >= t.N {
+		return false, fmt.Errorf("%w: no record %d in a tree of %d", merkletree.ErrMalformedProof, n, t.N)
+	}
+
+	return merkletree.VerifyInclusionRFC6962(data, int(n), int(t.N), fromHashes(p), t.Hash[:])
+}
+
+// VerifyTreeProof reports whether p proves that the tree old describes is a prefix of
+// the tree t describes. It checks what tlog.CheckTree checks, with
+// merkletree.VerifyConsistency.
+func VerifyTreeProof(p tlog.TreeProof, t, old tlog.Tree) (bool, error) {
+	if old.N < 1 || old.N > t.N {
+		return false, fmt.Errorf("%w: no tree proof from %d records to %d", merkletree.ErrMalformedProof, old.N, t.N)
+	}
+
+	return merkletree.VerifyConsistency(old.Hash[:], t.Hash[:], int(old.N), int(t.N), fromHashes(p), merkletree.WithRFC6962())
+}
+
+// checkTree reports whether tree is the tree tlog builds: RFC 6962 over SHA-256.
+func checkTree(tree *merkletree.MerkleTree) error {
+	if tree == nil || tree.Len() == 0 {
+		return fmt.Errorf("%w: tree has no root", merkletree.ErrMalformedTree)
+	}
+	if !tree.IsRFC6962SHA256() {
+		return fmt.Errorf("%w: tlog builds RFC 6962 trees over SHA-256, build with WithRFC6962 and the default hash strategy", merkletree.ErrConstructionMismatch)
+	}
+
+	return nil
+}
+
+func toHash(b []byte) (tlog.Hash, error) {
+	var h tlog.Hash
+	if len(b) != len(h) {
+		return h, errors.New("tlogcompat: hash is not a SHA-256 hash")
+	}
+	copy(h[:], b)
+
+	return h, nil
+}
+
+func toHashes(bs [][]byte) ([]tlog.Hash, error) {
+	hashes := make([]tlog.Hash, len(bs))
+	for i, b := range bs {
+		var err error
+		if hashes[i], err = toHash(b); err != nil {
+			return nil, err
+		}
+	}
+
+	return hashes, nil
+}
+
+// fromHashes returns hashes as the slices merkletree takes. The slices alias hashes.
+func fromHashes(hashes []tlog.Hash) [][]byte {
+	bs := make([][]byte, len(hashes))
+	for i := range hashes {
+		bs[i] = hashes[i][:]
+	}
+
+	return bs
+}
@@ -0,0 +1,250 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package tlogcompat
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cbergoon/merkletree"
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// record is a Content whose digest is its data, which is what makes a merkletree leaf
+// hash equal tlog's record hash.
+type record struct {
+	data []byte
+}
+
+func (r record) CalculateHash() ([]byte, error) { return r.data, nil }
+
+func (r record) Equals(other merkletree.Content) (bool, error) {
+	o, ok := other.(record)
+	if !ok {
+		return false, errors.New("error: value is not of type record")
+	}
+	return bytes.Equal(r.data, o.data), nil
+}
+
+func recordData(i int) []byte { return []byte(fmt.Sprintf("record %d\n", i)) }
+
+var sizes = []int{1, 2, 3, 4, 5, 7, 8, 9, 15, 16, 17, 31, 33, 100, 256, 257}
+
+// tlogTree builds the tree of n records with tlog itself, returning its stored hashes.
+func tlogTree(t *testing.T, n int) []tlog.Hash {
+	t.Helper()
+	var stored []tlog.Hash
+	r := tlog.HashReaderFunc(func(indexes []int64) ([]tlog.Hash, error) {
+		hashes := make([]tlog.Hash, len(indexes))
+		for i, index := range indexes {
+			hashes[i] = stored[index]
+		}
+		return hashes, nil
+	})
+	for i := 0; i < n; i++ {
+		hashes, err := tlog.StoredHashes(int64(i), recordData(i), r)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		stored = append(stored, hashes...)
+	}
+	return stored
+}
+
+func buildTree(t *testing.T, n int, opts ...merkletree.TreeOption) *merkletree.MerkleTree {
+	t.Helper()
+	cs := make([]merkletree.Content, n)
+	for i := range cs {
+		cs[i] = record{data: recordData(i)}
+	}
+	tree, err := merkletree.NewTreeWithOptions(cs, append([]merkletree.TreeOption{merkletree.WithRFC6962()}, opts...)...)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	return tree
+}
+
+// TestHashReaderMatchesStoredHashes checks every stored hash the adapter serves against
+// the one tlog stores for the same index.
+func TestHashReaderMatchesStoredHashes(t *testing.T) {
+	for _, n := range sizes {
+		for _, flat := range []bool{false, true} {
+			var opts []merkletree.TreeOption
+			if flat {
+				opts = append(opts, merkletree.WithFlatLayout())
+			}
+			tree := buildTree(t, n, opts...)
+			stored := tlogTree(t, n)
+			if count := tlog.StoredHashCount(int64(n)); int64(len(stored)) != count {
+				t.Fatalf("error: n=%d: tlog stored %d hashes, want %d", n, len(stored), count)
+			}
+
+			hr, err := HashReader(tree)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			indexes := make([]int64, len(stored))
+			for i := range indexes {
+				indexes[i] = int64(i)
+			}
+			got, err := hr.ReadHashes(indexes)
+			if err != nil {
+				t.Fatalf("error: n=%d: unexpected error: %v", n, err)
+			}
+			for i := range got {
+				if got[i] != stored[i] {
+					level, k := tlog.SplitStoredHashIndex(int64(i))
+					t.Fatalf("error: n=%d flat=%v: stored hash %d (level %d, node %d) differs from tlog's", n, flat, i, level, k)
+				}
+			}
+
+			th, err := tlog.TreeHash(int64(n), hr)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if desc, _ := Tree(tree); desc.N != int64(n) || desc.Hash != th {
+				t.Errorf("error: n=%d: Tree gives %v, tlog computes %v", n, desc, th)
+			}
+			if _, err := hr.ReadHashes([]int64{int64(len(stored))}); err == nil {
+				t.Errorf("error: n=%d: reading past the stored hashes: expected an error", n)
+			}
+		}
+	}
+}
+
+// TestProofsMatchTlog checks the proofs the adapter produces are the ones tlog produces
+// over the same tree, and that each side verifies the other's.
+func TestProofsMatchTlog(t *testing.T) {
+	for _, n := range sizes {
+		tree := buildTree(t, n)
+		hr, _ := HashReader(tree)
+		desc, _ := Tree(tree)
+
+		for i := 0; i < n; i++ {
+			want, err := tlog.ProveRecord(int64(n), int64(i), hr)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			got, err := RecordProof(tree, int64(i))
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("error: n=%d record %d: proof differs from tlog's", n, i)
+			}
+			if err := tlog.CheckRecord(got, desc.N, desc.Hash, int64(i), tlog.RecordHash(recordData(i))); err != nil {
+				t.Errorf("error: n=%d record %d: tlog rejects the proof: %v", n, i, err)
+			}
+			ok, err := VerifyRecordProof(want, desc, int64(i), recordData(i))
+			if err != nil || !ok {
+				t.Errorf("error: n=%d record %d: tlog's proof: got %v, %v, want true", n, i, ok, err)
+			}
+			ok, err = VerifyRecordProof(want, desc, int64(i), recordData(i+1))
+			if err != nil || ok {
+				t.Errorf("error: n=%d record %d: tlog's proof for other data: got %v, %v, want false", n, i, ok, err)
+			}
+		}
+
+		for m := 1; m <= n; m++ {
+			want, err := tlog.ProveTree(int64(n), int64(m), hr)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			got, err := TreeProof(tree, int64(m))
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("error: n=%d from %d: proof differs from tlog's", n, m)
+			}
+			old, _ := Tree(buildTree(t, m))
+			if err := tlog.CheckTree(got, desc.N, desc.Hash, old.N, old.Hash); err != nil {
+				t.Errorf("error: n=%d from %d: tlog rejects the proof: %v", n, m, err)
+			}
+			ok, err := VerifyTreeProof(want, desc, old)
+			if err != nil || !ok {
+				t.Errorf("error: n=%d from %d: tlog's proof: got %v, %v, want true", n, m, ok, err)
+			}
+			if m < n {
+				old.Hash[0] ^= 1
+				if ok, _ := VerifyTreeProof(want, desc, old); ok {
+					t.Errorf("error: n=%d from %d: proof verified against a wrong old root", n, m)
+				}
+			}
+		}
+	}
+}
+
+// TestAdapterWithoutContent checks the trees that hold hashes alone, from
+// NewTreeFromDigests or with their content discarded, are served like any other.
+func TestAdapterWithoutContent(t *testing.T) {
+	const n = 9
+	digests := make([][]byte, n)
+	for i := range digests {
+		digests[i] = recordData(i)
+	}
+	fromDigests, err := merkletree.NewTreeFromDigests(digests, merkletree.WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	stored := tlogTree(t, n)
+	for name, tree := range map[string]*merkletree.MerkleTree{
+		"from digests": fromDigests,
+		"discarded":    buildTree(t, n, merkletree.WithDiscardContent()),
+	} {
+		desc, err := Tree(tree)
+		if err != nil {
+			t.Fatalf("error: %s: unexpected error: %v", name, err)
+		}
+		if want, _ := tlog.TreeHash(n, tlog.HashReaderFunc(func(indexes []int64) ([]tlog.Hash, error) {
+			hashes := make([]tlog.Hash, len(indexes))
+			for i, index := range indexes {
+				hashes[i] = stored[index]
+			}
+			return hashes, nil
+		})); desc.Hash != want {
+			t.Errorf("error: %s: root differs from tlog's", name)
+		}
+		p, err := RecordProof(tree, 3)
+		if err != nil {
+			t.Fatalf("error: %s: unexpected error: %v", name, err)
+		}
+		if err := tlog.CheckRecord(p, n, desc.Hash, 3, tlog.RecordHash(recordData(3))); err != nil {
+			t.Errorf("error: %s: tlog rejected the record proof: %v", name, err)
+		}
+	}
+}
+
+func TestAdapterRejects(t *testing.T) {
+	for name, opts := range map[string][]merkletree.TreeOption{
+		"default": nil,
+		"sha224":  {merkletree.WithRFC6962(), merkletree.WithHasher(sha256.New224)},
+	} {
+		tree, err := merkletree.NewTreeWithOptions([]merkletree.Content{record{data: recordData(0)}}, opts...)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if _, err := HashReader(tree); !errors.Is(err, merkletree.ErrConstructionMismatch) {
+			t.Errorf("error: %s: got %v, want ErrConstructionMismatch", name, err)
+		}
+	}
+
+	tree := buildTree(t, 5)
+	if _, err := RecordProof(tree, 5); !errors.Is(err, merkletree.ErrContentNotFound) {
+		t.Errorf("error: record out of range: got %v, want ErrContentNotFound", err)
+	}
+	if _, err := TreeProof(tree, 0); err == nil {
+		t.Errorf("error: tree proof from size 0: expected an error")
+	}
+	desc, _ := Tree(tree)
+	if _, err := VerifyRecordProof(nil, desc, 5, nil); !errors.Is(err, merkletree.ErrMalformedProof) {
+		t.Errorf("error: record out of range: got %v, want ErrMalformedProof", err)
+	}
+	if _, err := VerifyTreeProof(nil, desc, tlog.Tree{N: 6}); !errors.Is(err, merkletree.ErrMalformedProof) {
+		t.Errorf("error: old tree larger: got %v, want ErrMalformedProof", err)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ErrInvalidSignature is returned when a SignedTreeHead's signature does not verify
+// under the key it is checked with. Test for it with errors.Is.
+var ErrInvalidSignature = errors.New("error: tree head signature is invalid")
+
+// treeHeadContext prefixes the message a tree head signature covers, so that the
+// signature cannot be passed off as one over any other message the same key signs.
+const treeHeadContext = "merkletree signed tree head v1\x00"
+
+// SignedTreeHead is a tree's root bound, by an ed25519 signature, to the size and
+// construction of the tree and to the time it was taken. It is the trusted channel
+// VerifyProof presumes: a verifier that holds the signer's public key can accept a root
+// from anywhere, because only the signer could have produced the signature over it.
+//
+// A head is made with MerkleTree.TreeHead and signed with Sign, and the exported fields
+// are all there is to it, so it can be stored or sent with any codec. Every field is
+// covered by the signature, which means a verifier that checks it can also trust the
+// size and construction, and check proofs against them with VerifyInclusion rather than
+// supplying the options itself.
+type SignedTreeHead struct {
+	// TreeSize is the number of content items the tree held, not counting any
+	// padding leaf.
+	TreeSize int
+	// RootHash is the tree's Merkle root.
+	RootHash []byte
+	// Timestamp is when the head was taken. It is signed to the millisecond, as
+	// RFC 6962 timestamps are, so finer precision is not covered by the signature.
+	Timestamp time.Time
+	// Sorted and RFC6962 record the construction, as MerkleTree.Sorted and
+	// MerkleTree.RFC6962 report it.
+	Sorted  bool
+	RFC6962 bool
+	// HashStrategy is the name the tree's hash strategy is registered under with
+	// RegisterHashStrategy.
+	HashStrategy string
+	// Signature is the ed25519 signature over the other fields, set by Sign.
+	Signature []byte
+}
+
+// TreeHead returns an unsigned head describing the tree as it stands, taken at
+// timestamp. The root is a copy, so the head is unaffected by later changes to the tree.
+//
+// The hash strategy must be registered with RegisterHashStrategy, as it must be to
+// serialize the tree, because a head names its strategy so that a verifier can check
+// proofs against it without being told separately. Returns an error wrapping
+// ErrNoHashStrategy otherwise, and ErrMalformedTree for a tree with no root.
+func (m *MerkleTree) TreeHead(timestamp time.Time) (*SignedTreeHead, error) {
+	if m.empty() {
+		return nil, fmt.Errorf("%w: tree has no root", ErrMalformedTree)
+	}
+	if err := m.requireBinary("a tree head"); err != nil {
+		return nil, err
+	}
+	name := m.hashStrategyName
+	if name == "" {
+		var ok bool
+		if name, ok = lookupHashStrategyName(m.hashStrategy); !ok {
+			return nil, fmt.Errorf("%w: register the tree's strategy with RegisterHashStrategy to take its head", ErrNoHashStrategy)
+		}
+	}
+
+	return &SignedTreeHead{
+		TreeSize:     m.contentCount(),
+		RootHash:     slices.Clone(m.merkleRoot),
+		Timestamp:    timestamp,
+		Sorted:       m.sort,
+		RFC6962:      m.rfc6962,
+		HashStrategy: name,
+	}, nil
+}
+
+// Sign signs the head with key, replacing any signature it had.
+func (h *SignedTreeHead) Sign(key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return fmt.Errorf("error: ed25519 private key is %d bytes, want %d", len(key), ed25519.PrivateKeySize)
+	}
+	msg, err := h.signedMessage()
+	if err != nil {
+		return err
+	}
+	h.Signature = ed25519.Sign(key, msg)
+
+	return nil
+}
+
+// Verify checks the head's signature under pub. It returns nil when the signature is
+// valid, so that every field of the head can be trusted, and an error wrapping
+// ErrInvalidSignature when it is not.
+func (h *SignedTreeHead) Verify(pub ed25519.PublicKey) error {
+	if len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("error: ed25519 public key is %d bytes, want %d", len(pub), ed25519.PublicKeySize)
+	}
+	msg, err := h.signedMessage()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, msg, h.Signature) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// VerifyInclusion checks the head's signature under pub and then reports whether p shows
+// content to be held by the tree the head describes. It is Verify and Proof.Verify in
+// one call, with the construction and hash strategy taken from the head, which the
+// signature makes trustworthy, rather than from options.
+//
+// Returns an error wrapping ErrInvalidSignature when the signature does not verify,
+// ErrConstructionMismatch when the proof is for a tree of another size or construction,
+// and ErrNoHashStrategy when the head names a strategy not registered here. False with
+// no error means the head is genuine and the proof does not reproduce its root.
+func (h *SignedTreeHead) VerifyInclusion(pub ed25519.PublicKey, content Content, p *Proof) (bool, error) {
+	if content == nil {
+		return false, ErrNilContent
+	}
+	digest, err := content.CalculateHash()
+	if err != nil {
+		return false, err
+	}
+
+	return h.VerifyInclusionDigest(pub, digest, p)
+}
+
+// VerifyInclusionDigest is VerifyInclusion for a verifier that holds the leaf digest
+// rather than the content, as Proof.VerifyDigest is for Proof.Verify.
+func (h *SignedTreeHead) VerifyInclusionDigest(pub ed25519.PublicKey, digest []byte, p *Proof) (bool, error) {
+	if p == nil {
+		return false, fmt.Errorf("%w: proof is nil", ErrMalformedProof)
+	}
+	if err := h.Verify(pub); err != nil {
+		return false, err
+	}
+	if p.TreeSize != h.TreeSize {
+		return false, fmt.Errorf("%w: the proof is for a tree of %d items and the head for one of %d", ErrConstructionMismatch, p.TreeSize, h.TreeSize)
+	}
+	strategy, ok := lookupHashStrategy(h.HashStrategy)
+	if !ok {
+		return false, fmt.Errorf("%w: the head names %q", ErrNoHashStrategy, h.HashStrategy)
+	}
+	opts := []TreeOption{WithHasher(strategy)}
+	if h.Sorted {
+		opts = append(opts, WithSortedSiblings())
+	}
+	if h.RFC6962 {
+		opts = append(opts, WithRFC6962())
+	}
+
+	return p.VerifyDigest(digest, h.RootHash, opts...)
+}
+
+// signedMessage returns the bytes the signature covers: a context string, then the
+// tree size and the timestamp in milliseconds as big endian uint64s, a flags byte, and
+// the hash strategy name and the root, each prefixed with its length as a uvarint.
+// Every field has a fixed width or a length, so no two heads share a message.
+func (h *SignedTreeHead) signedMessage() ([]byte, error) {
+	if h.TreeSize < 1 {
+		return nil, fmt.Errorf("error: tree head has size %d", h.TreeSize)
+	}
+	var flags byte
+	if h.Sorted {
+		flags |= 1
+	}
+	if h.RFC6962 {
+		flags |= 2
+	}
+
+	msg := make([]byte, 0, len(treeHeadContext)+17+2*binary.MaxVarintLen64+len(h.HashStrategy)+len(h.RootHash))
+	msg = append(msg, treeHeadContext...)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(h.TreeSize))
+	msg = binary.BigEndian.AppendUint64(msg, uint64(h.Timestamp.UnixMilli()))
+	msg = append(msg, flags)
+	msg = binary.AppendUvarint(msg, uint64(len(h.HashStrategy)))
+	msg = append(msg, h.HashStrategy...)
+	msg = binary.AppendUvarint(msg, uint64(len(h.RootHash)))
+	msg = append(msg, h.RootHash...)
+
+	return msg, nil
+}
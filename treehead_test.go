@@ -0,0 +1,156 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"hash"
+	"testing"
+	"time"
+)
+
+func testKey(seed byte) (ed25519.PublicKey, ed25519.PrivateKey) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+	return key.Public().(ed25519.PublicKey), key
+}
+
+func signedHead(t *testing.T, tree *MerkleTree, key ed25519.PrivateKey) *SignedTreeHead {
+	t.Helper()
+	sth, err := tree.TreeHead(time.UnixMilli(1700000000000))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := sth.Sign(key); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	return sth
+}
+
+func TestTreeHeadInclusion(t *testing.T) {
+	pub, key := testKey(1)
+	eachTree(t, func(t *testing.T, tree *MerkleTree, contents []Content, _ func() hash.Hash, mode propMode) {
+		sth := signedHead(t, tree, key)
+		if sth.TreeSize != len(contents) || !bytes.Equal(sth.RootHash, tree.MerkleRoot()) || sth.Sorted != mode.sorted || sth.RFC6962 != mode.rfc6962 {
+			t.Fatalf("error: head does not describe the tree: %+v", sth)
+		}
+		if err := sth.Verify(pub); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		for i, c := range contents {
+			p, err := tree.GetProofByIndex(i)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			ok, err := sth.VerifyInclusion(pub, c, p)
+			if err != nil || !ok {
+				t.Fatalf("error: leaf %d: got %v, %v, want true", i, ok, err)
+			}
+			ok, err = sth.VerifyInclusion(pub, contents[(i+1)%len(contents)], p)
+			if err != nil || (ok && len(contents) > 1) {
+				t.Fatalf("error: leaf %d with other content: got %v, %v, want false", i, ok, err)
+			}
+		}
+	})
+}
+
+func TestTreeHeadTampering(t *testing.T) {
+	pub, key := testKey(1)
+	otherPub, _ := testKey(2)
+	tree, err := NewTreeWithOptions(propSeries(9), WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	for name, tamper := range map[string]func(h *SignedTreeHead){
+		"size":      func(h *SignedTreeHead) { h.TreeSize++ },
+		"root":      func(h *SignedTreeHead) { h.RootHash[0] ^= 1 },
+		"timestamp": func(h *SignedTreeHead) { h.Timestamp = h.Timestamp.Add(time.Millisecond) },
+		"sorted":    func(h *SignedTreeHead) { h.Sorted = true },
+		"rfc6962":   func(h *SignedTreeHead) { h.RFC6962 = false },
+		"strategy":  func(h *SignedTreeHead) { h.HashStrategy = "sha512" },
+		"signature": func(h *SignedTreeHead) { h.Signature[0] ^= 1 },
+	} {
+		sth := signedHead(t, tree, key)
+		tamper(sth)
+		if err := sth.Verify(pub); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("error: tampered %s: got %v, want ErrInvalidSignature", name, err)
+		}
+		p, _ := tree.GetProofByIndex(0)
+		if _, err := sth.VerifyInclusion(pub, propSeries(1)[0], p); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("error: tampered %s: VerifyInclusion got %v, want ErrInvalidSignature", name, err)
+		}
+	}
+
+	sth := signedHead(t, tree, key)
+	if err := sth.Verify(otherPub); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("error: other key: got %v, want ErrInvalidSignature", err)
+	}
+	// Precision below a millisecond is not signed.
+	sth.Timestamp = sth.Timestamp.Add(time.Microsecond)
+	if err := sth.Verify(pub); err != nil {
+		t.Errorf("error: unexpected error: %v", err)
+	}
+	if err := sth.Verify(pub[:8]); err == nil || errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("error: short key: got %v, want a key size error", err)
+	}
+	if err := sth.Sign(ed25519.PrivateKey{1}); err == nil {
+		t.Errorf("error: short private key: expected an error")
+	}
+}
+
+func TestTreeHeadRoundTrip(t *testing.T) {
+	pub, key := testKey(3)
+	tree, err := NewTreeWithOptions(propSeries(5), WithSortedSiblings())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	data, err := json.Marshal(signedHead(t, tree, key))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	var sth SignedTreeHead
+	if err := json.Unmarshal(data, &sth); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := sth.Verify(pub); err != nil {
+		t.Errorf("error: decoded head does not verify: %v", err)
+	}
+}
+
+func TestTreeHeadMismatch(t *testing.T) {
+	pub, key := testKey(1)
+	tree, err := NewTreeWithOptions(propSeries(8), WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	sth := signedHead(t, tree, key)
+	c := propSeries(1)[0]
+
+	other, _ := NewTreeWithOptions(propSeries(9), WithRFC6962())
+	p, _ := other.GetProofByIndex(0)
+	if _, err := sth.VerifyInclusion(pub, c, p); !errors.Is(err, ErrConstructionMismatch) {
+		t.Errorf("error: other size: got %v, want ErrConstructionMismatch", err)
+	}
+	other, _ = NewTreeWithOptions(propSeries(8), WithSortedSiblings())
+	p, _ = other.GetProofByIndex(0)
+	if _, err := sth.VerifyInclusion(pub, c, p); !errors.Is(err, ErrConstructionMismatch) {
+		t.Errorf("error: other construction: got %v, want ErrConstructionMismatch", err)
+	}
+	if _, err := sth.VerifyInclusion(pub, c, nil); !errors.Is(err, ErrMalformedProof) {
+		t.Errorf("error: nil proof: got %v, want ErrMalformedProof", err)
+	}
+
+	unregistered := func() hash.Hash { return sha256.New() }
+	tree, _ = NewTreeWithOptions(propSeries(4), WithHasher(unregistered))
+	if _, err := tree.TreeHead(time.Now()); !errors.Is(err, ErrNoHashStrategy) {
+		t.Errorf("error: unregistered strategy: got %v, want ErrNoHashStrategy", err)
+	}
+	if _, err := (&MerkleTree{}).TreeHead(time.Now()); !errors.Is(err, ErrMalformedTree) {
+		t.Errorf("error: empty tree: got %v, want ErrMalformedTree", err)
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// TypedTree is a MerkleTree over values of type T, for callers whose leaves are plain
+// values rather than Content implementations. It is given the two things Content
+// supplies as functions instead, hashes a value with one and compares two with the
+// other, and hands values of type T back from its accessors, so there is no wrapper
+// type to write and no type assertion on the way out.
+//
+// Underneath is an ordinary MerkleTree built with the same options, and the roots and
+// proofs are the ones that tree gives: a TypedTree over values whose hash is what a
+// Content would return for them has the root a MerkleTree over that Content has.
+// Proofs verify with VerifyProofWithDigest, given the value's hash.
+type TypedTree[T any] struct {
+	tree  *MerkleTree
+	hash  func(T) ([]byte, error)
+	equal func(a, b T) bool
+}
+
+// typedContent is the Content a TypedTree holds each value in.
+type typedContent[T any] struct {
+	v     T
+	hash  func(T) ([]byte, error)
+	equal func(a, b T) bool
+}
+
+// CalculateHash hashes the value with the tree's hash function.
+func (c typedContent[T]) CalculateHash() ([]byte, error) {
+	return c.hash(c.v)
+}
+
+// Equals compares the values with the tree's equality function, or by their hashes
+// when it has none.
+func (c typedContent[T]) Equals(other Content) (bool, error) {
+	o, ok := other.(typedContent[T])
+	if !ok {
+		return false, fmt.Errorf("error: value is not of type %T", c.v)
+	}
+	if c.equal != nil {
+		return c.equal(c.v, o.v), nil
+	}
+	a, err := c.hash(c.v)
+	if err != nil {
+		return false, err
+	}
+	b, err := c.hash(o.v)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(a, b), nil
+}
+
+// NewTypedTree builds a tree over values with the given options, hashing each value with
+// hash. equal locates a value in the tree as Content.Equals does, and must agree with
+// hash in the same way; when it is nil, two values are equal when they hash alike.
+// Returns ErrNoContent if values is empty.
+func NewTypedTree[T any](values []T, hash func(T) ([]byte, error), equal func(a, b T) bool, opts ...TreeOption) (*TypedTree[T], error) {
+	if hash == nil {
+		return nil, errors.New("error: NewTypedTree requires a hash function")
+	}
+	t := &TypedTree[T]{hash: hash, equal: equal}
+	tree, err := NewTreeWithOptions(t.contents(values), opts...)
+	if err != nil {
+		return nil, err
+	}
+	t.tree = tree
+
+	return t, nil
+}
+
+// contents wraps values as Content.
+func (t *TypedTree[T]) contents(values []T) []Content {
+	cs := make([]Content, len(values))
+	for i, v := range values {
+		cs[i] = t.content(v)
+	}
+
+	return cs
+}
+
+// content wraps v as Content.
+func (t *TypedTree[T]) content(v T) Content {
+	return typedContent[T]{v: v, hash: t.hash, equal: t.equal}
+}
+
+// Tree returns the MerkleTree underneath, for what TypedTree does not wrap: multiproofs,
+// tree heads, Diff and the rest. Its content is of an unexported type, so change the
+// tree through the TypedTree, which keeps every leaf a value of type T.
+func (t *TypedTree[T]) Tree() *MerkleTree {
+	return t.tree
+}
+
+// MerkleRoot returns the Merkle root of the tree.
+func (t *TypedTree[T]) MerkleRoot() []byte {
+	return t.tree.MerkleRoot()
+}
+
+// Len returns the number of values the tree holds.
+func (t *TypedTree[T]) Len() int {
+	return t.tree.Len()
+}
+
+// At returns the value at position i, for i below Len. Returns ErrContentNotFound
+// otherwise.
+func (t *TypedTree[T]) At(i int) (T, error) {
+	c, err := t.tree.ContentAt(i)
+	if err != nil {
+		var zero T
+
+		return zero, err
+	}
+
+	return t.value(c)
+}
+
+// value unwraps the value c holds.
+func (t *TypedTree[T]) value(c Content) (T, error) {
+	tc, ok := c.(typedContent[T])
+	if !ok {
+		var zero T
+
+		return zero, fmt.Errorf("%w: leaf holds a %T, not a value of the tree's type", ErrMalformedTree, c)
+	}
+
+	return tc.v, nil
+}
+
+// Values returns the values the tree holds, in order.
+func (t *TypedTree[T]) Values() ([]T, error) {
+	values := make([]T, t.Len())
+	for i := range values {
+		var err error
+		if values[i], err = t.At(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// IndexOf returns the position of the first value equal to v. The returned error wraps
+// ErrContentNotFound if the tree holds no such value.
+func (t *TypedTree[T]) IndexOf(v T) (int, error) {
+	i, err := t.tree.findLeaf(t.content(v))
+	if err != nil {
+		return -1, err
+	}
+	if i < 0 {
+		return -1, ErrContentNotFound
+	}
+
+	return i, nil
+}
+
+// GetMerklePath returns the audit path for the first value equal to v, as
+// MerkleTree.GetMerklePath does for content.
+func (t *TypedTree[T]) GetMerklePath(v T) ([][]byte, []int64, error) {
+	return t.tree.GetMerklePath(t.content(v))
+}
+
+// GetMerklePathByIndex returns the value at position i and its audit path, as
+// MerkleTree.GetMerklePathByIndex does, and ErrContentNotFound for a position at or
+// beyond Len.
+func (t *TypedTree[T]) GetMerklePathByIndex(i int) (T, [][]byte, []int64, error) {
+	v, err := t.At(i)
+	if err != nil {
+		return v, nil, nil, err
+	}
+	path, index, err := t.tree.GetMerklePathByIndex(i)
+
+	return v, path, index, err
+}
+
+// GetProof returns the audit path for the first value equal to v as a Proof.
+func (t *TypedTree[T]) GetProof(v T) (*Proof, error) {
+	return t.tree.GetProof(t.content(v))
+}
+
+// GetProofByIndex returns the value at position i and its audit path as a Proof, and
+// ErrContentNotFound for a position at or beyond Len.
+func (t *TypedTree[T]) GetProofByIndex(i int) (T, *Proof, error) {
+	v, err := t.At(i)
+	if err != nil {
+		return v, nil, err
+	}
+	p, err := t.tree.GetProofByIndex(i)
+
+	return v, p, err
+}
+
+// VerifyContent reports whether the tree holds v and the hashes on its path are intact,
+// as MerkleTree.VerifyContent does for content.
+func (t *TypedTree[T]) VerifyContent(v T) (bool, error) {
+	return t.tree.VerifyContent(t.content(v))
+}
+
+// VerifyProof reports whether path and index prove v against the tree's root, as
+// MerkleTree.VerifyProof does for content.
+func (t *TypedTree[T]) VerifyProof(v T, path [][]byte, index []int64) (bool, error) {
+	return t.tree.VerifyProof(t.content(v), path, index)
+}
+
+// Append adds values to the end of the tree, as MerkleTree.Append does.
+func (t *TypedTree[T]) Append(values ...T) error {
+	return t.tree.Append(t.contents(values)...)
+}
+
+// UpdateLeaf replaces the value at position i, as MerkleTree.UpdateLeaf does.
+func (t *TypedTree[T]) UpdateLeaf(i int, v T) error {
+	return t.tree.UpdateLeaf(i, t.content(v))
+}
+
+// MarshalWith encodes the tree as MerkleTree.MarshalWith does, using enc to encode each
+// value. Decode it with UnmarshalTypedTree.
+func (t *TypedTree[T]) MarshalWith(enc func(T) ([]byte, error), opts ...MarshalOption) ([]byte, error) {
+	if enc == nil {
+		return nil, errors.New("error: MarshalWith requires a value marshal function")
+	}
+
+	return t.tree.MarshalWith(func(c Content) ([]byte, error) {
+		v, err := t.value(c)
+		if err != nil {
+			return nil, err
+		}
+
+		return enc(v)
+	}, opts...)
+}
+
+// UnmarshalTypedTree decodes a tree written by TypedTree.MarshalWith, using dec to decode
+// each value, and hash and equal as NewTypedTree uses them. As with UnmarshalWith, the
+// rebuilt tree is verified against the Merkle root recorded in data.
+func UnmarshalTypedTree[T any](data []byte, dec func([]byte) (T, error), hash func(T) ([]byte, error), equal func(a, b T) bool, opts ...UnmarshalOption) (*TypedTree[T], error) {
+	if dec == nil {
+		return nil, errors.New("error: UnmarshalTypedTree requires a value unmarshal function")
+	}
+	if hash == nil {
+		return nil, errors.New("error: UnmarshalTypedTree requires a hash function")
+	}
+	t := &TypedTree[T]{hash: hash, equal: equal}
+	tree, err := UnmarshalWith(data, func(b []byte) (Content, error) {
+		v, err := dec(b)
+		if err != nil {
+			return nil, err
+		}
+
+		return t.content(v), nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	t.tree = tree
+
+	return t, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// hashString hashes a string as TestSHA256Content does.
+func hashString(s string) ([]byte, error) {
+	sum := sha256.Sum256([]byte(s))
+
+	return sum[:], nil
+}
+
+func equalString(a, b string) bool { return a == b }
+
+func TestTypedTreeMatchesContent(t *testing.T) {
+	for _, opts := range [][]TreeOption{nil, {WithRFC6962()}, {WithSortedSiblings(), WithFlatLayout()}, {WithLeafIndex()}} {
+		for n := 1; n <= 9; n++ {
+			values := make([]string, n)
+			cs := make([]Content, n)
+			for i := range values {
+				values[i] = fmt.Sprintf("value-%d", i)
+				cs[i] = TestSHA256Content{x: values[i]}
+			}
+			typed, err := NewTypedTree(values, hashString, equalString, opts...)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			tree, err := NewTreeWithOptions(cs, opts...)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !bytes.Equal(typed.MerkleRoot(), tree.MerkleRoot()) || typed.Len() != n {
+				t.Fatalf("error: n=%d: root %x, Content tree %x", n, typed.MerkleRoot(), tree.MerkleRoot())
+			}
+			if got, err := typed.Values(); err != nil || !reflect.DeepEqual(got, values) {
+				t.Errorf("error: n=%d: Values returned %v, %v", n, got, err)
+			}
+
+			for i, v := range values {
+				at, path, index, err := typed.GetMerklePathByIndex(i)
+				if err != nil || at != v {
+					t.Fatalf("error: n=%d: GetMerklePathByIndex(%d) returned %q, %v", n, i, at, err)
+				}
+				wantPath, wantIndex, _ := tree.GetMerklePathByIndex(i)
+				if !reflect.DeepEqual(path, wantPath) || !reflect.DeepEqual(index, wantIndex) {
+					t.Errorf("error: n=%d: leaf %d: path differs from the Content tree's", n, i)
+				}
+				if j, err := typed.IndexOf(v); err != nil || j != i {
+					t.Errorf("error: n=%d: IndexOf(%q) returned %d, %v", n, v, j, err)
+				}
+				if ok, err := typed.VerifyProof(v, path, index); err != nil || !ok {
+					t.Errorf("error: n=%d: leaf %d: VerifyProof returned %v, %v", n, i, ok, err)
+				}
+				if ok, err := typed.VerifyContent(v); err != nil || !ok {
+					t.Errorf("error: n=%d: leaf %d: VerifyContent returned %v, %v", n, i, ok, err)
+				}
+				digest, _ := hashString(v)
+				if ok, err := VerifyProofWithDigest(digest, path, index, typed.MerkleRoot(), opts...); err != nil || !ok {
+					t.Errorf("error: n=%d: leaf %d: VerifyProofWithDigest returned %v, %v", n, i, ok, err)
+				}
+			}
+		}
+	}
+}
+
+func TestTypedTreeLookups(t *testing.T) {
+	values := []string{"a", "b", "c"}
+	typed, err := NewTypedTree(values, hashString, nil)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if _, err := typed.IndexOf("d"); !errors.Is(err, ErrContentNotFound) {
+		t.Errorf("error: absent value: got %v, want ErrContentNotFound", err)
+	}
+	if _, _, err := typed.GetMerklePath("d"); !errors.Is(err, ErrContentNotFound) {
+		t.Errorf("error: absent value: got %v, want ErrContentNotFound", err)
+	}
+	// Three values are padded to four leaves; the padding leaf is not a value.
+	if _, err := typed.At(3); !errors.Is(err, ErrContentNotFound) {
+		t.Errorf("error: At(3): got %v, want ErrContentNotFound", err)
+	}
+	if _, _, err := typed.GetProofByIndex(3); !errors.Is(err, ErrContentNotFound) {
+		t.Errorf("error: GetProofByIndex(3): got %v, want ErrContentNotFound", err)
+	}
+	v, proof, err := typed.GetProofByIndex(1)
+	if err != nil || v != "b" {
+		t.Fatalf("error: GetProofByIndex(1) returned %q, %v", v, err)
+	}
+	digest, _ := hashString(v)
+	if ok, err := proof.VerifyDigest(digest, typed.MerkleRoot()); err != nil || !ok {
+		t.Errorf("error: Proof.VerifyDigest returned %v, %v", ok, err)
+	}
+	if byValue, err := typed.GetProof("b"); err != nil || !reflect.DeepEqual(byValue, proof) {
+		t.Errorf("error: GetProof differs from GetProofByIndex: %v", err)
+	}
+
+	if _, err := NewTypedTree(values, nil, equalString); err == nil {
+		t.Errorf("error: nil hash: expected an error")
+	}
+	if _, err := NewTypedTree(nil, hashString, equalString); !errors.Is(err, ErrNoContent) {
+		t.Errorf("error: no values: got %v, want ErrNoContent", err)
+	}
+}
+
+func TestTypedTreeChanges(t *testing.T) {
+	typed, err := NewTypedTree([]string{"a", "b", "c"}, hashString, equalString)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := typed.Append("d", "e"); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := typed.UpdateLeaf(1, "B"); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	want, err := NewTypedTree([]string{"a", "B", "c", "d", "e"}, hashString, equalString)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(typed.MerkleRoot(), want.MerkleRoot()) {
+		t.Errorf("error: root after changes %x, want %x", typed.MerkleRoot(), want.MerkleRoot())
+	}
+	if v, _ := typed.At(1); v != "B" {
+		t.Errorf("error: At(1) is %q after UpdateLeaf", v)
+	}
+}
+
+func TestTypedTreeMarshalWith(t *testing.T) {
+	typed, err := NewTypedTree([]string{"a", "b", "c", "d", "e"}, hashString, equalString, WithRFC6962())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	enc := func(s string) ([]byte, error) { return []byte(s), nil }
+	dec := func(b []byte) (string, error) { return string(b), nil }
+	data, err := typed.MarshalWith(enc)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	got, err := UnmarshalTypedTree(data, dec, hashString, equalString)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(got.MerkleRoot(), typed.MerkleRoot()) {
+		t.Errorf("error: decoded root %x, want %x", got.MerkleRoot(), typed.MerkleRoot())
+	}
+	if values, _ := got.Values(); !reflect.DeepEqual(values, []string{"a", "b", "c", "d", "e"}) {
+		t.Errorf("error: decoded values %v", values)
+	}
+
+	upper := func(b []byte) (string, error) { return strings.ToUpper(string(b)), nil }
+	if _, err := UnmarshalTypedTree(data, upper, hashString, equalString); !errors.Is(err, ErrRootMismatch) {
+		t.Errorf("error: decoding to other values: got %v, want ErrRootMismatch", err)
+	}
+	if _, err := typed.MarshalWith(nil); err == nil {
+		t.Errorf("error: nil encoder: expected an error")
+	}
+}